@@ -0,0 +1,99 @@
+/*
+cachebench runs the eviction policies of the simulator package against a synthetic workload or a
+trace file, and prints the hit ratio, throughput and allocations of each policy and capacity:
+
+	cachebench -workload zipf -n 1000000 -keys 100000 -s 1.1 -capacities 1000,10000
+	cachebench -trace access.csv -format csv -policies lru,arc -capacities 5000
+
+Traces are local_cache access logs (-format csv or json) or one key per line (-format keys).
+*/
+
+package main
+
+import (
+	"cache/src/simulator"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cachebench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	workload := fs.String("workload", "zipf", "synthetic workload: zipf, uniform or scan")
+	trace := fs.String("trace", "", "trace file replayed instead of the workload, - reads stdin")
+	format := fs.String("format", "keys", "format of the trace: csv, json or keys")
+	n := fs.Int("n", 1000000, "accesses of the workload")
+	keys := fs.Int("keys", 100000, "distinct keys of the workload")
+	s := fs.Float64("s", 1.1, "exponent of the zipf workload, > 1")
+	seed := fs.Int64("seed", 1, "seed of the workload")
+	policies := fs.String("policies", "", "comma separated policies, all by default")
+	capacities := fs.String("capacities", "1000,10000", "comma separated capacities")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if err := bench(stdout, *workload, *trace, *format, *n, *keys, *s, *seed, *policies, *capacities); err != nil {
+		fmt.Fprintln(stderr, "cachebench:", err)
+		return 1
+	}
+	return 0
+}
+
+func bench(w io.Writer, workload, trace, format string, n, keys int, s float64, seed int64, policies, capacities string) error {
+	accesses, err := load(workload, trace, format, n, keys, s, seed)
+	if err != nil {
+		return err
+	}
+	all := simulator.DefaultPolicies()
+	selected := all
+	if policies != "" {
+		selected = make(map[string]simulator.Factory)
+		for _, name := range strings.Split(policies, ",") {
+			f, ok := all[strings.TrimSpace(name)]
+			if !ok {
+				return fmt.Errorf("unknown policy %q", name)
+			}
+			selected[strings.TrimSpace(name)] = f
+		}
+	}
+	var sizes []int
+	for _, c := range strings.Split(capacities, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || size <= 0 {
+			return fmt.Errorf("bad capacity %q", c)
+		}
+		sizes = append(sizes, size)
+	}
+	return simulator.WriteReport(w, simulator.Run(accesses, selected, sizes))
+}
+
+func load(workload, trace, format string, n, keys int, s float64, seed int64) ([]uint64, error) {
+	if trace == "" {
+		return simulator.Workload(workload, n, keys, s, seed)
+	}
+	formats := map[string]simulator.Format{
+		"csv":  simulator.FormatAccessLogCSV,
+		"json": simulator.FormatAccessLogJSON,
+		"keys": simulator.FormatKeys,
+	}
+	f, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown trace format %q", format)
+	}
+	if trace == "-" {
+		return simulator.ReadTrace(os.Stdin, f)
+	}
+	file, err := os.Open(trace)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return simulator.ReadTrace(file, f)
+}
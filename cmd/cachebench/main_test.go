@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"-workload", "scan", "-n", "1000", "-keys", "100", "-policies", "lru", "-capacities", "50,100"}, &out, &errOut); code != 0 {
+		t.Fatalf("unexpected exit %d: %s", code, errOut.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "OPS/S") {
+		t.Fatalf("unexpected report %q", out.String())
+	}
+	// a scan over more keys than the capacity never hits lru
+	if fields := strings.Fields(lines[1]); fields[3] != "0" {
+		t.Fatalf("unexpected lru row %q", lines[1])
+	}
+
+	path := filepath.Join(t.TempDir(), "trace")
+	if err := os.WriteFile(path, []byte("a\nb\na\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if code := run([]string{"-trace", path, "-policies", "lfu", "-capacities", "2"}, &out, &errOut); code != 0 {
+		t.Fatalf("unexpected exit %d: %s", code, errOut.String())
+	}
+	if fields := strings.Fields(strings.Split(out.String(), "\n")[1]); fields[3] != "1" {
+		t.Fatalf("the trace should hit once, got %q", out.String())
+	}
+	if code := run([]string{"-workload", "zipf", "-s", "1"}, &out, &errOut); code != 1 {
+		t.Fatalf("a zipf exponent of 1 should fail, got %d", code)
+	}
+}
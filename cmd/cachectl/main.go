@@ -0,0 +1,145 @@
+/*
+cachectl drives the admin API of a cache, see the admin package:
+
+	cachectl [-addr URL] [-token T] get KEY
+	cachectl set [-ttl 1m] KEY VALUE    VALUE is JSON, or a string when it isn't valid JSON
+	cachectl delete KEY
+	cachectl stats
+	cachectl flush
+	cachectl snapshot FILE              "-" writes to stdout
+	cachectl replay FILE                applies a JSON lines command log, "-" reads stdin
+
+The address and the token default to CACHECTL_ADDR and CACHECTL_TOKEN. Results are printed as JSON, a
+missing key exits with status 2 and the other errors with status 1.
+*/
+
+package main
+
+import (
+	"cache/src/admin"
+	"cache/src/cache"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cachectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", envOr("CACHECTL_ADDR", "http://127.0.0.1:8081"), "base URL of the admin API")
+	token := fs.String("token", os.Getenv("CACHECTL_TOKEN"), "bearer token of the admin API")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the command")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: cachectl [flags] get|set|delete|stats|flush|snapshot|replay ...")
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := &admin.Client{BaseURL: *addr, Token: *token}
+	err := command(ctx, c, fs.Arg(0), fs.Args()[1:], stdin, stdout)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, cache.ErrNotFound):
+		fmt.Fprintln(stderr, "cachectl: not found")
+		return 2
+	default:
+		fmt.Fprintln(stderr, "cachectl:", err)
+		return 1
+	}
+}
+
+func command(ctx context.Context, c *admin.Client, name string, args []string, stdin io.Reader, stdout io.Writer) error {
+	enc := json.NewEncoder(stdout)
+	switch name {
+	case "get":
+		if len(args) != 1 {
+			return errors.New("usage: get KEY")
+		}
+		v, err := c.Get(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return enc.Encode(v)
+	case "set":
+		fs := flag.NewFlagSet("set", flag.ContinueOnError)
+		ttl := fs.Duration("ttl", cache.DefaultExpire, "time to live, the default TTL of the cache when 0")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return errors.New("usage: set [-ttl 1m] KEY VALUE")
+		}
+		var val any
+		if err := json.Unmarshal([]byte(fs.Arg(1)), &val); err != nil {
+			val = fs.Arg(1)
+		}
+		return c.Set(ctx, fs.Arg(0), val, *ttl)
+	case "delete", "del":
+		if len(args) != 1 {
+			return errors.New("usage: delete KEY")
+		}
+		return c.Delete(ctx, args[0])
+	case "stats":
+		s, err := c.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(s)
+	case "flush":
+		return c.Flush(ctx)
+	case "snapshot":
+		if len(args) != 1 {
+			return errors.New("usage: snapshot FILE")
+		}
+		if args[0] == "-" {
+			return c.Snapshot(ctx, stdout)
+		}
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		if err := c.Snapshot(ctx, f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case "replay":
+		if len(args) != 1 {
+			return errors.New("usage: replay FILE")
+		}
+		r := stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		n, err := c.Replay(ctx, r)
+		fmt.Fprintf(stdout, "%d commands applied\n", n)
+		return err
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
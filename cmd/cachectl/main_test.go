@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"cache/src/admin"
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewServer(admin.New(cache.NewLocal(local_cache.NewCache(time.Minute, 0))))
+	defer srv.Close()
+	ctl := func(stdin string, args ...string) (int, string) {
+		var out, errOut bytes.Buffer
+		code := run(append([]string{"-addr", srv.URL}, args...), strings.NewReader(stdin), &out, &errOut)
+		return code, out.String() + errOut.String()
+	}
+	if code, out := ctl("", "set", "-ttl", "1m", "k", "plain text"); code != 0 {
+		t.Fatalf("set failed: %d %s", code, out)
+	}
+	if code, out := ctl("", "get", "k"); code != 0 || !strings.Contains(out, `"value":"plain text"`) {
+		t.Fatalf("unexpected get: %d %s", code, out)
+	}
+	if code, out := ctl(`{"op":"delete","key":"k"}`, "replay", "-"); code != 0 || !strings.Contains(out, "1 commands") {
+		t.Fatalf("unexpected replay: %d %s", code, out)
+	}
+	if code, _ := ctl("", "get", "k"); code != 2 {
+		t.Fatalf("a missing key should exit with 2, got %d", code)
+	}
+	if code, _ := ctl("", "nope"); code != 1 {
+		t.Fatalf("an unknown command should exit with 1, got %d", code)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+The package exposes a cache.Cache to operators over HTTP, and the client scripts use to drive it:
+
+	GET /keys/{key}: Returns the value and the remaining TTL of a key, 404 on a miss.
+	PUT /keys/{key}?ttl=1m: Sets a key to the JSON value of the body, with the default TTL when ttl is missing.
+	DELETE /keys/{key}: Deletes a key.
+	GET /stats: Returns the cache.Stats of the cache.
+	POST /flush: Deletes all the keys.
+	GET /snapshot: Streams a snapshot of the cache, when WithSnapshot is set.
+	GET /health: Returns 200 when HealthCheck passes, 503 otherwise.
+
+Values cross the API as JSON, a value set through it is stored as decoded by encoding/json. WithToken
+requires a bearer token on every request. Client.Replay applies a command log, JSON lines of Command,
+e.g. one the application appends to next to its writes.
+*/
+
+package admin
+
+import (
+	"cache/src/cache"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Option func(h *Handler)
+
+// WithToken requires the header "Authorization: Bearer token" on every request
+func WithToken(token string) Option {
+	return func(h *Handler) {
+		h.token = token
+	}
+}
+
+// WithSnapshot serves GET /snapshot with save, e.g. the Save method of a local_cache.Cache
+func WithSnapshot(save func(w io.Writer) error) Option {
+	return func(h *Handler) {
+		h.save = save
+	}
+}
+
+// Value is the body of GET /keys/{key}
+type Value struct {
+	Value any `json:"value"`
+	// TTL is the remaining time to live, empty for a key without expiration
+	TTL string `json:"ttl,omitempty"`
+}
+
+type Handler struct {
+	c     cache.Cache
+	token string
+	save  func(w io.Writer) error
+	mux   *http.ServeMux
+}
+
+// New returns the admin API of c, mount it on a listener only operators reach
+func New(c cache.Cache, opts ...Option) *Handler {
+	h := &Handler{c: c, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("/keys/", h.key)
+	h.mux.HandleFunc("/stats", h.stats)
+	h.mux.HandleFunc("/flush", h.flush)
+	h.mux.HandleFunc("/snapshot", h.snapshot)
+	h.mux.HandleFunc("/health", h.health)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) key(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/keys/")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		val, err := h.c.Get(ctx, key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res := Value{Value: val}
+		if ttl, err := h.c.TTL(ctx, key); err == nil && ttl >= 0 {
+			res.TTL = ttl.String()
+		}
+		writeJSON(w, res)
+	case http.MethodPut:
+		ttl := cache.DefaultExpire
+		if s := r.URL.Query().Get("ttl"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, "bad ttl: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		var val any
+		if err := json.NewDecoder(r.Body).Decode(&val); err != nil {
+			http.Error(w, "bad value: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.c.Set(ctx, key, val, ttl); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := h.c.Delete(ctx, key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	s, err := h.c.Stats(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, s)
+}
+
+func (h *Handler) flush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.c.Flush(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) snapshot(w http.ResponseWriter, r *http.Request) {
+	if h.save == nil {
+		http.Error(w, "snapshots are not enabled", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// the status is already sent when save fails midway, the client sees a truncated snapshot
+	_ = h.save(w)
+}
+
+func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
+	if err := h.c.HealthCheck(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cache.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
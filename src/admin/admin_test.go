@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"bytes"
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmin(t *testing.T) {
+	lc := local_cache.NewCache(time.Minute, 0)
+	srv := httptest.NewServer(New(cache.NewLocal(lc), WithToken("t"), WithSnapshot(lc.Save)))
+	defer srv.Close()
+	ctx := context.Background()
+
+	if _, err := (&Client{BaseURL: srv.URL}).Stats(ctx); err == nil {
+		t.Fatal("a request without the token should be rejected")
+	}
+	c := &Client{BaseURL: srv.URL, Token: "t"}
+	if err := c.Set(ctx, "a/b", map[string]any{"n": 1.0}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.Get(ctx, "a/b")
+	if err != nil || v.Value.(map[string]any)["n"] != 1.0 || !strings.HasPrefix(v.TTL, "59m") {
+		t.Fatalf("unexpected value: %+v %v", v, err)
+	}
+	if err := c.Delete(ctx, "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "a/b"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("a deleted key should be missing, got %v", err)
+	}
+
+	log := `{"op":"set","key":"x","value":"1"}
+{"op":"set","key":"y","value":2,"ttl":"1m"}
+
+{"op":"delete","key":"x"}
+{"op":"bogus"}
+{"op":"set","key":"z","value":3}
+`
+	n, err := c.Replay(ctx, strings.NewReader(log))
+	if n != 3 || err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Fatalf("the replay should stop at the bad line, got %d %v", n, err)
+	}
+	if s, err := c.Stats(ctx); err != nil || s.Items != 1 {
+		t.Fatalf("unexpected stats: %+v %v", s, err)
+	}
+
+	var snap bytes.Buffer
+	if err := c.Snapshot(ctx, &snap); err != nil {
+		t.Fatal(err)
+	}
+	restored := local_cache.NewCache(time.Minute, 0)
+	if err := restored.Load(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := restored.Get("y"); !ok || v != 2.0 {
+		t.Fatalf("the snapshot should hold y, got %v %v", v, ok)
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := c.Stats(ctx); s.Items != 0 {
+		t.Fatalf("the cache should be empty after a flush, got %+v", s)
+	}
+}
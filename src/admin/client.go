@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"cache/src/cache"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Command is one line of a command log, Op is "set", "delete" or "flush"
+type Command struct {
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value any    `json:"value,omitempty"`
+	// TTL is a time.Duration string, empty for the default TTL
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Client calls the admin API served at a base URL
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		if res.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/keys/") {
+			return nil, cache.ErrNotFound
+		}
+		return nil, fmt.Errorf("admin: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+func (c *Client) call(ctx context.Context, method, path string, body io.Reader, out any) error {
+	res, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Get returns the value and the TTL of key, cache.ErrNotFound on a miss
+func (c *Client) Get(ctx context.Context, key string) (Value, error) {
+	var v Value
+	err := c.call(ctx, http.MethodGet, "/keys/"+url.PathEscape(key), nil, &v)
+	return v, err
+}
+
+// Set sets key to val for ttl, cache.DefaultExpire uses the default TTL of the cache
+func (c *Client) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	body, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	path := "/keys/" + url.PathEscape(key)
+	if ttl != cache.DefaultExpire {
+		path += "?ttl=" + ttl.String()
+	}
+	return c.call(ctx, http.MethodPut, path, bytes.NewReader(body), nil)
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.call(ctx, http.MethodDelete, "/keys/"+url.PathEscape(key), nil, nil)
+}
+
+func (c *Client) Stats(ctx context.Context) (cache.Stats, error) {
+	var s cache.Stats
+	err := c.call(ctx, http.MethodGet, "/stats", nil, &s)
+	return s, err
+}
+
+func (c *Client) Flush(ctx context.Context) error {
+	return c.call(ctx, http.MethodPost, "/flush", nil, nil)
+}
+
+// Snapshot writes a snapshot of the cache to w
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) error {
+	res, err := c.do(ctx, http.MethodGet, "/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// Replay applies the commands of a log read from r in order, it stops at the first failing command
+// and returns the number of commands applied
+func (c *Client) Replay(ctx context.Context, r io.Reader) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	n := 0
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var cmd Command
+		if err := json.Unmarshal(sc.Bytes(), &cmd); err != nil {
+			return n, fmt.Errorf("admin: line %d: %w", line, err)
+		}
+		if err := c.apply(ctx, cmd); err != nil {
+			return n, fmt.Errorf("admin: line %d: %w", line, err)
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+func (c *Client) apply(ctx context.Context, cmd Command) error {
+	switch cmd.Op {
+	case "set":
+		ttl := cache.DefaultExpire
+		if cmd.TTL != "" {
+			d, err := time.ParseDuration(cmd.TTL)
+			if err != nil {
+				return err
+			}
+			ttl = d
+		}
+		return c.Set(ctx, cmd.Key, cmd.Value, ttl)
+	case "delete":
+		return c.Delete(ctx, cmd.Key)
+	case "flush":
+		return c.Flush(ctx)
+	default:
+		return errors.New("unknown op " + cmd.Op)
+	}
+}
@@ -0,0 +1,136 @@
+package bytecache
+
+/*
+The arena stores the []byte values in slabs of a fixed size instead of one allocation each: the GC has
+a few large pointer-free objects to account for rather than millions of small ones, and with Mmap the
+slabs are outside of the Go heap altogether, so multi-GB caches don't inflate the heap target and the
+GC pauses. Keys and metadata stay in the map. Values are appended to the last slab; deleted and
+overwritten ones leave garbage that is reclaimed by copying the live values into new slabs once more
+than half of the arena is garbage. Values larger than a slab are allocated on the heap as usual.
+*/
+
+// ArenaConfig configures WithArena
+type ArenaConfig struct {
+	// SlabSize is the size of the slabs, 1MB by default; larger values are not stored in the arena
+	SlabSize int
+	// Mmap maps the slabs outside of the Go heap, on unix systems; elsewhere it has no effect
+	Mmap bool
+}
+
+// WithArena stores the []byte values in an arena of slabs, see ArenaConfig
+func WithArena(cfg ArenaConfig) Option {
+	return func(c *cache) {
+		if cfg.SlabSize <= 0 {
+			cfg.SlabSize = 1 << 20
+		}
+		c.arena = &arena{slabSize: cfg.SlabSize, mmap: cfg.Mmap}
+	}
+}
+
+// ArenaStats describes the arena, see Cache.ArenaStats
+type ArenaStats struct {
+	Slabs int
+	// Used is the bytes of the slabs written, Live the bytes of the values still referenced
+	Used int
+	Live int
+	// Compactions counts the times the live values were copied into new slabs
+	Compactions uint64
+}
+
+// ArenaStats returns the state of the arena, zero without WithArena
+func (c *cache) ArenaStats() ArenaStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.arena == nil {
+		return ArenaStats{}
+	}
+	return ArenaStats{Slabs: len(c.arena.slabs), Used: c.arena.used(), Live: c.arena.live, Compactions: c.arena.compactions}
+}
+
+// slabRef locates a value in the arena
+type slabRef struct {
+	slab uint32
+	off  uint32
+	n    uint32
+}
+
+type arena struct {
+	slabSize    int
+	mmap        bool
+	slabs       [][]byte
+	off         int
+	live        int
+	compactions uint64
+}
+
+// alloc copies v, at most slabSize bytes, into the arena
+func (a *arena) alloc(v []byte) slabRef {
+	if len(a.slabs) == 0 || a.off+len(v) > a.slabSize {
+		a.slabs = append(a.slabs, a.newSlab())
+		a.off = 0
+	}
+	r := slabRef{slab: uint32(len(a.slabs) - 1), off: uint32(a.off), n: uint32(len(v))}
+	copy(a.slabs[r.slab][a.off:], v)
+	a.off += len(v)
+	a.live += len(v)
+	return r
+}
+
+func (a *arena) bytes(r slabRef) []byte {
+	return a.slabs[r.slab][r.off : r.off+r.n : r.off+r.n]
+}
+
+func (a *arena) free(r slabRef) {
+	a.live -= int(r.n)
+}
+
+// used returns the bytes of the slabs before the write offset, the tail of a full slab included
+func (a *arena) used() int {
+	if len(a.slabs) == 0 {
+		return 0
+	}
+	return (len(a.slabs)-1)*a.slabSize + a.off
+}
+
+// sparse reports whether more than half of the arena, and more than a slab, is garbage
+func (a *arena) sparse() bool {
+	garbage := a.used() - a.live
+	return garbage > a.slabSize && garbage > a.used()/2
+}
+
+func (a *arena) reset() {
+	a.slabs, a.off, a.live = nil, 0, 0
+}
+
+func (a *arena) newSlab() []byte {
+	if a.mmap {
+		if b, err := mmap(a.slabSize); err == nil {
+			return b
+		}
+	}
+	return make([]byte, a.slabSize)
+}
+
+// release unmaps the slabs of an mmapped arena, the heap ones are left to the GC
+func (a *arena) release(slabs [][]byte) {
+	if !a.mmap {
+		return
+	}
+	for _, b := range slabs {
+		munmap(b)
+	}
+}
+
+// compact copies the live values into new slabs and releases the old ones, the caller holds c.lock
+func (c *cache) compact() {
+	old := c.arena.slabs
+	c.arena.reset()
+	for k, e := range c.items {
+		if e.kind == kindArena {
+			e.ref = c.arena.alloc(old[e.ref.slab][e.ref.off : e.ref.off+e.ref.n])
+			c.items[k] = e
+		}
+	}
+	c.arena.release(old)
+	c.arena.compactions++
+}
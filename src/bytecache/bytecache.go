@@ -0,0 +1,261 @@
+/*
+The package provides a cache of string and []byte values for serialized blobs written at high rates.
+Unlike local_cache, whose items hold their value in an interface, it stores the values in typed
+fields, so the Sets don't box them: SetString and the Gets don't allocate, SetBytes only allocates
+its copy of the value, see the benchmarks:
+
+	SetString, GetString: Sets and gets a string value.
+	SetBytes, GetBytes: Sets a copy of a []byte value, and gets it without copying.
+	AppendBytes: Appends a value to a buffer, the way to read the values of the arena without allocating.
+	WithArena: Stores the []byte values in large slabs, on the heap or mmapped, instead of one allocation each.
+	SegmentCache: A cache of fixed memory storing the keys and values in ring buffers, see NewSegmentCache.
+	Delete: Deletes an item.
+	DeleteExpired: Deletes the expired items, the janitor calls it every cleanup interval.
+	Len, Flush: Returns the number of items, deletes all items.
+
+Read a value the way it was written: GetString on a []byte value, or GetBytes on a string one,
+converts and allocates. Expirations have nanosecond precision.
+*/
+
+package bytecache
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	NoExpire time.Duration = -1
+
+	DefaultExpire time.Duration = 0
+)
+
+type Cache struct {
+	*cache
+}
+
+type Option func(c *cache)
+
+type cache struct {
+	defaultExpire time.Duration
+	lock          sync.RWMutex
+	items         map[string]entry
+	arena         *arena
+	stop          chan struct{}
+}
+
+type kind uint8
+
+const (
+	kindString kind = iota
+	kindBytes
+	kindArena
+)
+
+// entry holds a string, a []byte or a value in the arena, kind tells which
+type entry struct {
+	str   string
+	bytes []byte
+	ref   slabRef
+	kind  kind
+	// expire is the unix nano expiration, 0 for none
+	expire int64
+}
+
+// New returns a cache whose items expire after defaultExpiration, never when it is <= 0, cleaned up
+// every cleanupInterval when it is > 0
+func New(defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Cache {
+	if defaultExpiration <= 0 {
+		defaultExpiration = NoExpire
+	}
+	c := &cache{defaultExpire: defaultExpiration, items: make(map[string]entry)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	C := &Cache{c}
+	if cleanupInterval > 0 {
+		c.stop = make(chan struct{})
+		go c.runJanitor(cleanupInterval)
+	}
+	if cleanupInterval > 0 || c.arena != nil {
+		runtime.SetFinalizer(C, func(C *Cache) { C.Close() })
+	}
+	return C
+}
+
+// Close stops the janitor and releases the arena, the cache must not be used afterwards
+func (c *cache) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	if c.arena != nil {
+		c.arena.release(c.arena.slabs)
+		c.arena.slabs = nil
+		c.items = make(map[string]entry)
+	}
+}
+
+func (c *cache) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.DeleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *cache) expireTime(d time.Duration) int64 {
+	if d == DefaultExpire {
+		d = c.defaultExpire
+	}
+	if d > 0 {
+		return time.Now().Add(d).UnixNano()
+	}
+	return 0
+}
+
+func (c *cache) SetString(k, v string, d time.Duration) {
+	e := entry{str: v, expire: c.expireTime(d)}
+	c.lock.Lock()
+	c.put(k, e)
+	c.lock.Unlock()
+}
+
+// SetBytes stores a copy of v, it may be reused by the caller
+func (c *cache) SetBytes(k string, v []byte, d time.Duration) {
+	expire := c.expireTime(d)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.arena != nil && len(v) <= c.arena.slabSize {
+		// removed before the allocation, a compaction only moves the values of c.items
+		c.remove(k)
+		c.items[k] = entry{ref: c.arena.alloc(v), kind: kindArena, expire: expire}
+		return
+	}
+	c.put(k, entry{bytes: append(make([]byte, 0, len(v)), v...), kind: kindBytes, expire: expire})
+}
+
+func (c *cache) GetString(k string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	e, ok := c.get(k)
+	switch {
+	case !ok:
+		return "", false
+	case e.kind == kindString:
+		return e.str, true
+	}
+	return string(c.bytesOf(e)), true
+}
+
+// GetBytes returns the value of k, the caller must not modify it; the values of the arena are copied,
+// read them with AppendBytes to reuse a buffer
+func (c *cache) GetBytes(k string) ([]byte, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	e, ok := c.get(k)
+	switch {
+	case !ok:
+		return nil, false
+	case e.kind == kindString:
+		return []byte(e.str), true
+	case e.kind == kindArena:
+		return append([]byte(nil), c.bytesOf(e)...), true
+	}
+	return e.bytes, true
+}
+
+// AppendBytes appends the value of k to dst
+func (c *cache) AppendBytes(dst []byte, k string) ([]byte, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	e, ok := c.get(k)
+	switch {
+	case !ok:
+		return dst, false
+	case e.kind == kindString:
+		return append(dst, e.str...), true
+	}
+	return append(dst, c.bytesOf(e)...), true
+}
+
+// get returns the unexpired entry of k, the caller holds c.lock
+func (c *cache) get(k string) (entry, bool) {
+	e, ok := c.items[k]
+	if !ok || e.expire > 0 && time.Now().UnixNano() > e.expire {
+		return entry{}, false
+	}
+	return e, true
+}
+
+// bytesOf returns the []byte value of e, in the arena or not, the caller holds c.lock
+func (c *cache) bytesOf(e entry) []byte {
+	if e.kind == kindArena {
+		return c.arena.bytes(e.ref)
+	}
+	return e.bytes
+}
+
+// put stores e under k, freeing the value it replaces, the caller holds c.lock
+func (c *cache) put(k string, e entry) {
+	c.remove(k)
+	c.items[k] = e
+}
+
+// remove deletes k, freeing its value in the arena and compacting the arena when it is mostly
+// garbage; the caller holds c.lock
+func (c *cache) remove(k string) {
+	e, ok := c.items[k]
+	if !ok {
+		return
+	}
+	delete(c.items, k)
+	if e.kind == kindArena {
+		c.arena.free(e.ref)
+		if c.arena.sparse() {
+			c.compact()
+		}
+	}
+}
+
+func (c *cache) Delete(k string) {
+	c.lock.Lock()
+	c.remove(k)
+	c.lock.Unlock()
+}
+
+func (c *cache) DeleteExpired() {
+	now := time.Now().UnixNano()
+	c.lock.Lock()
+	for k, e := range c.items {
+		if e.expire > 0 && now > e.expire {
+			c.remove(k)
+		}
+	}
+	c.lock.Unlock()
+}
+
+// Len returns the number of items, expired ones not yet deleted included
+func (c *cache) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.items)
+}
+
+func (c *cache) Flush() {
+	c.lock.Lock()
+	c.items = make(map[string]entry)
+	if c.arena != nil {
+		c.arena.release(c.arena.slabs)
+		c.arena.reset()
+	}
+	c.lock.Unlock()
+}
@@ -0,0 +1,241 @@
+package bytecache
+
+import (
+	"cache/src/local_cache"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := New(time.Minute, 0)
+	c.SetString("name", "will", DefaultExpire)
+	buf := []byte("blob")
+	c.SetBytes("blob", buf, DefaultExpire)
+	buf[0] = 'X'
+	if v, ok := c.GetString("name"); !ok || v != "will" {
+		t.Fatalf("unexpected value: %q %v", v, ok)
+	}
+	if v, ok := c.GetBytes("blob"); !ok || string(v) != "blob" {
+		t.Fatalf("SetBytes should store a copy, got %q %v", v, ok)
+	}
+	if v, _ := c.GetString("blob"); v != "blob" {
+		t.Fatalf("a []byte value should be readable as a string, got %q", v)
+	}
+	if v, _ := c.GetBytes("name"); string(v) != "will" {
+		t.Fatalf("a string value should be readable as []byte, got %q", v)
+	}
+	c.SetString("short", "lived", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.GetString("short"); ok {
+		t.Fatal("the item should have expired")
+	}
+	c.DeleteExpired()
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 items, got %d", c.Len())
+	}
+	c.Delete("name")
+	if _, ok := c.GetString("name"); ok {
+		t.Fatal("the item should be deleted")
+	}
+	c.Flush()
+	if c.Len() != 0 {
+		t.Fatalf("expected no items after Flush, got %d", c.Len())
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		c.SetString("name", "will", DefaultExpire)
+		_, _ = c.GetString("name")
+		_, _ = c.GetBytes("blob")
+	}); n != 0 {
+		t.Fatalf("SetString and the Gets should not allocate, got %v allocs", n)
+	}
+}
+
+func TestArena(t *testing.T) {
+	for _, mmap := range []bool{false, true} {
+		c := New(time.Minute, 0, WithArena(ArenaConfig{SlabSize: 1024, Mmap: mmap}))
+		v := make([]byte, 100)
+		for i := 0; i < 50; i++ {
+			v[0] = byte(i)
+			c.SetBytes(strconv.Itoa(i), v, DefaultExpire)
+		}
+		if s := c.ArenaStats(); s.Slabs != 5 || s.Live != 5000 {
+			t.Fatalf("50 values of 100 bytes should fill 5 slabs of 1KB, got %+v", s)
+		}
+		for i := 0; i < 40; i++ {
+			c.Delete(strconv.Itoa(i))
+		}
+		if s := c.ArenaStats(); s.Compactions == 0 || s.Live != 1000 || s.Slabs > 2 {
+			t.Fatalf("the arena should be compacted, got %+v", s)
+		}
+		for i := 40; i < 50; i++ {
+			if b, ok := c.GetBytes(strconv.Itoa(i)); !ok || len(b) != 100 || b[0] != byte(i) {
+				t.Fatalf("the value of %d should survive the compaction, got %v %v", i, b, ok)
+			}
+		}
+		c.SetBytes("big", make([]byte, 2048), DefaultExpire)
+		if b, _ := c.AppendBytes(nil, "big"); len(b) != 2048 {
+			t.Fatalf("a value larger than a slab should be stored, got %d bytes", len(b))
+		}
+		buf := make([]byte, 0, 100)
+		if n := testing.AllocsPerRun(100, func() {
+			c.SetBytes("49", v, DefaultExpire)
+			buf, _ = c.AppendBytes(buf[:0], "49")
+		}); n != 0 {
+			t.Fatalf("SetBytes and AppendBytes should not allocate in the arena, got %v allocs", n)
+		}
+		c.Flush()
+		if s := c.ArenaStats(); s.Slabs != 0 || c.Len() != 0 {
+			t.Fatalf("Flush should release the arena, got %+v", s)
+		}
+		c.Close()
+	}
+}
+
+func TestSegmentCache(t *testing.T) {
+	c := NewSegmentCache(4<<10, 4, NoExpire)
+	v := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		v[0] = byte(i)
+		if err := c.Set(strconv.Itoa(i), v, DefaultExpire); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every segment of 1KB holds 8 entries of 24+2+100 bytes at most, the oldest ones were overwritten
+	s := c.Stats()
+	if s.Entries == 0 || s.Entries > 4*8 || s.Evictions != uint64(100-s.Entries) {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if _, ok := c.Get("0"); ok {
+		t.Fatal("the oldest entry should be overwritten")
+	}
+	b, ok := c.Get("99")
+	if !ok || len(b) != 100 || b[0] != 99 {
+		t.Fatalf("the newest entry should be found, got %v %v", b, ok)
+	}
+	_ = c.Set("99", []byte("new"), DefaultExpire)
+	if b, _ := c.Get("99"); string(b) != "new" {
+		t.Fatalf("the entry should be overwritten, got %q", b)
+	}
+	c.Delete("99")
+	if _, ok := c.Get("99"); ok {
+		t.Fatal("the entry should be deleted")
+	}
+	_ = c.Set("short", v, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get("short"); ok {
+		t.Fatal("the entry should have expired")
+	}
+	if err := c.Set("big", make([]byte, 512), DefaultExpire); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	// entries wrapping around the end of their segment
+	for i := 0; i < 1000; i++ {
+		k := "wrap" + strconv.Itoa(i)
+		_ = c.Set(k, []byte(k), DefaultExpire)
+		if b, ok := c.Get(k); !ok || string(b) != k {
+			t.Fatalf("unexpected value of %s: %q %v", k, b, ok)
+		}
+	}
+	buf := make([]byte, 0, 16)
+	if n := testing.AllocsPerRun(100, func() {
+		_ = c.Set("wrap1", v[:10], DefaultExpire)
+		buf, _ = c.Append(buf[:0], "wrap1")
+	}); n != 0 {
+		t.Fatalf("Set and Append should not allocate, got %v allocs", n)
+	}
+}
+
+var keys = func() []string {
+	res := make([]string, 1024)
+	for i := range res {
+		res[i] = "key:" + strconv.Itoa(i)
+	}
+	return res
+}()
+
+func BenchmarkSetString(b *testing.B) {
+	c := New(time.Minute, 0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		k := keys[i%len(keys)]
+		c.SetString(k, k, DefaultExpire)
+	}
+}
+
+func BenchmarkGetString(b *testing.B) {
+	c := New(time.Minute, 0)
+	for _, k := range keys {
+		c.SetString(k, "value", DefaultExpire)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = c.GetString(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkSetBytes(b *testing.B) {
+	c := New(time.Minute, 0)
+	v := make([]byte, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.SetBytes(keys[i%len(keys)], v, DefaultExpire)
+	}
+}
+
+func BenchmarkGetBytes(b *testing.B) {
+	c := New(time.Minute, 0)
+	v := make([]byte, 256)
+	for _, k := range keys {
+		c.SetBytes(k, v, DefaultExpire)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = c.GetBytes(keys[i%len(keys)])
+	}
+}
+
+// BenchmarkLocalCacheSetString is BenchmarkSetString on local_cache, for comparison
+func BenchmarkLocalCacheSetString(b *testing.B) {
+	c := local_cache.NewCache(time.Minute, 0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		k := keys[i%len(keys)]
+		_ = c.Set(k, k, local_cache.DefaultExpire)
+	}
+}
+
+func BenchmarkSetBytesArena(b *testing.B) {
+	c := New(time.Minute, 0, WithArena(ArenaConfig{Mmap: true}))
+	defer c.Close()
+	v := make([]byte, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.SetBytes(keys[i%len(keys)], v, DefaultExpire)
+	}
+}
+
+func BenchmarkSegmentCacheSet(b *testing.B) {
+	c := NewSegmentCache(64<<20, 0, NoExpire)
+	v := make([]byte, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = c.Set(keys[i%len(keys)], v, DefaultExpire)
+	}
+}
+
+func BenchmarkSegmentCacheAppend(b *testing.B) {
+	c := NewSegmentCache(64<<20, 0, NoExpire)
+	v := make([]byte, 256)
+	for _, k := range keys {
+		_ = c.Set(k, v, DefaultExpire)
+	}
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, _ = c.Append(buf[:0], keys[i%len(keys)])
+	}
+}
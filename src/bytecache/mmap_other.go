@@ -0,0 +1,13 @@
+//go:build !unix
+
+package bytecache
+
+import (
+	"errors"
+)
+
+func mmap(size int) ([]byte, error) {
+	return nil, errors.New("bytecache: mmap not supported")
+}
+
+func munmap(b []byte) {}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package bytecache
+
+import (
+	"syscall"
+)
+
+func mmap(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+// munmap unmaps b, it ignores the slabs allocated on the heap when mmap failed
+func munmap(b []byte) {
+	_ = syscall.Munmap(b)
+}
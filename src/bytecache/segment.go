@@ -0,0 +1,213 @@
+package bytecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+/*
+SegmentCache is the freecache way of storing byte values: the memory is fixed when it is created and
+split into segments, each a ring buffer the entries are appended to, with a map from key hashes to
+offsets. When a segment wraps, the oldest entries are overwritten, FIFO, so nothing has to be freed;
+the buffers hold no pointers and the maps neither, so the GC has nothing to scan whatever the number
+of entries. Deleted and expired entries keep their space until the segment wraps over them.
+
+Two keys with the same 64-bit hash can't be cached together in a segment, the later Set wins.
+*/
+
+var ErrTooLarge = errors.New("bytecache: entry larger than a quarter of a segment")
+
+// headerSize is the bytes of the header of the entries: key length, value length, expiration, hash
+const headerSize = 24
+
+// SegmentCache is a fixed-size cache of []byte values, see NewSegmentCache
+type SegmentCache struct {
+	seed          maphash.Seed
+	defaultExpire time.Duration
+	segments      []segment
+}
+
+type segment struct {
+	mu  sync.Mutex
+	buf []byte
+	// head and tail are the offsets, growing without wrapping, of the end and of the oldest entry
+	head, tail int64
+	index      map[uint64]int64
+	evictions  uint64
+}
+
+// SegmentStats describes a SegmentCache
+type SegmentStats struct {
+	Entries int
+	// Evictions counts the live entries overwritten when their segment wrapped
+	Evictions uint64
+}
+
+// NewSegmentCache returns a cache of size bytes split into segments, 256 when segments <= 0, of 1KB at
+// least; its items expire after defaultExpiration, never when it is <= 0
+func NewSegmentCache(size, segments int, defaultExpiration time.Duration) *SegmentCache {
+	if segments <= 0 {
+		segments = 256
+	}
+	if size < segments<<10 {
+		size = segments << 10
+	}
+	if defaultExpiration <= 0 {
+		defaultExpiration = NoExpire
+	}
+	c := &SegmentCache{seed: maphash.MakeSeed(), defaultExpire: defaultExpiration, segments: make([]segment, segments)}
+	for i := range c.segments {
+		c.segments[i].buf = make([]byte, size/segments)
+		c.segments[i].index = make(map[uint64]int64)
+	}
+	return c
+}
+
+func (c *SegmentCache) segment(k string) (*segment, uint64) {
+	h := maphash.String(c.seed, k)
+	return &c.segments[h%uint64(len(c.segments))], h
+}
+
+// Set stores a copy of v, it returns ErrTooLarge when the entry takes more than a quarter of a segment
+func (c *SegmentCache) Set(k string, v []byte, d time.Duration) error {
+	if d == DefaultExpire {
+		d = c.defaultExpire
+	}
+	var expire int64
+	if d > 0 {
+		expire = time.Now().Add(d).UnixNano()
+	}
+	s, h := c.segment(k)
+	size := int64(headerSize + len(k) + len(v))
+	if size > int64(len(s.buf)/4) {
+		return ErrTooLarge
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(k)))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(v)))
+	binary.LittleEndian.PutUint64(header[8:], uint64(expire))
+	binary.LittleEndian.PutUint64(header[16:], h)
+	s.mu.Lock()
+	s.reclaim(s.head + size - int64(len(s.buf)))
+	off := s.head
+	s.write(off, header[:])
+	s.writeString(off+headerSize, k)
+	s.write(off+headerSize+int64(len(k)), v)
+	s.head += size
+	s.index[h] = off
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns a copy of the value of k
+func (c *SegmentCache) Get(k string) ([]byte, bool) {
+	return c.Append(nil, k)
+}
+
+// Append appends the value of k to dst, without allocating when dst is large enough
+func (c *SegmentCache) Append(dst []byte, k string) ([]byte, bool) {
+	s, h := c.segment(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off, ok := s.index[h]
+	if !ok {
+		return dst, false
+	}
+	klen, vlen, expire, _ := s.header(off)
+	if expire > 0 && time.Now().UnixNano() > expire {
+		delete(s.index, h)
+		return dst, false
+	}
+	if int(klen) != len(k) || !s.equal(off+headerSize, k) {
+		return dst, false
+	}
+	return s.appendTo(dst, off+headerSize+int64(klen), int(vlen)), true
+}
+
+// Delete deletes k, its space is reclaimed when the segment wraps
+func (c *SegmentCache) Delete(k string) {
+	s, h := c.segment(k)
+	s.mu.Lock()
+	if off, ok := s.index[h]; ok {
+		if klen, _, _, _ := s.header(off); int(klen) == len(k) && s.equal(off+headerSize, k) {
+			delete(s.index, h)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// Stats returns the entries, expired ones not yet overwritten included, and the evictions
+func (c *SegmentCache) Stats() SegmentStats {
+	var res SegmentStats
+	for i := range c.segments {
+		s := &c.segments[i]
+		s.mu.Lock()
+		res.Entries += len(s.index)
+		res.Evictions += s.evictions
+		s.mu.Unlock()
+	}
+	return res
+}
+
+// reclaim drops the entries starting before until, the caller holds s.mu
+func (s *segment) reclaim(until int64) {
+	for s.tail < until {
+		klen, vlen, _, h := s.header(s.tail)
+		if off, ok := s.index[h]; ok && off == s.tail {
+			delete(s.index, h)
+			s.evictions++
+		}
+		s.tail += headerSize + int64(klen) + int64(vlen)
+	}
+}
+
+// header reads the header of the entry at off
+func (s *segment) header(off int64) (klen, vlen uint32, expire int64, h uint64) {
+	var b [headerSize]byte
+	s.read(b[:], off)
+	return binary.LittleEndian.Uint32(b[0:]), binary.LittleEndian.Uint32(b[4:]),
+		int64(binary.LittleEndian.Uint64(b[8:])), binary.LittleEndian.Uint64(b[16:])
+}
+
+// write copies b to the buffer at off, wrapping around its end
+func (s *segment) write(off int64, b []byte) {
+	pos := int(off % int64(len(s.buf)))
+	n := copy(s.buf[pos:], b)
+	copy(s.buf, b[n:])
+}
+
+func (s *segment) writeString(off int64, str string) {
+	pos := int(off % int64(len(s.buf)))
+	n := copy(s.buf[pos:], str)
+	copy(s.buf, str[n:])
+}
+
+// read fills b from the buffer at off
+func (s *segment) read(b []byte, off int64) {
+	pos := int(off % int64(len(s.buf)))
+	n := copy(b, s.buf[pos:])
+	copy(b[n:], s.buf)
+}
+
+// appendTo appends the n bytes at off to dst
+func (s *segment) appendTo(dst []byte, off int64, n int) []byte {
+	pos := int(off % int64(len(s.buf)))
+	if pos+n <= len(s.buf) {
+		return append(dst, s.buf[pos:pos+n]...)
+	}
+	dst = append(dst, s.buf[pos:]...)
+	return append(dst, s.buf[:n-(len(s.buf)-pos)]...)
+}
+
+// equal reports whether the bytes at off are str
+func (s *segment) equal(off int64, str string) bool {
+	pos := int(off % int64(len(s.buf)))
+	if pos+len(str) <= len(s.buf) {
+		return string(s.buf[pos:pos+len(str)]) == str
+	}
+	n := len(s.buf) - pos
+	return string(s.buf[pos:]) == str[:n] && string(s.buf[:len(str)-n]) == str[n:]
+}
@@ -0,0 +1,167 @@
+/*
+The package defines a Cache interface shared by the local and the Redis-backed caches,
+so callers can depend on the interface and swap backends without code changes:
+
+	Set: Sets an item with a time to live, DefaultExpire and NoExpire follow local_cache.
+	Get: Gets an item, returns ErrNotFound on a miss.
+	Delete: Deletes an item.
+	TTL: Returns the remaining time to live, NoExpire for items without expiration.
+	Flush: Deletes all items.
+	Stats: Returns the hit/miss counters and the number of items.
+	HealthCheck: Returns an error when the backend is not ready to serve, for health endpoints.
+
+NewLocal and NewRedis adapt *local_cache.Cache and *redis_cache.Cache to the interface.
+*/
+
+package cache
+
+import (
+	"cache/src/local_cache"
+	"cache/src/redis_cache"
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	NoExpire = local_cache.NoExpire
+
+	DefaultExpire = local_cache.DefaultExpire
+)
+
+var ErrNotFound = errors.New("cache: item not found")
+
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	Items  int
+}
+
+type Cache interface {
+	Set(ctx context.Context, key string, val any, ttl time.Duration) error
+	Get(ctx context.Context, key string) (any, error)
+	Delete(ctx context.Context, key string) error
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Flush(ctx context.Context) error
+	Stats(ctx context.Context) (Stats, error)
+	HealthCheck(ctx context.Context) error
+}
+
+var (
+	_ Cache = (*localCache)(nil)
+	_ Cache = (*redisCache)(nil)
+)
+
+type localCache struct {
+	c *local_cache.Cache
+}
+
+// NewLocal adapts a local cache, ctx is only checked before each call since the local cache never blocks
+func NewLocal(c *local_cache.Cache) Cache {
+	return &localCache{c: c}
+}
+
+func (l *localCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	return l.c.SetCtx(ctx, key, val, ttl)
+}
+
+func (l *localCache) Get(ctx context.Context, key string) (any, error) {
+	val, ok, err := l.c.GetCtx(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return val, nil
+}
+
+func (l *localCache) Delete(ctx context.Context, key string) error {
+	return l.c.DeleteCtx(ctx, key)
+}
+
+func (l *localCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	ttl, ok := l.c.TTL(key)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return ttl, nil
+}
+
+func (l *localCache) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.c.Flush()
+	return nil
+}
+
+func (l *localCache) Stats(ctx context.Context) (Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return Stats{}, err
+	}
+	s := l.c.Stats()
+	return Stats{Hits: s.Hits, Misses: s.Misses, Items: s.Items}, nil
+}
+
+// HealthCheck reports a stalled janitor or a cache over its bounds, see local_cache HealthCheck
+func (l *localCache) HealthCheck(ctx context.Context) error {
+	return l.c.HealthCheck(ctx)
+}
+
+type redisCache struct {
+	c *redis_cache.Cache
+}
+
+func NewRedis(c *redis_cache.Cache) Cache {
+	return &redisCache{c: c}
+}
+
+func (r *redisCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	return r.c.Set(ctx, key, val, ttl)
+}
+
+func (r *redisCache) Get(ctx context.Context, key string) (any, error) {
+	val, ok, err := r.c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return val, nil
+}
+
+func (r *redisCache) Delete(ctx context.Context, key string) error {
+	return r.c.Delete(ctx, key)
+}
+
+func (r *redisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, ok, err := r.c.TTL(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return ttl, nil
+}
+
+func (r *redisCache) Flush(ctx context.Context) error {
+	return r.c.Flush(ctx)
+}
+
+func (r *redisCache) Stats(ctx context.Context) (Stats, error) {
+	s, err := r.c.Stats(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{Hits: s.Hits, Misses: s.Misses, Items: s.Items}, nil
+}
+
+func (r *redisCache) HealthCheck(ctx context.Context) error {
+	return r.c.HealthCheck(ctx)
+}
@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"cache/src/local_cache"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	var c Cache = NewLocal(local_cache.NewCache(time.Minute, 0))
+
+	if _, err := c.Get(ctx, "name"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := c.Set(ctx, "name", "will", DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "name"); err != nil || v != "will" {
+		t.Fatalf("unexpected get result %v %v", v, err)
+	}
+	if ttl, err := c.TTL(ctx, "name"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl %v %v", ttl, err)
+	}
+	if err := c.Set(ctx, "age", 13, NoExpire); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := c.TTL(ctx, "age"); ttl != NoExpire {
+		t.Fatalf("expected NoExpire, got %v", ttl)
+	}
+
+	s, err := c.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.HealthCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.Hits != 1 || s.Misses != 1 || s.Items != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	if err = c.Delete(ctx, "name"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.TTL(ctx, "name"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err = c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ = c.Stats(ctx); s.Items != 0 {
+		t.Fatalf("expected empty cache, got %d items", s.Items)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = c.Set(cctx, "name", "will", DefaultExpire); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, val any) { s.attrs[key] = val }
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+
+func (s *recordedSpan) End() { s.ended = true }
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordedSpan{name: name, attrs: map[string]any{}}
+	r.spans = append(r.spans, s)
+	return ctx, s
+}
+
+func TestWithTracing(t *testing.T) {
+	ctx := context.Background()
+	tr := &recordingTracer{}
+	c := WithTracing(NewLocal(local_cache.NewCache(time.Minute, 0)), tr)
+
+	_ = c.Set(ctx, "name", "will", DefaultExpire)
+	_, _ = c.Get(ctx, "name")
+	_, _ = c.Get(ctx, "missing")
+	load := TraceLoader(tr, "name", func(ctx context.Context) (any, error) { return nil, ErrNotFound })
+	_, _ = load(ctx)
+
+	if len(tr.spans) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(tr.spans))
+	}
+	for _, s := range tr.spans {
+		if !s.ended {
+			t.Fatalf("span %s not ended", s.name)
+		}
+	}
+	if s := tr.spans[1]; s.name != "cache.Get" || s.attrs[AttrHit] != true || s.attrs[AttrKey] != "name" {
+		t.Fatalf("unexpected hit span %+v", s)
+	}
+	if s := tr.spans[2]; s.attrs[AttrHit] != false || s.err != nil {
+		t.Fatalf("a miss should not be recorded as an error %+v", s)
+	}
+	if s := tr.spans[3]; s.name != "cache.Load" || s.err != ErrNotFound {
+		t.Fatalf("unexpected load span %+v", s)
+	}
+}
+
+// slowCache blocks every operation until ctx is done
+type slowCache struct {
+	Cache
+}
+
+func (s slowCache) Get(ctx context.Context, key string) (any, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s slowCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWithTimeouts(t *testing.T) {
+	c := WithTimeouts(slowCache{NewLocal(local_cache.NewCache(time.Minute, 0))}, 10*time.Millisecond, 0)
+	_, err := c.Get(context.Background(), "name")
+	var te *TimeoutError
+	if !errors.As(err, &te) || !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) || te.Op != "Get" {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(ctx, "name"); errors.Is(err, ErrTimeout) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("a canceled caller is not a timeout, got %v", err)
+	}
+	// no write timeout, the deadline of the caller applies
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Set(ctx, "name", "will", DefaultExpire); errors.Is(err, ErrTimeout) {
+		t.Fatalf("the caller deadline is not a timeout of the wrapper, got %v", err)
+	}
+	if err := c.Delete(context.Background(), "name"); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Timeouts(); s != (TimeoutStats{Get: 1}) {
+		t.Fatalf("unexpected timeout stats %+v", s)
+	}
+}
+
+// flakyCache fails the reads while readDown is set and the writes while writeDown is set
+type flakyCache struct {
+	Cache
+	readDown, writeDown *atomic.Bool
+}
+
+var errDown = errors.New("down")
+
+func (f flakyCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	if f.writeDown.Load() {
+		return errDown
+	}
+	return f.Cache.Set(ctx, key, val, ttl)
+}
+
+func (f flakyCache) Get(ctx context.Context, key string) (any, error) {
+	if f.readDown.Load() {
+		return nil, errDown
+	}
+	return f.Cache.Get(ctx, key)
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+	var readDown, writeDown atomic.Bool
+	primary := NewLocal(local_cache.NewCache(time.Minute, 0))
+	c := NewFallback(flakyCache{primary, &readDown, &writeDown}, NewLocal(local_cache.NewCache(time.Minute, 0)), WithRepair(time.Second))
+	waitStats := func(want FallbackStats) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for c.FallbackStats() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected stats %+v, want %+v", c.FallbackStats(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := c.Set(ctx, "name", "will", DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	_ = primary.Delete(ctx, "name")
+	if _, err := c.Get(ctx, "name"); err != ErrNotFound {
+		t.Fatalf("a miss of the primary is a miss, got %v", err)
+	}
+	readDown.Store(true)
+	writeDown.Store(true)
+	if v, err := c.Get(ctx, "name"); err != nil || v != "will" {
+		t.Fatalf("the secondary should serve the read, got %v %v", v, err)
+	}
+	waitStats(FallbackStats{Fallbacks: 1, RepairErrors: 1})
+	if _, err := c.Get(ctx, "other"); !errors.Is(err, errDown) {
+		t.Fatalf("a miss of the secondary should return the primary error, got %v", err)
+	}
+	if err := c.Set(ctx, "age", 13, DefaultExpire); err != nil {
+		t.Fatalf("a set reaching the secondary should succeed, got %v", err)
+	}
+	// the primary takes writes again but still fails reads
+	writeDown.Store(false)
+	if v, err := c.Get(ctx, "age"); err != nil || v != 13 {
+		t.Fatalf("the secondary should serve the read, got %v %v", v, err)
+	}
+	waitStats(FallbackStats{Fallbacks: 3, Repairs: 1, RepairErrors: 1})
+	if v, err := primary.Get(ctx, "age"); err != nil || v != 13 {
+		t.Fatalf("the primary should be repaired, got %v %v", v, err)
+	}
+}
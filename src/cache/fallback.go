@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+type FallbackOption func(f *FallbackCache)
+
+// WithRepair writes the values read from the secondary back to the primary in the background, each
+// write bounded by timeout, so the primary is warm again once it recovers
+func WithRepair(timeout time.Duration) FallbackOption {
+	return func(f *FallbackCache) {
+		f.repair = timeout
+	}
+}
+
+// WithFallbackOn decides which errors of the primary fall back to the secondary, every error but
+// ErrNotFound and the cancellation or deadline of the caller by default
+func WithFallbackOn(fn func(err error) bool) FallbackOption {
+	return func(f *FallbackCache) {
+		f.fallbackOn = fn
+	}
+}
+
+// FallbackStats counts the reads served by the secondary and the repairs of the primary
+type FallbackStats struct {
+	Fallbacks    uint64
+	Repairs      uint64
+	RepairErrors uint64
+}
+
+var _ Cache = (*FallbackCache)(nil)
+
+type FallbackCache struct {
+	primary    Cache
+	secondary  Cache
+	repair     time.Duration
+	fallbackOn func(err error) bool
+
+	fallbacks    atomic.Uint64
+	repairs      atomic.Uint64
+	repairErrors atomic.Uint64
+}
+
+// NewFallback chains primary and secondary, e.g. Redis and a local cache: reads go to the primary
+// and to the secondary when the primary fails, a miss of the primary is a miss. Set, Delete and Flush
+// go to both; Set only fails when both fail, Delete and Flush fail when either fails, since a
+// value left behind would be served later. HealthCheck passes while one of them is healthy
+func NewFallback(primary, secondary Cache, opts ...FallbackOption) *FallbackCache {
+	f := &FallbackCache{primary: primary, secondary: secondary}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+func (f *FallbackCache) FallbackStats() FallbackStats {
+	return FallbackStats{
+		Fallbacks:    f.fallbacks.Load(),
+		Repairs:      f.repairs.Load(),
+		RepairErrors: f.repairErrors.Load(),
+	}
+}
+
+// fallback reports whether err of the primary falls back to the secondary
+func (f *FallbackCache) fallback(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+		return false
+	}
+	if f.fallbackOn != nil && !f.fallbackOn(err) {
+		return false
+	}
+	f.fallbacks.Add(1)
+	return true
+}
+
+func (f *FallbackCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	err := f.primary.Set(ctx, key, val, ttl)
+	if err2 := f.secondary.Set(ctx, key, val, ttl); err2 == nil {
+		return nil
+	}
+	return err
+}
+
+func (f *FallbackCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := f.primary.Get(ctx, key)
+	if !f.fallback(ctx, err) {
+		return val, err
+	}
+	val, err2 := f.secondary.Get(ctx, key)
+	if err2 != nil {
+		if errors.Is(err2, ErrNotFound) {
+			// the secondary can't tell a miss, the primary failed
+			return nil, err
+		}
+		return nil, err2
+	}
+	if f.repair > 0 {
+		go f.repairKey(key, val)
+	}
+	return val, nil
+}
+
+// repairKey writes key back to the primary with the TTL it has in the secondary
+func (f *FallbackCache) repairKey(key string, val any) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.repair)
+	defer cancel()
+	ttl, err := f.secondary.TTL(ctx, key)
+	if err == nil {
+		if ttl == 0 {
+			// expiring right now
+			return
+		}
+		err = f.primary.Set(ctx, key, val, ttl)
+	}
+	if err != nil {
+		f.repairErrors.Add(1)
+		return
+	}
+	f.repairs.Add(1)
+}
+
+func (f *FallbackCache) Delete(ctx context.Context, key string) error {
+	err := f.primary.Delete(ctx, key)
+	if err2 := f.secondary.Delete(ctx, key); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (f *FallbackCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := f.primary.TTL(ctx, key)
+	if !f.fallback(ctx, err) {
+		return ttl, err
+	}
+	return f.secondary.TTL(ctx, key)
+}
+
+func (f *FallbackCache) Flush(ctx context.Context) error {
+	err := f.primary.Flush(ctx)
+	if err2 := f.secondary.Flush(ctx); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Stats returns the stats of the primary, of the secondary when the primary fails
+func (f *FallbackCache) Stats(ctx context.Context) (Stats, error) {
+	s, err := f.primary.Stats(ctx)
+	if !f.fallback(ctx, err) {
+		return s, err
+	}
+	return f.secondary.Stats(ctx)
+}
+
+func (f *FallbackCache) HealthCheck(ctx context.Context) error {
+	err := f.primary.HealthCheck(ctx)
+	if err == nil || f.secondary.HealthCheck(ctx) == nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTimeout matches every TimeoutError with errors.Is
+var ErrTimeout = errors.New("cache: operation timed out")
+
+// TimeoutError is returned by WithTimeouts when the deadline of an operation fired; it unwraps to
+// the error of the backend, usually context.DeadlineExceeded
+type TimeoutError struct {
+	Op    string
+	Key   string
+	Limit time.Duration
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("cache: %s timed out after %v: %v", e.Op, e.Limit, e.Err)
+	}
+	return fmt.Sprintf("cache: %s %q timed out after %v: %v", e.Op, e.Key, e.Limit, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Timeout reports true, like net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// TimeoutStats counts the timed out operations of WithTimeouts
+type TimeoutStats struct {
+	Get         uint64
+	Set         uint64
+	Delete      uint64
+	TTL         uint64
+	Flush       uint64
+	Stats       uint64
+	HealthCheck uint64
+}
+
+var _ Cache = (*TimeoutCache)(nil)
+
+type TimeoutCache struct {
+	c            Cache
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	get, set, del, ttl, flush, stats, health atomic.Uint64
+}
+
+// WithTimeouts wraps c so each operation runs under a deadline: getTimeout for Get, TTL, Stats and
+// HealthCheck, setTimeout for Set, Delete and Flush; 0 leaves the operations of its kind as they are.
+// A deadline of the caller shorter than the timeout is kept. The deadline only bounds backends that
+// honor ctx, such as Redis; the local cache never blocks
+func WithTimeouts(c Cache, getTimeout, setTimeout time.Duration) *TimeoutCache {
+	return &TimeoutCache{c: c, readTimeout: getTimeout, writeTimeout: setTimeout}
+}
+
+// Timeouts returns the number of timed out operations
+func (tc *TimeoutCache) Timeouts() TimeoutStats {
+	return TimeoutStats{
+		Get:         tc.get.Load(),
+		Set:         tc.set.Load(),
+		Delete:      tc.del.Load(),
+		TTL:         tc.ttl.Load(),
+		Flush:       tc.flush.Load(),
+		Stats:       tc.stats.Load(),
+		HealthCheck: tc.health.Load(),
+	}
+}
+
+// run calls fn under the timeout d, turning the expiry of that deadline into a TimeoutError
+func (tc *TimeoutCache) run(ctx context.Context, d time.Duration, op, key string, counter *atomic.Uint64, fn func(ctx context.Context) error) error {
+	if d <= 0 {
+		return fn(ctx)
+	}
+	tctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err := fn(tctx)
+	// the timeout fired, not the deadline or the cancellation of the caller
+	if err != nil && errors.Is(tctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		counter.Add(1)
+		return &TimeoutError{Op: op, Key: key, Limit: d, Err: err}
+	}
+	return err
+}
+
+func (tc *TimeoutCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	return tc.run(ctx, tc.writeTimeout, "Set", key, &tc.set, func(ctx context.Context) error {
+		return tc.c.Set(ctx, key, val, ttl)
+	})
+}
+
+func (tc *TimeoutCache) Get(ctx context.Context, key string) (any, error) {
+	var val any
+	err := tc.run(ctx, tc.readTimeout, "Get", key, &tc.get, func(ctx context.Context) (err error) {
+		val, err = tc.c.Get(ctx, key)
+		return err
+	})
+	return val, err
+}
+
+func (tc *TimeoutCache) Delete(ctx context.Context, key string) error {
+	return tc.run(ctx, tc.writeTimeout, "Delete", key, &tc.del, func(ctx context.Context) error {
+		return tc.c.Delete(ctx, key)
+	})
+}
+
+func (tc *TimeoutCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := tc.run(ctx, tc.readTimeout, "TTL", key, &tc.ttl, func(ctx context.Context) (err error) {
+		ttl, err = tc.c.TTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+func (tc *TimeoutCache) Flush(ctx context.Context) error {
+	return tc.run(ctx, tc.writeTimeout, "Flush", "", &tc.flush, tc.c.Flush)
+}
+
+func (tc *TimeoutCache) Stats(ctx context.Context) (Stats, error) {
+	var s Stats
+	err := tc.run(ctx, tc.readTimeout, "Stats", "", &tc.stats, func(ctx context.Context) (err error) {
+		s, err = tc.c.Stats(ctx)
+		return err
+	})
+	return s, err
+}
+
+func (tc *TimeoutCache) HealthCheck(ctx context.Context) error {
+	return tc.run(ctx, tc.readTimeout, "HealthCheck", "", &tc.health, tc.c.HealthCheck)
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts spans for cache operations. It mirrors the subset of the OpenTelemetry API
+// the wrapper needs, so the package doesn't depend on the SDK; an adapter over an otel
+// trace.Tracer is a few lines:
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, cache.Span) {
+//		ctx, span := t.tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is ended exactly once by the wrapper
+type Span interface {
+	SetAttribute(key string, val any)
+	RecordError(err error)
+	End()
+}
+
+// Span attributes set by WithTracing
+const (
+	AttrKey = "cache.key"
+	AttrHit = "cache.hit"
+	AttrTTL = "cache.ttl_ms"
+)
+
+// WithTracing wraps c so every operation emits a span named cache.<Op>, with the key and,
+// for Get, the hit/miss status; ErrNotFound is reported as a miss, not as an error
+func WithTracing(c Cache, t Tracer) Cache {
+	return &tracedCache{c: c, t: t}
+}
+
+// TraceLoader wraps a loader, e.g. the one passed to cacheaside.Fetch, in a cache.Load span
+func TraceLoader[T any](t Tracer, key string, loader func(ctx context.Context) (T, error)) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		ctx, span := t.Start(ctx, "cache.Load")
+		defer span.End()
+		span.SetAttribute(AttrKey, key)
+		val, err := loader(ctx)
+		if err != nil {
+			span.RecordError(err)
+		}
+		return val, err
+	}
+}
+
+type tracedCache struct {
+	c Cache
+	t Tracer
+}
+
+func (tc *tracedCache) start(ctx context.Context, op string, key string) (context.Context, Span) {
+	ctx, span := tc.t.Start(ctx, "cache."+op)
+	if key != "" {
+		span.SetAttribute(AttrKey, key)
+	}
+	return ctx, span
+}
+
+func end(span Span, err error) {
+	if err != nil && err != ErrNotFound {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func (tc *tracedCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	ctx, span := tc.start(ctx, "Set", key)
+	span.SetAttribute(AttrTTL, ttl.Milliseconds())
+	err := tc.c.Set(ctx, key, val, ttl)
+	end(span, err)
+	return err
+}
+
+func (tc *tracedCache) Get(ctx context.Context, key string) (any, error) {
+	ctx, span := tc.start(ctx, "Get", key)
+	val, err := tc.c.Get(ctx, key)
+	if err == nil || err == ErrNotFound {
+		span.SetAttribute(AttrHit, err == nil)
+	}
+	end(span, err)
+	return val, err
+}
+
+func (tc *tracedCache) Delete(ctx context.Context, key string) error {
+	ctx, span := tc.start(ctx, "Delete", key)
+	err := tc.c.Delete(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (tc *tracedCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, span := tc.start(ctx, "TTL", key)
+	ttl, err := tc.c.TTL(ctx, key)
+	end(span, err)
+	return ttl, err
+}
+
+func (tc *tracedCache) Flush(ctx context.Context) error {
+	ctx, span := tc.start(ctx, "Flush", "")
+	err := tc.c.Flush(ctx)
+	end(span, err)
+	return err
+}
+
+func (tc *tracedCache) Stats(ctx context.Context) (Stats, error) {
+	ctx, span := tc.start(ctx, "Stats", "")
+	s, err := tc.c.Stats(ctx)
+	end(span, err)
+	return s, err
+}
+
+func (tc *tracedCache) HealthCheck(ctx context.Context) error {
+	ctx, span := tc.start(ctx, "HealthCheck", "")
+	err := tc.c.HealthCheck(ctx)
+	end(span, err)
+	return err
+}
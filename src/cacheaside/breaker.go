@@ -0,0 +1,83 @@
+package cacheaside
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("cacheaside: circuit open")
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	halfOpen
+)
+
+// breaker opens after threshold consecutive loader failures, fails fast for openFor, then lets probes
+// loader calls through; the circuit closes once probes calls succeeded and opens again on any failure
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	openFor   time.Duration
+	probes    int
+	now       func() time.Time
+
+	state     breakerState
+	failures  int
+	openUntil time.Time
+	inFlight  int
+	successes int
+}
+
+// allow returns ErrCircuitOpen when the call must fail fast
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case open:
+		if b.now().Before(b.openUntil) {
+			return ErrCircuitOpen
+		}
+		b.state, b.inFlight, b.successes = halfOpen, 0, 0
+		fallthrough
+	case halfOpen:
+		if b.inFlight >= b.probes {
+			return ErrCircuitOpen
+		}
+		b.inFlight++
+	}
+	return nil
+}
+
+// done records the result of a call allow let through
+func (b *breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		if b.failures++; b.failures >= b.threshold {
+			b.trip()
+		}
+	case halfOpen:
+		b.inFlight--
+		if failed {
+			b.trip()
+			return
+		}
+		if b.successes++; b.successes >= b.probes {
+			b.state, b.failures = closed, 0
+		}
+	}
+}
+
+func (b *breaker) trip() {
+	b.state = open
+	b.openUntil = b.now().Add(b.openFor)
+}
@@ -0,0 +1,371 @@
+/*
+The package implements the cache-aside read path on top of cache.Cache and guards it against
+the common production failure modes:
+
+	penetration: keys missing from the source are cached as a negative entry for a short TTL.
+	breakdown: concurrent misses on the same key share one loader call (singleflight),
+		and with WithLock the loader runs under a redis_lock distributed lock across processes.
+	avalanche: TTLs are randomized by a jitter fraction so keys written together don't expire together.
+
+The loader returns ErrNotFound when the source has no value for the key.
+
+With WithCircuitBreaker, a source that keeps failing is left alone for a while: Fetch fails fast with
+ErrCircuitOpen, or with WithStale returns the last loaded value kept past its TTL. WithErrorTTL caches
+loader errors for a short TTL instead.
+
+Refresh-ahead (WithRefreshAhead), stale-while-revalidate (WithStaleWhileRevalidate) and Warm load in the
+background through a bounded worker pool, see WithWorkers, so they can't overload the source.
+*/
+
+package cacheaside
+
+import (
+	"cache/src/cache"
+	"cache/src/internal/singleflight"
+	"cache/src/redis_lock"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("cacheaside: not found")
+
+// negative is stored for keys missing from the source; it is a string so it survives the JSON round trip
+const negative = "\x00cacheaside:not_found"
+
+// errPrefix marks cached loader errors, followed by the error message
+const errPrefix = "\x00cacheaside:error:"
+
+type Loader func(ctx context.Context) (any, error)
+
+type Option func(c *CacheAside)
+
+// WithNegativeTTL caches ErrNotFound results for d, 0 disables negative caching
+func WithNegativeTTL(d time.Duration) Option {
+	return func(c *CacheAside) {
+		c.negativeTTL = d
+	}
+}
+
+// WithErrorTTL caches loader errors for ttl, so a failing source isn't called on every Fetch; cacheIf
+// selects the cached errors, nil caches all of them, see ErrorsIn and ErrorsNotIn. A cached error comes
+// back as a plain error with the same message
+func WithErrorTTL(ttl time.Duration, cacheIf func(error) bool) Option {
+	return func(c *CacheAside) {
+		c.errTTL = ttl
+		c.cacheErr = cacheIf
+	}
+}
+
+// ErrorsIn selects the errors matching one of targets with errors.Is
+func ErrorsIn(targets ...error) func(error) bool {
+	return func(err error) bool {
+		for _, t := range targets {
+			if errors.Is(err, t) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ErrorsNotIn selects the errors matching none of targets with errors.Is
+func ErrorsNotIn(targets ...error) func(error) bool {
+	in := ErrorsIn(targets...)
+	return func(err error) bool {
+		return !in(err)
+	}
+}
+
+// WithJitter randomizes every TTL by up to ±fraction of its value, e.g. 0.1 for ±10%
+func WithJitter(fraction float64) Option {
+	return func(c *CacheAside) {
+		c.jitter = fraction
+	}
+}
+
+// WithLock runs the loader under a distributed lock on key+":load", so only one process
+// reloads a key; the others wait for the lock and read the value it wrote.
+// Retry strategies are stateful, so retry is called for a new one on every load; nil means no retry
+func WithLock(client *redis_lock.Client, expiration time.Duration, retry func() redis_lock.RetryStrategy, timeout time.Duration) Option {
+	return func(c *CacheAside) {
+		c.locker = client
+		c.lockExpire = expiration
+		c.lockRetry = retry
+		c.lockTimeout = timeout
+	}
+}
+
+// WithCircuitBreaker opens the circuit after threshold consecutive loader errors; while it is open Fetch
+// doesn't call the loader for openFor, then up to probes calls are let through and the circuit closes
+// once they all succeed. ErrNotFound doesn't count as an error
+func WithCircuitBreaker(threshold int, openFor time.Duration, probes int) Option {
+	return func(c *CacheAside) {
+		if threshold <= 0 {
+			return
+		}
+		if probes <= 0 {
+			probes = 1
+		}
+		c.breaker = &breaker{threshold: threshold, openFor: openFor, probes: probes, now: time.Now}
+	}
+}
+
+// WithStale keeps a copy of every loaded value for ttl under key+":stale", served while the circuit is open
+func WithStale(ttl time.Duration) Option {
+	return func(c *CacheAside) {
+		c.staleTTL = ttl
+	}
+}
+
+// WithWorkers bounds the background loads of refresh-ahead, stale-while-revalidate and Warm to workers
+// concurrent loader calls, with up to queue loads waiting; policy decides what happens to refresh-ahead and
+// stale-while-revalidate loads when the queue is full, Warm always waits for room. The default is 4 workers,
+// a queue of 64 and DropNewest
+func WithWorkers(workers, queue int, policy DropPolicy) Option {
+	return func(c *CacheAside) {
+		c.pool = newPool(workers, queue, policy)
+	}
+}
+
+// WithRefreshAhead reloads a key in the background when Fetch hits it within window of its expiration
+func WithRefreshAhead(window time.Duration) Option {
+	return func(c *CacheAside) {
+		c.refreshAhead = window
+	}
+}
+
+// WithStaleWhileRevalidate makes Fetch return the stale copy kept by WithStale on a miss and reload
+// the key in the background
+func WithStaleWhileRevalidate() Option {
+	return func(c *CacheAside) {
+		c.swr = true
+	}
+}
+
+type CacheAside struct {
+	cache       cache.Cache
+	negativeTTL time.Duration
+	jitter      float64
+
+	locker      *redis_lock.Client
+	lockExpire  time.Duration
+	lockRetry   func() redis_lock.RetryStrategy
+	lockTimeout time.Duration
+
+	breaker  *breaker
+	staleTTL time.Duration
+	errTTL   time.Duration
+	cacheErr func(error) bool
+
+	pool         *pool
+	refreshAhead time.Duration
+	swr          bool
+	refreshing   sync.Map
+
+	group singleflight.Group
+}
+
+func New(c cache.Cache, opts ...Option) *CacheAside {
+	res := &CacheAside{
+		cache:       c,
+		negativeTTL: time.Minute,
+		lockExpire:  10 * time.Second,
+		lockTimeout: time.Second,
+		pool:        newPool(4, 64, DropNewest),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// Fetch returns the cached value of key, or loads it with loader and caches it for ttl
+func (c *CacheAside) Fetch(ctx context.Context, key string, ttl time.Duration, loader Loader) (any, error) {
+	if val, ok, err := c.get(ctx, key); ok || err != nil {
+		if err == nil && c.refreshAhead > 0 {
+			if left, err := c.cache.TTL(ctx, key); err == nil && left >= 0 && left <= c.refreshAhead {
+				c.refresh(key, ttl, loader)
+			}
+		}
+		return val, err
+	}
+	if c.swr && c.staleTTL > 0 {
+		if val, err := c.cache.Get(ctx, key+":stale"); err == nil {
+			c.refresh(key, ttl, loader)
+			return val, nil
+		}
+	}
+	return c.group.Do(key, func() (any, error) {
+		return c.load(ctx, key, ttl, loader, false)
+	})
+}
+
+// Warm loads every key of loaders through the background workers and waits for them, it returns the
+// first error other than ErrNotFound. It waits for room in the queue whatever the drop policy, so no key
+// is skipped; loads dropped by Close fail with ErrQueueFull
+func (c *CacheAside) Warm(ctx context.Context, ttl time.Duration, loaders map[string]Loader) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if first == nil {
+			first = err
+		}
+		mu.Unlock()
+	}
+	for key, loader := range loaders {
+		key, loader := key, loader
+		wg.Add(1)
+		c.pool.submitPolicy(func(dropped bool) {
+			defer wg.Done()
+			if dropped {
+				fail(fmt.Errorf("%w: %s", ErrQueueFull, key))
+				return
+			}
+			if err := ctx.Err(); err != nil {
+				fail(err)
+				return
+			}
+			_, err := c.group.Do(key, func() (any, error) {
+				return c.load(ctx, key, ttl, loader, true)
+			})
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				fail(err)
+			}
+		}, Block)
+	}
+	wg.Wait()
+	return first
+}
+
+// Dropped returns the number of background loads dropped by a full queue or by Close
+func (c *CacheAside) Dropped() uint64 {
+	return c.pool.dropped.Load()
+}
+
+// Close stops the background workers, queued loads are dropped
+func (c *CacheAside) Close() {
+	c.pool.close()
+}
+
+// refresh reloads key in the background, at most once at a time per key
+func (c *CacheAside) refresh(key string, ttl time.Duration, loader Loader) {
+	if _, busy := c.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	c.pool.submit(func(dropped bool) {
+		defer c.refreshing.Delete(key)
+		if dropped {
+			return
+		}
+		_, _ = c.group.Do(key, func() (any, error) {
+			return c.load(context.Background(), key, ttl, loader, true)
+		})
+	})
+}
+
+// get returns ok for both cached values and negative entries
+func (c *CacheAside) get(ctx context.Context, key string) (any, bool, error) {
+	val, err := c.cache.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if val == negative {
+		return nil, true, ErrNotFound
+	}
+	if s, ok := val.(string); ok && strings.HasPrefix(s, errPrefix) {
+		return nil, true, errors.New(strings.TrimPrefix(s, errPrefix))
+	}
+	return val, true, nil
+}
+
+// load calls loader and caches its result; with refresh the value cached by another process while
+// waiting for the lock doesn't stop the load, since the cached value is the one being refreshed
+func (c *CacheAside) load(ctx context.Context, key string, ttl time.Duration, loader Loader, refresh bool) (any, error) {
+	if c.locker != nil {
+		var retry redis_lock.RetryStrategy
+		if c.lockRetry != nil {
+			retry = c.lockRetry()
+		}
+		lock, err := c.locker.Lock(ctx, key+":load", token(), c.lockExpire, retry, c.lockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.UnLock(context.Background())
+		// another process may have loaded the key while we were waiting for the lock
+		if val, ok, err := c.get(ctx, key); !refresh && (ok || err != nil) {
+			return val, err
+		}
+	}
+	val, err := c.callLoader(ctx, loader)
+	if errors.Is(err, ErrCircuitOpen) && c.staleTTL > 0 {
+		if val, err := c.cache.Get(ctx, key+":stale"); err == nil {
+			return val, nil
+		}
+		return nil, ErrCircuitOpen
+	}
+	if errors.Is(err, ErrNotFound) {
+		if c.negativeTTL > 0 {
+			if err := c.cache.Set(ctx, key, negative, c.jittered(c.negativeTTL)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		// the circuit state is not an answer of the source
+		if c.errTTL > 0 && !errors.Is(err, ErrCircuitOpen) && (c.cacheErr == nil || c.cacheErr(err)) {
+			_ = c.cache.Set(ctx, key, errPrefix+err.Error(), c.jittered(c.errTTL))
+		}
+		return nil, err
+	}
+	if c.staleTTL > 0 {
+		if err := c.cache.Set(ctx, key+":stale", val, c.staleTTL); err != nil {
+			return nil, err
+		}
+	}
+	return val, c.cache.Set(ctx, key, val, c.jittered(ttl))
+}
+
+func (c *CacheAside) callLoader(ctx context.Context, loader Loader) (any, error) {
+	if c.breaker == nil {
+		return loader(ctx)
+	}
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	val, err := loader(ctx)
+	c.breaker.done(err != nil && !errors.Is(err, ErrNotFound))
+	return val, err
+}
+
+func (c *CacheAside) jittered(ttl time.Duration) time.Duration {
+	if c.jitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	delta := float64(ttl) * c.jitter
+	res := time.Duration(float64(ttl) - delta + mrand.Float64()*2*delta)
+	if res <= 0 {
+		return ttl
+	}
+	return res
+}
+
+func token() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,295 @@
+package cacheaside
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"cache/src/redis_lock"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithJitter(0.1),
+		WithLock(redis_lock.NewClientWithBackend(redis_lock.NewMemoryBackend()), time.Second, func() redis_lock.RetryStrategy {
+			return &redis_lock.FixIntervalRetry{Interval: time.Millisecond, Max: 100}
+		}, time.Second))
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (any, error) {
+		loads.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return "will", nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Fetch(ctx, "name", time.Minute, loader); err != nil || v != "will" {
+				t.Errorf("unexpected fetch result %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("expected one load, got %d", n)
+	}
+}
+
+// TestLockRetryPerLoad every load gets a fresh retry strategy, so one exhausted load doesn't
+// make the later ones give up without retrying
+func TestLockRetryPerLoad(t *testing.T) {
+	ctx := context.Background()
+	locker := redis_lock.NewClientWithBackend(redis_lock.NewMemoryBackend())
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)),
+		WithLock(locker, time.Second, func() redis_lock.RetryStrategy {
+			return &redis_lock.FixIntervalRetry{Interval: 5 * time.Millisecond, Max: 20}
+		}, time.Second))
+	loader := func(ctx context.Context) (any, error) {
+		return "will", nil
+	}
+
+	held, err := locker.TryLock(ctx, "name:load", "other", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Fetch(ctx, "name", time.Minute, loader); !errors.Is(err, redis_lock.ErrRetriesExhausted) {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = held.UnLock(ctx)
+	}()
+	if v, err := c.Fetch(ctx, "name", time.Minute, loader); err != nil || v != "will" {
+		t.Fatalf("unexpected fetch result %v %v", v, err)
+	}
+}
+
+func TestFetchNegative(t *testing.T) {
+	ctx := context.Background()
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithNegativeTTL(time.Minute))
+
+	var loads int
+	loader := func(ctx context.Context) (any, error) {
+		loads++
+		return nil, ErrNotFound
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Fetch(ctx, "missing", time.Minute, loader); err != ErrNotFound {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected the negative entry to be cached, got %d loads", loads)
+	}
+}
+
+// wrappedMiss wraps the errors of Get, like WithTimeouts does when its deadline fires with the reply
+type wrappedMiss struct {
+	cache.Cache
+}
+
+func (w wrappedMiss) Get(ctx context.Context, key string) (any, error) {
+	val, err := w.Cache.Get(ctx, key)
+	if err != nil {
+		err = &cache.TimeoutError{Op: "Get", Key: key, Limit: time.Second, Err: err}
+	}
+	return val, err
+}
+
+func TestFetchWrappedErrors(t *testing.T) {
+	ctx := context.Background()
+	c := New(wrappedMiss{cache.NewLocal(local_cache.NewCache(time.Minute, 0))}, WithNegativeTTL(time.Minute))
+
+	// a wrapped cache.ErrNotFound is a miss, not a failure of the cache
+	loader := func(ctx context.Context) (any, error) {
+		return "will", nil
+	}
+	if v, err := c.Fetch(ctx, "name", time.Minute, loader); err != nil || v != "will" {
+		t.Fatalf("unexpected fetch result %v %v", v, err)
+	}
+
+	// a wrapped ErrNotFound of the loader is cached like a bare one
+	var loads int
+	missing := func(ctx context.Context) (any, error) {
+		loads++
+		return nil, fmt.Errorf("user 1: %w", ErrNotFound)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Fetch(ctx, "missing", time.Minute, missing); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected the negative entry to be cached, got %d loads", loads)
+	}
+}
+
+func TestJittered(t *testing.T) {
+	c := New(nil, WithJitter(0.5))
+	for i := 0; i < 100; i++ {
+		if d := c.jittered(time.Minute); d < 30*time.Second || d > 90*time.Second {
+			t.Fatalf("jittered ttl %v out of range", d)
+		}
+	}
+	if c.jittered(local_cache.NoExpire) != local_cache.NoExpire {
+		t.Fatal("NoExpire should not be jittered")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	lc := local_cache.NewCache(time.Minute, 0)
+	c := New(cache.NewLocal(lc), WithCircuitBreaker(2, time.Minute, 1), WithStale(time.Hour))
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	var loads int
+	down := errors.New("down")
+	failing := func(ctx context.Context) (any, error) {
+		loads++
+		return nil, down
+	}
+	working := func(ctx context.Context) (any, error) {
+		loads++
+		return "will", nil
+	}
+
+	if _, err := c.Fetch(ctx, "name", time.Minute, working); err != nil {
+		t.Fatal(err)
+	}
+	// as if it expired, only the stale copy is left
+	lc.Delete("name")
+	for i := 0; i < 2; i++ {
+		if _, err := c.Fetch(ctx, "other", time.Minute, failing); err != down {
+			t.Fatalf("expected the loader error, got %v", err)
+		}
+	}
+	if _, err := c.Fetch(ctx, "other", time.Minute, failing); err != ErrCircuitOpen || loads != 3 {
+		t.Fatalf("expected a fast failure, got %v after %d loads", err, loads)
+	}
+	if v, err := c.Fetch(ctx, "name", time.Minute, working); err != nil || v != "will" || loads != 3 {
+		t.Fatalf("expected the stale value, got %v %v after %d loads", v, err, loads)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := c.Fetch(ctx, "other", time.Minute, failing); err != down {
+		t.Fatalf("expected a probe, got %v", err)
+	}
+	if _, err := c.Fetch(ctx, "other", time.Minute, working); err != ErrCircuitOpen {
+		t.Fatalf("a failed probe should open the circuit again, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Fetch(ctx, "key"+strconv.Itoa(i), time.Minute, working); err != nil {
+			t.Fatalf("a successful probe should close the circuit, got %v", err)
+		}
+	}
+}
+
+func TestErrorTTL(t *testing.T) {
+	ctx := context.Background()
+	timeout := errors.New("timeout")
+	denied := errors.New("denied")
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithErrorTTL(time.Minute, ErrorsNotIn(denied)))
+
+	var loads int
+	loader := func(err error) Loader {
+		return func(ctx context.Context) (any, error) {
+			loads++
+			return nil, fmt.Errorf("load: %w", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Fetch(ctx, "name", time.Minute, loader(timeout)); err == nil || err.Error() != "load: timeout" {
+			t.Fatalf("unexpected error %v", err)
+		}
+		_, _ = c.Fetch(ctx, "age", time.Minute, loader(denied))
+	}
+	if loads != 3 {
+		t.Fatalf("only the allowed error should be cached, got %d loads", loads)
+	}
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func TestBackgroundLoads(t *testing.T) {
+	ctx := context.Background()
+	lc := local_cache.NewCache(time.Minute, 0)
+	c := New(cache.NewLocal(lc), WithRefreshAhead(time.Minute), WithStale(time.Hour), WithStaleWhileRevalidate())
+	defer c.Close()
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (any, error) {
+		return int(loads.Add(1)), nil
+	}
+	if v, _ := c.Fetch(ctx, "rate", 30*time.Second, loader); v != 1 {
+		t.Fatalf("unexpected value %v", v)
+	}
+	// the hit is within a minute of expiring
+	if v, _ := c.Fetch(ctx, "rate", 30*time.Second, loader); v != 1 {
+		t.Fatalf("unexpected value %v", v)
+	}
+	eventually(t, func() bool { v, _ := lc.Get("rate"); return v == 2 })
+
+	lc.Delete("rate")
+	if v, _ := c.Fetch(ctx, "rate", time.Hour, loader); v != 2 {
+		t.Fatalf("expected the stale value, got %v", v)
+	}
+	eventually(t, func() bool { v, _ := lc.Get("rate"); return v == 3 })
+}
+
+func TestWarmWorkers(t *testing.T) {
+	ctx := context.Background()
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithWorkers(2, 10, Block))
+	defer c.Close()
+
+	var running, peak atomic.Int32
+	loaders := map[string]Loader{}
+	for i := 0; i < 10; i++ {
+		i := i
+		loaders["key"+strconv.Itoa(i)] = func(ctx context.Context) (any, error) {
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			return i, nil
+		}
+	}
+	if err := c.Warm(ctx, time.Minute, loaders); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p > 2 {
+		t.Fatalf("expected at most 2 concurrent loads, got %d", p)
+	}
+	if v, err := c.Fetch(ctx, "key3", time.Minute, nil); err != nil || v != 3 {
+		t.Fatalf("unexpected warmed value %v %v", v, err)
+	}
+
+	// Warm waits for room in a full queue instead of dropping keys, whatever the policy
+	c = New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithWorkers(1, 1, DropNewest))
+	defer c.Close()
+	if err := c.Warm(ctx, time.Minute, loaders); err != nil || c.Dropped() != 0 {
+		t.Fatalf("expected every key to be warmed, got %v with %d dropped", err, c.Dropped())
+	}
+	if v, err := c.Fetch(ctx, "key9", time.Minute, nil); err != nil || v != 9 {
+		t.Fatalf("unexpected warmed value %v %v", v, err)
+	}
+}
@@ -0,0 +1,134 @@
+package cacheaside
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrQueueFull = errors.New("cacheaside: background queue full")
+
+// DropPolicy decides what happens to a background load when the queue is full
+type DropPolicy int
+
+const (
+	// DropNewest drops the load being submitted
+	DropNewest DropPolicy = iota
+	// DropOldest drops the load waiting longest, making room for the new one
+	DropOldest
+	// Block waits for room in the queue; refresh-ahead and stale-while-revalidate then slow Fetch down
+	Block
+)
+
+// task is run with dropped set when it leaves the queue without running
+type task func(dropped bool)
+
+// pool runs the background loads of refresh-ahead, stale-while-revalidate and Warm, so they never
+// issue more than workers loader calls at once
+type pool struct {
+	workers int
+	policy  DropPolicy
+	tasks   chan task
+	start   sync.Once
+	stop    chan struct{}
+	closed  sync.Once
+	dropped atomic.Uint64
+}
+
+func newPool(workers, queue int, policy DropPolicy) *pool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &pool{
+		workers: workers,
+		policy:  policy,
+		tasks:   make(chan task, queue),
+		stop:    make(chan struct{}),
+	}
+}
+
+// submit queues t following the pool's policy, the workers start on the first call; it returns false
+// when t was dropped
+func (p *pool) submit(t task) bool {
+	return p.submitPolicy(t, p.policy)
+}
+
+// submitPolicy is submit with the given policy, Warm always uses Block
+func (p *pool) submitPolicy(t task, policy DropPolicy) bool {
+	p.start.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			go p.run()
+		}
+	})
+	select {
+	case <-p.stop:
+		p.drop(t)
+		return false
+	default:
+	}
+	switch {
+	case policy == Block:
+		select {
+		case p.tasks <- t:
+			return true
+		case <-p.stop:
+			p.drop(t)
+			return false
+		}
+	// without a queue there is nothing older to drop
+	case policy == DropOldest && cap(p.tasks) > 0:
+		for {
+			select {
+			case p.tasks <- t:
+				return true
+			default:
+			}
+			select {
+			case old := <-p.tasks:
+				p.drop(old)
+			default:
+			}
+		}
+	}
+	select {
+	case p.tasks <- t:
+		return true
+	default:
+		p.drop(t)
+		return false
+	}
+}
+
+func (p *pool) drop(t task) {
+	p.dropped.Add(1)
+	t(true)
+}
+
+func (p *pool) run() {
+	for {
+		select {
+		case t := <-p.tasks:
+			t(false)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// close stops the workers and drops the queued tasks
+func (p *pool) close() {
+	p.closed.Do(func() {
+		close(p.stop)
+	})
+	for {
+		select {
+		case t := <-p.tasks:
+			p.drop(t)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package cachetest
+
+import (
+	"cache/src/cache"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFakeAndRecorder(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+	r := NewRecorder(f)
+
+	_ = r.Set(ctx, "name", "will", time.Minute)
+	_ = r.Set(ctx, "age", 13, cache.NoExpire)
+	if v, err := r.Get(ctx, "name"); err != nil || v != "will" {
+		t.Fatalf("unexpected get result %v %v", v, err)
+	}
+	f.Advance(time.Minute)
+	if _, err := r.Get(ctx, "name"); err != cache.ErrNotFound {
+		t.Fatalf("expected the item to expire with the clock, got %v", err)
+	}
+	r.AssertCalls(t, "Set name", "Set age", "Get name", "Get name")
+	if calls := r.Calls(); calls[3].Err != cache.ErrNotFound || calls[0].TTL != time.Minute {
+		t.Fatalf("unexpected recorded calls %+v", calls)
+	}
+
+	down := errors.New("down")
+	f.FailOn("Get", down)
+	if _, err := r.Get(ctx, "age"); err != down {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+	f.FailOn("Get", nil)
+
+	_ = f.Set(ctx, "rate", 7.1, 30*time.Second)
+	snap, err := f.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Golden(t, filepath.Join("testdata", "snapshot.golden"), snap)
+}
@@ -0,0 +1,187 @@
+/*
+The package helps downstream projects unit test their cache interactions:
+
+	Fake: An in-memory cache.Cache with a manual clock and injectable errors.
+	Recorder: A cache.Cache decorator recording every call, with AssertCalls to check them.
+	Golden: Compares output, e.g. Fake.Snapshot, with a golden file; CACHETEST_UPDATE=1 rewrites it.
+*/
+
+package cachetest
+
+import (
+	"cache/src/cache"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ cache.Cache = (*Fake)(nil)
+
+type fakeItem struct {
+	val    any
+	expire time.Time
+}
+
+// Fake is a deterministic in-memory cache.Cache: time only moves with Advance
+type Fake struct {
+	mu     sync.Mutex
+	items  map[string]fakeItem
+	now    time.Time
+	hits   uint64
+	misses uint64
+	errs   map[string]error
+}
+
+// NewFake returns an empty fake whose clock starts at a fixed time; DefaultExpire means no expiration
+func NewFake() *Fake {
+	return &Fake{
+		items: make(map[string]fakeItem),
+		now:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		errs:  make(map[string]error),
+	}
+}
+
+// Advance moves the clock of the fake forward
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+// FailOn makes every call of op, e.g. "Get", return err until FailOn(op, nil)
+func (f *Fake) FailOn(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, op)
+		return
+	}
+	f.errs[op] = err
+}
+
+// get returns the unexpired item, the caller holds f.mu
+func (f *Fake) get(key string) (fakeItem, bool) {
+	item, ok := f.items[key]
+	if !ok || (!item.expire.IsZero() && !f.now.Before(item.expire)) {
+		return fakeItem{}, false
+	}
+	return item, true
+}
+
+func (f *Fake) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["Set"]; err != nil {
+		return err
+	}
+	item := fakeItem{val: val}
+	if ttl > 0 {
+		item.expire = f.now.Add(ttl)
+	}
+	f.items[key] = item
+	return nil
+}
+
+func (f *Fake) Get(ctx context.Context, key string) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["Get"]; err != nil {
+		return nil, err
+	}
+	item, ok := f.get(key)
+	if !ok {
+		f.misses++
+		return nil, cache.ErrNotFound
+	}
+	f.hits++
+	return item.val, nil
+}
+
+func (f *Fake) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["Delete"]; err != nil {
+		return err
+	}
+	delete(f.items, key)
+	return nil
+}
+
+func (f *Fake) TTL(ctx context.Context, key string) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["TTL"]; err != nil {
+		return 0, err
+	}
+	item, ok := f.get(key)
+	if !ok {
+		return 0, cache.ErrNotFound
+	}
+	if item.expire.IsZero() {
+		return cache.NoExpire, nil
+	}
+	return item.expire.Sub(f.now), nil
+}
+
+func (f *Fake) Flush(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["Flush"]; err != nil {
+		return err
+	}
+	f.items = make(map[string]fakeItem)
+	return nil
+}
+
+func (f *Fake) Stats(ctx context.Context) (cache.Stats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errs["Stats"]; err != nil {
+		return cache.Stats{}, err
+	}
+	n := 0
+	for k := range f.items {
+		if _, ok := f.get(k); ok {
+			n++
+		}
+	}
+	return cache.Stats{Hits: f.hits, Misses: f.misses, Items: n}, nil
+}
+
+func (f *Fake) HealthCheck(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.errs["HealthCheck"]
+}
+
+// Snapshot returns the unexpired items as indented JSON sorted by key, with their remaining TTL,
+// for comparison with Golden; values must be JSON encodable
+func (f *Fake) Snapshot() ([]byte, error) {
+	type entry struct {
+		Key   string `json:"key"`
+		Value any    `json:"value"`
+		TTL   string `json:"ttl,omitempty"`
+	}
+	f.mu.Lock()
+	entries := make([]entry, 0, len(f.items))
+	for k := range f.items {
+		item, ok := f.get(k)
+		if !ok {
+			continue
+		}
+		e := entry{Key: k, Value: item.val}
+		if !item.expire.IsZero() {
+			e.TTL = item.expire.Sub(f.now).String()
+		}
+		entries = append(entries, e)
+	}
+	f.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	res, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(res, '\n'), nil
+}
@@ -0,0 +1,30 @@
+package cachetest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Golden fails t unless got equals the content of the golden file at path; with CACHETEST_UPDATE=1 in
+// the environment the file is written with got instead
+func Golden(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if os.Getenv("CACHETEST_UPDATE") == "1" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file, run with CACHETEST_UPDATE=1 to create it: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("output differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package cachetest
+
+import (
+	"cache/src/cache"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ cache.Cache = (*Recorder)(nil)
+
+// Call is a recorded call, Key is empty for Flush, Stats and HealthCheck
+type Call struct {
+	Op  string
+	Key string
+	Val any
+	TTL time.Duration
+	Err error
+}
+
+// String formats the call as "Op key", the form AssertCalls compares
+func (c Call) String() string {
+	if c.Key == "" {
+		return c.Op
+	}
+	return c.Op + " " + c.Key
+}
+
+// Recorder records the calls made through it to the wrapped cache
+type Recorder struct {
+	c     cache.Cache
+	mu    sync.Mutex
+	calls []Call
+}
+
+func NewRecorder(c cache.Cache) *Recorder {
+	return &Recorder{c: c}
+}
+
+func (r *Recorder) record(call Call) {
+	r.mu.Lock()
+	r.calls = append(r.calls, call)
+	r.mu.Unlock()
+}
+
+// Calls returns the recorded calls in order
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.calls = nil
+	r.mu.Unlock()
+}
+
+// AssertCalls fails t unless the recorded calls, formatted by Call.String, are want in order
+func (r *Recorder) AssertCalls(t testing.TB, want ...string) {
+	t.Helper()
+	calls := r.Calls()
+	got := make([]string, len(calls))
+	for i, c := range calls {
+		got[i] = c.String()
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected cache calls\ngot:\n\t%s\nwant:\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+}
+
+func (r *Recorder) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	err := r.c.Set(ctx, key, val, ttl)
+	r.record(Call{Op: "Set", Key: key, Val: val, TTL: ttl, Err: err})
+	return err
+}
+
+func (r *Recorder) Get(ctx context.Context, key string) (any, error) {
+	val, err := r.c.Get(ctx, key)
+	r.record(Call{Op: "Get", Key: key, Val: val, Err: err})
+	return val, err
+}
+
+func (r *Recorder) Delete(ctx context.Context, key string) error {
+	err := r.c.Delete(ctx, key)
+	r.record(Call{Op: "Delete", Key: key, Err: err})
+	return err
+}
+
+func (r *Recorder) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.c.TTL(ctx, key)
+	r.record(Call{Op: "TTL", Key: key, TTL: ttl, Err: err})
+	return ttl, err
+}
+
+func (r *Recorder) Flush(ctx context.Context) error {
+	err := r.c.Flush(ctx)
+	r.record(Call{Op: "Flush", Err: err})
+	return err
+}
+
+func (r *Recorder) Stats(ctx context.Context) (cache.Stats, error) {
+	s, err := r.c.Stats(ctx)
+	r.record(Call{Op: "Stats", Val: s, Err: err})
+	return s, err
+}
+
+func (r *Recorder) HealthCheck(ctx context.Context) error {
+	err := r.c.HealthCheck(ctx)
+	r.record(Call{Op: "HealthCheck", Err: err})
+	return err
+}
+
+// String lists the recorded calls one per line
+func (r *Recorder) String() string {
+	var b strings.Builder
+	for _, c := range r.Calls() {
+		fmt.Fprintln(&b, c.String())
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+/*
+The package defines the Codec used to turn cache values into bytes, so values round-trip
+the same way in every backend that stores bytes:
+
+	JSON: encoding/json, readable and portable; numbers decode as float64 into an any.
+	Gob: encoding/gob, keeps Go types; decode into a pointer to the concrete type, not into an any.
+	Binary: for values implementing encoding.BinaryMarshaler / BinaryUnmarshaler.
+	MsgPack: MessagePack through reflection, structs are maps keyed by the msgpack tag or the field name
+		("-" skips a field, omitempty skips zero values), time.Time is the timestamp extension;
+		into an any, integers decode as int64 and maps as map[string]any.
+	Protobuf: messages generated by protoc-gen-go, encoded through their protobuf struct tags, or through
+		Marshal/Unmarshal methods as gogo/protobuf generates them; groups and extensions are not supported
+		and unknown fields are dropped.
+*/
+
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON   Codec = jsonCodec{}
+	Gob    Codec = gobCodec{}
+	Binary Codec = binaryCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T doesn't implement encoding.BinaryMarshaler", v)
+	}
+	return m.MarshalBinary()
+}
+
+func (binaryCodec) Unmarshal(data []byte, v any) error {
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("codec: %T doesn't implement encoding.BinaryUnmarshaler", v)
+	}
+	return u.UnmarshalBinary(data)
+}
@@ -0,0 +1,251 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, c := range map[string]Codec{"json": JSON, "gob": Gob, "msgpack": MsgPack} {
+		data, err := c.Marshal(user{Name: "will", Age: 13})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		var u user
+		if err = c.Unmarshal(data, &u); err != nil {
+			t.Fatal(name, err)
+		}
+		if !reflect.DeepEqual(u, user{Name: "will", Age: 13}) {
+			t.Fatalf("%s: unexpected value %+v", name, u)
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	now := time.Unix(1678867200, 0).UTC()
+	data, err := Binary.Marshal(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res time.Time
+	if err = Binary.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Equal(now) {
+		t.Fatalf("unexpected time %v", res)
+	}
+	if _, err = Binary.Marshal(url.Values{}); err == nil {
+		t.Fatal("expected an error for a type without MarshalBinary")
+	}
+}
+
+type profile struct {
+	Name    string            `msgpack:"name"`
+	Scores  []int             `msgpack:"scores"`
+	Tags    map[string]string `msgpack:"tags,omitempty"`
+	Avatar  []byte            `msgpack:"avatar"`
+	Ratio   float64           `msgpack:"ratio"`
+	Manager *profile          `msgpack:"manager"`
+	Created time.Time         `msgpack:"created"`
+	Secret  string            `msgpack:"-"`
+}
+
+func TestMsgPack(t *testing.T) {
+	// the example of msgpack.org
+	data, err := MsgPack.Marshal(map[string]any{"compact": true, "schema": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(data) != "82a7636f6d70616374c3a6736368656d6100" {
+		t.Fatalf("unexpected encoding %x", data)
+	}
+
+	p := profile{
+		Name:    "will",
+		Scores:  []int{-1, 200, -40000, math.MaxInt64},
+		Avatar:  []byte{1, 2, 3},
+		Ratio:   0.5,
+		Manager: &profile{Name: "boss", Tags: map[string]string{"k": "v"}, Created: time.Unix(1, 0)},
+		Created: time.Unix(1678867200, 123),
+		Secret:  "ignored",
+	}
+	if data, err = MsgPack.Marshal(&p); err != nil {
+		t.Fatal(err)
+	}
+	var res profile
+	if err = MsgPack.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Created.Equal(p.Created) || !res.Manager.Created.Equal(p.Manager.Created) {
+		t.Fatalf("unexpected times %v %v", res.Created, res.Manager.Created)
+	}
+	res.Created, res.Manager.Created = p.Created, p.Manager.Created
+	p.Secret = ""
+	if !reflect.DeepEqual(res, p) {
+		t.Fatalf("unexpected value %+v", res)
+	}
+
+	// into an any, and into a struct that lost fields since the value was written
+	var generic any
+	if err = MsgPack.Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := generic.(map[string]any)
+	if !ok || m["name"] != "will" || !reflect.DeepEqual(m["scores"], []any{int64(-1), int64(200), int64(-40000), int64(math.MaxInt64)}) {
+		t.Fatalf("unexpected generic value %#v", generic)
+	}
+	var u user
+	if err = MsgPack.Unmarshal(data, &u); err != nil || u != (user{}) {
+		t.Fatalf("unexpected value %+v %v", u, err)
+	}
+
+	var n int8
+	if err = MsgPack.Unmarshal([]byte{0xcd, 0x01, 0x00}, &n); err == nil {
+		t.Fatal("expected an error for 256 in an int8")
+	}
+	if err = MsgPack.Unmarshal(data[:len(data)-1], &res); err == nil {
+		t.Fatal("expected an error for truncated data")
+	}
+	if err = MsgPack.Unmarshal([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, &generic); err == nil {
+		t.Fatal("expected an error for an array longer than the data")
+	}
+}
+
+// the shape protoc-gen-go generates for:
+//
+//	message Item {
+//	  int32 id = 1;
+//	  string name = 2;
+//	  repeated int64 prices = 3;
+//	  sint32 delta = 4;
+//	  double weight = 5;
+//	  Item parent = 6;
+//	  repeated Item children = 7;
+//	  map<string, int32> stock = 8;
+//	  bytes raw = 9;
+//	  optional bool enabled = 10;
+//	  oneof contact { string email = 11; int64 phone = 12; }
+//	}
+type item struct {
+	state    struct{ _ int }
+	Id       int32            `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name     string           `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Prices   []int64          `protobuf:"varint,3,rep,packed,name=prices,proto3" json:"prices,omitempty"`
+	Delta    int32            `protobuf:"zigzag32,4,opt,name=delta,proto3" json:"delta,omitempty"`
+	Weight   float64          `protobuf:"fixed64,5,opt,name=weight,proto3" json:"weight,omitempty"`
+	Parent   *item            `protobuf:"bytes,6,opt,name=parent,proto3" json:"parent,omitempty"`
+	Children []*item          `protobuf:"bytes,7,rep,name=children,proto3" json:"children,omitempty"`
+	Stock    map[string]int32 `protobuf:"bytes,8,rep,name=stock,proto3" json:"stock,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	Raw      []byte           `protobuf:"bytes,9,opt,name=raw,proto3" json:"raw,omitempty"`
+	Enabled  *bool            `protobuf:"varint,10,opt,name=enabled,proto3,oneof" json:"enabled,omitempty"`
+	Contact  isItemContact    `protobuf_oneof:"contact"`
+}
+
+type isItemContact interface {
+	isItemContact()
+}
+
+type itemEmail struct {
+	Email string `protobuf:"bytes,11,opt,name=email,proto3,oneof"`
+}
+
+type itemPhone struct {
+	Phone int64 `protobuf:"varint,12,opt,name=phone,proto3,oneof"`
+}
+
+func (*itemEmail) isItemContact() {
+}
+
+func (*itemPhone) isItemContact() {
+}
+
+func (*item) XXX_OneofWrappers() []any {
+	return []any{(*itemEmail)(nil), (*itemPhone)(nil)}
+}
+
+// gogoMessage has the methods gogo/protobuf generates
+type gogoMessage struct {
+	data []byte
+}
+
+func (m *gogoMessage) Marshal() ([]byte, error) {
+	return m.data, nil
+}
+
+func (m *gogoMessage) Unmarshal(data []byte) error {
+	m.data = data
+	return nil
+}
+
+func TestProtobuf(t *testing.T) {
+	// the examples of the encoding guide at protobuf.dev
+	data, err := Protobuf.Marshal(&item{Id: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(data) != "089601" {
+		t.Fatalf("unexpected encoding %x", data)
+	}
+	if data, err = Protobuf.Marshal(&item{Name: "testing", Prices: []int64{3, 270, 86942}}); err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(data) != "120774657374696e671a06038e029ea705" {
+		t.Fatalf("unexpected encoding %x", data)
+	}
+
+	enabled := false
+	it := item{
+		Id:       -1,
+		Name:     "root",
+		Prices:   []int64{1, -2},
+		Delta:    -3,
+		Weight:   2.5,
+		Parent:   &item{Name: "parent"},
+		Children: []*item{{Id: 1}, {Id: 2, Contact: &itemPhone{Phone: 0}}},
+		Stock:    map[string]int32{"a": 1, "b": 0},
+		Raw:      []byte{0, 1},
+		Enabled:  &enabled,
+		Contact:  &itemEmail{Email: "will@example.com"},
+	}
+	if data, err = Protobuf.Marshal(&it); err != nil {
+		t.Fatal(err)
+	}
+	var res item
+	if err = Protobuf.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, it) {
+		t.Fatalf("unexpected value %+v", res)
+	}
+
+	// unpacked repeated scalars and unknown fields, as older writers send them
+	unpacked := []byte{0x18, 0x01, 0x18, 0x02, 0xf8, 0x06, 0x07, 0x08, 0x05}
+	res = item{}
+	if err = Protobuf.Unmarshal(unpacked, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Id != 5 || !reflect.DeepEqual(res.Prices, []int64{1, 2}) {
+		t.Fatalf("unexpected value %+v", res)
+	}
+
+	m := &gogoMessage{data: []byte("raw")}
+	if data, err = Protobuf.Marshal(m); err != nil || !bytes.Equal(data, m.data) {
+		t.Fatalf("unexpected gogo encoding %q %v", data, err)
+	}
+	if err = Protobuf.Unmarshal(data[:1], &res); err == nil {
+		t.Fatal("expected an error for truncated data")
+	}
+	if _, err = Protobuf.Marshal(user{}); err == nil {
+		t.Fatal("expected an error for a value that isn't a pointer to a message")
+	}
+}
@@ -0,0 +1,686 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var MsgPack Codec = msgpackCodec{}
+
+// ErrMsgPack is returned for truncated or malformed msgpack data
+var ErrMsgPack = errors.New("codec: invalid msgpack data")
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	e := &mpEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("codec: msgpack needs a non-nil pointer, got %T", v)
+	}
+	d := &mpDecoder{data: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("%w: %d trailing bytes", ErrMsgPack, len(d.data)-d.pos)
+	}
+	return nil
+}
+
+// mpField is an exported struct field and its msgpack name
+type mpField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var mpFields sync.Map // reflect.Type -> []mpField
+
+func msgpackFields(t reflect.Type) []mpField {
+	if res, ok := mpFields.Load(t); ok {
+		return res.([]mpField)
+	}
+	var res []mpField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("msgpack"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		res = append(res, mpField{name: name, index: i, omitEmpty: opts == "omitempty"})
+	}
+	mpFields.Store(t, res)
+	return res
+}
+
+type mpEncoder struct {
+	buf []byte
+}
+
+func (e *mpEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	if v.Type() == timeType {
+		e.time(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, 0xca)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, 0xcb)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bin(v.Bytes())
+			return nil
+		}
+		return e.array(v)
+	case reflect.Array:
+		return e.array(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.mapValue(v)
+	case reflect.Struct:
+		return e.structValue(v)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("codec: msgpack can't encode %s", v.Type())
+	}
+	return nil
+}
+
+func (e *mpEncoder) int(i int64) {
+	switch {
+	case i >= 0:
+		e.uint(uint64(i))
+	case i >= -32:
+		e.buf = append(e.buf, byte(i))
+	case i >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		e.buf = append(e.buf, 0xd1)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(i))
+	case i >= math.MinInt32:
+		e.buf = append(e.buf, 0xd2)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(i))
+	default:
+		e.buf = append(e.buf, 0xd3)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(i))
+	}
+}
+
+func (e *mpEncoder) uint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.buf = append(e.buf, byte(u))
+	case u <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		e.buf = append(e.buf, 0xcd)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(u))
+	case u <= math.MaxUint32:
+		e.buf = append(e.buf, 0xce)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(u))
+	default:
+		e.buf = append(e.buf, 0xcf)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, u)
+	}
+}
+
+// header writes the header of a str, bin, array or map of n elements; fix is the fixed format
+// prefix, 0 when the type has none, and formats the 8, 16 and 32 bit length prefixes
+func (e *mpEncoder) header(n int, fix byte, fixMax int, formats [3]byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.buf = append(e.buf, fix|byte(n))
+	case formats[0] != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, formats[0], byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, formats[1])
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, formats[2])
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *mpEncoder) str(s string) {
+	e.header(len(s), 0xa0, 31, [3]byte{0xd9, 0xda, 0xdb})
+	e.buf = append(e.buf, s...)
+}
+
+func (e *mpEncoder) bin(b []byte) {
+	e.header(len(b), 0, 0, [3]byte{0xc4, 0xc5, 0xc6})
+	e.buf = append(e.buf, b...)
+}
+
+func (e *mpEncoder) array(v reflect.Value) error {
+	e.header(v.Len(), 0x90, 15, [3]byte{0, 0xdc, 0xdd})
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *mpEncoder) mapValue(v reflect.Value) error {
+	e.header(v.Len(), 0x80, 15, [3]byte{0, 0xde, 0xdf})
+	keys := v.MapKeys()
+	if v.Type().Key().Kind() == reflect.String {
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	}
+	for _, k := range keys {
+		if err := e.encode(k); err != nil {
+			return err
+		}
+		if err := e.encode(v.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *mpEncoder) structValue(v reflect.Value) error {
+	fields := msgpackFields(v.Type())
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !v.Field(f.index).IsZero() {
+			n++
+		}
+	}
+	e.header(n, 0x80, 15, [3]byte{0, 0xde, 0xdf})
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		e.str(f.name)
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// time writes the timestamp extension in its shortest form
+func (e *mpEncoder) time(t time.Time) {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		e.buf = append(e.buf, 0xd6, 0xff)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(sec))
+	case sec>>34 == 0:
+		e.buf = append(e.buf, 0xd7, 0xff)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(nsec)<<34|uint64(sec))
+	default:
+		e.buf = append(e.buf, 0xc7, 12, 0xff)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(nsec))
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(sec))
+	}
+}
+
+type mpKind int
+
+const (
+	mpNil mpKind = iota
+	mpBool
+	mpInt
+	mpUint
+	mpFloat
+	mpStr
+	mpBin
+	mpArray
+	mpMap
+	mpExt
+)
+
+// mpToken is the header of a value: its scalar, or the length of an array or a map,
+// or the payload of a str, bin or ext
+type mpToken struct {
+	kind    mpKind
+	b       bool
+	i       int64
+	u       uint64
+	f       float64
+	n       int
+	data    []byte
+	extType int8
+}
+
+type mpDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *mpDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrMsgPack)
+	}
+	res := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return res, nil
+}
+
+// uintN reads a big endian unsigned integer of size bytes
+func (d *mpDecoder) uintN(size int) (uint64, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *mpDecoder) next() (mpToken, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return mpToken{}, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return mpToken{kind: mpInt, i: int64(c)}, nil
+	case c >= 0xe0:
+		return mpToken{kind: mpInt, i: int64(int8(c))}, nil
+	case c&0xf0 == 0x80:
+		return d.container(mpMap, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return d.container(mpArray, int(c&0x0f))
+	case c&0xe0 == 0xa0:
+		return d.payload(mpStr, int(c&0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return mpToken{kind: mpNil}, nil
+	case 0xc2, 0xc3:
+		return mpToken{kind: mpBool, b: c == 0xc3}, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uintN(1 << (c - 0xcc))
+		if err != nil {
+			return mpToken{}, err
+		}
+		if u > math.MaxInt64 {
+			return mpToken{kind: mpUint, u: u}, nil
+		}
+		return mpToken{kind: mpInt, i: int64(u)}, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := d.uintN(size)
+		if err != nil {
+			return mpToken{}, err
+		}
+		// sign extend from size bytes
+		shift := 64 - 8*size
+		return mpToken{kind: mpInt, i: int64(u<<shift) >> shift}, nil
+	case 0xca:
+		u, err := d.uintN(4)
+		return mpToken{kind: mpFloat, f: float64(math.Float32frombits(uint32(u)))}, err
+	case 0xcb:
+		u, err := d.uintN(8)
+		return mpToken{kind: mpFloat, f: math.Float64frombits(u)}, err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uintN(1 << (c - 0xd9))
+		if err != nil {
+			return mpToken{}, err
+		}
+		return d.payload(mpStr, int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uintN(1 << (c - 0xc4))
+		if err != nil {
+			return mpToken{}, err
+		}
+		return d.payload(mpBin, int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uintN(2 << (c - 0xdc))
+		if err != nil {
+			return mpToken{}, err
+		}
+		return d.container(mpArray, int(n))
+	case 0xde, 0xdf:
+		n, err := d.uintN(2 << (c - 0xde))
+		if err != nil {
+			return mpToken{}, err
+		}
+		return d.container(mpMap, int(n))
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uintN(1 << (c - 0xc7))
+		if err != nil {
+			return mpToken{}, err
+		}
+		return d.ext(int(n))
+	}
+	return mpToken{}, fmt.Errorf("%w: unknown format 0x%02x", ErrMsgPack, c)
+}
+
+func (d *mpDecoder) payload(kind mpKind, n int) (mpToken, error) {
+	data, err := d.read(n)
+	return mpToken{kind: kind, n: n, data: data}, err
+}
+
+// container checks n against the remaining data, every element takes at least a byte
+func (d *mpDecoder) container(kind mpKind, n int) (mpToken, error) {
+	size := n
+	if kind == mpMap {
+		size *= 2
+	}
+	if size < 0 || size > len(d.data)-d.pos {
+		return mpToken{}, fmt.Errorf("%w: %d elements past the end of data", ErrMsgPack, n)
+	}
+	return mpToken{kind: kind, n: n}, nil
+}
+
+func (d *mpDecoder) ext(n int) (mpToken, error) {
+	typ, err := d.read(1)
+	if err != nil {
+		return mpToken{}, err
+	}
+	tok, err := d.payload(mpExt, n)
+	tok.extType = int8(typ[0])
+	return tok, err
+}
+
+func (d *mpDecoder) decode(v reflect.Value) error {
+	tok, err := d.next()
+	if err != nil {
+		return err
+	}
+	return d.decodeToken(tok, v)
+}
+
+func (d *mpDecoder) decodeToken(tok mpToken, v reflect.Value) error {
+	if tok.kind == mpNil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Type() == timeType {
+		t, err := tok.time()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeToken(tok, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("codec: msgpack can't decode into %s", v.Type())
+		}
+		res, err := d.generic(tok)
+		if err != nil {
+			return err
+		}
+		if res == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(res))
+		}
+		return nil
+	case reflect.Bool:
+		if tok.kind == mpBool {
+			v.SetBool(tok.b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if tok.kind == mpInt && !v.OverflowInt(tok.i) {
+			v.SetInt(tok.i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := tok.u
+		if tok.kind == mpInt && tok.i >= 0 {
+			u = uint64(tok.i)
+		}
+		if (tok.kind == mpUint || tok.kind == mpInt && tok.i >= 0) && !v.OverflowUint(u) {
+			v.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch tok.kind {
+		case mpFloat:
+			v.SetFloat(tok.f)
+			return nil
+		case mpInt:
+			v.SetFloat(float64(tok.i))
+			return nil
+		case mpUint:
+			v.SetFloat(float64(tok.u))
+			return nil
+		}
+	case reflect.String:
+		if tok.kind == mpStr || tok.kind == mpBin {
+			v.SetString(string(tok.data))
+			return nil
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (tok.kind == mpBin || tok.kind == mpStr) {
+			v.SetBytes(append([]byte{}, tok.data...))
+			return nil
+		}
+		if tok.kind == mpArray {
+			s := reflect.MakeSlice(v.Type(), tok.n, tok.n)
+			for i := 0; i < tok.n; i++ {
+				if err := d.decode(s.Index(i)); err != nil {
+					return err
+				}
+			}
+			v.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		if tok.kind == mpArray && tok.n <= v.Len() {
+			v.Set(reflect.Zero(v.Type()))
+			for i := 0; i < tok.n; i++ {
+				if err := d.decode(v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if tok.kind == mpMap {
+			if v.IsNil() {
+				v.Set(reflect.MakeMapWithSize(v.Type(), tok.n))
+			}
+			for i := 0; i < tok.n; i++ {
+				k := reflect.New(v.Type().Key()).Elem()
+				if err := d.decode(k); err != nil {
+					return err
+				}
+				e := reflect.New(v.Type().Elem()).Elem()
+				if err := d.decode(e); err != nil {
+					return err
+				}
+				v.SetMapIndex(k, e)
+			}
+			return nil
+		}
+	case reflect.Struct:
+		if tok.kind == mpMap {
+			return d.structValue(tok.n, v)
+		}
+	}
+	return fmt.Errorf("codec: msgpack can't decode %s into %s", tok.kind, v.Type())
+}
+
+func (d *mpDecoder) structValue(n int, v reflect.Value) error {
+	fields := msgpackFields(v.Type())
+	for i := 0; i < n; i++ {
+		tok, err := d.next()
+		if err != nil {
+			return err
+		}
+		if tok.kind != mpStr {
+			return fmt.Errorf("codec: msgpack can't decode a %s key into %s", tok.kind, v.Type())
+		}
+		name := string(tok.data)
+		found := false
+		for _, f := range fields {
+			if f.name == name {
+				if err = d.decode(v.Field(f.index)); err != nil {
+					return err
+				}
+				found = true
+				break
+			}
+		}
+		// unknown keys, e.g. fields removed since the value was written, are skipped
+		if !found {
+			if err = d.skip(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *mpDecoder) skip() error {
+	tok, err := d.next()
+	if err != nil {
+		return err
+	}
+	_, err = d.generic(tok)
+	return err
+}
+
+// generic decodes the value of tok into the types of an any
+func (d *mpDecoder) generic(tok mpToken) (any, error) {
+	switch tok.kind {
+	case mpNil:
+		return nil, nil
+	case mpBool:
+		return tok.b, nil
+	case mpInt:
+		return tok.i, nil
+	case mpUint:
+		return tok.u, nil
+	case mpFloat:
+		return tok.f, nil
+	case mpStr:
+		return string(tok.data), nil
+	case mpBin:
+		return append([]byte{}, tok.data...), nil
+	case mpExt:
+		return tok.time()
+	case mpArray:
+		res := make([]any, tok.n)
+		for i := range res {
+			if err := d.decode(reflect.ValueOf(&res[i]).Elem()); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	keys, vals := make([]any, tok.n), make([]any, tok.n)
+	strKeys := true
+	for i := 0; i < tok.n; i++ {
+		if err := d.decode(reflect.ValueOf(&keys[i]).Elem()); err != nil {
+			return nil, err
+		}
+		if err := d.decode(reflect.ValueOf(&vals[i]).Elem()); err != nil {
+			return nil, err
+		}
+		_, ok := keys[i].(string)
+		strKeys = strKeys && ok
+	}
+	if strKeys {
+		res := make(map[string]any, tok.n)
+		for i, k := range keys {
+			res[k.(string)] = vals[i]
+		}
+		return res, nil
+	}
+	res := make(map[any]any, tok.n)
+	for i, k := range keys {
+		if k != nil && !reflect.TypeOf(k).Comparable() {
+			return nil, fmt.Errorf("codec: msgpack can't use a %T as a map key", k)
+		}
+		res[k] = vals[i]
+	}
+	return res, nil
+}
+
+// time decodes the timestamp extension
+func (tok mpToken) time() (time.Time, error) {
+	if tok.kind != mpExt || tok.extType != -1 {
+		return time.Time{}, fmt.Errorf("codec: msgpack can't decode %s into time.Time", tok.kind)
+	}
+	b := tok.data
+	switch len(b) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
+	case 8:
+		u := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(u&(1<<34-1)), int64(u>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b))), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: timestamp of %d bytes", ErrMsgPack, len(b))
+}
+
+func (k mpKind) String() string {
+	return [...]string{"nil", "bool", "int", "uint", "float", "str", "bin", "array", "map", "ext"}[k]
+}
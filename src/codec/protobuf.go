@@ -0,0 +1,566 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var Protobuf Codec = protobufCodec{}
+
+// ErrProtobuf is returned for truncated or malformed protobuf data
+var ErrProtobuf = errors.New("codec: invalid protobuf data")
+
+// protoMarshaler and protoUnmarshaler are the methods of gogo/protobuf and vtprotobuf messages
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type protoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(protoMarshaler); ok {
+		return m.Marshal()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("codec: protobuf needs a non-nil pointer to a message, got %T", v)
+	}
+	return appendMessage(nil, rv.Elem())
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(protoUnmarshaler); ok {
+		return m.Unmarshal(data)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("codec: protobuf needs a non-nil pointer to a message, got %T", v)
+	}
+	rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	return decodeMessage(data, rv.Elem())
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoTag is a parsed protobuf struct tag, e.g. "varint,1,opt,name=id,proto3"
+type protoTag struct {
+	enc string
+	num int
+}
+
+func (t protoTag) wire() int {
+	switch t.enc {
+	case "varint", "zigzag32", "zigzag64":
+		return wireVarint
+	case "fixed64":
+		return wireFixed64
+	case "fixed32":
+		return wireFixed32
+	}
+	return wireBytes
+}
+
+func parseProtoTag(s string) (protoTag, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 3 {
+		return protoTag{}, fmt.Errorf("codec: invalid protobuf tag %q", s)
+	}
+	num, err := strconv.Atoi(parts[1])
+	if err != nil || num <= 0 {
+		return protoTag{}, fmt.Errorf("codec: invalid protobuf tag %q", s)
+	}
+	switch parts[0] {
+	case "varint", "zigzag32", "zigzag64", "fixed32", "fixed64", "bytes":
+	default:
+		return protoTag{}, fmt.Errorf("codec: unsupported protobuf encoding %q", parts[0])
+	}
+	return protoTag{enc: parts[0], num: num}, nil
+}
+
+// protoField is a struct field with a protobuf tag; key and val are set for maps,
+// oneof holds the wrapper types by field number for a oneof interface field
+type protoField struct {
+	tag   protoTag
+	index int
+	key   protoTag
+	val   protoTag
+	oneof map[int]reflect.Type
+}
+
+var protoFields sync.Map // reflect.Type -> []protoField
+
+// oneofWrappers is implemented by generated messages with oneof fields
+type oneofWrappers interface {
+	XXX_OneofWrappers() []any
+}
+
+func messageFields(t reflect.Type) ([]protoField, error) {
+	if res, ok := protoFields.Load(t); ok {
+		return res.([]protoField), nil
+	}
+	var (
+		res      []protoField
+		wrappers map[int]reflect.Type
+	)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if _, ok := f.Tag.Lookup("protobuf_oneof"); ok {
+			if wrappers == nil {
+				w, ok := reflect.New(t).Interface().(oneofWrappers)
+				if !ok {
+					return nil, fmt.Errorf("codec: %s has a oneof but no XXX_OneofWrappers", t)
+				}
+				wrappers = make(map[int]reflect.Type)
+				for _, v := range w.XXX_OneofWrappers() {
+					wt := reflect.TypeOf(v)
+					tag, err := parseProtoTag(wt.Elem().Field(0).Tag.Get("protobuf"))
+					if err != nil {
+						return nil, err
+					}
+					wrappers[tag.num] = wt
+				}
+			}
+			oneof := make(map[int]reflect.Type)
+			for num, wt := range wrappers {
+				if wt.Implements(f.Type) {
+					oneof[num] = wt
+				}
+			}
+			res = append(res, protoField{index: i, oneof: oneof})
+			continue
+		}
+		s, ok := f.Tag.Lookup("protobuf")
+		if !ok {
+			continue
+		}
+		tag, err := parseProtoTag(s)
+		if err != nil {
+			return nil, err
+		}
+		pf := protoField{tag: tag, index: i}
+		if f.Type.Kind() == reflect.Map {
+			if pf.key, err = parseProtoTag(f.Tag.Get("protobuf_key")); err != nil {
+				return nil, err
+			}
+			if pf.val, err = parseProtoTag(f.Tag.Get("protobuf_val")); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, pf)
+	}
+	protoFields.Store(t, res)
+	return res, nil
+}
+
+func appendVarint(b []byte, u uint64) []byte {
+	return binary.AppendUvarint(b, u)
+}
+
+func appendKey(b []byte, num, wire int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wire))
+}
+
+// appendMessage writes the fields of the struct v, zero scalars are left out as proto3 does
+func appendMessage(b []byte, v reflect.Value) ([]byte, error) {
+	fields, err := messageFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		switch {
+		case f.oneof != nil:
+			if fv.IsNil() {
+				continue
+			}
+			w := fv.Elem().Elem()
+			tag, err := parseProtoTag(w.Type().Field(0).Tag.Get("protobuf"))
+			if err != nil {
+				return nil, err
+			}
+			// a oneof member is written even when it is zero, its presence is the point
+			if b, err = appendField(b, tag, w.Field(0)); err != nil {
+				return nil, err
+			}
+		case fv.Kind() == reflect.Map:
+			if b, err = appendMap(b, f, fv); err != nil {
+				return nil, err
+			}
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8:
+			if b, err = appendRepeated(b, f.tag, fv); err != nil {
+				return nil, err
+			}
+		default:
+			if fv.IsZero() || fv.Kind() == reflect.Slice && fv.Len() == 0 {
+				continue
+			}
+			if b, err = appendField(b, f.tag, fv); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendRepeated packs scalars and writes strings, bytes and messages one field each
+func appendRepeated(b []byte, tag protoTag, v reflect.Value) ([]byte, error) {
+	if v.Len() == 0 {
+		return b, nil
+	}
+	var err error
+	if tag.wire() != wireBytes {
+		var packed []byte
+		for i := 0; i < v.Len(); i++ {
+			if packed, err = appendScalar(packed, tag, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		b = appendKey(b, tag.num, wireBytes)
+		b = appendVarint(b, uint64(len(packed)))
+		return append(b, packed...), nil
+	}
+	for i := 0; i < v.Len(); i++ {
+		if b, err = appendField(b, tag, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendMap writes every entry as a message of its key (field 1) and value (field 2), in key order
+func appendMap(b []byte, f protoField, v reflect.Value) ([]byte, error) {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	for _, k := range keys {
+		entry, err := appendField(nil, f.key, k)
+		if err != nil {
+			return nil, err
+		}
+		if entry, err = appendField(entry, f.val, v.MapIndex(k)); err != nil {
+			return nil, err
+		}
+		b = appendKey(b, f.tag.num, wireBytes)
+		b = appendVarint(b, uint64(len(entry)))
+		b = append(b, entry...)
+	}
+	return b, nil
+}
+
+// appendField writes the key and the value of a single field
+func appendField(b []byte, tag protoTag, v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		if v.Type().Elem().Kind() == reflect.Struct {
+			// an empty message
+			v = reflect.New(v.Type().Elem())
+		} else {
+			return b, nil
+		}
+	}
+	b = appendKey(b, tag.num, tag.wire())
+	return appendScalar(b, tag, v)
+}
+
+// appendScalar writes a value without its key, bytes values are length prefixed
+func appendScalar(b []byte, tag protoTag, v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Pointer && v.Type().Elem().Kind() != reflect.Struct {
+		v = v.Elem()
+	}
+	switch tag.enc {
+	case "varint":
+		switch v.Kind() {
+		case reflect.Bool:
+			if v.Bool() {
+				return append(b, 1), nil
+			}
+			return append(b, 0), nil
+		case reflect.Int32, reflect.Int64, reflect.Int:
+			return appendVarint(b, uint64(v.Int())), nil
+		case reflect.Uint32, reflect.Uint64, reflect.Uint:
+			return appendVarint(b, v.Uint()), nil
+		}
+	case "zigzag32":
+		if v.Kind() == reflect.Int32 {
+			x := int32(v.Int())
+			return appendVarint(b, uint64(uint32(x<<1)^uint32(x>>31))), nil
+		}
+	case "zigzag64":
+		if v.Kind() == reflect.Int64 {
+			x := v.Int()
+			return appendVarint(b, uint64(x<<1)^uint64(x>>63)), nil
+		}
+	case "fixed32":
+		switch v.Kind() {
+		case reflect.Uint32:
+			return binary.LittleEndian.AppendUint32(b, uint32(v.Uint())), nil
+		case reflect.Int32:
+			return binary.LittleEndian.AppendUint32(b, uint32(v.Int())), nil
+		case reflect.Float32:
+			return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v.Float()))), nil
+		}
+	case "fixed64":
+		switch v.Kind() {
+		case reflect.Uint64:
+			return binary.LittleEndian.AppendUint64(b, v.Uint()), nil
+		case reflect.Int64:
+			return binary.LittleEndian.AppendUint64(b, uint64(v.Int())), nil
+		case reflect.Float64:
+			return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+		}
+	case "bytes":
+		switch {
+		case v.Kind() == reflect.String:
+			b = appendVarint(b, uint64(v.Len()))
+			return append(b, v.String()...), nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			b = appendVarint(b, uint64(v.Len()))
+			return append(b, v.Bytes()...), nil
+		case v.Kind() == reflect.Pointer:
+			msg, err := appendMessage(nil, v.Elem())
+			if err != nil {
+				return nil, err
+			}
+			b = appendVarint(b, uint64(len(msg)))
+			return append(b, msg...), nil
+		}
+	}
+	return nil, fmt.Errorf("codec: protobuf can't encode %s as %s", v.Type(), tag.enc)
+}
+
+// protoReader reads the fields of a message
+type protoReader struct {
+	data []byte
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	u, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: bad varint", ErrProtobuf)
+	}
+	r.data = r.data[n:]
+	return u, nil
+}
+
+func (r *protoReader) read(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)) {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrProtobuf)
+	}
+	res := r.data[:n]
+	r.data = r.data[n:]
+	return res, nil
+}
+
+// value reads a value of the wire type, a number for the scalar types and the payload for bytes
+func (r *protoReader) value(wire int) (uint64, []byte, error) {
+	switch wire {
+	case wireVarint:
+		u, err := r.varint()
+		return u, nil, err
+	case wireFixed64:
+		b, err := r.read(8)
+		if err != nil {
+			return 0, nil, err
+		}
+		return binary.LittleEndian.Uint64(b), nil, nil
+	case wireFixed32:
+		b, err := r.read(4)
+		if err != nil {
+			return 0, nil, err
+		}
+		return uint64(binary.LittleEndian.Uint32(b)), nil, nil
+	case wireBytes:
+		n, err := r.varint()
+		if err != nil {
+			return 0, nil, err
+		}
+		b, err := r.read(n)
+		return 0, b, err
+	}
+	return 0, nil, fmt.Errorf("%w: unsupported wire type %d", ErrProtobuf, wire)
+}
+
+// decodeMessage merges data into the struct v, unknown fields are skipped
+func decodeMessage(data []byte, v reflect.Value) error {
+	fields, err := messageFields(v.Type())
+	if err != nil {
+		return err
+	}
+	r := &protoReader{data: data}
+	for len(r.data) > 0 {
+		key, err := r.varint()
+		if err != nil {
+			return err
+		}
+		num, wire := int(key>>3), int(key&7)
+		u, b, err := r.value(wire)
+		if err != nil {
+			return err
+		}
+		if err = decodeField(fields, v, num, wire, u, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeField(fields []protoField, v reflect.Value, num, wire int, u uint64, b []byte) error {
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.oneof != nil {
+			wt, ok := f.oneof[num]
+			if !ok {
+				continue
+			}
+			w := reflect.New(wt.Elem())
+			if !fv.IsNil() && fv.Elem().Type() == wt {
+				// a repeated message member merges into the previous one
+				w.Elem().Set(fv.Elem().Elem())
+			}
+			tag, err := parseProtoTag(wt.Elem().Field(0).Tag.Get("protobuf"))
+			if err != nil {
+				return err
+			}
+			if err = setScalar(tag, wire, u, b, w.Elem().Field(0)); err != nil {
+				return err
+			}
+			fv.Set(w)
+			return nil
+		}
+		if f.tag.num != num {
+			continue
+		}
+		switch {
+		case fv.Kind() == reflect.Map:
+			return decodeMapEntry(f, b, fv)
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8:
+			return decodeRepeated(f.tag, wire, u, b, fv)
+		}
+		return setScalar(f.tag, wire, u, b, fv)
+	}
+	return nil
+}
+
+// decodeRepeated appends a value, or every value of a packed field
+func decodeRepeated(tag protoTag, wire int, u uint64, b []byte, v reflect.Value) error {
+	if wire == wireBytes && tag.wire() != wireBytes {
+		r := &protoReader{data: b}
+		for len(r.data) > 0 {
+			u, _, err := r.value(tag.wire())
+			if err != nil {
+				return err
+			}
+			if err = decodeRepeated(tag, tag.wire(), u, nil, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	e := reflect.New(v.Type().Elem()).Elem()
+	if err := setScalar(tag, wire, u, b, e); err != nil {
+		return err
+	}
+	v.Set(reflect.Append(v, e))
+	return nil
+}
+
+func decodeMapEntry(f protoField, b []byte, v reflect.Value) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	k, e := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+	r := &protoReader{data: b}
+	for len(r.data) > 0 {
+		key, err := r.varint()
+		if err != nil {
+			return err
+		}
+		num, wire := int(key>>3), int(key&7)
+		u, b, err := r.value(wire)
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			err = setScalar(f.key, wire, u, b, k)
+		case 2:
+			err = setScalar(f.val, wire, u, b, e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// an entry without a message value still holds an empty message
+	if e.Kind() == reflect.Pointer && e.IsNil() {
+		e.Set(reflect.New(e.Type().Elem()))
+	}
+	v.SetMapIndex(k, e)
+	return nil
+}
+
+// setScalar sets v, or the value v points to for optional scalars, from a value read off the wire
+func setScalar(tag protoTag, wire int, u uint64, b []byte, v reflect.Value) error {
+	if wire != tag.wire() {
+		return fmt.Errorf("%w: wire type %d for a %s field", ErrProtobuf, wire, tag.enc)
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().Elem().Kind() == reflect.Struct {
+			return decodeMessage(b, v.Elem())
+		}
+		v = v.Elem()
+	}
+	switch tag.enc {
+	case "zigzag32":
+		u = uint64(int64(int32(uint32(u)>>1) ^ -int32(u&1)))
+	case "zigzag64":
+		u = u>>1 ^ -(u & 1)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(u != 0)
+	case reflect.Int32:
+		v.SetInt(int64(int32(u)))
+	case reflect.Int64, reflect.Int:
+		v.SetInt(int64(u))
+	case reflect.Uint32:
+		v.SetUint(uint64(uint32(u)))
+	case reflect.Uint64, reflect.Uint:
+		v.SetUint(u)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(u))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(u))
+	case reflect.String:
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("codec: protobuf can't decode into %s", v.Type())
+		}
+		v.SetBytes(append([]byte{}, b...))
+	default:
+		return fmt.Errorf("codec: protobuf can't decode into %s", v.Type())
+	}
+	return nil
+}
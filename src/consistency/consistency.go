@@ -0,0 +1,91 @@
+/*
+The package implements the delayed double delete pattern for cache-aside setups:
+
+	1. delete the cache key
+	2. write the source through the user callback
+	3. delete the cache key again after a delay
+
+The second delete evicts values that concurrent readers loaded from the source before the write
+committed and put back into the cache. The delay should cover a read plus a cache write,
+and the replication lag when reads go to a replica.
+
+The second delete is scheduled in process by default; RedisQueue keeps it in a redis sorted set
+so it survives restarts and is executed by whichever worker runs the queue.
+*/
+
+package consistency
+
+import (
+	"cache/src/cache"
+	"context"
+	"time"
+)
+
+// Scheduler runs the second delete of key after delay
+type Scheduler interface {
+	Schedule(ctx context.Context, key string, delay time.Duration) error
+}
+
+type Option func(d *DoubleDeleter)
+
+// WithScheduler replaces the in process scheduler, e.g. with a RedisQueue
+func WithScheduler(s Scheduler) Option {
+	return func(d *DoubleDeleter) {
+		d.scheduler = s
+	}
+}
+
+// WithErrorHandler receives the errors of the second delete, which happens after Update returned
+func WithErrorHandler(fn func(key string, err error)) Option {
+	return func(d *DoubleDeleter) {
+		d.onError = fn
+	}
+}
+
+type DoubleDeleter struct {
+	cache     cache.Cache
+	delay     time.Duration
+	scheduler Scheduler
+	onError   func(key string, err error)
+}
+
+func NewDoubleDeleter(c cache.Cache, delay time.Duration, opts ...Option) *DoubleDeleter {
+	res := &DoubleDeleter{
+		cache:   c,
+		delay:   delay,
+		onError: func(string, error) {},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.scheduler == nil {
+		res.scheduler = &localScheduler{d: res}
+	}
+	return res
+}
+
+// Update deletes key, runs write and schedules the second delete; the second delete is
+// scheduled even if write fails, since the write may have been partly applied
+func (d *DoubleDeleter) Update(ctx context.Context, key string, write func(ctx context.Context) error) error {
+	if err := d.cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	err := write(ctx)
+	if sErr := d.scheduler.Schedule(ctx, key, d.delay); sErr != nil && err == nil {
+		err = sErr
+	}
+	return err
+}
+
+type localScheduler struct {
+	d *DoubleDeleter
+}
+
+func (s *localScheduler) Schedule(_ context.Context, key string, delay time.Duration) error {
+	time.AfterFunc(delay, func() {
+		if err := s.d.cache.Delete(context.Background(), key); err != nil {
+			s.d.onError(key, err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,70 @@
+package consistency
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"cache/src/redis_cache"
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDoubleDelete(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLocal(local_cache.NewCache(time.Minute, 0))
+	d := NewDoubleDeleter(c, 20*time.Millisecond)
+
+	_ = c.Set(ctx, "name", "old", cache.DefaultExpire)
+	err := d.Update(ctx, "name", func(ctx context.Context) error {
+		if _, err := c.Get(ctx, "name"); err != cache.ErrNotFound {
+			t.Errorf("first delete should run before the write, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a concurrent reader put the stale value back
+	_ = c.Set(ctx, "name", "old", cache.DefaultExpire)
+	time.Sleep(50 * time.Millisecond)
+	if _, err = c.Get(ctx, "name"); err != cache.ErrNotFound {
+		t.Fatalf("second delete should evict the stale value, got %v", err)
+	}
+}
+
+func TestDoubleDeleteWriteError(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLocal(local_cache.NewCache(time.Minute, 0))
+	d := NewDoubleDeleter(c, time.Millisecond)
+	wErr := errors.New("write failed")
+	if err := d.Update(ctx, "name", func(ctx context.Context) error { return wErr }); err != wErr {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+}
+
+// TestRedisQueue needs a redis server, set REDIS_ADDR to run it
+func TestRedisQueue(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	c := cache.NewRedis(redis_cache.NewCache(rdb, time.Minute, redis_cache.WithPrefix("consistency:")))
+	q := NewRedisQueue(rdb, "consistency:queue", c, 10*time.Millisecond)
+	d := NewDoubleDeleter(c, 20*time.Millisecond, WithScheduler(q))
+	go q.Run(ctx, func(key string, err error) { t.Log(key, err) })
+
+	if err := d.Update(ctx, "name", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Set(ctx, "name", "old", cache.DefaultExpire)
+	time.Sleep(200 * time.Millisecond)
+	if _, err := c.Get(ctx, "name"); err != cache.ErrNotFound {
+		t.Fatalf("second delete should evict the stale value, got %v", err)
+	}
+}
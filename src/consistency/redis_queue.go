@@ -0,0 +1,80 @@
+package consistency
+
+import (
+	"cache/src/cache"
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+var _ Scheduler = (*RedisQueue)(nil)
+
+// RedisQueue is a delayed queue in a redis sorted set scored by the due time in milliseconds;
+// several workers may run the same queue, each key is claimed by the worker whose ZREM succeeds
+type RedisQueue struct {
+	client   redis.Cmdable
+	queue    string
+	cache    cache.Cache
+	interval time.Duration
+}
+
+func NewRedisQueue(client redis.Cmdable, queue string, c cache.Cache, pollInterval time.Duration) *RedisQueue {
+	if pollInterval <= 0 {
+		pollInterval = 100 * time.Millisecond
+	}
+	return &RedisQueue{
+		client:   client,
+		queue:    queue,
+		cache:    c,
+		interval: pollInterval,
+	}
+}
+
+func (q *RedisQueue) Schedule(ctx context.Context, key string, delay time.Duration) error {
+	return q.client.ZAdd(ctx, q.queue, redis.Z{
+		Score:  float64(time.Now().Add(delay).UnixMilli()),
+		Member: key,
+	}).Err()
+}
+
+// Run deletes due keys until ctx is done, errors are passed to onError and don't stop the loop
+func (q *RedisQueue) Run(ctx context.Context, onError func(key string, err error)) error {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		if err := q.poll(ctx, onError); err != nil && ctx.Err() == nil {
+			onError("", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (q *RedisQueue) poll(ctx context.Context, onError func(key string, err error)) error {
+	keys, err := q.client.ZRangeByScore(ctx, q.queue, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		n, err := q.client.ZRem(ctx, q.queue, key).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// claimed by another worker
+			continue
+		}
+		if err = q.cache.Delete(ctx, key); err != nil {
+			onError(key, err)
+		}
+	}
+	return nil
+}
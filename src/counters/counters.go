@@ -0,0 +1,137 @@
+/*
+The package counts events over rolling windows on a local_cache, for lightweight rate metrics such as
+requests per user per minute without a redis:
+
+	IncrWindow, IncrWindowBy: Adds to the counter of a key and returns its sum over the last window.
+	Sum: Returns the sum of a counter over the last window.
+	Rate: Returns the sum over the last window per second.
+	Reset: Deletes the buckets of a counter.
+
+A window is split into buckets, 10 by default, see WithBuckets: the sum covers the current bucket and
+the ones before it within the window, so it slides by one bucket at a time. Every bucket is an item
+of the cache expiring once it left the window, nothing has to clean them up.
+*/
+
+package counters
+
+import (
+	"cache/src/local_cache"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrInvalidWindow = errors.New("counters: window must be at least one nanosecond per bucket")
+
+type Option func(c *Counters)
+
+// WithBuckets splits the windows into n buckets, more buckets slide more smoothly but cost more reads
+func WithBuckets(n int) Option {
+	return func(c *Counters) {
+		if n > 0 {
+			c.buckets = n
+		}
+	}
+}
+
+type Counters struct {
+	c       *local_cache.Cache
+	buckets int
+	// mu makes the read and write of a bucket atomic
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// New returns counters on a new local_cache, cleanupInterval is passed to it
+func New(cleanupInterval time.Duration, opts ...Option) *Counters {
+	c := &Counters{
+		c:       local_cache.NewCache(local_cache.NoExpire, cleanupInterval, local_cache.WithLazyExpiry()),
+		buckets: 10,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// IncrWindow adds 1 to the counter of key and returns its sum over the last window
+func (c *Counters) IncrWindow(key string, window time.Duration) (int64, error) {
+	return c.IncrWindowBy(key, window, 1)
+}
+
+// IncrWindowBy adds n to the counter of key and returns its sum over the last window; the counters of
+// a key with different windows are distinct
+func (c *Counters) IncrWindowBy(key string, window time.Duration, n int64) (int64, error) {
+	width, err := c.width(window)
+	if err != nil {
+		return 0, err
+	}
+	cur := c.now().UnixNano() / int64(width)
+	k := bucketKey(key, window, cur)
+	c.mu.Lock()
+	v, _ := c.c.Get(k)
+	count, _ := v.(int64)
+	// the bucket leaves the window one window after it ended
+	err = c.c.Set(k, count+n, window+width)
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return c.sum(key, window, cur), nil
+}
+
+// Sum returns the sum of the counter of key over the last window
+func (c *Counters) Sum(key string, window time.Duration) (int64, error) {
+	width, err := c.width(window)
+	if err != nil {
+		return 0, err
+	}
+	return c.sum(key, window, c.now().UnixNano()/int64(width)), nil
+}
+
+// Rate returns the sum of the counter of key over the last window per second
+func (c *Counters) Rate(key string, window time.Duration) (float64, error) {
+	sum, err := c.Sum(key, window)
+	if err != nil {
+		return 0, err
+	}
+	return float64(sum) / window.Seconds(), nil
+}
+
+// Reset deletes the buckets of the counter of key with window
+func (c *Counters) Reset(key string, window time.Duration) error {
+	width, err := c.width(window)
+	if err != nil {
+		return err
+	}
+	cur := c.now().UnixNano() / int64(width)
+	for i := int64(0); i < int64(c.buckets); i++ {
+		c.c.Delete(bucketKey(key, window, cur-i))
+	}
+	return nil
+}
+
+func (c *Counters) width(window time.Duration) (time.Duration, error) {
+	width := window / time.Duration(c.buckets)
+	if width <= 0 {
+		return 0, ErrInvalidWindow
+	}
+	return width, nil
+}
+
+// sum adds the buckets of the window ending with bucket cur
+func (c *Counters) sum(key string, window time.Duration, cur int64) int64 {
+	var res int64
+	for i := int64(0); i < int64(c.buckets); i++ {
+		if v, ok := c.c.Get(bucketKey(key, window, cur-i)); ok {
+			res += v.(int64)
+		}
+	}
+	return res
+}
+
+func bucketKey(key string, window time.Duration, bucket int64) string {
+	return key + "|" + strconv.FormatInt(int64(window), 36) + "|" + strconv.FormatInt(bucket, 36)
+}
@@ -0,0 +1,52 @@
+package counters
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIncrWindow(t *testing.T) {
+	c := New(0, WithBuckets(6))
+	now := time.Date(2023, 3, 15, 16, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, _ = c.IncrWindow("user:1", time.Minute)
+	}
+	now = now.Add(30 * time.Second)
+	if n, _ := c.IncrWindowBy("user:1", time.Minute, 2); n != 5 {
+		t.Fatalf("expected 5 in the window, got %d", n)
+	}
+	if n, _ := c.Sum("user:1", time.Hour); n != 0 {
+		t.Fatalf("counters of other windows are distinct, got %d", n)
+	}
+	// the first bucket leaves the window, one 10s bucket at a time
+	now = now.Add(30 * time.Second)
+	if n, _ := c.Sum("user:1", time.Minute); n != 2 {
+		t.Fatalf("expected 2 once the first bucket left, got %d", n)
+	}
+	if r, _ := c.Rate("user:1", time.Minute); r != 2.0/60 {
+		t.Fatalf("unexpected rate %v", r)
+	}
+	_ = c.Reset("user:1", time.Minute)
+	if n, _ := c.Sum("user:1", time.Minute); n != 0 {
+		t.Fatalf("expected 0 after Reset, got %d", n)
+	}
+	if _, err := c.IncrWindow("user:1", 5); err != ErrInvalidWindow {
+		t.Fatalf("expected ErrInvalidWindow, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = c.IncrWindow("concurrent", time.Minute)
+		}()
+	}
+	wg.Wait()
+	if n, _ := c.Sum("concurrent", time.Minute); n != 50 {
+		t.Fatalf("concurrent increments should all count, got %d", n)
+	}
+}
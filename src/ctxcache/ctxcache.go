@@ -0,0 +1,132 @@
+/*
+The package adds a request-scoped layer in front of a cache.Cache: reads are remembered in the context
+of the request, so repeated lookups of a key within one request reach the shared cache once, and
+nothing request-specific is written to it:
+
+	ctx = ctxcache.WithScope(ctx) // or Middleware for HTTP handlers
+	c := ctxcache.New(shared)
+	c.Get(ctx, "user:1") // shared cache
+	c.Get(ctx, "user:1") // request scope
+
+Misses are remembered too. Set, Delete and Flush go to the shared cache and update the scope, so a
+request reads its own writes. Without a scope in the context the calls go straight to the shared cache.
+*/
+
+package ctxcache
+
+import (
+	"cache/src/cache"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type scopeKey struct{}
+
+type entry struct {
+	val any
+	err error
+}
+
+type scope struct {
+	mu    sync.Mutex
+	items map[string]entry
+}
+
+// WithScope returns a context carrying an empty request scope
+func WithScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &scope{items: make(map[string]entry)})
+}
+
+// Middleware gives every request its own scope
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithScope(r.Context())))
+	})
+}
+
+func scopeOf(ctx context.Context) *scope {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	return s
+}
+
+var _ cache.Cache = (*Cache)(nil)
+
+type Cache struct {
+	c cache.Cache
+}
+
+// New returns a cache.Cache reading through the scope of each call's context to c
+func New(c cache.Cache) *Cache {
+	return &Cache{c: c}
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (any, error) {
+	s := scopeOf(ctx)
+	if s == nil {
+		return c.c.Get(ctx, key)
+	}
+	s.mu.Lock()
+	e, ok := s.items[key]
+	s.mu.Unlock()
+	if ok {
+		return e.val, e.err
+	}
+	val, err := c.c.Get(ctx, key)
+	// other errors are failures of the shared cache, the next read retries
+	if err == nil || err == cache.ErrNotFound {
+		s.mu.Lock()
+		s.items[key] = entry{val: val, err: err}
+		s.mu.Unlock()
+	}
+	return val, err
+}
+
+func (c *Cache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	if err := c.c.Set(ctx, key, val, ttl); err != nil {
+		return err
+	}
+	if s := scopeOf(ctx); s != nil {
+		s.mu.Lock()
+		s.items[key] = entry{val: val}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	if err := c.c.Delete(ctx, key); err != nil {
+		return err
+	}
+	if s := scopeOf(ctx); s != nil {
+		s.mu.Lock()
+		s.items[key] = entry{err: cache.ErrNotFound}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *Cache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.c.TTL(ctx, key)
+}
+
+func (c *Cache) Flush(ctx context.Context) error {
+	if err := c.c.Flush(ctx); err != nil {
+		return err
+	}
+	if s := scopeOf(ctx); s != nil {
+		s.mu.Lock()
+		s.items = make(map[string]entry)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *Cache) Stats(ctx context.Context) (cache.Stats, error) {
+	return c.c.Stats(ctx)
+}
+
+func (c *Cache) HealthCheck(ctx context.Context) error {
+	return c.c.HealthCheck(ctx)
+}
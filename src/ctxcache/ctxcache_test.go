@@ -0,0 +1,45 @@
+package ctxcache
+
+import (
+	"cache/src/cache"
+	"cache/src/cachetest"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	shared := cachetest.NewRecorder(cachetest.NewFake())
+	c := New(shared)
+	ctx := WithScope(context.Background())
+
+	_ = shared.Set(ctx, "user:1", "will", cache.NoExpire)
+	for i := 0; i < 3; i++ {
+		if v, err := c.Get(ctx, "user:1"); err != nil || v != "will" {
+			t.Fatalf("unexpected get result %v %v", v, err)
+		}
+		if _, err := c.Get(ctx, "user:2"); err != cache.ErrNotFound {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	_ = c.Set(ctx, "user:2", "yin", cache.NoExpire)
+	if v, _ := c.Get(ctx, "user:2"); v != "yin" {
+		t.Fatalf("the request should read its own write, got %v", v)
+	}
+	_, _ = c.Get(context.Background(), "user:1")
+	shared.AssertCalls(t, "Set user:1", "Get user:1", "Get user:2", "Set user:2", "Get user:1")
+}
+
+func TestMiddleware(t *testing.T) {
+	shared := cachetest.NewRecorder(cachetest.NewFake())
+	c := New(shared)
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = c.Get(r.Context(), "user:1")
+		_, _ = c.Get(r.Context(), "user:1")
+	}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	shared.AssertCalls(t, "Get user:1", "Get user:1")
+}
@@ -0,0 +1,163 @@
+package distcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Authentication between peers, for groups spread over networks that are not fully trusted:
+
+	WithTLS: Talks to the peers over TLS, MutualTLS builds a config where both ends present a
+	certificate signed by a shared CA; the pool then rejects the requests without a verified client
+	certificate, so the http.Server serving it must use the same config.
+	WithSecret: Signs every request with an HMAC of a secret shared by the nodes, over the method, the
+	path, the name of the sender, the time and the body; the pool rejects the requests with a missing,
+	wrong or more than AuthMaxSkew old signature.
+	Group.Allow: Restricts a group to some peers, named by their base URL or, under mutual TLS, by the
+	common name or a DNS name of their certificate.
+
+The name a peer sends is only as trusted as the secret, any node holding it can claim any name; under
+mutual TLS the names of the certificate are trusted. Without either, ACLs only guard against mistakes.
+*/
+
+const (
+	peerHeader = "X-Distcache-Peer"
+	authHeader = "X-Distcache-Auth"
+)
+
+// AuthMaxSkew bounds the age of a signed request, a signature may be replayed within it
+var AuthMaxSkew = time.Minute
+
+// WithTLS sets the TLS config the pool reaches its peers with, and requires a verified client
+// certificate on the requests it serves; the client of WithHTTPClient is used as is
+func WithTLS(cfg *tls.Config) PoolOption {
+	return func(p *Pool) {
+		p.tls = cfg
+	}
+}
+
+// WithSecret signs the requests to the peers with secret and rejects the requests not signed with it
+func WithSecret(secret []byte) PoolOption {
+	return func(p *Pool) {
+		p.secret = append([]byte(nil), secret...)
+	}
+}
+
+// MutualTLS returns a config presenting cert and verifying the other end against ca, for both the
+// http.Server serving the pool and WithTLS
+func MutualTLS(cert tls.Certificate, ca *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca,
+		ClientCAs:    ca,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// acl holds the peers allowed to reach a group, nil allows every peer
+type acl struct {
+	mu    sync.RWMutex
+	peers map[string]bool
+}
+
+// Allow restricts the peers reaching the group to peers, none lifts the restriction; this node
+// always reaches its own group
+func (g *Group) Allow(peers ...string) {
+	var m map[string]bool
+	if len(peers) > 0 {
+		m = make(map[string]bool, len(peers))
+		for _, peer := range peers {
+			m[peer] = true
+		}
+	}
+	g.acl.mu.Lock()
+	g.acl.peers = m
+	g.acl.mu.Unlock()
+}
+
+func (a *acl) allows(names []string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.peers == nil {
+		return true
+	}
+	for _, name := range names {
+		if a.peers[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// request builds a request for key of group on peer, signed when the pool has a secret
+func (p *Pool) request(ctx context.Context, method, peer, group, key string, body []byte) (*http.Request, error) {
+	path := p.basePath + url.PathEscape(group) + "/" + url.PathEscape(key)
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(peer, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(peerHeader, p.self)
+	if p.secret != nil {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(authHeader, ts+":"+p.sign(method, path, p.self, ts, body))
+	}
+	return req, nil
+}
+
+func (p *Pool) sign(method, path, peer, ts string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, p.secret)
+	for _, s := range []string{method, path, peer, ts, hex.EncodeToString(sum[:])} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authorize checks a request served for g, it returns the status to reject it with or 0
+func (p *Pool) authorize(r *http.Request, g *Group, body []byte) int {
+	var names []string
+	if p.tls != nil {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return http.StatusUnauthorized
+		}
+		cert := r.TLS.VerifiedChains[0][0]
+		names = append(append(names, cert.Subject.CommonName), cert.DNSNames...)
+	}
+	peer := r.Header.Get(peerHeader)
+	if p.secret != nil {
+		ts, mac, _ := strings.Cut(r.Header.Get(authHeader), ":")
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return http.StatusUnauthorized
+		}
+		if d := time.Since(time.Unix(sec, 0)); d > AuthMaxSkew || d < -AuthMaxSkew {
+			return http.StatusUnauthorized
+		}
+		want := p.sign(r.Method, r.URL.EscapedPath(), peer, ts, body)
+		if subtle.ConstantTimeCompare([]byte(mac), []byte(want)) != 1 {
+			return http.StatusUnauthorized
+		}
+	}
+	if p.secret != nil || p.tls == nil {
+		names = append(names, peer)
+	}
+	if !g.acl.allows(names) {
+		return http.StatusForbidden
+	}
+	return 0
+}
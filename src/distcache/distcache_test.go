@@ -0,0 +1,391 @@
+package distcache
+
+import (
+	"cache/src/logging"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cluster starts n nodes serving the group "users" with getter
+func cluster(t *testing.T, n int, getter Getter, opts ...PoolOption) ([]*Pool, []*Group, []*httptest.Server) {
+	t.Helper()
+	pools := make([]*Pool, n)
+	groups := make([]*Group, n)
+	servers := make([]*httptest.Server, n)
+	urls := make([]string, n)
+	var ready sync.WaitGroup
+	ready.Add(1)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ready.Wait()
+			pools[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		urls[i] = servers[i].URL
+	}
+	for i := range pools {
+		pools[i] = NewPool(urls[i], opts...)
+		pools[i].SetPeers(urls...)
+		groups[i] = pools[i].NewGroup("users", time.Minute, getter)
+	}
+	ready.Done()
+	return pools, groups, servers
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(50)
+	if r.Get("key") != "" {
+		t.Fatal("an empty ring owns nothing")
+	}
+	r.Set("a", "b", "c")
+	owners := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		owners[k] = r.Get(k)
+	}
+	r.Set("a", "b", "c", "d")
+	moved := 0
+	for k, owner := range owners {
+		if now := r.Get(k); now != owner {
+			if now != "d" {
+				t.Fatalf("%s moved from %s to %s, keys should only move to the new node", k, owner, now)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 500 {
+		t.Fatalf("unexpected number of moved keys: %d", moved)
+	}
+	keys := make([]string, 0, len(owners))
+	for k := range owners {
+		keys = append(keys, k)
+	}
+	b := r.Balance(keys)
+	if len(b.Counts) != 4 || b.Skew < 1 || b.Skew > 2 {
+		t.Fatalf("unexpected balance: %+v", b)
+	}
+	if b = NewRing(1).Balance(keys); b.Skew != 0 || len(b.Counts) != 0 {
+		t.Fatalf("an empty ring has no balance, got %+v", b)
+	}
+
+	r = NewRingWithHasher(50, FNV1a)
+	r.Set("a", "b", "c")
+	if b = r.Balance(keys); len(b.Counts) != 3 || b.Skew > 2 {
+		t.Fatalf("unexpected balance with FNV1a: %+v", b)
+	}
+	// a custom hash, here one putting every key at the same point
+	r = NewRingWithHasher(1, HasherFunc(func(data []byte) uint64 {
+		if data[0] >= 'a' {
+			return uint64(data[0])
+		}
+		return 0
+	}))
+	r.Set("a", "b")
+	if r.Get("1") != "a" || r.Get("2") != "a" {
+		t.Fatalf("the keys should be placed by the custom hash, got %s %s", r.Get("1"), r.Get("2"))
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var loads atomic.Int64
+	_, groups, _ := cluster(t, 3, func(ctx context.Context, key string) ([]byte, error) {
+		loads.Add(1)
+		if key == "bad" {
+			return nil, errors.New("no such user")
+		}
+		return []byte("user " + key), nil
+	})
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		for _, g := range groups {
+			v, err := g.Get(ctx, strconv.Itoa(i))
+			if err != nil || string(v) != "user "+strconv.Itoa(i) {
+				t.Fatalf("unexpected value: %q %v", v, err)
+			}
+		}
+	}
+	if loads.Load() != 20 {
+		t.Fatalf("each key should be loaded once by its owner, got %d loads", loads.Load())
+	}
+	if _, err := groups[0].Get(ctx, "bad"); err == nil || errors.Is(err, ErrPeer) {
+		t.Fatalf("the getter error should be returned, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	var loads atomic.Int64
+	pools, groups, servers := cluster(t, 2, func(ctx context.Context, key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the second node leaves the group
+	servers[1].Close()
+	go pools[0].Watch(ctx, StaticPeers{pools[0].self}, time.Hour, nil)
+	deadline := time.Now().Add(time.Second)
+	for len(pools[0].Peers()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the peers were not updated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := groups[0].Get(ctx, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := groups[0].Stats(); s.PeerErrors != 0 || s.Loads != 10 {
+		t.Fatalf("the remaining node should own every key, got %+v", s)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	var loads atomic.Int64
+	pools, groups, _ := cluster(t, 3, func(ctx context.Context, key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	}, WithHotKeys(5, 1, time.Minute))
+	ctx := context.Background()
+	owner, replica, reader := -1, -1, -1
+	nodes := pools[0].ring.GetN("hot", 2)
+	for i, p := range pools {
+		switch p.self {
+		case nodes[0]:
+			owner = i
+		case nodes[1]:
+			replica = i
+		default:
+			reader = i
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if v, err := groups[reader].Get(ctx, "hot"); err != nil || string(v) != "hot" {
+			t.Fatalf("unexpected value: %q %v", v, err)
+		}
+	}
+	if s := groups[owner].Stats(); s.HotKeys != 1 {
+		t.Fatalf("the owner should replicate the key, got %+v", s)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := groups[replica].hot.Get("hot"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the key was not pushed to the replica")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := groups[replica].Get(ctx, "hot"); err != nil {
+		t.Fatal(err)
+	}
+	if s := groups[replica].Stats(); s.Hits != 1 || s.HotHits == 0 || s.PeerLoads != 0 {
+		t.Fatalf("the replica should serve the key locally, got %+v", s)
+	}
+	before := groups[replica].Stats().HotHits
+	for i := 0; i < 50; i++ {
+		if _, err := groups[reader].Get(ctx, "hot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := groups[replica].Stats(); s.HotHits < before+5 {
+		t.Fatalf("the reader should spread its fetches over the replica, got %+v", s)
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("the key should be loaded once, got %d loads", loads.Load())
+	}
+}
+
+func TestSecret(t *testing.T) {
+	var logged atomic.Int64
+	logger := logging.Func(func(level logging.Level, msg string, keyvals ...any) {
+		if msg == "distcache: peer failed, loading locally" {
+			logged.Add(1)
+		}
+	})
+	pools, groups, servers := cluster(t, 2, func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(key), nil
+	}, WithSecret([]byte("secret")), WithLogger(logger))
+	ctx := context.Background()
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := strconv.Itoa(i); pools[0].ring.Get(k) == pools[1].self {
+			key = k
+		}
+	}
+	if _, err := groups[0].Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if s := groups[0].Stats(); s.PeerLoads != 1 {
+		t.Fatalf("a signed fetch should be served, got %+v", s)
+	}
+	res, err := http.Get(servers[1].URL + DefaultBasePath + "users/" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("an unsigned fetch should be rejected, got %s", res.Status)
+	}
+	groups[1].Allow("http://elsewhere")
+	groups[0].Remove(key)
+	if _, err := groups[0].Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if s := groups[0].Stats(); s.PeerErrors != 1 || s.Loads != 1 {
+		t.Fatalf("a peer out of the ACL should load the key itself, got %+v", s)
+	}
+	if n := logged.Load(); n != 1 {
+		t.Fatalf("the peer failure should be logged once, got %d", n)
+	}
+	groups[1].Allow(pools[0].self)
+	if _, err := groups[0].Get(ctx, key+"x"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one self-signed certificate is both the CA and the certificate of every node
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "node"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	ca := x509.NewCertPool()
+	ca.AddCert(leaf)
+	cfg := MutualTLS(tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, ca)
+
+	pools := make([]*Pool, 2)
+	urls := make([]string, 2)
+	groups := make([]*Group, 2)
+	for i := range pools {
+		i := i
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		s.TLS = cfg
+		s.StartTLS()
+		t.Cleanup(s.Close)
+		urls[i] = s.URL
+	}
+	for i := range pools {
+		pools[i] = NewPool(urls[i], WithTLS(cfg))
+		pools[i].SetPeers(urls...)
+		groups[i] = pools[i].NewGroup("users", time.Minute, func(ctx context.Context, key string) ([]byte, error) {
+			return []byte(key), nil
+		})
+		groups[i].Allow("node")
+	}
+	// enough keys that some are owned by the other node whatever the ports of the servers
+	for i := 0; i < 100; i++ {
+		if _, err := groups[0].Get(context.Background(), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := groups[0].Stats(); s.PeerLoads == 0 || s.PeerErrors != 0 {
+		t.Fatalf("the peers should reach each other, got %+v", s)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca}}}
+	if res, err := client.Get(urls[1] + DefaultBasePath + "users/1"); err == nil {
+		res.Body.Close()
+		t.Fatalf("a client without a certificate should be rejected, got %s", res.Status)
+	}
+}
+
+func TestWire(t *testing.T) {
+	in := entry{Key: "k", Value: []byte{0, 1, 2}, TTLms: 1500}
+	var out entry
+	if err := out.unmarshal(in.marshal()); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("unexpected entry: %+v %v", out, err)
+	}
+	// unknown fields are skipped
+	b := appendVarint(appendBytes(in.marshal(), 9, []byte("new")), 10, 7)
+	if err := out.unmarshal(b); err != nil || !reflect.DeepEqual(in, out) {
+		t.Fatalf("unexpected entry: %+v %v", out, err)
+	}
+	if err := out.unmarshal(in.marshal()[:4]); err == nil {
+		t.Fatal("a truncated message should fail")
+	}
+}
+
+func TestWarm(t *testing.T) {
+	var loads atomic.Int64
+	pools, groups, _ := cluster(t, 3, func(ctx context.Context, key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	})
+	ctx := context.Background()
+	// the first node is out of the ring while the others load the keys
+	for _, p := range pools[1:] {
+		p.SetPeers(pools[1].self, pools[2].self)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := groups[1].Get(ctx, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peers := []string{pools[0].self, pools[1].self, pools[2].self}
+	for _, p := range pools {
+		p.SetPeers(peers...)
+	}
+	n, err := pools[0].Warm(ctx)
+	if err != nil || n == 0 {
+		t.Fatalf("the keys of the new node should be transferred, got %d %v", n, err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := groups[0].Get(ctx, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads.Load() != 100 {
+		t.Fatalf("the new node should not load the transferred keys, got %d loads", loads.Load())
+	}
+	if s := groups[0].Stats(); s.Transferred != uint64(n) || s.Loads != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if ttl, ok := groups[0].cache.TTL(strconv.Itoa(firstOwned(pools[0]))); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("the remaining TTL should be transferred, got %v", ttl)
+	}
+}
+
+// firstOwned returns the first integer key p owns
+func firstOwned(p *Pool) int {
+	for i := 0; ; i++ {
+		if _, ok := p.PickPeer(strconv.Itoa(i)); !ok {
+			return i
+		}
+	}
+}
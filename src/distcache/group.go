@@ -0,0 +1,176 @@
+package distcache
+
+import (
+	"cache/src/internal/singleflight"
+	"cache/src/local_cache"
+	"cache/src/logging"
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Getter loads a key from the backing store, it runs on the node owning the key
+type Getter func(ctx context.Context, key string) ([]byte, error)
+
+type GroupStats struct {
+	// Hits and Misses count the Gets of this node on its local cache
+	Hits   uint64
+	Misses uint64
+	// PeerLoads counts the keys fetched from their owner, PeerErrors the fetches that failed
+	PeerLoads  uint64
+	PeerErrors uint64
+	// Loads counts the calls to the getter
+	Loads uint64
+	// HotKeys counts the keys this node replicated for being hot, HotHits the reads of this node and
+	// its peers served from the hot keys it holds for other nodes
+	HotKeys uint64
+	HotHits uint64
+	// Transferred counts the keys pulled from the peers by Warm
+	Transferred uint64
+}
+
+type Group struct {
+	name   string
+	pool   *Pool
+	getter Getter
+	ttl    time.Duration
+	cache  *local_cache.Cache
+	loads  singleflight.Group
+	acl    acl
+	// hot holds the hot keys pushed by their owner, routes the replicas of the hot keys fetched
+	// from their owner, tracker the requests of the keys this node owns; nil without WithHotKeys
+	hot     *local_cache.Cache
+	routes  *local_cache.Cache
+	tracker *hotTracker
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	peerLoads   atomic.Uint64
+	peerErrors  atomic.Uint64
+	getterRuns  atomic.Uint64
+	hotKeys     atomic.Uint64
+	hotHits     atomic.Uint64
+	transferred atomic.Uint64
+}
+
+// NewGroup registers the group name, the keys this node owns are cached for ttl in a local_cache
+// built with opts
+func (p *Pool) NewGroup(name string, ttl time.Duration, getter Getter, opts ...local_cache.Option) *Group {
+	g := &Group{
+		name:   name,
+		pool:   p,
+		getter: getter,
+		ttl:    ttl,
+		cache:  local_cache.NewCache(ttl, time.Minute, opts...),
+	}
+	if p.hot != nil {
+		g.hot = local_cache.NewCache(p.hot.ttl, time.Minute)
+		g.routes = local_cache.NewCache(p.hot.ttl, time.Minute)
+		g.tracker = newHotTracker()
+	}
+	p.mu.Lock()
+	p.groups[name] = g
+	p.mu.Unlock()
+	return g
+}
+
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Get returns the value of key, from the local cache, from its owner or from the getter
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	if v, ok := g.cache.Get(key); ok {
+		g.hits.Add(1)
+		g.observe(key, v.([]byte))
+		return v.([]byte), nil
+	}
+	if v, ok := g.getHot(key); ok {
+		g.hits.Add(1)
+		return v, nil
+	}
+	g.misses.Add(1)
+	if peer, ok := g.pool.PickPeer(key); ok {
+		v, h, err := g.pool.fetch(ctx, g.route(key, peer), g.name, key)
+		if err == nil {
+			g.peerLoads.Add(1)
+			g.remember(key, h)
+			return v, nil
+		}
+		g.peerErrors.Add(1)
+		if ctx.Err() != nil || !errors.Is(err, ErrPeer) {
+			return nil, err
+		}
+		// the owner is unreachable, load the key here
+		g.pool.log(logging.LevelWarn, "distcache: peer failed, loading locally", "group", g.name, "key", key, "peer", peer, "error", err)
+	}
+	v, err := g.load(ctx, key)
+	if err == nil {
+		g.observe(key, v)
+	}
+	return v, err
+}
+
+// serve returns key to a peer, with the replicas of a hot key and how long they keep it
+func (g *Group) serve(ctx context.Context, key string) ([]byte, []string, time.Duration, error) {
+	if v, ok := g.getHot(key); ok {
+		return v, nil, 0, nil
+	}
+	v, err := g.load(ctx, key)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	replicas, ttl := g.observe(key, v)
+	return v, replicas, ttl, nil
+}
+
+func (g *Group) getHot(key string) ([]byte, bool) {
+	if g.hot == nil {
+		return nil, false
+	}
+	v, ok := g.hot.Get(key)
+	if !ok {
+		return nil, false
+	}
+	g.hotHits.Add(1)
+	return v.([]byte), true
+}
+
+// load returns key from the local cache or the getter, one getter call per key at a time
+func (g *Group) load(ctx context.Context, key string) ([]byte, error) {
+	v, err := g.loads.Do(key, func() (any, error) {
+		if v, ok := g.cache.Get(key); ok {
+			return v, nil
+		}
+		g.getterRuns.Add(1)
+		v, err := g.getter(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		_ = g.cache.Set(key, v, local_cache.DefaultExpire)
+		return v, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// Remove drops key from the local cache of this node, the owner keeps its copy until it expires
+func (g *Group) Remove(key string) {
+	g.cache.Delete(key)
+}
+
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Hits:        g.hits.Load(),
+		Misses:      g.misses.Load(),
+		PeerLoads:   g.peerLoads.Load(),
+		PeerErrors:  g.peerErrors.Load(),
+		Loads:       g.getterRuns.Load(),
+		HotKeys:     g.hotKeys.Load(),
+		HotHits:     g.hotHits.Load(),
+		Transferred: g.transferred.Load(),
+	}
+}
@@ -0,0 +1,181 @@
+package distcache
+
+import (
+	"cache/src/logging"
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Hot keys: the owner counts the requests of each key, its own Gets and the fetches of its peers, over
+one second windows. A key requested more than the threshold in a window is pushed to the next nodes
+on the ring, which keep it for the hot TTL, and the owner names them in its responses for that key.
+The nodes fetching the key then spread their fetches over the owner and its replicas, and the
+replicas serve the key locally, until the TTL runs out. A hot value may therefore be served up to the
+hot TTL after the owner dropped it.
+*/
+
+const (
+	replicasHeader = "X-Distcache-Replicas"
+	hotTTLHeader   = "X-Distcache-Hot-TTL"
+)
+
+// WithHotKeys replicates the keys requested more than threshold times a second to replicas peers,
+// for ttl
+func WithHotKeys(threshold, replicas int, ttl time.Duration) PoolOption {
+	return func(p *Pool) {
+		if threshold > 0 && replicas > 0 && ttl > 0 {
+			p.hot = &hotConfig{threshold: threshold, replicas: replicas, ttl: ttl}
+		}
+	}
+}
+
+type hotConfig struct {
+	threshold int
+	replicas  int
+	ttl       time.Duration
+}
+
+// hotTracker counts the requests of the keys a node owns
+type hotTracker struct {
+	mu     sync.Mutex
+	window int64
+	counts map[string]int
+	// until holds the hot keys with the end of their replication
+	until map[string]time.Time
+}
+
+func newHotTracker() *hotTracker {
+	return &hotTracker{counts: make(map[string]int), until: make(map[string]time.Time)}
+}
+
+// hit counts a request of key, it reports whether key just became hot and how long it stays hot
+func (t *hotTracker) hit(key string, cfg *hotConfig) (bool, time.Duration) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if w := now.Unix(); w != t.window {
+		t.window = w
+		t.counts = make(map[string]int)
+		for k, until := range t.until {
+			if !now.Before(until) {
+				delete(t.until, k)
+			}
+		}
+	}
+	if until, ok := t.until[key]; ok && now.Before(until) {
+		return false, until.Sub(now)
+	}
+	t.counts[key]++
+	if t.counts[key] <= cfg.threshold {
+		return false, 0
+	}
+	t.until[key] = now.Add(cfg.ttl)
+	return true, cfg.ttl
+}
+
+// observe counts a request of a key this node owns, replicating the key when it becomes hot; it
+// returns the replicas of a hot key and how long they keep it
+func (g *Group) observe(key string, val []byte) ([]string, time.Duration) {
+	cfg := g.pool.hot
+	if cfg == nil {
+		return nil, 0
+	}
+	g.pool.mu.RLock()
+	nodes := g.pool.ring.GetN(key, cfg.replicas+1)
+	g.pool.mu.RUnlock()
+	if len(nodes) == 0 || nodes[0] != g.pool.self {
+		// a key loaded while its owner was unreachable
+		return nil, 0
+	}
+	started, ttl := g.tracker.hit(key, cfg)
+	if ttl <= 0 {
+		return nil, 0
+	}
+	replicas := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if n != g.pool.self {
+			replicas = append(replicas, n)
+		}
+	}
+	if started {
+		g.hotKeys.Add(1)
+		for _, peer := range replicas {
+			go func(peer string) {
+				ctx, cancel := context.WithTimeout(context.Background(), ttl)
+				defer cancel()
+				if err := g.pool.push(ctx, peer, g.name, key, val, ttl); err != nil {
+					g.pool.log(logging.LevelWarn, "distcache: hot key push failed", "group", g.name, "key", key, "peer", peer, "error", err)
+				}
+			}(peer)
+		}
+	}
+	return replicas, ttl
+}
+
+// route returns the node to fetch key from: its owner, or any of the owner and its replicas while
+// the owner reports the key hot
+func (g *Group) route(key, owner string) string {
+	if g.routes == nil {
+		return owner
+	}
+	v, ok := g.routes.Get(key)
+	if !ok {
+		return owner
+	}
+	nodes := append([]string{owner}, v.([]string)...)
+	return nodes[rand.Intn(len(nodes))]
+}
+
+// remember records the replicas the owner of key reported in h
+func (g *Group) remember(key string, h http.Header) {
+	if g.routes == nil || h.Get(replicasHeader) == "" {
+		return
+	}
+	ttl, err := time.ParseDuration(h.Get(hotTTLHeader))
+	if err != nil || ttl <= 0 {
+		return
+	}
+	var nodes []string
+	for _, n := range strings.Split(h.Get(replicasHeader), ",") {
+		if n != g.pool.self {
+			nodes = append(nodes, n)
+		}
+	}
+	_ = g.routes.Set(key, nodes, ttl)
+}
+
+// storeHot keeps a hot key pushed by its owner for the TTL in h
+func (g *Group) storeHot(key string, val []byte, h http.Header) bool {
+	if g.hot == nil {
+		return false
+	}
+	ttl, err := time.ParseDuration(h.Get(hotTTLHeader))
+	if err != nil || ttl <= 0 {
+		return false
+	}
+	_ = g.hot.Set(key, val, ttl)
+	return true
+}
+
+// push stores the hot key of group on peer for ttl
+func (p *Pool) push(ctx context.Context, peer, group, key string, val []byte, ttl time.Duration) error {
+	req, err := p.request(ctx, http.MethodPut, peer, group, key, val)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(hotTTLHeader, ttl.String())
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return ErrPeer
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package distcache
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+)
+
+/*
+The nodes of the group come from a PeerSource, polled by Watch: a static list, DNS, or an adapter over
+a membership library such as memberlist or the service discovery of the platform. A change of the
+nodes rebuilds the ring and warms the node up with the keys it now owns, see Warm; the keys that
+could not be transferred are loaded by their new owner on their next Get.
+*/
+
+// PeerSource returns the base URLs of the nodes of the group
+type PeerSource interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// StaticPeers is a fixed list of nodes
+type StaticPeers []string
+
+func (s StaticPeers) Peers(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+// DNSPeers resolves Host to the nodes, e.g. a headless kubernetes service, each address making
+// the base URL Scheme://address:Port
+type DNSPeers struct {
+	Host   string
+	Port   string
+	Scheme string
+	// Resolver defaults to net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+func (d DNSPeers) Peers(ctx context.Context) ([]string, error) {
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	addrs, err := r.LookupHost(ctx, d.Host)
+	if err != nil {
+		return nil, err
+	}
+	scheme := d.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	res := make([]string, len(addrs))
+	for i, a := range addrs {
+		res[i] = scheme + "://" + net.JoinHostPort(a, d.Port)
+	}
+	return res, nil
+}
+
+// Watch polls src every interval until ctx is done, sets the peers when they change and warms the
+// node up; errors of src keep the current peers, they and the errors of Warm are passed to onError
+// when it isn't nil. It returns ctx.Err()
+func (p *Pool) Watch(ctx context.Context, src PeerSource, interval time.Duration, onError func(error)) error {
+	var last []string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		peers, err := src.Peers(ctx)
+		switch {
+		case err != nil:
+			if onError != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case !samePeers(last, peers):
+			last = peers
+			p.SetPeers(peers...)
+			if _, err := p.Warm(ctx); err != nil && onError != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func samePeers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,264 @@
+/*
+The package spreads a cache over a group of processes, in the manner of groupcache: every key is
+owned by one node picked on a consistent hash ring, the owner loads and caches it and the other nodes
+fetch it from the owner over HTTP. The load of a key therefore hits the backing store once for the
+whole group, and each node only holds its share of the keys:
+
+	Pool: The peer transport, an http.Handler serving the groups of this node to its peers.
+	Group: A namespace of keys with its loader and local cache.
+	SetPeers, Watch: Set the nodes of the ring, by hand or from a PeerSource polled for changes.
+	Balance: Reports how a sample of keys spreads over the nodes, to tune the replicas and key schemas.
+	WithHasher: Sets the hash of the ring, e.g. a keyed SipHash against hash flooding, CRC32 by default.
+	WithHotKeys: Replicates the keys requested too often to more nodes, sparing their owner.
+	WithTLS, WithSecret, Group.Allow: Authenticate the peers and restrict the groups they reach.
+	Warm: Pulls the keys a node owns from its peers in bulk, after it joined the group.
+	WithLogger: Logs the peers failing and the hot keys and transfers that could not be sent.
+
+Values are []byte, encode them with a codec.Codec. When the owner can't be reached the node loads the
+key itself, so a node leaving the group costs extra loads, not errors.
+*/
+
+package distcache
+
+import (
+	"cache/src/logging"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultBasePath = "/_distcache/"
+
+var ErrPeer = errors.New("distcache: peer error")
+
+// PeerPicker picks the node owning a key, ok is false when this node owns it
+type PeerPicker interface {
+	PickPeer(key string) (peer string, ok bool)
+}
+
+type PoolOption func(p *Pool)
+
+// WithBasePath sets the path the pool is served under, DefaultBasePath by default
+func WithBasePath(path string) PoolOption {
+	return func(p *Pool) {
+		p.basePath = path
+	}
+}
+
+// WithHTTPClient sets the client used to reach the peers, with a 5s timeout by default
+func WithHTTPClient(client *http.Client) PoolOption {
+	return func(p *Pool) {
+		p.client = client
+	}
+}
+
+// WithLogger sets the Logger of the warnings of the pool: peers failing, hot keys not pushed and
+// transfers failing
+func WithLogger(l logging.Logger) PoolOption {
+	return func(p *Pool) {
+		p.logger = l
+	}
+}
+
+// WithHasher sets the hash placing the keys and the nodes on the ring, CRC32 by default; every node of
+// the group must use the same
+func WithHasher(h Hasher) PoolOption {
+	return func(p *Pool) {
+		p.hasher = h
+	}
+}
+
+// WithReplicas sets the points of each node on the ring, see NewRing
+func WithReplicas(n int) PoolOption {
+	return func(p *Pool) {
+		p.replicas = n
+	}
+}
+
+var _ PeerPicker = (*Pool)(nil)
+
+type Pool struct {
+	self     string
+	basePath string
+	client   *http.Client
+	replicas int
+	hasher   Hasher
+	hot      *hotConfig
+	tls      *tls.Config
+	secret   []byte
+	logger   logging.Logger
+
+	mu     sync.RWMutex
+	ring   *Ring
+	peers  []string
+	groups map[string]*Group
+}
+
+// NewPool returns the pool of the node reachable at self, a base URL like "http://10.0.0.1:8080"
+// that its peers also use to name it
+func NewPool(self string, opts ...PoolOption) *Pool {
+	p := &Pool{
+		self:     self,
+		basePath: DefaultBasePath,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		groups:   make(map[string]*Group),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.tls != nil && p.client.Transport == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = p.tls
+		p.client.Transport = t
+	}
+	p.ring = NewRingWithHasher(p.replicas, p.hasher)
+	p.ring.Set(self)
+	return p
+}
+
+func (p *Pool) log(level logging.Level, msg string, keyvals ...any) {
+	if p.logger != nil {
+		p.logger.Log(level, msg, keyvals...)
+	}
+}
+
+// SetPeers replaces the nodes of the group, self is added when missing
+func (p *Pool) SetPeers(peers ...string) {
+	ring := NewRingWithHasher(p.replicas, p.hasher)
+	list := append([]string(nil), peers...)
+	found := false
+	for _, peer := range peers {
+		found = found || peer == p.self
+	}
+	if !found {
+		list = append(list, p.self)
+	}
+	ring.Set(list...)
+	p.mu.Lock()
+	p.ring, p.peers = ring, list
+	p.mu.Unlock()
+}
+
+// Peers returns the nodes of the group
+func (p *Pool) Peers() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]string(nil), p.peers...)
+}
+
+// Balance returns how keys spread over the nodes of the group, see Ring.Balance
+func (p *Pool) Balance(keys []string) Balance {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ring.Balance(keys)
+}
+
+func (p *Pool) PickPeer(key string) (string, bool) {
+	p.mu.RLock()
+	peer := p.ring.Get(key)
+	p.mu.RUnlock()
+	if peer == "" || peer == p.self {
+		return "", false
+	}
+	return peer, true
+}
+
+func (p *Pool) group(name string) *Group {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.groups[name]
+}
+
+// ServeHTTP serves GET basePath/group/key to the peers, PUT for the hot keys their owner pushes and
+// POST basePath/group/ for the bulk transfers of Warm
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, p.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	name, key, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), p.basePath), "/")
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if name, err = url.PathUnescape(name); err == nil {
+		key, err = url.PathUnescape(key)
+	}
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	g := p.group(name)
+	if g == nil {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	var body []byte
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	if status := p.authorize(r, g, body); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if r.Method == http.MethodPost {
+		g.serveTransfer(w, body)
+		return
+	}
+	if r.Method == http.MethodPut {
+		if !g.storeHot(key, body, r.Header) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	val, replicas, ttl, err := g.serve(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(replicas) > 0 {
+		w.Header().Set(replicasHeader, strings.Join(replicas, ","))
+		w.Header().Set(hotTTLHeader, ttl.String())
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(val)
+}
+
+// fetch gets key of group from peer, with the headers of the response
+func (p *Pool) fetch(ctx context.Context, peer, group, key string) ([]byte, http.Header, error) {
+	req, err := p.request(ctx, http.MethodGet, peer, group, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrPeer, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrPeer, err)
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusInternalServerError:
+		// the getter of the owner failed, loading the key here would fail the same way
+		return nil, nil, fmt.Errorf("distcache: %s: %s", peer, strings.TrimSpace(string(body)))
+	default:
+		return nil, nil, fmt.Errorf("%w: %s: %s", ErrPeer, res.Status, strings.TrimSpace(string(body)))
+	}
+	return body, res.Header, nil
+}
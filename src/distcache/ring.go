@@ -0,0 +1,145 @@
+package distcache
+
+import (
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Hasher places the keys and the nodes on a ring. Every node of a group must use the same one
+type Hasher interface {
+	Sum64(data []byte) uint64
+}
+
+// HasherFunc adapts a function to a Hasher, e.g. a SipHash keyed with a secret against hash flooding
+type HasherFunc func(data []byte) uint64
+
+func (f HasherFunc) Sum64(data []byte) uint64 {
+	return f(data)
+}
+
+var (
+	// CRC32 is the default Hasher, the IEEE checksum
+	CRC32 Hasher = HasherFunc(func(data []byte) uint64 {
+		return uint64(crc32.ChecksumIEEE(data))
+	})
+	// FNV1a is the 64-bit FNV-1a hash followed by the finalizer of murmur3, which spreads the high bits
+	// FNV leaves poorly mixed for short keys such as the points of the nodes
+	FNV1a Hasher = HasherFunc(func(data []byte) uint64 {
+		h := fnv.New64a()
+		h.Write(data)
+		x := h.Sum64()
+		x ^= x >> 33
+		x *= 0xff51afd7ed558ccd
+		x ^= x >> 33
+		x *= 0xc4ceb9fe1a85ec53
+		return x ^ x>>33
+	})
+)
+
+// Ring is a consistent hash ring, each node is placed at replicas points so that adding or removing
+// a node only moves the keys of its neighbours
+type Ring struct {
+	replicas int
+	hasher   Hasher
+	points   []uint64
+	nodes    map[uint64]string
+}
+
+// NewRing returns an empty ring placing each node at replicas points, 50 when replicas <= 0, with CRC32
+func NewRing(replicas int) *Ring {
+	return NewRingWithHasher(replicas, CRC32)
+}
+
+// NewRingWithHasher is NewRing placing the keys and the nodes with h, CRC32 when nil
+func NewRingWithHasher(replicas int, h Hasher) *Ring {
+	if replicas <= 0 {
+		replicas = 50
+	}
+	if h == nil {
+		h = CRC32
+	}
+	return &Ring{replicas: replicas, hasher: h, nodes: make(map[uint64]string)}
+}
+
+// Set replaces the nodes of the ring
+func (r *Ring) Set(nodes ...string) {
+	r.points = r.points[:0]
+	r.nodes = make(map[uint64]string, len(nodes)*r.replicas)
+	for _, n := range nodes {
+		for i := 0; i < r.replicas; i++ {
+			p := r.hasher.Sum64([]byte(strconv.Itoa(i) + n))
+			if _, ok := r.nodes[p]; ok {
+				continue
+			}
+			r.nodes[p] = n
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Get returns the node owning key, "" on an empty ring
+func (r *Ring) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	return r.nodes[r.points[r.search(key)]]
+}
+
+// GetN returns up to n distinct nodes for key, the owner first then the next nodes on the ring
+func (r *Ring) GetN(key string, n int) []string {
+	var res []string
+	if len(r.points) == 0 {
+		return res
+	}
+	seen := make(map[string]bool, n)
+	for i, start := 0, r.search(key); i < len(r.points) && len(res) < n; i++ {
+		node := r.nodes[r.points[(start+i)%len(r.points)]]
+		if !seen[node] {
+			seen[node] = true
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+func (r *Ring) search(key string) int {
+	h := r.hasher.Sum64([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
+
+// Balance is the distribution of keys over the nodes of a ring, see Ring.Balance
+type Balance struct {
+	// Counts is the number of keys each node owns, nodes owning none included
+	Counts map[string]int
+	// Skew is the keys of the busiest node over the mean, 1 for a perfect distribution; above 1.2 or so
+	// try more replicas per node, or a key schema whose keys differ in more than a counter suffix
+	Skew float64
+}
+
+// Balance returns how keys, e.g. a sample of the keys of the application, spread over the nodes
+func (r *Ring) Balance(keys []string) Balance {
+	b := Balance{Counts: make(map[string]int)}
+	for _, n := range r.nodes {
+		b.Counts[n] = 0
+	}
+	if len(b.Counts) == 0 || len(keys) == 0 {
+		return b
+	}
+	max := 0
+	for _, k := range keys {
+		n := r.Get(k)
+		b.Counts[n]++
+		if b.Counts[n] > max {
+			max = b.Counts[n]
+		}
+	}
+	b.Skew = float64(max) / (float64(len(keys)) / float64(len(b.Counts)))
+	return b
+}
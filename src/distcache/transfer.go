@@ -0,0 +1,123 @@
+package distcache
+
+import (
+	"bufio"
+	"cache/src/local_cache"
+	"cache/src/logging"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+/*
+Warm-up: a node joining the group pulls the keys it now owns from the nodes that held them, in one
+stream per peer and group, instead of loading each of them from the backing store on its first Get.
+The request carries the peers of the new ring, the peer streams the keys of its local cache that the
+new node owns on it, with their remaining TTL. Watch warms the node up after each change of the peers.
+*/
+
+// Warm pulls from every peer the keys of every group this node owns, it returns the number of keys
+// stored and the first error when some transfers failed
+func (p *Pool) Warm(ctx context.Context) (int, error) {
+	p.mu.RLock()
+	peers := append([]string(nil), p.peers...)
+	groups := make([]*Group, 0, len(p.groups))
+	for _, g := range p.groups {
+		groups = append(groups, g)
+	}
+	p.mu.RUnlock()
+	var (
+		total int
+		errs  []error
+	)
+	for _, peer := range peers {
+		if peer == p.self {
+			continue
+		}
+		for _, g := range groups {
+			n, err := p.pull(ctx, peer, g, peers)
+			total += n
+			if err != nil {
+				p.log(logging.LevelWarn, "distcache: transfer failed", "group", g.name, "peer", peer, "keys", n, "error", err)
+				errs = append(errs, fmt.Errorf("%s: %w", peer, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return total, fmt.Errorf("distcache: %d transfers failed, first: %w", len(errs), errs[0])
+	}
+	return total, nil
+}
+
+// pull stores the keys of g this node owns on the ring of peers, streamed by peer
+func (p *Pool) pull(ctx context.Context, peer string, g *Group, peers []string) (int, error) {
+	body := (&transferRequest{Group: g.name, Node: p.self, Peers: peers}).marshal()
+	req, err := p.request(ctx, http.MethodPost, peer, g.name, "", body)
+	if err != nil {
+		return 0, err
+	}
+	// the stream may outlast the timeout of a single fetch
+	client := *p.client
+	client.Timeout = 0
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPeer, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: %s", ErrPeer, res.Status)
+	}
+	r := bufio.NewReader(res.Body)
+	n := 0
+	for {
+		msg, err := readDelimited(r)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("%w: %v", ErrPeer, err)
+		}
+		var e entry
+		if err := e.unmarshal(msg); err != nil {
+			return n, err
+		}
+		ttl := local_cache.NoExpire
+		if e.TTLms > 0 {
+			ttl = time.Duration(e.TTLms) * time.Millisecond
+		}
+		_ = g.cache.Set(e.Key, e.Value, ttl)
+		g.transferred.Add(1)
+		n++
+	}
+}
+
+// serveTransfer streams the keys of g owned by the node of the request
+func (g *Group) serveTransfer(w http.ResponseWriter, body []byte) {
+	var req transferRequest
+	if err := req.unmarshal(body); err != nil || req.Group != g.name {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	ring := NewRingWithHasher(g.pool.replicas, g.pool.hasher)
+	ring.Set(req.Peers...)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	bw := bufio.NewWriter(w)
+	now := time.Now()
+	for k, item := range g.cache.Items() {
+		if ring.Get(k) != req.Node {
+			continue
+		}
+		e := entry{Key: k, Value: item.Obj.([]byte)}
+		if item.ExpireTime > 0 {
+			if e.TTLms = time.Unix(item.ExpireTime, 0).Sub(now).Milliseconds(); e.TTLms <= 0 {
+				continue
+			}
+		}
+		if err := writeDelimited(bw, e.marshal()); err != nil {
+			return
+		}
+	}
+	_ = bw.Flush()
+}
@@ -0,0 +1,152 @@
+package distcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+A hand-written encoding of the messages of distcache.proto, in the protobuf wire format: each field is
+a varint key, number<<3 | type, followed by a varint or a length-prefixed value. Unknown fields are
+skipped, so the messages can grow like any protobuf message.
+
+The module depends on go-redis only; generated code would add google.golang.org/protobuf for two
+small messages. distcache.proto stays the reference of the format, peers in other languages can
+generate their code from it.
+*/
+
+var errWire = errors.New("distcache: malformed message")
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// maxMessage bounds a message read from a stream
+const maxMessage = 64 << 20
+
+type transferRequest struct {
+	Group string
+	Node  string
+	Peers []string
+}
+
+type entry struct {
+	Key   string
+	Value []byte
+	TTLms int64
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func (m *transferRequest) marshal() []byte {
+	var b []byte
+	b = appendBytes(b, 1, []byte(m.Group))
+	b = appendBytes(b, 2, []byte(m.Node))
+	for _, p := range m.Peers {
+		b = appendBytes(b, 3, []byte(p))
+	}
+	return b
+}
+
+func (m *transferRequest) unmarshal(b []byte) error {
+	return fields(b, func(field int, v uint64, data []byte) {
+		switch field {
+		case 1:
+			m.Group = string(data)
+		case 2:
+			m.Node = string(data)
+		case 3:
+			m.Peers = append(m.Peers, string(data))
+		}
+	})
+}
+
+func (m *entry) marshal() []byte {
+	var b []byte
+	b = appendBytes(b, 1, []byte(m.Key))
+	b = appendBytes(b, 2, m.Value)
+	if m.TTLms != 0 {
+		b = appendVarint(b, 3, uint64(m.TTLms))
+	}
+	return b
+}
+
+func (m *entry) unmarshal(b []byte) error {
+	return fields(b, func(field int, v uint64, data []byte) {
+		switch field {
+		case 1:
+			m.Key = string(data)
+		case 2:
+			m.Value = append([]byte(nil), data...)
+		case 3:
+			m.TTLms = int64(v)
+		}
+	})
+}
+
+// fields calls fn with the varint or the bytes of each field of b
+func fields(b []byte, fn func(field int, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errWire
+		}
+		b = b[n:]
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errWire
+			}
+			b = b[n:]
+			fn(int(key>>3), v, nil)
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errWire
+			}
+			fn(int(key>>3), 0, b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			return errWire
+		}
+	}
+	return nil
+}
+
+// writeDelimited writes msg prefixed with its length
+func writeDelimited(w io.Writer, msg []byte) error {
+	if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(msg)))); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// readDelimited reads a message written by writeDelimited, io.EOF at the end of the stream
+func readDelimited(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxMessage {
+		return nil, errWire
+	}
+	msg := make([]byte, l)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return msg, nil
+}
@@ -0,0 +1,241 @@
+/*
+The package wraps an io.Writer / io.Reader with AES-GCM so cache snapshots and logs can be
+persisted encrypted. The stream is split into frames that are sealed separately, so large
+snapshots don't have to fit in memory:
+
+	header: magic "GCM1" | 8 byte random nonce prefix
+	frame:  4 byte big endian plaintext length | sealed frame
+
+The nonce of a frame is the prefix followed by the 4 byte frame counter, and the last frame
+is sealed with different additional data, so reordered, dropped or truncated frames fail to open.
+The key is 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+*/
+
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	magic     = "GCM1"
+	prefixLen = 8
+	frameSize = 64 << 10
+)
+
+var (
+	ErrInvalidHeader = errors.New("encryption: not an encrypted stream")
+	ErrTruncated     = errors.New("encryption: stream is truncated")
+	ErrTooManyFrames = errors.New("encryption: stream is too long")
+)
+
+var (
+	dataFrame = []byte{0}
+	lastFrame = []byte{1}
+)
+
+// KeyProvider supplies the encryption key, e.g. from a KMS or a secret manager
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+type staticKey []byte
+
+func (k staticKey) Key() ([]byte, error) {
+	return k, nil
+}
+
+// StaticKey returns a provider for a key held in memory
+func StaticKey(key []byte) KeyProvider {
+	return staticKey(key)
+}
+
+type envKey string
+
+func (k envKey) Key() ([]byte, error) {
+	val := os.Getenv(string(k))
+	if val == "" {
+		return nil, fmt.Errorf("encryption: env %s is not set", string(k))
+	}
+	return base64.StdEncoding.DecodeString(val)
+}
+
+// EnvKey returns a provider that reads a base64 encoded key from the environment variable name
+func EnvKey(name string) KeyProvider {
+	return envKey(name)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	sealed  []byte
+	closed  bool
+}
+
+// NewWriter returns a writer encrypting to w; Close must be called to write the last frame,
+// it doesn't close w
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce[:prefixLen]); err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(w, magic); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(nonce[:prefixLen]); err != nil {
+		return nil, err
+	}
+	return &writer{
+		w:     w,
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, frameSize),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("encryption: write to closed writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// keep a full buffer until more data arrives, the last frame is written by Close
+		if len(w.buf) == frameSize {
+			if err := w.flush(dataFrame); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):frameSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(lastFrame)
+}
+
+func (w *writer) flush(ad []byte) error {
+	if w.counter == math.MaxUint32 {
+		return ErrTooManyFrames
+	}
+	binary.BigEndian.PutUint32(w.nonce[prefixLen:], w.counter)
+	w.counter++
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(w.buf)))
+	w.sealed = w.aead.Seal(w.sealed[:0], w.nonce, w.buf, ad)
+	w.buf = w.buf[:0]
+	if _, err := w.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(w.sealed)
+	return err
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	sealed  []byte
+	plain   []byte
+	buf     []byte
+	done    bool
+}
+
+// NewReader returns a reader decrypting a stream written by NewWriter
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(magic)+prefixLen)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, ErrInvalidHeader
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrInvalidHeader
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(magic):])
+	return &reader{
+		r:     bufio.NewReader(r),
+		aead:  aead,
+		nonce: nonce,
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return ErrTruncated
+	}
+	n := int(binary.BigEndian.Uint32(size[:]))
+	if n > frameSize {
+		return ErrInvalidHeader
+	}
+	if cap(r.sealed) < n+r.aead.Overhead() {
+		r.sealed = make([]byte, n+r.aead.Overhead())
+	}
+	sealed := r.sealed[:n+r.aead.Overhead()]
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		return ErrTruncated
+	}
+	binary.BigEndian.PutUint32(r.nonce[prefixLen:], r.counter)
+	r.counter++
+	// not in place: a failed Open clears its output and the frame is opened a second time as the last one
+	plain, err := r.aead.Open(r.plain[:0], r.nonce, sealed, dataFrame)
+	if err != nil {
+		if plain, err = r.aead.Open(r.plain[:0], r.nonce, sealed, lastFrame); err != nil {
+			return err
+		}
+		r.done = true
+	}
+	r.plain, r.buf = plain, plain
+	return nil
+}
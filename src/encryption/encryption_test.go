@@ -0,0 +1,79 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	for _, size := range []int{0, 10, frameSize, frameSize*3 + 7} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(plain); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		encrypted := buf.Bytes()
+
+		r, err := NewReader(bytes.NewReader(encrypted), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		if !bytes.Equal(res, plain) {
+			t.Fatalf("size %d: decrypted data differs", size)
+		}
+
+		// dropping the last frame must be detected
+		if size > frameSize {
+			r, _ = NewReader(bytes.NewReader(encrypted[:len(encrypted)-30]), key)
+			if _, err = io.ReadAll(r); err == nil {
+				t.Fatalf("size %d: truncated stream should fail", size)
+			}
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, make([]byte, 16))
+	_, _ = w.Write([]byte("session token"))
+	_ = w.Close()
+
+	key := make([]byte, 16)
+	key[0] = 1
+	r, err := NewReader(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r); err == nil {
+		t.Fatal("wrong key should fail")
+	}
+}
+
+func TestEnvKey(t *testing.T) {
+	t.Setenv("CACHE_TEST_KEY", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+	key, err := EnvKey("CACHE_TEST_KEY").Key()
+	if err != nil || string(key) != "0123456789abcdef" {
+		t.Fatalf("unexpected key %q %v", key, err)
+	}
+	if _, err = EnvKey("CACHE_TEST_MISSING").Key(); err == nil {
+		t.Fatal("missing env should fail")
+	}
+}
@@ -0,0 +1,201 @@
+/*
+The package wraps a cache.Cache to inject faults, so applications can test in integration tests how
+they cope with a degraded cache:
+
+	WithLatency: Delays every operation by a random duration in a range, or until ctx is done.
+	WithErrorRate: Fails a fraction of the operations with an error, ErrInjected by default.
+	WithStaleRate: Makes a fraction of the Gets return the value a key had before its last Set.
+
+Faults are drawn from a seeded source, WithSeed makes a run reproducible; SetEnabled turns injection
+off and on at runtime.
+*/
+
+package faultwrap
+
+import (
+	"cache/src/cache"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInjected = errors.New("faultwrap: injected fault")
+
+type Option func(c *Cache)
+
+// WithLatency delays every operation by a random duration in [min, max]
+func WithLatency(min, max time.Duration) Option {
+	return func(c *Cache) {
+		if max < min {
+			max = min
+		}
+		c.minLatency, c.maxLatency = min, max
+	}
+}
+
+// WithErrorRate fails the given fraction of the operations with err, ErrInjected when err is nil;
+// a failed Set or Delete doesn't reach the wrapped cache
+func WithErrorRate(rate float64, err error) Option {
+	return func(c *Cache) {
+		if err == nil {
+			err = ErrInjected
+		}
+		c.errorRate, c.err = rate, err
+	}
+}
+
+// WithStaleRate makes the given fraction of the Gets return the value the key had before its last Set
+// through the wrapper, when there was one; Set then reads the current value first, which the wrapped
+// cache counts in its stats
+func WithStaleRate(rate float64) Option {
+	return func(c *Cache) {
+		c.staleRate = rate
+	}
+}
+
+// WithSeed seeds the source faults are drawn from, the current time by default
+func WithSeed(seed int64) Option {
+	return func(c *Cache) {
+		c.rnd = rand.New(rand.NewSource(seed))
+	}
+}
+
+var _ cache.Cache = (*Cache)(nil)
+
+type Cache struct {
+	c          cache.Cache
+	minLatency time.Duration
+	maxLatency time.Duration
+	errorRate  float64
+	err        error
+	staleRate  float64
+	disabled   atomic.Bool
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+	// previous holds the value each key had before its last Set, for stale reads
+	previous map[string]any
+}
+
+func New(c cache.Cache, opts ...Option) *Cache {
+	res := &Cache{
+		c:        c,
+		err:      ErrInjected,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		previous: make(map[string]any),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// SetEnabled turns fault injection on or off, it is on after New
+func (c *Cache) SetEnabled(enabled bool) {
+	c.disabled.Store(!enabled)
+}
+
+func (c *Cache) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rnd.Float64() < rate
+}
+
+// inject applies the latency and returns the injected error, if any
+func (c *Cache) inject(ctx context.Context) error {
+	if c.disabled.Load() {
+		return nil
+	}
+	if c.maxLatency > 0 {
+		d := c.minLatency
+		if span := c.maxLatency - c.minLatency; span > 0 {
+			c.mu.Lock()
+			d += time.Duration(c.rnd.Int63n(int64(span) + 1))
+			c.mu.Unlock()
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+	if c.chance(c.errorRate) {
+		return c.err
+	}
+	return nil
+}
+
+func (c *Cache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	if c.staleRate > 0 {
+		if old, err := c.c.Get(ctx, key); err == nil {
+			c.mu.Lock()
+			c.previous[key] = old
+			c.mu.Unlock()
+		}
+	}
+	return c.c.Set(ctx, key, val, ttl)
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (any, error) {
+	if err := c.inject(ctx); err != nil {
+		return nil, err
+	}
+	if !c.disabled.Load() && c.chance(c.staleRate) {
+		c.mu.Lock()
+		old, ok := c.previous[key]
+		c.mu.Unlock()
+		if ok {
+			return old, nil
+		}
+	}
+	return c.c.Get(ctx, key)
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	return c.c.Delete(ctx, key)
+}
+
+func (c *Cache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := c.inject(ctx); err != nil {
+		return 0, err
+	}
+	return c.c.TTL(ctx, key)
+}
+
+func (c *Cache) Flush(ctx context.Context) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.previous = make(map[string]any)
+	c.mu.Unlock()
+	return c.c.Flush(ctx)
+}
+
+func (c *Cache) Stats(ctx context.Context) (cache.Stats, error) {
+	if err := c.inject(ctx); err != nil {
+		return cache.Stats{}, err
+	}
+	return c.c.Stats(ctx)
+}
+
+func (c *Cache) HealthCheck(ctx context.Context) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	return c.c.HealthCheck(ctx)
+}
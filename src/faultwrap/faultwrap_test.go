@@ -0,0 +1,55 @@
+package faultwrap
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	down := errors.New("down")
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithErrorRate(0.5, down), WithSeed(1))
+
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		if err := c.Set(ctx, "name", "will", cache.DefaultExpire); err == down {
+			failed++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failed < 400 || failed > 600 {
+		t.Fatalf("expected about half of the sets to fail, got %d", failed)
+	}
+	c.SetEnabled(false)
+	for i := 0; i < 100; i++ {
+		if _, err := c.Get(ctx, "name"); err != nil {
+			t.Fatalf("disabled injection should not fail, got %v", err)
+		}
+	}
+}
+
+func TestLatencyAndStale(t *testing.T) {
+	ctx := context.Background()
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithLatency(20*time.Millisecond, 20*time.Millisecond), WithStaleRate(1))
+
+	start := time.Now()
+	_ = c.Set(ctx, "rate", 1, cache.DefaultExpire)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("expected injected latency, took %v", d)
+	}
+	_ = c.Set(ctx, "rate", 2, cache.DefaultExpire)
+	if v, _ := c.Get(ctx, "rate"); v != 1 {
+		t.Fatalf("expected the stale value, got %v", v)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(cctx, "rate"); err != context.DeadlineExceeded {
+		t.Fatalf("expected the latency to respect ctx, got %v", err)
+	}
+}
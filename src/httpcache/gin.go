@@ -0,0 +1,89 @@
+package httpcache
+
+import "net/http"
+
+/*
+Framework adapters. echo needs none, echo.WrapMiddleware takes the net/http middleware as is:
+
+	e.GET("/items/:id", handler, echo.WrapMiddleware(c.MiddlewareWithTTL(time.Minute)))
+
+gin has no such wrapper and its writer is a gin.ResponseWriter, so GinHandler adapts the middleware without
+importing gin, through accessors of the *gin.Context fields:
+
+	func GinCache(mw func(http.Handler) http.Handler) gin.HandlerFunc {
+		return httpcache.GinHandler(mw,
+			func(ctx *gin.Context) (httpcache.GinResponseWriter, *http.Request) { return ctx.Writer, ctx.Request },
+			func(ctx *gin.Context, w httpcache.GinResponseWriter, r *http.Request) { ctx.Writer, ctx.Request = w, r },
+			(*gin.Context).Next, (*gin.Context).Abort)
+	}
+
+	r.GET("/items/:id", GinCache(c.MiddlewareWithTTL(time.Minute)), handler)
+*/
+
+// GinResponseWriter has the method set of gin.ResponseWriter, so values convert both ways
+type GinResponseWriter interface {
+	http.ResponseWriter
+	http.Hijacker
+	http.Flusher
+	http.CloseNotifier
+	Status() int
+	Size() int
+	WriteString(string) (int, error)
+	Written() bool
+	WriteHeaderNow()
+	Pusher() http.Pusher
+}
+
+// GinHandler runs mw as a gin handler: get and set access the writer and request of the context,
+// next and abort are its Next and Abort. On a hit the rest of the chain is aborted; on a miss it runs
+// with a writer recording the response, and the context gets its own writer back afterwards.
+func GinHandler[C any](mw func(http.Handler) http.Handler,
+	get func(ctx C) (GinResponseWriter, *http.Request),
+	set func(ctx C, w GinResponseWriter, r *http.Request),
+	next func(ctx C), abort func(ctx C)) func(ctx C) {
+	return func(ctx C) {
+		orig, r := get(ctx)
+		called := false
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			set(ctx, &ginWriter{GinResponseWriter: orig, w: w}, r)
+			next(ctx)
+			set(ctx, orig, r)
+		})).ServeHTTP(orig, r)
+		if !called {
+			abort(ctx)
+		}
+	}
+}
+
+// ginWriter sends the writes of the gin handlers through w, the writer mw passed down, and the
+// other methods to the gin writer
+type ginWriter struct {
+	GinResponseWriter
+	w http.ResponseWriter
+}
+
+func (g *ginWriter) Header() http.Header {
+	return g.w.Header()
+}
+
+func (g *ginWriter) WriteHeader(status int) {
+	g.w.WriteHeader(status)
+}
+
+func (g *ginWriter) Write(p []byte) (int, error) {
+	return g.w.Write(p)
+}
+
+func (g *ginWriter) WriteString(s string) (int, error) {
+	return g.w.Write([]byte(s))
+}
+
+// WriteHeaderNow writes a header set without a body, e.g. by ctx.Status, through w first so that
+// the status is recorded
+func (g *ginWriter) WriteHeaderNow() {
+	if !g.Written() {
+		g.w.WriteHeader(g.Status())
+	}
+	g.GinResponseWriter.WriteHeaderNow()
+}
@@ -0,0 +1,335 @@
+/*
+The package caches GET responses in a cache.Cache:
+
+	key: method, host and request URI, plus the values of the Vary headers configured with WithVary.
+	TTL: s-maxage or max-age of the response Cache-Control, or the default TTL when the response has none;
+		responses with no-store, no-cache or private, with Set-Cookie, or above the body limit are not cached.
+	shared cache rules (RFC 9111): responses to requests with Authorization are only cached when they are
+		public, s-maxage or must-revalidate; responses with a Vary header are only cached when every header
+		it names is part of the key through WithVary, Vary: * is never cached.
+	hits: served from the cache with the stored status, headers and body, and an X-Cache: HIT header.
+	routes: MiddlewareWithTTL attaches the cache to single routes with a fixed TTL, GinHandler adapts it to gin.
+	busting: Invalidate and InvalidateURL delete the entry of a request or URL.
+
+Entries are stored as JSON strings, so they round-trip unchanged through every cache backend.
+*/
+
+package httpcache
+
+import (
+	"bytes"
+	"cache/src/cache"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const HeaderXCache = "X-Cache"
+
+type entry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+type Option func(c *Cache)
+
+// WithKeyFunc replaces the default key, the Vary headers are still appended to it
+func WithKeyFunc(fn func(r *http.Request) string) Option {
+	return func(c *Cache) {
+		c.keyFunc = fn
+	}
+}
+
+// WithVary adds request headers whose values are part of the key, e.g. Accept-Encoding;
+// responses that Vary on other headers are not cached
+func WithVary(headers ...string) Option {
+	return func(c *Cache) {
+		for _, h := range headers {
+			c.vary = append(c.vary, http.CanonicalHeaderKey(h))
+		}
+	}
+}
+
+// WithBypass skips the cache for requests matching fn, e.g. authenticated requests
+func WithBypass(fn func(r *http.Request) bool) Option {
+	return func(c *Cache) {
+		c.bypass = append(c.bypass, fn)
+	}
+}
+
+// WithDefaultTTL caches responses without Cache-Control for ttl, 0 (the default) doesn't cache them
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithMaxBodySize sets the largest cached body, 1MB by default
+func WithMaxBodySize(n int) Option {
+	return func(c *Cache) {
+		c.maxBody = n
+	}
+}
+
+type Cache struct {
+	cache      cache.Cache
+	keyFunc    func(r *http.Request) string
+	vary       []string
+	bypass     []func(r *http.Request) bool
+	defaultTTL time.Duration
+	maxBody    int
+}
+
+func New(c cache.Cache, opts ...Option) *Cache {
+	res := &Cache{
+		cache:   c,
+		keyFunc: DefaultKey,
+		maxBody: 1 << 20,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// DefaultKey is method, host and request URI
+func DefaultKey(r *http.Request) string {
+	return r.Method + " " + r.Host + r.URL.RequestURI()
+}
+
+// Key returns the cache key of r, it can be used to delete an entry
+func (c *Cache) Key(r *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString("httpcache:")
+	sb.WriteString(c.keyFunc(r))
+	for _, h := range c.vary {
+		sb.WriteString("|")
+		sb.WriteString(h)
+		sb.WriteString("=")
+		sb.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return sb.String()
+}
+
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return c.middleware(next, 0)
+}
+
+// MiddlewareWithTTL is Middleware with a fixed TTL for the routes it is attached to; it overrides
+// max-age and the default TTL, responses that must not be cached are still passed through.
+// Frameworks take it through their net/http wrappers, echo.WrapMiddleware or GinHandler for gin.
+func (c *Cache) MiddlewareWithTTL(ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return c.middleware(next, ttl)
+	}
+}
+
+// Invalidate deletes the entry r would be served from
+func (c *Cache) Invalidate(r *http.Request) error {
+	return c.cache.Delete(r.Context(), c.Key(r))
+}
+
+// InvalidateURL deletes the entry of a GET on rawURL with the given Vary header values,
+// e.g. after an update through another route
+func (c *Cache) InvalidateURL(ctx context.Context, rawURL string, header http.Header) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	if header != nil {
+		r.Header = header
+	}
+	return c.Invalidate(r)
+}
+
+func (c *Cache) middleware(next http.Handler, override time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := c.Key(r)
+		if e, ok := c.get(r, key); ok {
+			serve(w, e)
+			return
+		}
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK, limit: c.maxBody}
+		w.Header().Set(HeaderXCache, "MISS")
+		next.ServeHTTP(rec, r)
+		if rec.overflow {
+			return
+		}
+		ttl, ok := c.ttl(r, rec.status, w.Header())
+		if override > 0 && (ok || c.storable(r, rec.status, w.Header())) {
+			ttl, ok = override, true
+		}
+		if !ok {
+			return
+		}
+		header := w.Header().Clone()
+		header.Del(HeaderXCache)
+		data, err := json.Marshal(entry{Status: rec.status, Header: header, Body: rec.body.Bytes()})
+		if err != nil {
+			return
+		}
+		// the response is already written, a failed store only costs a later miss
+		_ = c.cache.Set(r.Context(), key, string(data), ttl)
+	})
+}
+
+func (c *Cache) cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	for _, fn := range c.bypass {
+		if fn(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) get(r *http.Request, key string) (*entry, bool) {
+	val, err := c.cache.Get(r.Context(), key)
+	if err != nil {
+		return nil, false
+	}
+	s, ok := val.(string)
+	if !ok {
+		return nil, false
+	}
+	var e entry
+	if err = json.Unmarshal([]byte(s), &e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+func serve(w http.ResponseWriter, e *entry) {
+	for k, v := range e.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(HeaderXCache, "HIT")
+	w.WriteHeader(e.Status)
+	_, _ = w.Write(e.Body)
+}
+
+// storable reports whether the response to r may be cached at all, regardless of its TTL
+func (c *Cache) storable(r *http.Request, status int, header http.Header) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	// a shared cache only stores authenticated responses the origin explicitly allows, RFC 9111 section 3.5
+	shared := r.Header.Get("Authorization") == ""
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return false
+		case "public", "s-maxage", "must-revalidate":
+			shared = true
+		}
+	}
+	return shared && c.varyCovered(header)
+}
+
+// varyCovered reports whether every header the response varies on is part of the key
+func (c *Cache) varyCovered(header http.Header) bool {
+	for _, v := range header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h == "" {
+				continue
+			}
+			if h == "*" || !c.varies(h) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *Cache) varies(h string) bool {
+	for _, v := range c.vary {
+		if v == h {
+			return true
+		}
+	}
+	return false
+}
+
+// ttl returns the TTL of the response to r and whether it may be cached
+func (c *Cache) ttl(r *http.Request, status int, header http.Header) (time.Duration, bool) {
+	if !c.storable(r, status, header) {
+		return 0, false
+	}
+	cc := header.Get("Cache-Control")
+	if cc == "" {
+		return c.defaultTTL, c.defaultTTL > 0
+	}
+	var maxAge, sMaxAge time.Duration = -1, -1
+	for _, directive := range strings.Split(cc, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "max-age":
+			maxAge = seconds(val)
+		case "s-maxage":
+			sMaxAge = seconds(val)
+		}
+	}
+	if sMaxAge >= 0 {
+		return sMaxAge, sMaxAge > 0
+	}
+	if maxAge >= 0 {
+		return maxAge, maxAge > 0
+	}
+	return c.defaultTTL, c.defaultTTL > 0
+}
+
+func seconds(val string) time.Duration {
+	n, err := strconv.Atoi(strings.Trim(val, `"`))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return time.Duration(n) * time.Second
+}
+
+// recorder copies the body of a response, it stops copying once the body exceeds the limit
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int
+	overflow    bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	if !r.overflow {
+		if r.body.Len()+len(p) > r.limit {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,261 @@
+package httpcache
+
+import (
+	"bufio"
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	h := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)), WithVary("Accept-Language")).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("hello " + r.Header.Get("Accept-Language")))
+		}))
+
+	get := func(lang string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/greet?x=1", nil)
+		r.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := get("en"); w.Header().Get(HeaderXCache) != "MISS" || w.Body.String() != "hello en" {
+		t.Fatalf("unexpected first response %v %q", w.Header(), w.Body.String())
+	}
+	w := get("en")
+	if w.Header().Get(HeaderXCache) != "HIT" || w.Body.String() != "hello en" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected cached response %v %q", w.Header(), w.Body.String())
+	}
+	if w = get("fr"); w.Body.String() != "hello fr" {
+		t.Fatalf("vary header should be part of the key, got %q", w.Body.String())
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 handler calls, got %d", calls)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	c := New(nil, WithDefaultTTL(time.Second), WithVary("Accept-Language"))
+	auth := http.Header{"Authorization": {"Bearer token"}}
+	cases := []struct {
+		req    http.Header
+		status int
+		header http.Header
+		ttl    time.Duration
+		ok     bool
+	}{
+		{nil, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, time.Minute, true},
+		{nil, http.StatusOK, http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, 10 * time.Second, true},
+		{nil, http.StatusOK, http.Header{"Cache-Control": {"no-store"}}, 0, false},
+		{nil, http.StatusOK, http.Header{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{nil, http.StatusOK, http.Header{"Cache-Control": {"max-age=0"}}, 0, false},
+		{nil, http.StatusOK, http.Header{}, time.Second, true},
+		{nil, http.StatusOK, http.Header{"Set-Cookie": {"a=b"}}, 0, false},
+		{nil, http.StatusInternalServerError, http.Header{"Cache-Control": {"max-age=60"}}, 0, false},
+		// authenticated responses only when the origin allows shared caching
+		{auth, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, 0, false},
+		{auth, http.StatusOK, http.Header{}, 0, false},
+		{auth, http.StatusOK, http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute, true},
+		{auth, http.StatusOK, http.Header{"Cache-Control": {"s-maxage=10"}}, 10 * time.Second, true},
+		{auth, http.StatusOK, http.Header{"Cache-Control": {"must-revalidate, max-age=60"}}, time.Minute, true},
+		// only Vary headers that are part of the key
+		{nil, http.StatusOK, http.Header{"Vary": {"accept-language"}}, time.Second, true},
+		{nil, http.StatusOK, http.Header{"Vary": {"Accept-Language, Accept-Encoding"}}, 0, false},
+		{nil, http.StatusOK, http.Header{"Vary": {"*"}}, 0, false},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tc.req {
+			r.Header[k] = v
+		}
+		ttl, ok := c.ttl(r, tc.status, tc.header)
+		if ttl != tc.ttl || ok != tc.ok {
+			t.Errorf("%v %d %v: got %v %v, want %v %v", tc.req, tc.status, tc.header, ttl, ok, tc.ttl, tc.ok)
+		}
+	}
+}
+
+func TestMiddlewareWithTTL(t *testing.T) {
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)))
+	calls := 0
+	h := c.MiddlewareWithTTL(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte("item"))
+	}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/items/1", nil))
+	}
+	if calls != 1 {
+		t.Fatalf("response without Cache-Control should be cached with the route TTL, got %d calls", calls)
+	}
+	if err := c.InvalidateURL(context.Background(), "http://example.com/items/1", nil); err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/items/1", nil))
+	if calls != 2 {
+		t.Fatalf("invalidated entry should be reloaded, got %d calls", calls)
+	}
+}
+
+func TestMiddlewareAuthorization(t *testing.T) {
+	calls := 0
+	h := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0))).MiddlewareWithTTL(time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			_, _ = w.Write([]byte("secret of " + r.Header.Get("Authorization")))
+		}))
+	get := func(auth string) string {
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	if body := get("alice"); body != "secret of alice" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	// the route TTL doesn't make an authenticated response shared
+	if body := get("bob"); body != "secret of bob" {
+		t.Fatalf("authenticated response was served to another user: %q", body)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 handler calls, got %d", calls)
+	}
+}
+
+// fakeGinWriter behaves like the writer of gin: WriteHeader only keeps the status, it is written with the
+// first Write or WriteHeaderNow
+type fakeGinWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *fakeGinWriter) WriteHeader(status int) {
+	if !w.Written() {
+		w.status = status
+	}
+}
+
+func (w *fakeGinWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *fakeGinWriter) Write(p []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+func (w *fakeGinWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *fakeGinWriter) Status() int {
+	return w.status
+}
+
+func (w *fakeGinWriter) Size() int {
+	return w.size
+}
+
+func (w *fakeGinWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *fakeGinWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *fakeGinWriter) Flush() {
+}
+
+func (w *fakeGinWriter) CloseNotify() <-chan bool {
+	return nil
+}
+
+func (w *fakeGinWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// fakeGinContext runs its handlers like gin.Context
+type fakeGinContext struct {
+	Writer   GinResponseWriter
+	Request  *http.Request
+	handlers []func(*fakeGinContext)
+	index    int
+}
+
+func (c *fakeGinContext) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+func (c *fakeGinContext) Abort() {
+	c.index = len(c.handlers)
+}
+
+func TestGinHandler(t *testing.T) {
+	c := New(cache.NewLocal(local_cache.NewCache(time.Minute, 0)))
+	mw := GinHandler(c.MiddlewareWithTTL(time.Minute),
+		func(ctx *fakeGinContext) (GinResponseWriter, *http.Request) { return ctx.Writer, ctx.Request },
+		func(ctx *fakeGinContext, w GinResponseWriter, r *http.Request) { ctx.Writer, ctx.Request = w, r },
+		(*fakeGinContext).Next, (*fakeGinContext).Abort)
+	calls := 0
+	serve := func(target string, handler func(*fakeGinContext)) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		gw := &fakeGinWriter{ResponseWriter: rec, status: http.StatusOK, size: -1}
+		ctx := &fakeGinContext{Writer: gw, Request: httptest.NewRequest(http.MethodGet, target, nil), index: -1}
+		ctx.handlers = []func(*fakeGinContext){mw, handler, func(ctx *fakeGinContext) {
+			calls++
+		}}
+		ctx.Next()
+		if ctx.Writer != gw {
+			t.Fatal("the context should get its writer back")
+		}
+		return rec
+	}
+
+	item := func(ctx *fakeGinContext) {
+		ctx.Writer.WriteHeader(http.StatusOK)
+		_, _ = ctx.Writer.WriteString("item")
+	}
+	for i := 0; i < 2; i++ {
+		if w := serve("/items/1", item); w.Body.String() != "item" {
+			t.Fatalf("unexpected body %q", w.Body.String())
+		}
+	}
+	if w := serve("/items/1", item); w.Header().Get(HeaderXCache) != "HIT" {
+		t.Fatalf("expected a hit, got %v", w.Header())
+	}
+	if calls != 1 {
+		t.Fatalf("a hit should abort the rest of the chain, got %d calls", calls)
+	}
+
+	// a header without a body, written by WriteHeaderNow
+	missing := func(ctx *fakeGinContext) {
+		ctx.Writer.WriteHeader(http.StatusNotFound)
+		ctx.Writer.WriteHeaderNow()
+	}
+	serve("/items/2", missing)
+	if w := serve("/items/2", missing); w.Code != http.StatusNotFound || w.Header().Get(HeaderXCache) != "HIT" {
+		t.Fatalf("unexpected cached response %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,53 @@
+// Package singleflight runs one call per key at a time, concurrent callers of the same key share its result
+package singleflight
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPanicked is returned to the callers waiting on a call whose fn panicked or called runtime.Goexit,
+// the caller running fn gets the panic itself
+var ErrPanicked = errors.New("singleflight: call panicked")
+
+type call struct {
+	wg  sync.WaitGroup
+	val any
+	err error
+}
+
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Do runs fn for key unless a call for key is in flight, in which case it waits for that call's result
+func (g *Group) Do(key string, fn func() (any, error)) (any, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			c.err = ErrPanicked
+		}
+		c.wg.Done()
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+	}()
+	c.val, c.err = fn()
+	returned = true
+	return c.val, c.err
+}
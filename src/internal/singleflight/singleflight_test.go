@@ -0,0 +1,40 @@
+package singleflight
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	started, waited := make(chan struct{}), make(chan error, 1)
+	go func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic should reach the caller running fn")
+			}
+		}()
+		_, _ = g.Do("key", func() (any, error) {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		_, err := g.Do("key", func() (any, error) { return nil, nil })
+		waited <- err
+	}()
+	select {
+	case err := <-waited:
+		if err != ErrPanicked && err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiters should not hang after a panic")
+	}
+	// the key is released, later calls run fn again
+	if v, err := g.Do("key", func() (any, error) { return "again", nil }); err != nil || v != "again" {
+		t.Fatalf("unexpected result %v %v", v, err)
+	}
+}
@@ -0,0 +1,74 @@
+// Package slot computes the redis cluster slot of keys, following the hash tag rules of the cluster spec
+package slot
+
+import "strings"
+
+// Count is the number of slots of a redis cluster
+const Count = 16384
+
+// Key returns the part of key that is hashed: the content of the first non-empty {...}, or the whole key
+func Key(key string) string {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return key
+}
+
+// Tag wraps key in braces so that keys derived from it by appending a suffix hash to the same slot;
+// a key that already has a hash tag is returned as is
+func Tag(key string) string {
+	if Key(key) != key || key == "" {
+		return key
+	}
+	return "{" + key + "}"
+}
+
+// Of returns the slot of key
+func Of(key string) int {
+	return int(crc16(Key(key)) % Count)
+}
+
+// Same reports whether all keys hash to the same slot
+func Same(keys ...string) bool {
+	for i := 1; i < len(keys); i++ {
+		if Of(keys[i]) != Of(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Split groups keys by slot, keeping their order within a group and ordering the groups by first appearance
+func Split(keys []string) [][]string {
+	var res [][]string
+	index := make(map[int]int)
+	for _, k := range keys {
+		s := Of(k)
+		i, ok := index[s]
+		if !ok {
+			i = len(res)
+			index[s] = i
+			res = append(res, nil)
+		}
+		res[i] = append(res[i], k)
+	}
+	return res
+}
+
+// crc16 is the CRC-16/XMODEM redis cluster hashes keys with
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
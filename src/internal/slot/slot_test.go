@@ -0,0 +1,38 @@
+package slot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	// the values CLUSTER KEYSLOT returns
+	cases := map[string]int{
+		"foo":                  12182,
+		"123456789":            12739,
+		"{user1000}.following": Of("user1000"),
+		"foo{}{bar}":           Of("foo{}{bar}"),
+	}
+	for k, want := range cases {
+		if got := Of(k); got != want {
+			t.Errorf("Of(%q) = %d, want %d", k, got, want)
+		}
+	}
+	if Key("foo{}{bar}") != "foo{}{bar}" || Key("foo{{bar}}zap") != "{bar" {
+		t.Fatal("an empty tag hashes the whole key, the first { opens the tag")
+	}
+}
+
+func TestTagAndSplit(t *testing.T) {
+	if Tag("order") != "{order}" || Tag("{order}:1") != "{order}:1" {
+		t.Fatal("Tag should wrap untagged keys only")
+	}
+	if !Same(Tag("order"), Tag("order")+":fencing") {
+		t.Fatal("a suffixed tagged key should share the slot")
+	}
+	keys := []string{"{a}1", "{b}1", "{a}2", "{b}2", "{a}3"}
+	want := [][]string{{"{a}1", "{a}2", "{a}3"}, {"{b}1", "{b}2"}}
+	if got := Split(keys); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Split = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+The package provides a cache whose items all expire together at aligned wall-clock boundaries,
+e.g. every 5 minutes on the clock, instead of each item having its own TTL. It suits data refreshed
+on a schedule, such as exchange rates or leaderboards:
+
+	Set: Stores an item until the end of the current window.
+	Get: Gets an item set during the current window.
+	Delete: Deletes an item.
+	Flush: Deletes all items.
+	NextBoundary: Returns the end of the current window.
+
+Windows are aligned on the Unix epoch, so a 24h interval ends at UTC midnight; WithOffset shifts the
+boundaries, e.g. -8h for midnight at UTC+8. Items of a past window miss right at the boundary, the
+underlying local_cache drops them when they are read or on its next cleanup.
+*/
+
+package intervalcache
+
+import (
+	"cache/src/local_cache"
+	"errors"
+	"time"
+)
+
+var ErrInvalidInterval = errors.New("intervalcache: interval must be positive")
+
+type Option func(c *Cache)
+
+// WithOffset shifts the boundaries by d from the epoch alignment
+func WithOffset(d time.Duration) Option {
+	return func(c *Cache) {
+		c.offset = d
+	}
+}
+
+type Cache struct {
+	c        *local_cache.Cache
+	interval time.Duration
+	offset   time.Duration
+	now      func() time.Time
+}
+
+type entry struct {
+	val    any
+	window int64
+}
+
+// New returns a cache whose windows last interval, cleanupInterval is passed to the underlying local_cache
+func New(interval, cleanupInterval time.Duration, opts ...Option) (*Cache, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidInterval
+	}
+	c := &Cache{
+		c:        local_cache.NewCache(local_cache.NoExpire, cleanupInterval, local_cache.WithLazyExpiry()),
+		interval: interval,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// window returns the index of the window t falls in
+func (c *Cache) window(t time.Time) int64 {
+	n := t.UnixNano() - int64(c.offset)
+	w := n / int64(c.interval)
+	if n < 0 && n%int64(c.interval) != 0 {
+		w--
+	}
+	return w
+}
+
+func (c *Cache) boundary(w int64) time.Time {
+	return time.Unix(0, (w+1)*int64(c.interval)+int64(c.offset))
+}
+
+// NextBoundary returns the time the items set now expire at
+func (c *Cache) NextBoundary() time.Time {
+	return c.boundary(c.window(c.now()))
+}
+
+func (c *Cache) Set(k string, v any) error {
+	now := c.now()
+	w := c.window(now)
+	return c.c.Set(k, entry{val: v, window: w}, c.boundary(w).Sub(now))
+}
+
+func (c *Cache) Get(k string) (any, bool) {
+	v, ok := c.c.Get(k)
+	if !ok {
+		return nil, false
+	}
+	e := v.(entry)
+	if e.window != c.window(c.now()) {
+		return nil, false
+	}
+	return e.val, true
+}
+
+func (c *Cache) Delete(k string) {
+	c.c.Delete(k)
+}
+
+func (c *Cache) Flush() {
+	c.c.Flush()
+}
@@ -0,0 +1,37 @@
+package intervalcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindows(t *testing.T) {
+	c, err := New(5*time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 3, 15, 16, 3, 20, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if b := c.NextBoundary(); !b.Equal(time.Date(2023, 3, 15, 16, 5, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected boundary %v", b)
+	}
+	_ = c.Set("rate", 7.1)
+	now = now.Add(90 * time.Second)
+	if v, ok := c.Get("rate"); !ok || v != 7.1 {
+		t.Fatalf("unexpected get result %v %v", v, ok)
+	}
+	now = time.Date(2023, 3, 15, 16, 5, 0, 0, time.UTC)
+	if _, ok := c.Get("rate"); ok {
+		t.Fatal("item should miss at the boundary")
+	}
+
+	if _, err = New(0, 0); err != ErrInvalidInterval {
+		t.Fatalf("expected ErrInvalidInterval, got %v", err)
+	}
+	c, _ = New(24*time.Hour, 0, WithOffset(-8*time.Hour))
+	c.now = func() time.Time { return now }
+	if b := c.NextBoundary(); !b.Equal(time.Date(2023, 3, 15, 16, 0, 0, 0, time.UTC).Add(24 * time.Hour)) {
+		t.Fatalf("unexpected boundary with offset %v", b)
+	}
+}
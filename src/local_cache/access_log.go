@@ -0,0 +1,140 @@
+package local_cache
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+The access log keeps sampled access records in a ring buffer for offline analysis, e.g. replaying
+them in the simulator. Sampling is by key hash, so a sampled key has all of its accesses recorded
+and hit ratios computed on the sample stay representative; keys are stored as their FNV-1a hash.
+*/
+
+type AccessLogFormat int
+
+const (
+	AccessLogCSV AccessLogFormat = iota
+	// AccessLogJSON writes one JSON object per line
+	AccessLogJSON
+)
+
+type AccessOp string
+
+const (
+	OpGet    AccessOp = "get"
+	OpSet    AccessOp = "set"
+	OpDelete AccessOp = "delete"
+)
+
+type AccessRecord struct {
+	KeyHash uint64    `json:"key_hash"`
+	Op      AccessOp  `json:"op"`
+	Time    time.Time `json:"time"`
+	Hit     bool      `json:"hit"`
+}
+
+type accessLog struct {
+	threshold uint64
+	mu        sync.Mutex
+	records   []AccessRecord
+	next      int
+	full      bool
+}
+
+// WithAccessLog records the accesses of a sampleRate fraction of the keys (0 < sampleRate <= 1),
+// keeping the last capacity records until DumpAccessLog drains them
+func WithAccessLog(sampleRate float64, capacity int) Option {
+	return func(c *cache) {
+		if sampleRate <= 0 || capacity <= 0 {
+			return
+		}
+		if sampleRate > 1 {
+			sampleRate = 1
+		}
+		c.accessLog = &accessLog{
+			threshold: uint64(sampleRate * float64(1<<32)),
+			records:   make([]AccessRecord, capacity),
+		}
+	}
+}
+
+func hashKey(k string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(k))
+	return h.Sum64()
+}
+
+func (c *cache) recordAccess(k string, op AccessOp, hit bool) {
+	l := c.accessLog
+	if l == nil {
+		return
+	}
+	h := hashKey(k)
+	if h&(1<<32-1) >= l.threshold {
+		return
+	}
+	l.mu.Lock()
+	l.records[l.next] = AccessRecord{KeyHash: h, Op: op, Time: time.Now(), Hit: hit}
+	l.next++
+	if l.next == len(l.records) {
+		l.next, l.full = 0, true
+	}
+	l.mu.Unlock()
+}
+
+// drain returns the buffered records oldest first and empties the buffer
+func (l *accessLog) drain() []AccessRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var res []AccessRecord
+	if l.full {
+		res = append(res, l.records[l.next:]...)
+	}
+	res = append(res, l.records[:l.next]...)
+	l.next, l.full = 0, false
+	return res
+}
+
+// DumpAccessLog writes and drains the sampled access records, it writes nothing without WithAccessLog
+func (c *cache) DumpAccessLog(w io.Writer, format AccessLogFormat) error {
+	if c.accessLog == nil {
+		return nil
+	}
+	records := c.accessLog.drain()
+	switch format {
+	case AccessLogCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"key_hash", "op", "time", "hit"}); err != nil {
+			return err
+		}
+		for _, r := range records {
+			err := cw.Write([]string{
+				strconv.FormatUint(r.KeyHash, 16),
+				string(r.Op),
+				r.Time.Format(time.RFC3339Nano),
+				strconv.FormatBool(r.Hit),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case AccessLogJSON:
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("local_cache: unknown access log format %d", format)
+}
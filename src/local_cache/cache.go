@@ -4,17 +4,58 @@
 
 The package provides the following methods on cache:
 
-	Set: Sets an item in the cache with an expiration time.
+	Set: Sets an item in the cache with an expiration time, keys are checked by the validators set with options
+		and nil values by the NilPolicy.
 	SetDefault: Sets an item in the cache with the default expiration time.
 	SetNoExpire: Sets an item in the cache with no expiration time.
+		Set, SetDefault and SetNoExpire return an error since keys are validated, they returned nothing before;
+		a call ignoring it still compiles but misses rejected keys, capacity and load shedding errors.
+	SetWithPriority: Sets an item with a priority, the low ones are shed under load, see WithLoadShedding.
+	SetIfExpiringWithin: Sets an item only if it is missing or about to expire, for refresh-ahead writers.
+	SetWithTags, Range: Sets an item with tags, and iterates over the items, optionally only those with given tags.
+	SetWithTTLs, GetWithState: Sets an item with a soft TTL after which Gets report it stale, and a hard one.
+	SetUntil, InvalidateAt: Sets an item until a wall-clock time, or deletes a key at that time.
 	Replace: Replaces an item in the cache with a new one.
-	Get: Gets an item from the cache.
+	Rename: Moves an item to another key atomically, keeping its expiration.
+	GetAndDelete, GetAndExpire: Gets an item and deletes it or changes its expiration atomically, like GETDEL and GETEX.
+	Get: Gets an item from the cache, WithValueValidator turns the values failing a check into misses.
+		Keys may be canonicalized with WithKeyTransform and long keys stored by hash with WithHashedKeys.
 	GetWithExpire: Gets an item from the cache with its expiration time.
 	Delete: Deletes an item from the cache.
-	DeleteExpired: Deletes all expired items from the cache.
+	DeleteExpired: Deletes all expired items from the cache, WithLazyExpiry also deletes them as Get finds them.
+		WithMapShrink rebuilds the item map after large expirations to release its memory.
 	WithCallBack: Sets a callback function to be called when an item is deleted from the cache.
+	WithExpiredBatch: Delivers the expired items to a callback in batches instead.
+	WithSlowLog: Logs the Gets, Sets, loaders and callbacks slower than a threshold.
+	WithLogger: Sets the Logger of the warnings of the janitor, of load shedding and of WithSlowLog.
 	Flush: Clears all items from the cache.
+	ReplaceAll: Swaps all the items of the cache at once, for datasets rebuilt periodically.
 	ItemCount: Returns the number of items in the cache.
+	TTL: Returns the remaining time to live of an item.
+	Stats: Returns the hit/miss counters and the number of items in the cache, and the value size histogram
+		with WithSizeHistogram and the lock contention with WithContentionSampling.
+	SetMaxEntries: Changes the item bound set with WithMaxEntries, AutoTune adjusts it to a target hit ratio or memory budget.
+	EvictFraction, WatchMemory: Evicts a fraction of the items, on demand or when the heap goes above a threshold.
+	Pin, Unpin: Exempts an item from eviction and Flush, see WithFlushPinned.
+	NamespaceUsage: Returns the items and bytes under a namespace quota, see WithNamespaceQuota.
+	DeletePrefix: Deletes all items under a key prefix.
+	SAdd, SRem, SUnion, SInter, SDiff: Keeps sets of strings and combines the sets of several keys.
+	DependOn: Invalidates an item whenever one of the items it was derived from changes.
+	Restore: Undoes a Delete within the grace period of tombstone mode, see WithTombstones.
+	NextExpiry, ExpiringWithin: Returns the item expiring first and the keys expiring within a duration.
+	GetMultiOrLoad: Gets several items, loading the missing ones with one batch loader call.
+	DumpAccessLog: Writes the sampled access records as CSV or JSON lines, see WithAccessLog.
+	Save, Load: Writes the items to an io.Writer and adds the items read from an io.Reader.
+	SaveFile, LoadFile: Save and Load on a file, optionally encrypted with WithEncryption.
+	Subscribe, Apply, Snapshot, Items: Streams the changes, applies the changes of another cache and copies
+		the state, for replication; WithLWW makes caches replicating to each other converge.
+	HealthCheck: Returns an error when the janitor stalled or the cache is over its bounds.
+	Reconfigure: Applies the runtime settings of a Config, see New for building a cache from a Config.
+	ShutdownHandler: Returns a function that stops the janitor and saves a snapshot, for defer or signal handlers.
+	GetCtx, SetCtx, DeleteCtx: Context variants of Get, Set and Delete, they fail fast once the context is done.
+
+A Manager owns named caches sharing one janitor, and cron jobs flushing or refreshing them, see NewManager
+and Schedule.
 
 The janitor struct has a runJanitor method which runs a goroutine that periodically checks for expired items and deletes them.
 */
@@ -22,9 +63,13 @@ The janitor struct has a runJanitor method which runs a goroutine that periodica
 package local_cache
 
 import (
+	"cache/src/logging"
+	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,6 +79,8 @@ const (
 	DefaultExpire time.Duration = 0
 )
 
+var ErrUnhealthy = errors.New("local_cache: unhealthy")
+
 type Object struct {
 	key string
 	val any
@@ -42,6 +89,14 @@ type Object struct {
 type Item struct {
 	Obj        any
 	ExpireTime int64
+	// KeyDigest identifies the original key of a hashed key, see WithHashedKeys
+	KeyDigest string
+	// Stamp orders the writes of replicating caches, see WithLWW
+	Stamp Stamp
+	// Tags are the tags of SetWithTags
+	Tags []string
+	// SoftExpireTime is the unix time after which the item is stale, 0 if it has no soft TTL, see SetWithTTLs
+	SoftExpireTime int64
 }
 
 func (i *Item) Expired() bool {
@@ -52,11 +107,70 @@ func (i *Item) Expired() bool {
 }
 
 type cache struct {
-	defaultExpire time.Duration
-	items         map[string]Item
-	lock          sync.RWMutex
-	onEvicted     func(string, any)
-	*janitor
+	defaultExpire  time.Duration
+	items          map[string]Item
+	lock           sync.RWMutex
+	onEvicted      func(string, any)
+	onExpired      func([]Entry)
+	expiredBatch   int
+	validators     []func(string) error
+	valueValidator func(key string, val any) bool
+	accessLog      *accessLog
+	maxEntries     int
+	keyTransform   func(string) string
+	hashKeysFrom   int
+	onReconfigure  func([]ConfigChange)
+	ghost          *ghostList
+	loadGroup      loadGroup
+	tombstoneGrace time.Duration
+	tombstones     map[string]tombstone
+	namespaces     []*namespace
+	sizer          Sizer
+	pinned         map[string]struct{}
+	flushPinned    bool
+	nilPolicy      NilPolicy
+	lazyExpire     bool
+	sizes          *sizeStats
+	subscribers    []*subscriber
+	lww            *lww
+	shedder        *shedder
+	contention     *contention
+	shrink         *shrinker
+	deps           *deps
+	schedule       *schedule
+	slowLog        *SlowLogConfig
+	logger         logging.Logger
+	hits           atomic.Uint64
+	misses         atomic.Uint64
+	evictions      atomic.Uint64
+	ghostHits      atomic.Uint64
+	shed           atomic.Uint64
+	invalids       atomic.Uint64
+	// janitorLock guards janitor, which Reconfigure replaces; managed caches are cleaned by their Manager
+	janitorLock sync.Mutex
+	janitor     *janitor
+	managed     bool
+}
+
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Items     int
+	Evictions uint64
+	// GhostHits counts misses on recently evicted keys, see WithMaxEntries
+	GhostHits uint64
+	// Sizes is the histogram of written value sizes over SizeBuckets, nil without WithSizeHistogram
+	Sizes []uint64
+	// Shed counts the Sets rejected by WithLoadShedding
+	Shed uint64
+	// Invalid counts the values rejected by WithValueValidator
+	Invalid uint64
+	// LockSamples and LockContended count the sampled lock acquisitions and those that had to wait,
+	// see WithContentionSampling
+	LockSamples   uint64
+	LockContended uint64
+	// MapRebuilds counts the rebuilds of the item map by WithMapShrink
+	MapRebuilds uint64
 }
 
 func newCache(d time.Duration, items map[string]Item) *cache {
@@ -69,55 +183,191 @@ func newCache(d time.Duration, items map[string]Item) *cache {
 	}
 }
 
-func (c *cache) Set(k string, v any, d time.Duration) {
-	if d == DefaultExpire {
-		d = c.defaultExpire
+func (c *cache) Set(k string, v any, d time.Duration) error {
+	return c.SetWithPriority(k, v, d, PriorityNormal)
+}
+
+// write is Set past the load shedding, extra holds the fields of the item besides the value, the
+// expiration and the key digest
+func (c *cache) write(k string, v any, d time.Duration, extra Item) error {
+	if c.slowLog != nil {
+		defer c.slow("set", k, time.Now())
 	}
-	var e int64
-	if d > 0 {
-		e = time.Now().Add(d).Unix()
+	k, digest := c.key(k)
+	if err := c.validate(k, v); err != nil {
+		return err
+	}
+	c.writeLock()
+	extra.Obj, extra.ExpireTime, extra.KeyDigest = v, c.expireTime(d), digest
+	evicted, err := c.put(k, extra)
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	if err != nil {
+		return err
+	}
+	c.observeSize(k, v)
+	c.recordAccess(k, OpSet, false)
+	return nil
+}
+
+// SetIfExpiringWithin sets k only if it is missing or expires within window, so a refresh-ahead writer
+// doesn't clobber a value written since; items without expiration are never replaced
+func (c *cache) SetIfExpiringWithin(k string, v any, d, window time.Duration) (bool, error) {
+	k, digest := c.key(k)
+	if err := c.validate(k, v); err != nil {
+		return false, err
 	}
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.items[k] = Item{
+	if item, ok := c.items[k]; ok && item.KeyDigest == digest && !item.Expired() {
+		if item.ExpireTime == 0 || time.Until(time.Unix(item.ExpireTime, 0)) > window {
+			c.lock.Unlock()
+			return false, nil
+		}
+	}
+	evicted, err := c.store(k, digest, v, d)
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	if err != nil {
+		return false, err
+	}
+	c.observeSize(k, v)
+	c.recordAccess(k, OpSet, false)
+	return true, nil
+}
+
+// store checks the quota, makes room and stores k, the caller holds c.lock
+func (c *cache) store(k, digest string, v any, d time.Duration) ([]Object, error) {
+	return c.put(k, Item{
 		Obj:        v,
-		ExpireTime: e,
+		ExpireTime: c.expireTime(d),
+		KeyDigest:  digest,
+	})
+}
+
+// put is store with the item built, the caller holds c.lock
+func (c *cache) put(k string, item Item) ([]Object, error) {
+	item.Stamp = c.stamp(item.Stamp)
+	evicted, err := c.admit(k, item.Obj)
+	if err != nil {
+		return evicted, err
+	}
+	if _, ok := c.items[k]; !ok && c.maxEntries > 0 && len(c.items) >= c.maxEntries {
+		evicted = append(evicted, c.evict(len(c.items)-c.maxEntries+1)...)
+	}
+	c.items[k] = item
+	c.notePeak()
+	c.track(k, item)
+	if c.tombstones != nil {
+		delete(c.tombstones, k)
+	}
+	c.emit(Event{Op: OpSet, Key: k, Item: item})
+	return evicted, nil
+}
+
+// expireTime returns the expiration of an item set now with d
+func (c *cache) expireTime(d time.Duration) int64 {
+	if d == DefaultExpire {
+		d = c.defaultExpire
 	}
+	if d > 0 {
+		return time.Now().Add(d).Unix()
+	}
+	return 0
 }
 
-func (c *cache) SetDefault(k string, v any) {
-	c.Set(k, v, DefaultExpire)
+func (c *cache) SetDefault(k string, v any) error {
+	return c.Set(k, v, DefaultExpire)
 }
 
-func (c *cache) SetNoExpire(k string, v any) {
-	c.Set(k, v, NoExpire)
+func (c *cache) SetNoExpire(k string, v any) error {
+	return c.Set(k, v, NoExpire)
 }
 
 func (c *cache) Replace(k string, v any, d time.Duration) error {
+	k, digest := c.key(k)
+	if err := c.validate(k, v); err != nil {
+		return err
+	}
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	if !c.exist(k) {
+	if item, ok := c.items[k]; !ok || item.KeyDigest != digest {
+		c.lock.Unlock()
 		return fmt.Errorf("Item %s doesn't exist", k)
 	}
 	// In this way, there is lock competition, and you can get the release and get it
 	//c.Set(k, v, d)
 
-	c.set(k, v, d)
-	return nil
+	evicted, err := c.admit(k, v)
+	if err == nil {
+		c.set(k, digest, v, d)
+	}
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	if err == nil {
+		c.observeSize(k, v)
+	}
+	return err
 }
 
-func (c *cache) set(k string, v any, d time.Duration) {
-	if d == DefaultExpire {
-		d = c.defaultExpire
+// Rename moves the item of oldKey to newKey with its expiration and pin; an existing newKey is
+// overwritten only when overwrite is true
+func (c *cache) Rename(oldKey, newKey string, overwrite bool) error {
+	oldKey, oldDigest := c.key(oldKey)
+	newKey, newDigest := c.key(newKey)
+	c.lock.Lock()
+	item, ok := c.items[oldKey]
+	if !ok || item.KeyDigest != oldDigest || item.Expired() {
+		c.lock.Unlock()
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, oldKey)
 	}
-	var e int64
-	if d > 0 {
-		e = time.Now().Add(d).Unix()
+	if oldKey == newKey {
+		c.lock.Unlock()
+		return nil
+	}
+	if err := c.validate(newKey, item.Obj); err != nil {
+		c.lock.Unlock()
+		return err
+	}
+	if cur, ok := c.items[newKey]; ok && cur.KeyDigest == newDigest && !cur.Expired() && !overwrite {
+		c.lock.Unlock()
+		return fmt.Errorf("%w: %s", ErrKeyExists, newKey)
+	}
+	// oldKey must not be picked to make room for its own item
+	c.untrack(oldKey)
+	evicted, err := c.admit(newKey, item.Obj)
+	if err != nil {
+		c.track(oldKey, item)
+		c.lock.Unlock()
+		c.callEvicted(evicted)
+		return err
+	}
+	delete(c.items, oldKey)
+	item.KeyDigest = newDigest
+	item.Stamp = c.stamp(Stamp{})
+	c.items[newKey] = item
+	c.track(newKey, item)
+	c.emit(Event{Op: OpDelete, Key: oldKey})
+	c.emit(Event{Op: OpSet, Key: newKey, Item: item})
+	if _, ok := c.pinned[oldKey]; ok {
+		delete(c.pinned, oldKey)
+		c.pinned[newKey] = struct{}{}
 	}
+	if c.tombstones != nil {
+		delete(c.tombstones, newKey)
+	}
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	return nil
+}
+
+func (c *cache) set(k, digest string, v any, d time.Duration) {
 	c.items[k] = Item{
 		Obj:        v,
-		ExpireTime: e,
+		ExpireTime: c.expireTime(d),
+		KeyDigest:  digest,
+		Stamp:      c.stamp(Stamp{}),
 	}
+	c.track(k, c.items[k])
+	c.emit(Event{Op: OpSet, Key: k, Item: c.items[k]})
 }
 
 func (c *cache) exist(k string) bool {
@@ -126,47 +376,135 @@ func (c *cache) exist(k string) bool {
 }
 
 func (c *cache) Get(k string) (any, bool) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+	if c.slowLog != nil {
+		defer c.slow("get", k, time.Now())
+	}
+	item, ok := c.lookup(k)
+	return item.Obj, ok
+}
+
+// lookup returns the unexpired item of k and counts the read
+func (c *cache) lookup(k string) (Item, bool) {
+	k, digest := c.key(k)
+	c.readLock()
 	item, ok := c.items[k]
-	if !ok {
-		return nil, false
+	c.lock.RUnlock()
+	if !ok || item.KeyDigest != digest {
+		c.hit(k, false)
+		return Item{}, false
 	}
 	if item.ExpireTime > 0 {
 		if time.Now().Unix() > item.ExpireTime {
-			return nil, false
+			c.hit(k, false)
+			c.reap(k)
+			return Item{}, false
 		}
 	}
-	return item.Obj, true
+	if c.invalid(k, item.Obj) {
+		c.hit(k, false)
+		return Item{}, false
+	}
+	c.hit(k, true)
+	return item, true
 }
 
 func (c *cache) GetWithExpire(k string) (any, time.Time, bool) {
-	c.lock.Lock()
-	defer c.lock.RUnlock()
+	k, digest := c.key(k)
+	c.lock.RLock()
 	item, ok := c.items[k]
-	if !ok {
+	c.lock.RUnlock()
+	if !ok || item.KeyDigest != digest {
+		c.hit(k, false)
 		return nil, time.Time{}, false
 	}
 	if item.ExpireTime > 0 {
 		if time.Now().Unix() > item.ExpireTime {
+			c.hit(k, false)
+			c.reap(k)
 			return nil, time.Time{}, false
 		}
-		return item.Obj, time.Unix(0, item.ExpireTime), true
 	}
+	if c.invalid(k, item.Obj) {
+		c.hit(k, false)
+		return nil, time.Time{}, false
+	}
+	if item.ExpireTime > 0 {
+		c.hit(k, true)
+		return item.Obj, time.Unix(item.ExpireTime, 0), true
+	}
+	c.hit(k, true)
 	return item.Obj, time.Time{}, true
 }
 
+// hit counts a read and records it in the access log
+func (c *cache) hit(k string, ok bool) {
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+		if c.ghost != nil && c.ghost.contains(hashKey(k)) {
+			c.ghostHits.Add(1)
+		}
+	}
+	c.recordAccess(k, OpGet, ok)
+}
+
 func (c *cache) Delete(k string) {
+	k, digest := c.key(k)
 	c.lock.Lock()
+	if item, ok := c.items[k]; ok && item.KeyDigest != digest {
+		// a hash collision, the item belongs to another key
+		c.lock.Unlock()
+		return
+	}
+	if c.tombstones != nil {
+		c.bury(k)
+		c.lock.Unlock()
+		c.recordAccess(k, OpDelete, false)
+		// the tombstone waits for its grace period, the invalidated children don't
+		c.callInvalidated()
+		return
+	}
 	v, hasCallBack := c.delete(k)
 	c.lock.Unlock()
+	c.recordAccess(k, OpDelete, false)
 	if hasCallBack {
-		c.onEvicted(k, v)
+		c.evictedCallback(k, v)
+	}
+	c.callInvalidated()
+}
+
+// SetCtx is Set with a context, the local cache never blocks so ctx is only checked before the write
+func (c *cache) SetCtx(ctx context.Context, k string, v any, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Set(k, v, d)
+}
+
+func (c *cache) GetCtx(ctx context.Context, k string) (any, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	v, ok := c.Get(k)
+	return v, ok, nil
+}
+
+func (c *cache) DeleteCtx(ctx context.Context, k string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	c.Delete(k)
+	return nil
 }
 
 func (c *cache) delete(k string) (any, bool) {
 	defer delete(c.items, k)
+	c.untrack(k)
+	delete(c.pinned, k)
+	if _, ok := c.items[k]; ok {
+		c.emit(Event{Op: OpDelete, Key: k})
+	}
 	if c.onEvicted != nil {
 		val, ok := c.items[k]
 		if ok {
@@ -179,23 +517,37 @@ func (c *cache) delete(k string) (any, bool) {
 func (c *cache) DeleteExpired() {
 	var (
 		callBackObj []Object
+		expired     []Entry
 		now         = time.Now().Unix()
 	)
 	c.lock.Lock()
+	c.notePeak()
 	for key, val := range c.items {
 		if val.ExpireTime > 0 && now > val.ExpireTime {
+			if c.onExpired != nil {
+				c.delete(key)
+				expired = append(expired, Entry{Key: key, Value: val.Obj, ExpireTime: time.Unix(val.ExpireTime, 0)})
+				continue
+			}
 			v, hasCallBack := c.delete(key)
 			if hasCallBack {
 				callBackObj = append(callBackObj, Object{key: key, val: v})
 			}
 		}
 	}
+	if c.tombstones != nil {
+		callBackObj = append(callBackObj, c.purgeTombstones(time.Now().UnixNano())...)
+	}
+	c.pruneLWW(time.Now())
+	c.shrinkMap()
 	c.lock.Unlock()
+	c.callExpired(expired)
 	if c.onEvicted != nil {
 		for _, val := range callBackObj {
-			c.onEvicted(val.key, val.val)
+			c.evictedCallback(val.key, val.val)
 		}
 	}
+	c.callInvalidated()
 }
 
 func (c *cache) OnEvicted(fun func(string, any)) {
@@ -204,9 +556,35 @@ func (c *cache) OnEvicted(fun func(string, any)) {
 	c.lock.Unlock()
 }
 
+// Flush clears the cache, pinned items are kept unless WithFlushPinned is set
 func (c *cache) Flush() {
 	c.lock.Lock()
-	c.items = map[string]Item{}
+	if c.deps != nil {
+		c.deps.parents, c.deps.children = map[string]map[string]struct{}{}, map[string]map[string]struct{}{}
+	}
+	items := map[string]Item{}
+	if !c.flushPinned {
+		for k := range c.pinned {
+			items[k] = c.items[k]
+		}
+	} else {
+		c.pinned = nil
+	}
+	c.items = items
+	if c.tombstones != nil {
+		c.tombstones = map[string]tombstone{}
+	}
+	for _, ns := range c.namespaces {
+		ns.keys, ns.bytes = map[string]int64{}, 0
+	}
+	for k, v := range items {
+		c.track(k, v)
+	}
+	c.emit(Event{Op: OpFlush})
+	for k, v := range items {
+		// the pinned items survived the flush
+		c.emit(Event{Op: OpSet, Key: k, Item: v})
+	}
 	c.lock.Unlock()
 }
 
@@ -217,9 +595,56 @@ func (c *cache) ItemCount() int {
 	return n
 }
 
+// TTL returns the remaining time to live, NoExpire for items without expiration and false for missing or expired items
+func (c *cache) TTL(k string) (time.Duration, bool) {
+	k, digest := c.key(k)
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	item, ok := c.items[k]
+	if !ok || item.KeyDigest != digest {
+		return 0, false
+	}
+	if item.ExpireTime == 0 {
+		return NoExpire, true
+	}
+	if time.Now().Unix() > item.ExpireTime {
+		return 0, false
+	}
+	ttl := time.Until(time.Unix(item.ExpireTime, 0))
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, true
+}
+
+func (c *cache) Stats() Stats {
+	s := Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Items:     c.ItemCount(),
+		Evictions: c.evictions.Load(),
+		GhostHits: c.ghostHits.Load(),
+		Sizes:     c.sizes.snapshot(),
+		Shed:      c.shed.Load(),
+		Invalid:   c.invalids.Load(),
+	}
+	if c.contention != nil {
+		s.LockSamples, s.LockContended = c.contention.samples.Load(), c.contention.contended.Load()
+	}
+	if c.shrink != nil {
+		c.lock.RLock()
+		s.MapRebuilds = c.shrink.rebuilds
+		c.lock.RUnlock()
+	}
+	return s
+}
+
 type janitor struct {
 	Interval time.Duration
 	stop     chan struct{}
+	stopOnce sync.Once
+	// lastRun is the unix nano time of the last run, or of the start before the first one
+	lastRun atomic.Int64
 }
 
 func initJanitor(interval time.Duration, c *cache) {
@@ -234,11 +659,18 @@ func initJanitor(interval time.Duration, c *cache) {
 }
 
 func (j *janitor) runJanitor(c *cache) {
+	j.lastRun.Store(time.Now().UnixNano())
 	ticker := time.NewTicker(j.Interval)
 	for {
 		select {
 		case <-ticker.C:
+			start := time.Now()
 			c.DeleteExpired()
+			now := time.Now()
+			j.lastRun.Store(now.UnixNano())
+			if took := now.Sub(start); took > j.Interval {
+				c.log(logging.LevelWarn, "local_cache: janitor run overran its interval", "took", took, "interval", j.Interval)
+			}
 		case <-j.stop:
 			ticker.Stop()
 			return
@@ -246,17 +678,57 @@ func (j *janitor) runJanitor(c *cache) {
 	}
 }
 
+// HealthCheck returns ErrUnhealthy when the janitor missed 3 runs or the cache holds more items than
+// WithMaxEntries allows; pinned items may keep it over the bound
+func (c *cache) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.janitorLock.Lock()
+	j := c.janitor
+	c.janitorLock.Unlock()
+	// lastRun is 0 until the janitor goroutine starts
+	if j != nil && j.lastRun.Load() != 0 {
+		if since := time.Since(time.Unix(0, j.lastRun.Load())); since > 3*j.Interval {
+			return fmt.Errorf("%w: janitor last ran %v ago, interval %v", ErrUnhealthy, since.Round(time.Millisecond), j.Interval)
+		}
+	}
+	c.lock.RLock()
+	n, max := len(c.items), c.maxEntries
+	c.lock.RUnlock()
+	if max > 0 && n > max {
+		return fmt.Errorf("%w: %d items, max %d", ErrUnhealthy, n, max)
+	}
+	return nil
+}
+
+// StopJanitor returns once the janitor finished its current run, it may be called more than once
 func StopJanitor(c *cache) {
-	c.janitor.stop <- struct{}{}
+	c.janitorLock.Lock()
+	defer c.janitorLock.Unlock()
+	c.janitor.halt()
+}
+
+// halt stops the janitor goroutine, the caller holds janitorLock; the janitor never takes it
+func (j *janitor) halt() {
+	if j == nil {
+		return
+	}
+	j.stopOnce.Do(func() {
+		j.stop <- struct{}{}
+	})
 }
 
 type Cache struct {
 	*cache
 }
 
-func NewCache(defaultExpiration, cleanupInterval time.Duration) *Cache {
+func NewCache(defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Cache {
 	items := make(map[string]Item)
 	c := newCache(defaultExpiration, items)
+	for _, opt := range opts {
+		opt(c)
+	}
 	C := &Cache{
 		c,
 	}
@@ -266,8 +738,11 @@ func NewCache(defaultExpiration, cleanupInterval time.Duration) *Cache {
 	return C
 }
 
-func NewCacheWithItems(defaultExpiration, cleanupInterval time.Duration, items map[string]Item) *Cache {
+func NewCacheWithItems(defaultExpiration, cleanupInterval time.Duration, items map[string]Item, opts ...Option) *Cache {
 	c := newCache(defaultExpiration, items)
+	for _, opt := range opts {
+		opt(c)
+	}
 	C := &Cache{
 		c,
 	}
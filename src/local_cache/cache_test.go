@@ -1,24 +1,46 @@
 package local_cache
 
 import (
+	"bytes"
+	"cache/src/encryption"
+	"cache/src/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode"
 )
 
+// mustSet sets a fixture, failing the test when the cache rejects it
+func mustSet(t *testing.T, ce *Cache, k string, v any, d time.Duration) {
+	t.Helper()
+	if err := ce.Set(k, v, d); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCache(t *testing.T) {
 	ce := NewCache(time.Second*2, time.Second*4)
 	ce.cache.OnEvicted(func(s string, a any) {
 		t.Log("delete", s)
 	})
 
-	ce.Set("name", "will", time.Second*2)
+	mustSet(t, ce, "name", "will", time.Second*2)
 	ce.Delete("name")
 
-	ce.Set("age", 13, DefaultExpire)
+	mustSet(t, ce, "age", 13, DefaultExpire)
 	time.Sleep(time.Second * 4)
 	t.Log(ce.Get("age"))
 
-	ce.Set("sex", "man", DefaultExpire)
+	mustSet(t, ce, "sex", "man", DefaultExpire)
 	time.Sleep(time.Second * 5)
 	t.Log(ce.Get("sex"))
 	t.Log(ce.items)
@@ -29,8 +51,1464 @@ func TestCahceWithOutJanitor(t *testing.T) {
 	ce.cache.OnEvicted(func(s string, a any) {
 		t.Log("delete", s)
 	})
-	ce.Set("sex", "man", DefaultExpire)
+	mustSet(t, ce, "sex", "man", DefaultExpire)
 	time.Sleep(time.Second * 5)
 	t.Log(ce.Get("sex"))
 	t.Log(ce.items)
 }
+
+func TestCacheCtx(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	ctx := context.Background()
+	if err := ce.SetCtx(ctx, "name", "will", DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := ce.GetCtx(ctx, "name"); err != nil || !ok || v != "will" {
+		t.Fatalf("unexpected get result %v %v %v", v, ok, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := ce.GetCtx(cctx, "name"); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := ce.DeleteCtx(cctx, "name"); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := ce.DeleteCtx(ctx, "name"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ce.Get("name"); ok {
+		t.Fatal("item should be deleted")
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	key := encryption.StaticKey([]byte("0123456789abcdef"))
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	ce := NewCache(time.Minute, 0)
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	mustSet(t, ce, "age", 13, NoExpire)
+	if err := ce.SaveFile(path, WithEncryption(key)); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewCache(time.Minute, 0)
+	if err := loaded.LoadFile(path); err == nil {
+		t.Fatal("loading an encrypted snapshot without the key should fail")
+	}
+	if err := loaded.LoadFile(path, WithEncryption(key)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := loaded.Get("name"); !ok || v != "will" {
+		t.Fatalf("unexpected item %v %v", v, ok)
+	}
+	if v, ok := loaded.Get("age"); !ok || v != 13 {
+		t.Fatalf("unexpected item %v %v", v, ok)
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithMaxKeyLen(8), WithKeyCharset(func(r rune) bool {
+		return r == ':' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}))
+	if err := ce.Set("user:1", "will", DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	if err := ce.Set("user:123456", "will", DefaultExpire); !errors.Is(err, ErrKeyTooLong) {
+		t.Fatalf("expected ErrKeyTooLong, got %v", err)
+	}
+	if err := ce.SetDefault("user 1", "will"); !errors.Is(err, ErrInvalidKeyChar) {
+		t.Fatalf("expected ErrInvalidKeyChar, got %v", err)
+	}
+	if ce.ItemCount() != 1 {
+		t.Fatalf("rejected keys should not be stored, got %d items", ce.ItemCount())
+	}
+}
+
+func TestDumpAccessLog(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithAccessLog(1, 3))
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	ce.Get("name")
+	ce.Get("age")
+	ce.Delete("name")
+
+	var buf bytes.Buffer
+	if err := ce.DumpAccessLog(&buf, AccessLogCSV); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// header plus the last 3 of 4 records
+	if len(lines) != 4 || !strings.Contains(lines[1], ",get,") || !strings.HasSuffix(lines[1], ",true") ||
+		!strings.HasSuffix(lines[2], ",false") || !strings.Contains(lines[3], ",delete,") {
+		t.Fatalf("unexpected csv %q", lines)
+	}
+
+	ce.Get("name")
+	buf.Reset()
+	if err := ce.DumpAccessLog(&buf, AccessLogJSON); err != nil {
+		t.Fatal(err)
+	}
+	var r AccessRecord
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Op != OpGet || r.Hit || r.KeyHash != hashKey("name") {
+		t.Fatalf("unexpected record %+v", r)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	var evicted []string
+	ce := NewCache(time.Minute, 0, WithMaxEntries(3))
+	ce.OnEvicted(func(k string, v any) { evicted = append(evicted, k) })
+	mustSet(t, ce, "a", 1, NoExpire)
+	mustSet(t, ce, "b", 2, time.Hour)
+	mustSet(t, ce, "c", 3, time.Minute)
+	mustSet(t, ce, "d", 4, NoExpire)
+	// c expires first
+	if ce.ItemCount() != 3 || len(evicted) != 1 || evicted[0] != "c" {
+		t.Fatalf("unexpected eviction %v, %d items", evicted, ce.ItemCount())
+	}
+	ce.Get("c")
+	if s := ce.Stats(); s.Evictions != 1 || s.GhostHits != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	ce.SetMaxEntries(1)
+	if ce.ItemCount() != 1 || len(evicted) != 3 {
+		t.Fatalf("shrinking should evict, got %v", evicted)
+	}
+}
+
+func TestTuneNext(t *testing.T) {
+	cfg := TuneConfig{MinEntries: 10, MaxEntries: 200, TargetHitRatio: 0.9, Tolerance: 0.02, Step: 0.1, MemoryBudget: 1000}
+	cases := []struct {
+		hits, misses, ghost, mem uint64
+		want                     int
+	}{
+		{50, 50, 10, 100, 110}, // below target with ghost hits: grow
+		{50, 50, 0, 100, 100},  // below target but a larger cache wouldn't help
+		{99, 1, 0, 100, 90},    // above target: shrink
+		{90, 10, 5, 100, 100},  // on target
+		{50, 50, 10, 950, 100}, // growing would exceed the budget
+		{50, 50, 10, 2000, 90}, // over budget: shrink
+		{0, 0, 0, 100, 100},    // idle
+	}
+	for _, tc := range cases {
+		if got := cfg.next(100, tc.hits, tc.misses, tc.ghost, tc.mem); got != tc.want {
+			t.Errorf("%+v: got %d, want %d", tc, got, tc.want)
+		}
+	}
+	if got := cfg.next(195, 50, 50, 10, 100); got != 200 {
+		t.Errorf("growth should be clamped, got %d", got)
+	}
+}
+
+func TestShutdownHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	ce := NewCache(time.Minute, time.Millisecond)
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	shutdown := ce.ShutdownHandler(path)
+	if err := shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	// a second call, e.g. defer after a signal handler, must not block on the stopped janitor
+	if err := shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	StopJanitor(ce.cache)
+
+	loaded := NewCache(time.Minute, 0)
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := loaded.Get("name"); !ok || v != "will" {
+		t.Fatalf("unexpected item %v %v", v, ok)
+	}
+}
+
+func TestGetMultiOrLoad(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	mustSet(t, ce, "a", 1, DefaultExpire)
+	var (
+		mu     sync.Mutex
+		loaded []string
+	)
+	loader := func(ctx context.Context, missing []string) (map[string]any, error) {
+		mu.Lock()
+		loaded = append(loaded, missing...)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		res := map[string]any{}
+		for _, k := range missing {
+			if k != "none" {
+				res[k] = k + "!"
+			}
+		}
+		return res, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := ce.GetMultiOrLoad(context.Background(), []string{"a", "b", "c", "none"}, loader)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(res) != 3 || res["a"] != 1 || res["b"] != "b!" || res["c"] != "c!" {
+				t.Errorf("unexpected result %v", res)
+			}
+		}()
+	}
+	wg.Wait()
+	// concurrent calls share the loads; "none" may be loaded again by a late call since it is never cached
+	sort.Strings(loaded)
+	if len(loaded) < 3 || loaded[0] != "b" || loaded[1] != "c" || (len(loaded) > 2 && loaded[2] != "none") {
+		t.Fatalf("unexpected loads %v", loaded)
+	}
+	for _, k := range loaded[2:] {
+		if k != "none" {
+			t.Fatalf("cached keys should not be loaded twice, got %v", loaded)
+		}
+	}
+}
+
+func TestGetMultiOrLoadPanic(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	started, waited := make(chan struct{}), make(chan error, 1)
+	go func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic should reach the call running the loader")
+			}
+		}()
+		_, _ = ce.GetMultiOrLoad(context.Background(), []string{"a"}, func(ctx context.Context, missing []string) (map[string]any, error) {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		_, err := ce.GetMultiOrLoad(context.Background(), []string{"a"}, func(ctx context.Context, missing []string) (map[string]any, error) {
+			return map[string]any{"a": 1}, nil
+		})
+		waited <- err
+	}()
+	select {
+	case err := <-waited:
+		if err != nil && err != ErrLoaderPanicked {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting calls should not hang after the loader panicked")
+	}
+	// the key is released, a later call loads it again
+	res, err := ce.GetMultiOrLoad(context.Background(), []string{"a"}, func(ctx context.Context, missing []string) (map[string]any, error) {
+		return map[string]any{"a": 2}, nil
+	})
+	if err != nil || res["a"] == nil {
+		t.Fatalf("unexpected result %v %v", res, err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	if _, _, ok := ce.NextExpiry(); ok {
+		t.Fatal("empty cache has no next expiry")
+	}
+	mustSet(t, ce, "session:1", 1, time.Hour)
+	mustSet(t, ce, "session:2", 2, 10*time.Second)
+	mustSet(t, ce, "session:3", 3, 20*time.Second)
+	mustSet(t, ce, "config", 4, NoExpire)
+
+	k, at, ok := ce.NextExpiry()
+	if !ok || k != "session:2" || time.Until(at) > 10*time.Second {
+		t.Fatalf("unexpected next expiry %s %v %v", k, at, ok)
+	}
+	if keys := ce.ExpiringWithin(time.Minute); !reflect.DeepEqual(keys, []string{"session:2", "session:3"}) {
+		t.Fatalf("unexpected expiring keys %v", keys)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	var evicted []string
+	ce := NewCache(time.Minute, 0, WithTombstones(20*time.Millisecond))
+	ce.OnEvicted(func(k string, v any) { evicted = append(evicted, k) })
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	mustSet(t, ce, "age", 13, DefaultExpire)
+	mustSet(t, ce, "sex", "man", DefaultExpire)
+
+	ce.Delete("name")
+	if _, ok := ce.Get("name"); ok {
+		t.Fatal("tombstoned item should miss")
+	}
+	if !ce.Restore("name") {
+		t.Fatal("restore within the grace period should succeed")
+	}
+	if v, ok := ce.Get("name"); !ok || v != "will" {
+		t.Fatalf("unexpected restored item %v %v", v, ok)
+	}
+
+	ce.Delete("age")
+	mustSet(t, ce, "age", 14, DefaultExpire)
+	if ce.Restore("age") {
+		t.Fatal("a new Set should discard the tombstone")
+	}
+
+	ce.Delete("sex")
+	time.Sleep(30 * time.Millisecond)
+	ce.DeleteExpired()
+	if ce.Restore("sex") || len(evicted) != 1 || evicted[0] != "sex" {
+		t.Fatalf("tombstone should be purged after the grace period, evicted %v", evicted)
+	}
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	ce := NewCache(time.Minute, 0,
+		WithNamespaceQuota("tenant:a:", 2, 0, OverflowReject),
+		WithNamespaceQuota("tenant:b:", 0, 22, OverflowEvictOwn))
+
+	mustSet(t, ce, "tenant:a:1", 1, DefaultExpire)
+	mustSet(t, ce, "tenant:a:2", 2, DefaultExpire)
+	if err := ce.Set("tenant:a:3", 3, DefaultExpire); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	// overwriting doesn't add an item
+	if err := ce.Set("tenant:a:2", 22, DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+
+	// each item is 10 bytes of key plus 1 byte of value
+	mustSet(t, ce, "tenant:b:1", "x", time.Minute)
+	mustSet(t, ce, "tenant:b:2", "y", time.Hour)
+	if err := ce.Set("tenant:b:3", "z", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ce.Get("tenant:b:1"); ok {
+		t.Fatal("the item of the namespace expiring first should be evicted")
+	}
+	if n, bytes, _ := ce.NamespaceUsage("tenant:b:"); n != 2 || bytes != 22 {
+		t.Fatalf("unexpected usage %d items %d bytes", n, bytes)
+	}
+	if err := ce.Set("tenant:b:big", strings.Repeat("x", 30), DefaultExpire); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("an item larger than the quota should be rejected, got %v", err)
+	}
+
+	ce.Delete("tenant:a:1")
+	if n, _, _ := ce.NamespaceUsage("tenant:a:"); n != 1 {
+		t.Fatalf("delete should release quota, got %d items", n)
+	}
+	mustSet(t, ce, "other", 1, DefaultExpire)
+	if ce.ItemCount() != 4 {
+		t.Fatalf("unexpected item count %d", ce.ItemCount())
+	}
+}
+
+func TestPin(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithMaxEntries(2))
+	mustSet(t, ce, "flag", true, time.Second)
+	mustSet(t, ce, "name", "will", time.Hour)
+	if !ce.Pin("flag") || ce.Pin("missing") {
+		t.Fatal("only existing items can be pinned")
+	}
+	// flag expires first, eviction has to pick name instead
+	mustSet(t, ce, "age", 13, time.Hour)
+	if _, ok := ce.Get("flag"); !ok {
+		t.Fatal("pinned item should not be evicted")
+	}
+	ce.Flush()
+	if _, ok := ce.Get("flag"); !ok || ce.ItemCount() != 1 {
+		t.Fatalf("flush should keep only the pinned item, got %d items", ce.ItemCount())
+	}
+	ce.Delete("flag")
+	mustSet(t, ce, "flag", false, DefaultExpire)
+	if ce.Pinned("flag") {
+		t.Fatal("delete should drop the pin")
+	}
+
+	ce = NewCache(time.Minute, 0, WithFlushPinned())
+	mustSet(t, ce, "flag", true, DefaultExpire)
+	ce.Pin("flag")
+	ce.Flush()
+	if ce.ItemCount() != 0 {
+		t.Fatal("flush should remove pinned items with WithFlushPinned")
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	mustSet(t, ce, "name", nil, DefaultExpire)
+	if v, ok := ce.Get("name"); !ok || v != nil {
+		t.Fatalf("nil should be stored as present, got %v %v", v, ok)
+	}
+
+	ce = NewCache(time.Minute, 0, WithNilPolicy(NilReject))
+	var p *Item
+	for _, v := range []any{nil, p, []byte(nil)} {
+		if err := ce.Set("name", v, DefaultExpire); !errors.Is(err, ErrNilValue) {
+			t.Fatalf("expected ErrNilValue for %#v, got %v", v, err)
+		}
+	}
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	if err := ce.Replace("name", nil, DefaultExpire); !errors.Is(err, ErrNilValue) {
+		t.Fatalf("expected ErrNilValue on replace, got %v", err)
+	}
+}
+
+func TestLazyExpiry(t *testing.T) {
+	past := time.Now().Add(-time.Minute).Unix()
+	items := map[string]Item{
+		"name": {Obj: "will", ExpireTime: past},
+		"age":  {Obj: 13, ExpireTime: past},
+	}
+	ce := NewCacheWithItems(time.Minute, 0, items, WithLazyExpiry())
+	var evicted []string
+	ce.OnEvicted(func(k string, _ any) { evicted = append(evicted, k) })
+
+	if _, ok := ce.Get("name"); ok {
+		t.Fatal("expired item should miss")
+	}
+	if _, _, ok := ce.GetWithExpire("age"); ok {
+		t.Fatal("expired item should miss")
+	}
+	if ce.ItemCount() != 0 || !reflect.DeepEqual(evicted, []string{"name", "age"}) {
+		t.Fatalf("expired items should be reaped on get, %d items left, evicted %v", ce.ItemCount(), evicted)
+	}
+}
+
+func TestRename(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithNamespaceQuota("live:", 1, 0, OverflowReject))
+	mustSet(t, ce, "staging:config", "v2", time.Hour)
+	mustSet(t, ce, "live:config", "v1", NoExpire)
+	ce.Pin("staging:config")
+
+	if err := ce.Rename("missing", "other", false); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := ce.Rename("staging:config", "live:config", false); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	// overwriting doesn't add an item to the namespace
+	if err := ce.Rename("staging:config", "live:config", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ce.Get("staging:config"); ok {
+		t.Fatal("old key should be gone")
+	}
+	if v, ok := ce.Get("live:config"); !ok || v != "v2" || !ce.Pinned("live:config") {
+		t.Fatalf("unexpected renamed item %v %v", v, ok)
+	}
+	if ttl, _ := ce.TTL("live:config"); ttl <= time.Minute {
+		t.Fatalf("rename should keep the expiration, got %v", ttl)
+	}
+	if n, _, _ := ce.NamespaceUsage("live:"); n != 1 {
+		t.Fatalf("unexpected namespace usage %d", n)
+	}
+
+	mustSet(t, ce, "staging:other", "x", DefaultExpire)
+	if err := ce.Rename("staging:other", "live:other", false); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if _, ok := ce.Get("staging:other"); !ok {
+		t.Fatal("a failed rename should keep the old key")
+	}
+}
+
+func TestSetIfExpiringWithin(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	if ok, err := ce.SetIfExpiringWithin("rate", 1, time.Hour, time.Minute); !ok || err != nil {
+		t.Fatalf("a missing item should be set, got %v %v", ok, err)
+	}
+	if ok, _ := ce.SetIfExpiringWithin("rate", 2, time.Hour, time.Minute); ok {
+		t.Fatal("an item far from expiring should be kept")
+	}
+	mustSet(t, ce, "rate", 3, 30*time.Second)
+	if ok, _ := ce.SetIfExpiringWithin("rate", 4, time.Hour, time.Minute); !ok {
+		t.Fatal("an item expiring within the window should be replaced")
+	}
+	mustSet(t, ce, "rate", 5, NoExpire)
+	if ok, _ := ce.SetIfExpiringWithin("rate", 6, time.Hour, time.Minute); ok {
+		t.Fatal("an item without expiration should be kept")
+	}
+	if v, _ := ce.Get("rate"); v != 5 {
+		t.Fatalf("unexpected value %v", v)
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	var large []string
+	ce := NewCache(time.Minute, 0, WithLargeValueCallback(1<<10, func(k string, size int64) {
+		large = append(large, k)
+	}))
+	mustSet(t, ce, "small", "x", DefaultExpire)
+	mustSet(t, ce, "big", strings.Repeat("x", 2<<10), DefaultExpire)
+	ce.Replace("small", strings.Repeat("x", 100), DefaultExpire)
+
+	sizes := ce.Stats().Sizes
+	if len(sizes) != len(SizeBuckets)+1 || sizes[0] != 1 || sizes[1] != 1 || sizes[3] != 1 {
+		t.Fatalf("unexpected histogram %v", sizes)
+	}
+	if !reflect.DeepEqual(large, []string{"big"}) {
+		t.Fatalf("unexpected large values %v", large)
+	}
+	if NewCache(time.Minute, 0).Stats().Sizes != nil {
+		t.Fatal("the histogram should be off by default")
+	}
+}
+
+func TestWatchMemory(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	for i := 0; i < 100; i++ {
+		mustSet(t, ce, strconv.Itoa(i), i, DefaultExpire)
+	}
+	ce.Pin("0")
+
+	var mem atomic.Uint64
+	mem.Store(200)
+	relief := make(chan int, 10)
+	signal := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := ce.WatchMemory(ctx, PressureConfig{
+		Threshold:   100,
+		MemoryUsage: mem.Load,
+		Signal:      signal,
+		Fraction:    0.5,
+		Interval:    5 * time.Millisecond,
+		OnRelief: func(evicted int, m uint64) {
+			mem.Store(50)
+			relief <- evicted
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := <-relief; n != 50 || ce.ItemCount() != 50 {
+		t.Fatalf("expected half of the items evicted, got %d, %d left", n, ce.ItemCount())
+	}
+	signal <- struct{}{}
+	if n := <-relief; n != 25 {
+		t.Fatalf("expected a signaled relief of 25 items, got %d", n)
+	}
+	if _, ok := ce.Get("0"); !ok {
+		t.Fatal("pinned item should survive")
+	}
+	if err = ce.WatchMemory(ctx, PressureConfig{Interval: time.Second}); err != ErrInvalidPressureConfig {
+		t.Fatalf("expected ErrInvalidPressureConfig, got %v", err)
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithKeyTransform(strings.TrimSpace), WithKeyTransform(strings.ToLower))
+	mustSet(t, ce, " Name ", "will", DefaultExpire)
+	if v, ok := ce.Get("NAME"); !ok || v != "will" {
+		t.Fatalf("unexpected get result %v %v", v, ok)
+	}
+	if err := ce.Rename("name ", "User:Name", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ce.TTL("user:name"); !ok || ce.ItemCount() != 1 {
+		t.Fatal("renamed key should be canonical")
+	}
+	ce.Delete(" USER:NAME")
+	if ce.ItemCount() != 0 {
+		t.Fatal("delete should use the canonical key")
+	}
+}
+
+func TestHashedKeys(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithHashedKeys(32))
+	long := "https://example.com/search?q=" + strings.Repeat("x", 1000)
+	mustSet(t, ce, long, "page", DefaultExpire)
+	mustSet(t, ce, "short", 1, DefaultExpire)
+	if v, ok := ce.Get(long); !ok || v != "page" {
+		t.Fatalf("unexpected get result %v %v", v, ok)
+	}
+	for k := range ce.items {
+		if len(k) > 32 {
+			t.Fatalf("long key stored as is: %d bytes", len(k))
+		}
+	}
+
+	// another key colliding on the hash finds an item with a different digest
+	stored, _ := ce.key(long)
+	item := ce.items[stored]
+	item.KeyDigest = "other"
+	ce.items[stored] = item
+	if _, ok := ce.Get(long); ok {
+		t.Fatal("a collision should miss")
+	}
+	ce.Delete(long)
+	if ce.ItemCount() != 2 {
+		t.Fatal("a collision should not delete the other item")
+	}
+	mustSet(t, ce, long, "page", DefaultExpire)
+	if v, _ := ce.Get(long); v != "page" {
+		t.Fatal("set should replace the colliding item")
+	}
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager(time.Minute, 10*time.Millisecond, WithMaxEntries(10))
+	defer m.CloseAll()
+	users, orders := m.Get("users"), m.Get("orders", WithKeyTransform(strings.ToLower))
+	if m.Get("users") != users {
+		t.Fatal("get should return the same cache")
+	}
+	mustSet(t, orders, "A", 1, DefaultExpire)
+	if _, ok := orders.Get("a"); !ok || users.MaxEntries() != 10 {
+		t.Fatal("caches should get the manager and their own options")
+	}
+
+	past := time.Now().Add(-time.Minute).Unix()
+	users.lock.Lock()
+	users.items["old"] = Item{Obj: 1, ExpireTime: past}
+	users.lock.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for users.ItemCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if users.ItemCount() != 0 {
+		t.Fatal("the shared janitor should delete expired items")
+	}
+
+	if !reflect.DeepEqual(m.Names(), []string{"orders", "users"}) {
+		t.Fatalf("unexpected names %v", m.Names())
+	}
+	if s := m.Stats(); s["orders"].Hits != 1 || s["orders"].Items != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	m.CloseAll()
+	if len(m.Names()) != 0 {
+		t.Fatal("close should forget the caches")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cfg, err := FromYAML(strings.NewReader(`
+# cache settings
+default_expiration: 5m
+cleanup_interval: 0s
+max_entries: 100 # per process
+nil_policy: "reject"
+lazy_expiry: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{DefaultExpiration: 5 * time.Minute, MaxEntries: 100, NilPolicy: "reject", LazyExpiry: true}
+	if cfg != want {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if _, err = FromYAML(strings.NewReader("shards: 16")); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+
+	t.Setenv("CACHE_MAX_ENTRIES", "10")
+	t.Setenv("CACHE_PERSISTENCE_PATH", filepath.Join(t.TempDir(), "missing.gob"))
+	env, err := FromEnv("CACHE_")
+	if err != nil || env.MaxEntries != 10 {
+		t.Fatalf("unexpected env config %+v %v", env, err)
+	}
+	c, err := New(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxEntries() != 10 {
+		t.Fatalf("unexpected max entries %d", c.MaxEntries())
+	}
+	c, _ = New(cfg)
+	if err = c.Set("name", nil, DefaultExpire); !errors.Is(err, ErrNilValue) {
+		t.Fatalf("expected ErrNilValue, got %v", err)
+	}
+	if _, err = New(Config{NilPolicy: "keep"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	var events [][]ConfigChange
+	ce := NewCache(time.Minute, 0, WithMaxEntries(10), WithOnReconfigure(func(changes []ConfigChange) {
+		events = append(events, changes)
+	}))
+	for i := 0; i < 10; i++ {
+		mustSet(t, ce, strconv.Itoa(i), i, DefaultExpire)
+	}
+	changes := ce.Reconfigure(Config{DefaultExpiration: time.Hour, MaxEntries: 5, CleanupInterval: 10 * time.Millisecond})
+	if len(changes) != 3 || len(events) != 1 || ce.ItemCount() != 5 {
+		t.Fatalf("unexpected changes %+v, %d items", changes, ce.ItemCount())
+	}
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	if ttl, _ := ce.TTL("name"); ttl <= time.Minute {
+		t.Fatalf("the new default expiration should apply, got %v", ttl)
+	}
+
+	past := time.Now().Add(-time.Minute).Unix()
+	ce.lock.Lock()
+	ce.items["old"] = Item{Obj: 1, ExpireTime: past}
+	ce.lock.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for ce.ItemCount() != 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ce.ItemCount() != 5 {
+		t.Fatal("the new janitor should delete expired items")
+	}
+	if changes = ce.Reconfigure(Config{DefaultExpiration: time.Hour, MaxEntries: 5, CleanupInterval: 10 * time.Millisecond}); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", changes)
+	}
+	ce.Reconfigure(Config{DefaultExpiration: time.Hour, MaxEntries: 5})
+}
+
+func TestReconfigureConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ce := NewCache(time.Minute, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				ce.Reconfigure(Config{CleanupInterval: time.Duration(i*50+j+1) * time.Millisecond})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_ = ce.HealthCheck(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+	StopJanitor(ce.cache)
+
+	// a cache of a Manager keeps the shared janitor
+	m := NewManager(time.Minute, time.Hour)
+	defer m.CloseAll()
+	mc := m.Get("a")
+	if changes := mc.Reconfigure(Config{DefaultExpiration: time.Minute, CleanupInterval: time.Second}); len(changes) != 0 || mc.janitor != nil {
+		t.Fatalf("cleanup_interval should be ignored for a managed cache, got %+v", changes)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	ce := NewCache(time.Minute, time.Hour, WithMaxEntries(1))
+	defer StopJanitor(ce.cache)
+	if err := ce.HealthCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ce.janitor.lastRun.Store(time.Now().Add(-4 * time.Hour).UnixNano())
+	if err := ce.HealthCheck(ctx); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("expected a stalled janitor, got %v", err)
+	}
+	ce.janitor.lastRun.Store(time.Now().UnixNano())
+
+	mustSet(t, ce, "flag", true, DefaultExpire)
+	ce.Pin("flag")
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	if err := ce.HealthCheck(ctx); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("expected a cache over its bound, got %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithHashedKeys(8))
+	mirror := NewCache(time.Minute, 0)
+	var ops []AccessOp
+	unsubscribe := ce.Subscribe(func(e Event) {
+		ops = append(ops, e.Op)
+		if err := mirror.Apply(e); err != nil {
+			t.Error(err)
+		}
+	})
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	mustSet(t, ce, "a-rather-long-key", 13, NoExpire)
+	ce.Replace("name", "yin", DefaultExpire)
+	ce.Rename("name", "nick", false)
+	ce.Delete("missing")
+	ce.Delete("nick")
+	if want := []AccessOp{OpSet, OpSet, OpSet, OpDelete, OpSet, OpDelete}; !reflect.DeepEqual(ops, want) {
+		t.Fatalf("unexpected events: %v", ops)
+	}
+	if !reflect.DeepEqual(mirror.Items(), ce.Items()) {
+		t.Fatalf("the mirror diverged: %v != %v", mirror.Items(), ce.Items())
+	}
+	if _, ok := mirror.Get("a-rather-long-key"); ok {
+		t.Fatal("applied keys are stored as is, the mirror has no hashed keys")
+	}
+
+	ce.Flush()
+	if mirror.ItemCount() != 0 {
+		t.Fatal("the flush should be applied")
+	}
+	unsubscribe()
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	if len(ops) != 7 {
+		t.Fatalf("no events after unsubscribe, got %v", ops)
+	}
+}
+
+func TestLWW(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithLWW("a", time.Minute))
+	var events []Event
+	ce.Subscribe(func(e Event) { events = append(events, e) })
+
+	mustSet(t, ce, "name", "will", DefaultExpire)
+	local := events[0].Stamp
+	if local.Node != "a" || local.IsZero() {
+		t.Fatalf("local writes should be stamped, got %+v", local)
+	}
+	older := Stamp{Time: local.Time - 1, Node: "b"}
+	newer := Stamp{Time: local.Time, Node: "b"}
+	ce.Apply(Event{Op: OpSet, Key: "name", Item: Item{Obj: "old", Stamp: older}})
+	if v, _ := ce.Get("name"); v != "will" || len(events) != 1 {
+		t.Fatalf("an older write should be ignored, got %v", v)
+	}
+	ce.Apply(Event{Op: OpSet, Key: "name", Item: Item{Obj: "yin", Stamp: newer}})
+	if v, _ := ce.Get("name"); v != "yin" || events[1].Stamp != newer {
+		t.Fatalf("a newer write should win on the node ID and keep its stamp, got %v %+v", v, events[1].Stamp)
+	}
+	// the same change coming back is not passed on again
+	ce.Apply(events[1])
+	if len(events) != 2 {
+		t.Fatalf("a change should not bounce, got %d events", len(events))
+	}
+
+	ce.Delete("name")
+	ce.Apply(Event{Op: OpSet, Key: "name", Item: Item{Obj: "late", Stamp: newer}})
+	if _, ok := ce.Get("name"); ok {
+		t.Fatal("a set stamped before the delete should not bring the item back")
+	}
+	mustSet(t, ce, "name", "again", DefaultExpire)
+	if !events[len(events)-1].Stamp.After(events[len(events)-2].Stamp) {
+		t.Fatal("local stamps should move past the stamps seen")
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	depth := 0
+	ce := NewCache(time.Minute, 0, WithLoadShedding(ShedConfig{
+		MaxWriteRate:  5,
+		QueueDepth:    func() int { return depth },
+		MaxQueueDepth: 10,
+	}))
+	for i := 0; i < 5; i++ {
+		if err := ce.SetWithPriority(strconv.Itoa(i), i, DefaultExpire, PriorityLow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a second may start between the writes, the window then starts over
+	shed := 0
+	for i := 5; i < 10; i++ {
+		if err := ce.SetWithPriority(strconv.Itoa(i), i, DefaultExpire, PriorityLow); errors.Is(err, ErrShed) {
+			shed++
+		}
+		if err := ce.Set("normal"+strconv.Itoa(i), i, DefaultExpire); err != nil {
+			t.Fatalf("normal sets should not be shed, got %v", err)
+		}
+	}
+	if shed == 0 || ce.Stats().Shed != uint64(shed) {
+		t.Fatalf("low priority sets over the rate should be shed, got %d %+v", shed, ce.Stats())
+	}
+
+	logged := 0
+	ce = NewCache(time.Minute, 0, WithLoadShedding(ShedConfig{
+		QueueDepth:    func() int { return depth },
+		MaxQueueDepth: 10,
+		Below:         PriorityHigh,
+		SampleRate:    0.5,
+	}), WithLogger(logging.Func(func(logging.Level, string, ...any) { logged++ })))
+	depth = 11
+	shed = 0
+	for i := 0; i < 1000; i++ {
+		if errors.Is(ce.Set(strconv.Itoa(i), i, DefaultExpire), ErrShed) {
+			shed++
+		}
+	}
+	if shed < 400 || shed > 600 {
+		t.Fatalf("about half the sets should be sampled out, got %d", shed)
+	}
+	if logged == 0 || logged > 2 {
+		t.Fatalf("shedding should be logged once a second, got %d", logged)
+	}
+	if err := ce.SetWithPriority("high", 1, DefaultExpire, PriorityHigh); err != nil {
+		t.Fatalf("high priority sets are never shed, got %v", err)
+	}
+	depth = 0
+	if err := ce.Set("calm", 1, DefaultExpire); err != nil {
+		t.Fatalf("nothing should be shed once the queue drained, got %v", err)
+	}
+}
+
+func TestExpiredBatch(t *testing.T) {
+	var batches [][]Entry
+	var evicted []string
+	ce := NewCache(time.Minute, 0, WithExpiredBatch(3, func(entries []Entry) {
+		batches = append(batches, entries)
+	}))
+	ce.OnEvicted(func(k string, v any) {
+		evicted = append(evicted, k)
+	})
+	for i := 0; i < 7; i++ {
+		mustSet(t, ce, strconv.Itoa(i), i, time.Second)
+	}
+	mustSet(t, ce, "kept", 1, DefaultExpire)
+	mustSet(t, ce, "deleted", 1, DefaultExpire)
+	ce.Delete("deleted")
+	// whole second expiry, see Item.Expired
+	time.Sleep(2100 * time.Millisecond)
+	ce.DeleteExpired()
+	if len(batches) != 3 || len(batches[0]) != 3 || len(batches[2]) != 1 {
+		t.Fatalf("7 expired items should come in batches of 3, 3 and 1, got %v", batches)
+	}
+	seen := map[string]bool{}
+	for _, b := range batches {
+		for _, e := range b {
+			if e.Value != mustAtoi(e.Key) || e.ExpireTime.IsZero() {
+				t.Fatalf("unexpected entry %+v", e)
+			}
+			seen[e.Key] = true
+		}
+	}
+	if len(seen) != 7 || !reflect.DeepEqual(evicted, []string{"deleted"}) {
+		t.Fatalf("expired items should only go to the batch callback, got %v %v", seen, evicted)
+	}
+}
+
+func mustAtoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func TestDependOn(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	var evicted []string
+	ce.OnEvicted(func(k string, v any) {
+		evicted = append(evicted, k)
+	})
+	mustSet(t, ce, "user:1", "will", DefaultExpire)
+	mustSet(t, ce, "user:2", "yin", DefaultExpire)
+	mustSet(t, ce, "team", "will,yin", DefaultExpire)
+	mustSet(t, ce, "team:count", 2, DefaultExpire)
+	mustSet(t, ce, "report", "1 team", DefaultExpire)
+	ce.DependOn("team", "user:1", "user:2")
+	ce.DependOn("team:count", "team")
+	ce.DependOn("report", "team:count")
+
+	mustSet(t, ce, "user:1", "will yin", DefaultExpire)
+	for _, k := range []string{"team", "team:count", "report"} {
+		if _, ok := ce.Get(k); ok {
+			t.Fatalf("%s should be invalidated with its transitive parent", k)
+		}
+	}
+	sort.Strings(evicted)
+	if !reflect.DeepEqual(evicted, []string{"report", "team", "team:count"}) {
+		t.Fatalf("the invalidated children should run the callback, got %v", evicted)
+	}
+	if _, ok := ce.Get("user:2"); !ok {
+		t.Fatal("the other parent should be kept")
+	}
+
+	// the edges went away with the child
+	mustSet(t, ce, "team", "will yin,yin", DefaultExpire)
+	ce.Delete("user:2")
+	if _, ok := ce.Get("team"); !ok {
+		t.Fatal("a child set again without DependOn should not be invalidated")
+	}
+	ce.DependOn("team", "user:1")
+	ce.DependOn("user:1", "team")
+	ce.Delete("user:1")
+	if _, ok := ce.Get("team"); ok {
+		t.Fatal("deleting a parent should invalidate its child")
+	}
+	if ce.ItemCount() != 0 {
+		t.Fatalf("a cycle should be invalidated once, %d items left", ce.ItemCount())
+	}
+}
+
+func TestDependOnTombstones(t *testing.T) {
+	ce := NewCache(time.Minute, 0, WithTombstones(time.Minute))
+	var evicted []string
+	ce.OnEvicted(func(k string, v any) {
+		evicted = append(evicted, k)
+	})
+	mustSet(t, ce, "user:1", "will", DefaultExpire)
+	mustSet(t, ce, "team", "will", DefaultExpire)
+	ce.DependOn("team", "user:1")
+
+	ce.Delete("user:1")
+	if _, ok := ce.Get("team"); ok {
+		t.Fatal("burying a parent should invalidate its child")
+	}
+	if !reflect.DeepEqual(evicted, []string{"team"}) {
+		t.Fatalf("the invalidated child should run the callback right away, got %v", evicted)
+	}
+	if !ce.Restore("user:1") {
+		t.Fatal("the parent should be restorable")
+	}
+}
+
+func TestScheduledInvalidation(t *testing.T) {
+	ce := NewCache(time.Minute, 0)
+	deadline := time.Now().Add(50 * time.Millisecond)
+	if err := ce.SetUntil("sale", "20% off", deadline); err != nil {
+		t.Fatal(err)
+	}
+	if err := ce.SetUntil("replaced", 1, deadline); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, ce, "replaced", 2, time.Hour)
+	mustSet(t, ce, "token", "abc", time.Hour)
+	ce.InvalidateAt("token", deadline.Add(50*time.Millisecond))
+	if _, ok := ce.Get("sale"); !ok {
+		t.Fatal("the item should live until its deadline")
+	}
+	time.Sleep(70 * time.Millisecond)
+	if _, ok := ce.Get("sale"); ok {
+		t.Fatal("the item should be gone at its deadline, not at the next second")
+	}
+	if v, _ := ce.Get("replaced"); v != 2 {
+		t.Fatalf("a replaced item should not be deleted by the deadline of the old one, got %v", v)
+	}
+	if _, ok := ce.Get("token"); !ok {
+		t.Fatal("the token should live until its invalidation time")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := ce.Get("token"); ok {
+		t.Fatal("the token should be invalidated")
+	}
+	if err := ce.SetUntil("past", 1, time.Now().Add(-time.Second)); err != nil || ce.ItemCount() != 1 {
+		t.Fatalf("a past deadline should not store the item, got %v %d", err, ce.ItemCount())
+	}
+}
+
+func TestCron(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tc := range []struct{ spec, from, next string }{
+		{"0 3 * * *", "2024-05-01 03:00", "2024-05-02 03:00"},
+		{"@hourly", "2024-05-01 03:59", "2024-05-01 04:00"},
+		{"*/15 9-17 * * 1-5", "2024-05-03 17:50", "2024-05-06 09:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		// either the day of month or the day of week
+		{"0 12 1 * 7", "2024-05-01 13:00", "2024-05-05 12:00"},
+		{"30 1,2 * 6 *", "2024-05-01 00:00", "2024-06-01 01:30"},
+		// a stepped * is not a restriction, both fields must match
+		{"0 0 */2 * 1", "2024-05-01 00:00", "2024-05-13 00:00"},
+		{"0 0 1 * */2", "2024-05-01 00:00", "2024-06-01 00:00"},
+	} {
+		s, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := s.Next(at(tc.from)); !next.Equal(at(tc.next)) {
+			t.Fatalf("%s after %s: got %v, want %s", tc.spec, tc.from, next, tc.next)
+		}
+	}
+	// the hours are local hours in zones with a half hour offset too, Asia/Kolkata is UTC+5:30
+	kolkata := time.FixedZone("IST", 5*3600+30*60)
+	s, _ := ParseCron("0 3 * * *")
+	from := time.Date(2026, 10, 16, 0, 1, 0, 0, kolkata)
+	if next := s.Next(from); !next.Equal(time.Date(2026, 10, 16, 3, 0, 0, 0, kolkata)) {
+		t.Fatalf("0 3 * * * after %v: got %v", from, next)
+	}
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every -1s"} {
+		if _, err := ParseCron(spec); !errors.Is(err, ErrInvalidCron) {
+			t.Fatalf("%q should be invalid, got %v", spec, err)
+		}
+	}
+
+	m := NewManager(time.Minute, 0)
+	defer m.CloseAll()
+	errs := make(chan error, 10)
+	m.OnJobError(func(name string, err error) {
+		errs <- err
+	})
+	mustSet(t, m.Get("catalog"), "product:1", "old", DefaultExpire)
+	mustSet(t, m.Get("catalog"), "promo:1", "10%", DefaultExpire)
+	version := 0
+	cancel, err := m.ScheduleRefresh("@every 10ms", "catalog", time.Hour, func(ctx context.Context) (map[string]any, error) {
+		version++
+		if version == 2 {
+			return nil, errors.New("catalog unavailable")
+		}
+		return map[string]any{"product:1": "v" + strconv.Itoa(version)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Fatalf("the error of the loader should be reported, got %v", err)
+	}
+	cancel()
+	if v, _ := m.Get("catalog").Get("product:1"); v == "old" {
+		t.Fatalf("the refresh should set the items, got %v", v)
+	}
+	if _, err := m.ScheduleFlush("@every 10ms", "catalog", "promo:"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for m.Get("catalog").ItemCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the namespace should be flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	var mu sync.Mutex
+	var ops []string
+	logger := logging.Func(func(level logging.Level, msg string, keyvals ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		if level != logging.LevelWarn || len(keyvals) != 6 || keyvals[0] != "op" || keyvals[2] != "key" {
+			t.Errorf("unexpected message: %v %s %v", level, msg, keyvals)
+			return
+		}
+		ops = append(ops, keyvals[1].(string)+" "+keyvals[3].(string))
+	})
+	c := NewCache(time.Minute, 0, WithSlowLog(SlowLogConfig{Threshold: 5 * time.Millisecond, Logger: logger}))
+	c.OnEvicted(func(string, any) { time.Sleep(10 * time.Millisecond) })
+	_ = c.Set("fast", 1, time.Minute)
+	_, _ = c.Get("fast")
+	c.Delete("fast")
+	_, _ = c.GetMultiOrLoad(context.Background(), []string{"a", "b"}, func(ctx context.Context, keys []string) (map[string]any, error) {
+		time.Sleep(10 * time.Millisecond)
+		return map[string]any{"a": 1, "b": 2}, nil
+	})
+	mu.Lock()
+	if want := []string{"evicted_callback fast", "loader "}; !reflect.DeepEqual(ops, want) {
+		t.Fatalf("expected %v, got %v", want, ops)
+	}
+	ops = nil
+	mu.Unlock()
+
+	c = NewCache(time.Minute, 0, WithSlowLog(SlowLogConfig{Threshold: time.Nanosecond, Logger: logger, HashKeys: true}))
+	_ = c.Set("user:12", 1, time.Minute)
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"set " + strconv.FormatUint(hashKey("user:12"), 16)}; !reflect.DeepEqual(ops, want) {
+		t.Fatalf("the keys should be hashed, expected %v, got %v", want, ops)
+	}
+}
+
+func TestValueValidator(t *testing.T) {
+	var evicted []string
+	c := NewCache(time.Minute, 0, WithValueValidator(func(key string, val any) bool {
+		_, ok := val.(int)
+		return ok
+	}))
+	c.OnEvicted(func(k string, v any) { evicted = append(evicted, k) })
+	_ = c.Set("new", 1, DefaultExpire)
+	_ = c.Set("old", "1", DefaultExpire)
+	if v, ok := c.Get("new"); !ok || v != 1 {
+		t.Fatalf("a valid value should be a hit, got %v %v", v, ok)
+	}
+	if v, ok := c.Get("old"); ok {
+		t.Fatalf("an invalid value should be a miss, got %v", v)
+	}
+	if _, _, ok := c.GetWithExpire("old"); ok || c.ItemCount() != 1 {
+		t.Fatalf("the invalid value should be deleted, %d items", c.ItemCount())
+	}
+	if !reflect.DeepEqual(evicted, []string{"old"}) {
+		t.Fatalf("the eviction callback should run, got %v", evicted)
+	}
+	if s := c.Stats(); s.Invalid != 1 || s.Hits != 1 || s.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestTags(t *testing.T) {
+	c := NewCache(time.Minute, 0)
+	_ = c.SetWithTags("user:1", 1, DefaultExpire, "tenant:a", "users")
+	_ = c.SetWithTags("user:2", 2, DefaultExpire, "tenant:b", "users")
+	_ = c.SetWithTags("order:1", 3, DefaultExpire, "tenant:a")
+	_ = c.Set("plain", 4, DefaultExpire)
+	keys := func(tags ...string) []string {
+		var res []string
+		c.Range(func(k string, item Item) bool {
+			res = append(res, k)
+			return true
+		}, tags...)
+		sort.Strings(res)
+		return res
+	}
+	if got := keys("tenant:a"); !reflect.DeepEqual(got, []string{"order:1", "user:1"}) {
+		t.Fatalf("unexpected items of tenant:a: %v", got)
+	}
+	if got := keys("tenant:a", "users"); !reflect.DeepEqual(got, []string{"user:1"}) {
+		t.Fatalf("unexpected users of tenant:a: %v", got)
+	}
+	if got := keys(); len(got) != 4 {
+		t.Fatalf("every item should be ranged over without tags, got %v", got)
+	}
+	if item := c.Items()["user:2"]; !reflect.DeepEqual(item.Tags, []string{"tenant:b", "users"}) {
+		t.Fatalf("Items should carry the tags, got %v", item.Tags)
+	}
+	_ = c.Set("user:1", 5, DefaultExpire)
+	if got := keys("users"); !reflect.DeepEqual(got, []string{"user:2"}) {
+		t.Fatalf("a Set should clear the tags, got %v", got)
+	}
+	n := 0
+	c.Range(func(string, Item) bool { n++; return false })
+	if n != 1 {
+		t.Fatalf("Range should stop when fn returns false, called %d times", n)
+	}
+}
+
+func TestSoftTTL(t *testing.T) {
+	c := NewCache(time.Minute, 0)
+	if err := c.SetWithTTLs("name", "will", 2*time.Minute, DefaultExpire); !errors.Is(err, ErrSoftTTL) {
+		t.Fatalf("a soft TTL past the hard one should be rejected, got %v", err)
+	}
+	if err := c.SetWithTTLs("name", "will", time.Second, NoExpire); err != nil {
+		t.Fatal(err)
+	}
+	if v, s := c.GetWithState("name"); v != "will" || s != StateFresh {
+		t.Fatalf("unexpected value: %v %v", v, s)
+	}
+	// move the soft expiration to the past rather than sleeping for a second
+	c.lock.Lock()
+	item := c.items["name"]
+	item.SoftExpireTime = time.Now().Add(-time.Second).Unix()
+	c.items["name"] = item
+	c.lock.Unlock()
+	if v, s := c.GetWithState("name"); v != "will" || s != StateStale {
+		t.Fatalf("a value past its soft TTL should be served stale, got %v %v", v, s)
+	}
+	if v, ok := c.Get("name"); !ok || v != "will" {
+		t.Fatalf("Get should serve stale values, got %v %v", v, ok)
+	}
+	_ = c.Set("name", "yin", DefaultExpire)
+	if _, s := c.GetWithState("name"); s != StateFresh {
+		t.Fatalf("a Set should clear the soft TTL, got %v", s)
+	}
+	if _, s := c.GetWithState("missing"); s != StateMiss {
+		t.Fatalf("unexpected state: %v", s)
+	}
+
+	// the default expiration changing under SetWithTTLs, for the race detector; the soft TTL is
+	// always rejected so that SetWithTTLs doesn't take the lock of the write
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.Reconfigure(Config{DefaultExpiration: time.Duration(i+1) * time.Minute})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := c.SetWithTTLs("name", "will", time.Hour, DefaultExpire); !errors.Is(err, ErrSoftTTL) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestGetAndDelete(t *testing.T) {
+	c := NewCache(time.Minute, 0)
+	_ = c.Set("job", "payload", DefaultExpire)
+	var claimed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := c.GetAndDelete("job"); ok && v == "payload" {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := claimed.Load(); n != 1 || c.ItemCount() != 0 {
+		t.Fatalf("exactly one caller should claim the job, got %d, %d items left", n, c.ItemCount())
+	}
+
+	_ = c.Set("token", "t", DefaultExpire)
+	if v, ok := c.GetAndExpire("token", NoExpire); !ok || v != "t" {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if _, exp, _ := c.GetWithExpire("token"); !exp.IsZero() {
+		t.Fatalf("NoExpire should make the item persistent, expires at %v", exp)
+	}
+	_, _ = c.GetAndExpire("token", time.Hour)
+	if ttl, _ := c.TTL("token"); ttl <= 59*time.Minute {
+		t.Fatalf("the expiration should be changed, TTL %v", ttl)
+	}
+	if _, ok := c.GetAndExpire("missing", time.Hour); ok {
+		t.Fatal("a missing key should be a miss")
+	}
+}
+
+func TestSets(t *testing.T) {
+	c := NewCache(time.Minute, 0)
+	if n, err := c.SAdd("role:admin", "read", "write", "delete", "read"); err != nil || n != 3 {
+		t.Fatalf("unexpected SAdd result: %d %v", n, err)
+	}
+	_, _ = c.SAdd("role:editor", "read", "write")
+	_, _ = c.SAdd("banned", "delete")
+	before, _ := c.Get("role:editor")
+
+	if got, _ := c.SUnion("role:editor", "banned", "missing"); !reflect.DeepEqual(got, []string{"delete", "read", "write"}) {
+		t.Fatalf("unexpected union: %v", got)
+	}
+	if got, _ := c.SInter("role:admin", "role:editor"); !reflect.DeepEqual(got, []string{"read", "write"}) {
+		t.Fatalf("unexpected intersection: %v", got)
+	}
+	if got, _ := c.SInter("role:admin", "missing"); len(got) != 0 {
+		t.Fatalf("a missing key should be an empty set, got %v", got)
+	}
+	if got, _ := c.SDiff("role:admin", "banned"); !reflect.DeepEqual(got, []string{"read", "write"}) {
+		t.Fatalf("unexpected difference: %v", got)
+	}
+	if ok, _ := c.SIsMember("role:editor", "write"); !ok {
+		t.Fatal("write should be a member")
+	}
+	if n, _ := c.SRem("role:editor", "write", "nope"); n != 1 {
+		t.Fatalf("expected 1 member removed, got %d", n)
+	}
+	if got, _ := c.SMembers("role:editor"); !reflect.DeepEqual(got, []string{"read"}) {
+		t.Fatalf("unexpected members: %v", got)
+	}
+	if len(before.(Set)) != 2 {
+		t.Fatalf("a stored set should never be modified, got %v", before)
+	}
+	_, _ = c.SRem("role:editor", "read")
+	if _, ok := c.Get("role:editor"); ok {
+		t.Fatal("the key should be deleted with its last member")
+	}
+
+	_ = c.Set("name", "will", DefaultExpire)
+	if _, err := c.SAdd("name", "x"); !errors.Is(err, ErrNotSet) {
+		t.Fatalf("expected ErrNotSet, got %v", err)
+	}
+	if _, err := c.SUnion("role:admin", "name"); !errors.Is(err, ErrNotSet) {
+		t.Fatalf("expected ErrNotSet, got %v", err)
+	}
+}
+
+func TestContentionSampling(t *testing.T) {
+	c := NewCache(time.Minute, 0, WithContentionSampling(2))
+	for i := 0; i < 10; i++ {
+		_ = c.Set("name", i, DefaultExpire)
+	}
+	if s := c.Stats(); s.LockSamples != 5 || s.LockContended != 0 {
+		t.Fatalf("one lock in 2 should be sampled without contention, got %+v", s)
+	}
+	// the next acquisition is not sampled, the one after is
+	_ = c.Set("name", 0, DefaultExpire)
+	c.lock.Lock()
+	done := make(chan struct{})
+	go func() {
+		_, _ = c.Get("name")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.lock.Unlock()
+	<-done
+	if s := c.Stats(); s.LockSamples != 6 || s.LockContended != 1 {
+		t.Fatalf("a sampled Get waiting for the lock should be counted, got %+v", s)
+	}
+}
+
+func TestMapShrink(t *testing.T) {
+	c := NewCache(NoExpire, 0, WithMapShrink(0.25))
+	for i := 0; i < 2000; i++ {
+		_ = c.Set(strconv.Itoa(i), i, DefaultExpire)
+	}
+	expire := func(n int) {
+		c.lock.Lock()
+		for i := 0; i < n; i++ {
+			if item, ok := c.items[strconv.Itoa(i)]; ok {
+				item.ExpireTime = time.Now().Add(-time.Second).Unix()
+				c.items[strconv.Itoa(i)] = item
+			}
+		}
+		c.lock.Unlock()
+	}
+	expire(1000)
+	c.DeleteExpired()
+	if s := c.Stats(); s.MapRebuilds != 0 || s.Items != 1000 {
+		t.Fatalf("half of the peak should not rebuild the map, got %+v", s)
+	}
+	expire(1900)
+	c.DeleteExpired()
+	if s := c.Stats(); s.MapRebuilds != 1 || s.Items != 100 {
+		t.Fatalf("the map should be rebuilt below a quarter of the peak, got %+v", s)
+	}
+	if v, ok := c.Get("1999"); !ok || v != 1999 {
+		t.Fatalf("the items should survive the rebuild, got %v %v", v, ok)
+	}
+	c.DeleteExpired()
+	if s := c.Stats(); s.MapRebuilds != 1 {
+		t.Fatalf("the peak should restart from the rebuilt map, got %+v", s)
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	c := NewCache(time.Minute, 0)
+	for i := 0; i < 100; i++ {
+		_ = c.Set(strconv.Itoa(i), "old", DefaultExpire)
+	}
+	c.Pin("1")
+	c.Pin("99")
+	var ops []AccessOp
+	c.Subscribe(func(e Event) { ops = append(ops, e.Op) })
+
+	stop := make(chan struct{})
+	var partial atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// a reader sees either dataset, never a mix or an empty cache
+			a, okA := c.Get("1")
+			if !okA || a != "old" && a != "new" {
+				partial.Store(true)
+			}
+		}
+	}()
+	items := make(map[string]Item, 50)
+	for i := 0; i < 50; i++ {
+		items[strconv.Itoa(i)] = Item{Obj: "new"}
+	}
+	items["expired"] = Item{Obj: "new", ExpireTime: time.Now().Add(-time.Minute).Unix()}
+	c.ReplaceAll(items)
+	close(stop)
+	wg.Wait()
+	if partial.Load() {
+		t.Fatal("readers should never see a partial cache")
+	}
+	if n := c.ItemCount(); n != 50 {
+		t.Fatalf("expected the 50 unexpired new items, got %d", n)
+	}
+	if v, _ := c.Get("1"); v != "new" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if _, ok := c.Get("99"); ok {
+		t.Fatal("the items missing from the new dataset should be gone")
+	}
+	if c.Pinned("99") || !c.Pinned("1") {
+		t.Fatal("only the pins of the keys still present should be kept")
+	}
+	if len(ops) != 51 || ops[0] != OpFlush || ops[1] != OpSet {
+		t.Fatalf("subscribers should get a flush and the sets, got %d events", len(ops))
+	}
+}
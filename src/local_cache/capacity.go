@@ -0,0 +1,259 @@
+package local_cache
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+/*
+Capacity bounds the number of items. When a new key is set on a full cache, an item is evicted from a
+small random sample: an expired one if the sample has any, otherwise the one expiring first, items
+without expiration last. Evicted keys are remembered by hash in a ghost list; a miss on a ghost key
+is a miss a larger cache would have served, which is what AutoTune uses to decide to grow.
+*/
+
+const evictionSamples = 5
+
+type ghostList struct {
+	mu   sync.Mutex
+	set  map[uint64]struct{}
+	ring []uint64
+	next int
+	full bool
+}
+
+func newGhostList(n int) *ghostList {
+	return &ghostList{
+		set:  make(map[uint64]struct{}, n),
+		ring: make([]uint64, n),
+	}
+}
+
+func (g *ghostList) add(h uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.set[h]; ok {
+		return
+	}
+	if g.full {
+		delete(g.set, g.ring[g.next])
+	}
+	g.ring[g.next] = h
+	g.set[h] = struct{}{}
+	g.next++
+	if g.next == len(g.ring) {
+		g.next, g.full = 0, true
+	}
+}
+
+func (g *ghostList) contains(h uint64) bool {
+	g.mu.Lock()
+	_, ok := g.set[h]
+	g.mu.Unlock()
+	return ok
+}
+
+// WithMaxEntries bounds the cache to n items, the ghost list remembers the last n evicted keys
+func WithMaxEntries(n int) Option {
+	return func(c *cache) {
+		if n <= 0 {
+			return
+		}
+		c.maxEntries = n
+		c.ghost = newGhostList(n)
+	}
+}
+
+func (c *cache) MaxEntries() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.maxEntries
+}
+
+// SetMaxEntries changes the bound, shrinking evicts down to n right away; n <= 0 removes the bound
+func (c *cache) SetMaxEntries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	c.lock.Lock()
+	c.maxEntries = n
+	if n > 0 && c.ghost == nil {
+		c.ghost = newGhostList(n)
+	}
+	var evicted []Object
+	if n > 0 && len(c.items) > n {
+		evicted = c.evict(len(c.items) - n)
+	}
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+}
+
+// evict removes n items, it returns the removed items when there is an eviction callback; the caller holds c.lock
+func (c *cache) evict(n int) []Object {
+	var evicted []Object
+	now := time.Now().Unix()
+	for ; n > 0 && len(c.items) > 0; n-- {
+		var (
+			victim string
+			best   int64
+			i      int
+		)
+		for k, v := range c.items {
+			if _, ok := c.pinned[k]; ok {
+				continue
+			}
+			rank := evictionRank(v, now)
+			if i == 0 || rank < best {
+				victim, best = k, rank
+			}
+			i++
+			if rank == 0 || i == evictionSamples {
+				break
+			}
+		}
+		if i == 0 {
+			// only pinned items left
+			break
+		}
+		if v, ok := c.delete(victim); ok {
+			evicted = append(evicted, Object{key: victim, val: v})
+		}
+		c.evictions.Add(1)
+		if c.ghost != nil {
+			c.ghost.add(hashKey(victim))
+		}
+	}
+	return evicted
+}
+
+// evictionRank orders eviction candidates: expired items first, then the earliest expiring,
+// items without expiration last
+func evictionRank(v Item, now int64) int64 {
+	switch {
+	case v.ExpireTime == 0:
+		return 1<<63 - 1
+	case now > v.ExpireTime:
+		return 0
+	}
+	return v.ExpireTime
+}
+
+func (c *cache) callEvicted(evicted []Object) {
+	for _, o := range evicted {
+		c.evictedCallback(o.key, o.val)
+	}
+	c.callInvalidated()
+}
+
+// TuneConfig configures AutoTune, MinEntries and MaxEntries are required
+type TuneConfig struct {
+	MinEntries int
+	MaxEntries int
+	// TargetHitRatio is the hit ratio to hold, the cache grows below it while the ghost list shows
+	// a larger cache would help and shrinks when the ratio is above it by more than Tolerance;
+	// 0 tunes for the memory budget only
+	TargetHitRatio float64
+	Tolerance      float64
+	// MemoryBudget in bytes, the cache shrinks while MemoryUsage reports more; 0 disables the check
+	MemoryBudget uint64
+	// MemoryUsage reports the memory in use, the Go heap by default
+	MemoryUsage func() uint64
+	// Step is the fraction the bound changes by per interval, 0.1 by default
+	Step     float64
+	Interval time.Duration
+	// OnTune is called after every change of the bound
+	OnTune func(old, new int)
+}
+
+var ErrInvalidTuneConfig = errors.New("local_cache: invalid tune config")
+
+// AutoTune adjusts MaxEntries every cfg.Interval until ctx is done
+func (c *cache) AutoTune(ctx context.Context, cfg TuneConfig) error {
+	if cfg.MinEntries <= 0 || cfg.MaxEntries < cfg.MinEntries || cfg.Interval <= 0 {
+		return ErrInvalidTuneConfig
+	}
+	if cfg.Step <= 0 {
+		cfg.Step = 0.1
+	}
+	if cfg.Tolerance <= 0 {
+		cfg.Tolerance = 0.02
+	}
+	if cfg.MemoryUsage == nil {
+		cfg.MemoryUsage = heapAlloc
+	}
+	if cur := c.MaxEntries(); cur < cfg.MinEntries || cur > cfg.MaxEntries {
+		c.SetMaxEntries(clamp(cur, cfg.MinEntries, cfg.MaxEntries))
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		hits, misses, ghostHits := c.hits.Load(), c.misses.Load(), c.ghostHits.Load()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			h, m, g := c.hits.Load(), c.misses.Load(), c.ghostHits.Load()
+			var mem uint64
+			if cfg.MemoryBudget > 0 {
+				mem = cfg.MemoryUsage()
+			}
+			old := c.MaxEntries()
+			if n := cfg.next(old, h-hits, m-misses, g-ghostHits, mem); n != old {
+				c.SetMaxEntries(n)
+				if cfg.OnTune != nil {
+					cfg.OnTune(old, n)
+				}
+			}
+			hits, misses, ghostHits = h, m, g
+		}
+	}()
+	return nil
+}
+
+// next returns the bound for the next interval from the counters of the last one
+func (cfg TuneConfig) next(cur int, hits, misses, ghostHits uint64, mem uint64) int {
+	step := int(float64(cur) * cfg.Step)
+	if step < 1 {
+		step = 1
+	}
+	if cfg.MemoryBudget > 0 && mem > cfg.MemoryBudget {
+		return clamp(cur-step, cfg.MinEntries, cfg.MaxEntries)
+	}
+	if hits+misses == 0 {
+		return cur
+	}
+	ratio := float64(hits) / float64(hits+misses)
+	switch {
+	// without a target hit ratio the cache grows while it has ghost hits and memory to spare
+	case ghostHits > 0 && (cfg.TargetHitRatio <= 0 || ratio < cfg.TargetHitRatio):
+		// don't grow into the memory budget
+		if cfg.MemoryBudget > 0 && float64(mem)*(1+cfg.Step) > float64(cfg.MemoryBudget) {
+			return cur
+		}
+		return clamp(cur+step, cfg.MinEntries, cfg.MaxEntries)
+	case cfg.TargetHitRatio > 0 && ratio > cfg.TargetHitRatio+cfg.Tolerance:
+		return clamp(cur-step, cfg.MinEntries, cfg.MaxEntries)
+	}
+	return cur
+}
+
+func clamp(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
+
+func heapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
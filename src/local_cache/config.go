@@ -0,0 +1,220 @@
+package local_cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Config carries the settings that are usually tuned per deployment rather than in code. FromYAML reads
+a flat mapping of the yaml names below, one "name: value" per line, with # comments; nested mappings,
+lists and multi-line values are not supported, the module has no YAML dependency. FromEnv reads the
+same names upper-cased after a prefix, e.g. CACHE_MAX_ENTRIES with the prefix "CACHE_". Durations use
+time.ParseDuration syntax.
+*/
+
+var ErrInvalidConfig = errors.New("local_cache: invalid config")
+
+type Config struct {
+	DefaultExpiration time.Duration `yaml:"default_expiration"`
+	CleanupInterval   time.Duration `yaml:"cleanup_interval"`
+	MaxEntries        int           `yaml:"max_entries"`
+	MaxKeyLen         int           `yaml:"max_key_len"`
+	// NilPolicy is "store" or "reject"
+	NilPolicy      string        `yaml:"nil_policy"`
+	LazyExpiry     bool          `yaml:"lazy_expiry"`
+	TombstoneGrace time.Duration `yaml:"tombstone_grace"`
+	HashKeysFrom   int           `yaml:"hash_keys_from"`
+	SizeHistogram  bool          `yaml:"size_histogram"`
+	// PersistencePath is loaded by New when the file exists, save it with SaveFile or ShutdownHandler
+	PersistencePath string `yaml:"persistence_path"`
+}
+
+var configNames = []string{
+	"default_expiration", "cleanup_interval", "max_entries", "max_key_len", "nil_policy",
+	"lazy_expiry", "tombstone_grace", "hash_keys_from", "size_histogram", "persistence_path",
+}
+
+// FromYAML reads a config from a flat YAML mapping, see above
+func FromYAML(r io.Reader) (Config, error) {
+	var cfg Config
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line == "---" {
+			continue
+		}
+		name, val, ok := strings.Cut(line, ":")
+		if !ok {
+			return Config{}, fmt.Errorf("%w: line %d: expected name: value", ErrInvalidConfig, n)
+		}
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		if err := cfg.set(strings.TrimSpace(name), val); err != nil {
+			return Config{}, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return cfg, sc.Err()
+}
+
+// FromEnv reads a config from the environment variables named prefix + the upper-cased yaml names
+func FromEnv(prefix string) (Config, error) {
+	var cfg Config
+	for _, name := range configNames {
+		if val, ok := os.LookupEnv(prefix + strings.ToUpper(name)); ok {
+			if err := cfg.set(name, val); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) set(name, val string) error {
+	var err error
+	switch name {
+	case "default_expiration":
+		cfg.DefaultExpiration, err = time.ParseDuration(val)
+	case "cleanup_interval":
+		cfg.CleanupInterval, err = time.ParseDuration(val)
+	case "max_entries":
+		cfg.MaxEntries, err = strconv.Atoi(val)
+	case "max_key_len":
+		cfg.MaxKeyLen, err = strconv.Atoi(val)
+	case "nil_policy":
+		cfg.NilPolicy = val
+	case "lazy_expiry":
+		cfg.LazyExpiry, err = strconv.ParseBool(val)
+	case "tombstone_grace":
+		cfg.TombstoneGrace, err = time.ParseDuration(val)
+	case "hash_keys_from":
+		cfg.HashKeysFrom, err = strconv.Atoi(val)
+	case "size_histogram":
+		cfg.SizeHistogram, err = strconv.ParseBool(val)
+	case "persistence_path":
+		cfg.PersistencePath = val
+	default:
+		return fmt.Errorf("%w: unknown setting %q", ErrInvalidConfig, name)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, name, err)
+	}
+	return nil
+}
+
+// Options returns the options the config stands for
+func (cfg Config) Options() ([]Option, error) {
+	var opts []Option
+	if cfg.MaxEntries > 0 {
+		opts = append(opts, WithMaxEntries(cfg.MaxEntries))
+	}
+	if cfg.MaxKeyLen > 0 {
+		opts = append(opts, WithMaxKeyLen(cfg.MaxKeyLen))
+	}
+	switch cfg.NilPolicy {
+	case "", "store":
+	case "reject":
+		opts = append(opts, WithNilPolicy(NilReject))
+	default:
+		return nil, fmt.Errorf("%w: nil_policy %q", ErrInvalidConfig, cfg.NilPolicy)
+	}
+	if cfg.LazyExpiry {
+		opts = append(opts, WithLazyExpiry())
+	}
+	if cfg.TombstoneGrace > 0 {
+		opts = append(opts, WithTombstones(cfg.TombstoneGrace))
+	}
+	if cfg.HashKeysFrom > 0 {
+		opts = append(opts, WithHashedKeys(cfg.HashKeysFrom))
+	}
+	if cfg.SizeHistogram {
+		opts = append(opts, WithSizeHistogram())
+	}
+	return opts, nil
+}
+
+// New returns a cache configured by cfg followed by opts, loading cfg.PersistencePath when it exists
+func New(cfg Config, opts ...Option) (*Cache, error) {
+	cfgOpts, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+	c := NewCache(cfg.DefaultExpiration, cfg.CleanupInterval, append(cfgOpts, opts...)...)
+	if cfg.PersistencePath != "" {
+		if err = c.LoadFile(cfg.PersistencePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			StopJanitor(c.cache)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ConfigChange is a setting Reconfigure changed
+type ConfigChange struct {
+	Setting string
+	Old     any
+	New     any
+}
+
+// WithOnReconfigure calls fn with the changes of every Reconfigure that changed something
+func WithOnReconfigure(fn func(changes []ConfigChange)) Option {
+	return func(c *cache) {
+		c.onReconfigure = fn
+	}
+}
+
+// Reconfigure applies the runtime settings of cfg, default_expiration, max_entries and cleanup_interval,
+// keeping the items; the other settings shape the cache when it is built and are ignored here.
+// cleanup_interval is ignored for the caches of a Manager, whose janitor cleans them.
+// It returns the changes, also passed to the WithOnReconfigure callback
+func (c *cache) Reconfigure(cfg Config) []ConfigChange {
+	var changes []ConfigChange
+	d := cfg.DefaultExpiration
+	if d <= 0 {
+		d = NoExpire
+	}
+	c.lock.Lock()
+	if d != c.defaultExpire {
+		changes = append(changes, ConfigChange{Setting: "default_expiration", Old: c.defaultExpire, New: d})
+		c.defaultExpire = d
+	}
+	maxEntries := c.maxEntries
+	c.lock.Unlock()
+
+	if n := cfg.MaxEntries; n >= 0 && n != maxEntries {
+		changes = append(changes, ConfigChange{Setting: "max_entries", Old: maxEntries, New: n})
+		c.SetMaxEntries(n)
+	}
+	// the janitor may be waiting for c.lock, it is replaced under janitorLock only
+	c.janitorLock.Lock()
+	var interval time.Duration
+	if c.janitor != nil {
+		interval = c.janitor.Interval
+	}
+	if !c.managed && cfg.CleanupInterval != interval {
+		changes = append(changes, ConfigChange{Setting: "cleanup_interval", Old: interval, New: cfg.CleanupInterval})
+		c.janitor.halt()
+		c.janitor = nil
+		if cfg.CleanupInterval > 0 {
+			c.janitor = &janitor{Interval: cfg.CleanupInterval, stop: make(chan struct{})}
+			go c.janitor.runJanitor(c)
+		}
+	}
+	c.janitorLock.Unlock()
+	if len(changes) > 0 && c.onReconfigure != nil {
+		c.onReconfigure(changes)
+	}
+	return changes
+}
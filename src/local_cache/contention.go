@@ -0,0 +1,58 @@
+package local_cache
+
+import (
+	"sync/atomic"
+)
+
+/*
+Lock contention sampling: one lock acquisition of the Gets and Sets in every n first tries the lock
+without waiting, Stats counts the tries in LockSamples and the failed ones in LockContended. A high
+LockContended/LockSamples ratio means the goroutines queue on the lock of the cache, split the data
+over several caches, e.g. by key prefix.
+*/
+
+// WithContentionSampling samples one lock acquisition of the Gets and Sets in every, see Stats.LockContended
+func WithContentionSampling(every int) Option {
+	return func(c *cache) {
+		if every > 0 {
+			c.contention = &contention{every: uint64(every)}
+		}
+	}
+}
+
+type contention struct {
+	every     uint64
+	n         atomic.Uint64
+	samples   atomic.Uint64
+	contended atomic.Uint64
+}
+
+func (s *contention) sample() bool {
+	if s.n.Add(1)%s.every != 0 {
+		return false
+	}
+	s.samples.Add(1)
+	return true
+}
+
+// writeLock is c.lock.Lock, sampled by WithContentionSampling
+func (c *cache) writeLock() {
+	if s := c.contention; s != nil && s.sample() {
+		if c.lock.TryLock() {
+			return
+		}
+		s.contended.Add(1)
+	}
+	c.lock.Lock()
+}
+
+// readLock is c.lock.RLock, sampled by WithContentionSampling
+func (c *cache) readLock() {
+	if s := c.contention; s != nil && s.sample() {
+		if c.lock.TryRLock() {
+			return
+		}
+		s.contended.Add(1)
+	}
+	c.lock.RLock()
+}
@@ -0,0 +1,246 @@
+package local_cache
+
+import (
+	"cache/src/logging"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Cron jobs of a Manager: flush a namespace or reload a set of items at fixed times, e.g. refresh the
+product catalog at 03:00. Jobs name their cache rather than hold it, so they apply to the cache the
+manager returns for that name at each run, and they stop with CloseAll or their cancel function.
+
+Schedules are standard 5 field cron expressions, minute hour day-of-month month day-of-week, with
+*, lists, ranges and steps, the descriptors @yearly, @monthly, @weekly, @daily, @midnight and @hourly,
+and @every <duration> for fixed intervals. Times are in the local time zone.
+*/
+
+var ErrInvalidCron = errors.New("local_cache: invalid cron expression")
+
+// CronSchedule is a parsed cron expression
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when the field starts with *, including steps such as */2
+	domStar, dowStar bool
+	// every is the interval of @every, the fields are unused then
+	every time.Duration
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a 5 field cron expression, a descriptor such as @daily or @every <duration>
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCron, expr)
+		}
+		return &CronSchedule{every: every}, nil
+	}
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q needs 5 fields", ErrInvalidCron, expr)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidCron, expr, err)
+		}
+		sets[i] = set
+	}
+	// 7 is sunday too
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &CronSchedule{minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: strings.HasPrefix(fields[2], "*"), dowStar: strings.HasPrefix(fields[4], "*")}, nil
+}
+
+func parseCronField(f string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			rng, step = r, n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+			} else if step > 1 {
+				// a/n runs from a to the end
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first time of the schedule after t, the zero time when there is none within five
+// years, e.g. for February 30
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// Truncate works on absolute time, it would land on the half hour in zones like Asia/Kolkata
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule: when both the day of month and the day of week are restricted,
+// either matches; like Vixie cron a field starting with * is not restricted, so */2 only narrows the
+// other one down
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Job is run by Schedule with the cache of its name
+type Job func(ctx context.Context, c *Cache) error
+
+// OnJobError sets the function the errors of the jobs are passed to, they are dropped by default
+func (m *Manager) OnJobError(fn func(name string, err error)) {
+	m.lock.Lock()
+	m.onJobError = fn
+	m.lock.Unlock()
+}
+
+// SetLogger sets the Logger the errors of the jobs are logged to, besides OnJobError
+func (m *Manager) SetLogger(l logging.Logger) {
+	m.lock.Lock()
+	m.logger = l
+	m.lock.Unlock()
+}
+
+// Schedule runs job with the cache named name at the times of spec, until cancel or CloseAll is
+// called; a run still going when the next one is due delays it
+func (m *Manager) Schedule(spec, name string, job Job) (cancel func(), err error) {
+	s, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	go func() {
+		for {
+			next := s.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-m.stop:
+				timer.Stop()
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err := job(ctx, m.Get(name)); err != nil {
+				m.lock.Lock()
+				onError, logger := m.onJobError, m.logger
+				m.lock.Unlock()
+				if logger != nil {
+					logger.Log(logging.LevelError, "local_cache: job failed", "cache", name, "spec", spec, "error", err)
+				}
+				if onError != nil {
+					onError(name, err)
+				}
+			}
+		}
+	}()
+	return stop, nil
+}
+
+// ScheduleFlush deletes the items under prefix of the cache named name at the times of spec, all
+// of its items when prefix is empty
+func (m *Manager) ScheduleFlush(spec, name, prefix string) (cancel func(), err error) {
+	return m.Schedule(spec, name, func(ctx context.Context, c *Cache) error {
+		if prefix == "" {
+			c.Flush()
+		} else {
+			c.DeletePrefix(prefix)
+		}
+		return nil
+	})
+}
+
+// ScheduleRefresh sets the items returned by load in the cache named name for ttl at the times of
+// spec; the items are kept as they are when load fails
+func (m *Manager) ScheduleRefresh(spec, name string, ttl time.Duration, load func(ctx context.Context) (map[string]any, error)) (cancel func(), err error) {
+	return m.Schedule(spec, name, func(ctx context.Context, c *Cache) error {
+		items, err := load(ctx)
+		if err != nil {
+			return err
+		}
+		var errs []string
+		for k, v := range items {
+			if err := c.Set(k, v, ttl); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("local_cache: refresh: %d items failed: %s", len(errs), strings.Join(errs, "; "))
+		}
+		return nil
+	})
+}
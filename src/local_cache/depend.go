@@ -0,0 +1,94 @@
+package local_cache
+
+import "sync"
+
+/*
+Dependencies between keys, for caches of derived values: DependOn(child, parents...) makes every Set or
+deletion of a parent delete the child, and in turn the children of the child. The edges of a child go
+away with it, declare them again when the child is set again. The invalidated children run the
+OnEvicted callback and are reported to the subscribers like any deletion; Flush drops every edge.
+*/
+
+type deps struct {
+	// parents maps a parent to its children, children a child to its parents; guarded by c.lock
+	parents  map[string]map[string]struct{}
+	children map[string]map[string]struct{}
+	// pending holds the invalidated children waiting for the OnEvicted callback
+	mu      sync.Mutex
+	pending []Object
+}
+
+// DependOn invalidates child whenever one of parents is set or deleted; the parents don't need to
+// be in the cache yet
+func (c *cache) DependOn(child string, parents ...string) {
+	child, _ = c.key(child)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.deps == nil {
+		c.deps = &deps{parents: map[string]map[string]struct{}{}, children: map[string]map[string]struct{}{}}
+	}
+	for _, p := range parents {
+		p, _ = c.key(p)
+		if p == child {
+			continue
+		}
+		if c.deps.parents[p] == nil {
+			c.deps.parents[p] = map[string]struct{}{}
+		}
+		c.deps.parents[p][child] = struct{}{}
+		if c.deps.children[child] == nil {
+			c.deps.children[child] = map[string]struct{}{}
+		}
+		c.deps.children[child][p] = struct{}{}
+	}
+}
+
+// changed invalidates the children of k after k was set or deleted, the caller holds c.lock
+func (c *cache) changed(k string, deleted bool) {
+	if c.deps == nil {
+		return
+	}
+	if deleted {
+		c.unlink(k)
+	}
+	children := c.deps.parents[k]
+	delete(c.deps.parents, k)
+	for child := range children {
+		// unlinked first, so that a cycle ends
+		c.unlink(child)
+		if _, ok := c.items[child]; !ok {
+			continue
+		}
+		// deleting the child invalidates its own children through emit
+		if v, ok := c.delete(child); ok {
+			c.deps.mu.Lock()
+			c.deps.pending = append(c.deps.pending, Object{key: child, val: v})
+			c.deps.mu.Unlock()
+		}
+	}
+}
+
+// unlink drops the edges from the parents of child, the caller holds c.lock
+func (c *cache) unlink(child string) {
+	for p := range c.deps.children[child] {
+		delete(c.deps.parents[p], child)
+		if len(c.deps.parents[p]) == 0 {
+			delete(c.deps.parents, p)
+		}
+	}
+	delete(c.deps.children, child)
+}
+
+// callInvalidated runs the OnEvicted callback of the invalidated children, without holding c.lock
+func (c *cache) callInvalidated() {
+	if c.deps == nil {
+		return
+	}
+	c.deps.mu.Lock()
+	pending := c.deps.pending
+	c.deps.pending = nil
+	c.deps.mu.Unlock()
+	for _, o := range pending {
+		c.evictedCallback(o.key, o.val)
+	}
+}
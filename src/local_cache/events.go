@@ -0,0 +1,126 @@
+package local_cache
+
+/*
+The event stream reports every change of the items, for replication or change data capture: OpSet
+with the stored item for Set, SetIfExpiringWithin, Replace, Rename and Restore, OpDelete for Delete,
+DeletePrefix, evictions, expirations and the children invalidated by DependOn, and OpFlush for Flush.
+Keys are the stored keys, after WithKeyTransform and WithHashedKeys. Load doesn't emit events.
+
+Subscribers run under the cache lock, in the order the changes are applied, so they must not call
+the cache and should only queue the event.
+*/
+
+const OpFlush AccessOp = "flush"
+
+// Event is a change of the cache, Item is only set for OpSet; Stamp is the stamp of the change, also
+// Item.Stamp for OpSet, see WithLWW
+type Event struct {
+	Op    AccessOp
+	Key   string
+	Item  Item
+	Stamp Stamp
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+// Subscribe calls fn with every change of the cache until the returned function is called
+func (c *cache) Subscribe(fn func(Event)) (unsubscribe func()) {
+	s := &subscriber{fn: fn}
+	c.lock.Lock()
+	c.subscribers = append(c.subscribers, s)
+	c.lock.Unlock()
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		for i, cur := range c.subscribers {
+			if cur == s {
+				c.subscribers = append(c.subscribers[:i:i], c.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// emit passes e to the subscribers, the caller holds c.lock
+func (c *cache) emit(e Event) {
+	if e.Op == OpSet {
+		e.Stamp = e.Item.Stamp
+	} else if c.lww != nil {
+		e.Stamp = c.stamp(e.Stamp)
+		c.recordLWW(e)
+	}
+	for _, s := range c.subscribers {
+		s.fn(e)
+	}
+	if e.Op != OpFlush {
+		c.changed(e.Key, e.Op == OpDelete)
+	}
+}
+
+// Apply applies an event of another cache, as is: the key is not transformed, the item keeps its
+// expiration and the quotas and max entries apply; it emits the event in turn. In WithLWW mode only
+// the events stamped after the last known change of their key are applied
+func (c *cache) Apply(e Event) error {
+	var evicted []Object
+	var err error
+	c.lock.Lock()
+	if c.lww != nil {
+		evicted, err = c.applyLWW(e)
+		c.lock.Unlock()
+		c.callEvicted(evicted)
+		return err
+	}
+	switch e.Op {
+	case OpSet:
+		evicted, err = c.put(e.Key, e.Item)
+	case OpDelete:
+		if v, ok := c.delete(e.Key); ok {
+			evicted = append(evicted, Object{key: e.Key, val: v})
+		}
+	case OpFlush:
+		c.lock.Unlock()
+		c.Flush()
+		return nil
+	}
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	return err
+}
+
+// Snapshot returns the events that rebuild the state of c on an empty cache: the sets of the unexpired
+// items and, in WithLWW mode, the last flush and the deletes remembered since, so that they win over
+// older sets the other cache may get later
+func (c *cache) Snapshot() []Event {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var res []Event
+	if c.lww != nil {
+		if !c.lww.flushed.IsZero() {
+			res = append(res, Event{Op: OpFlush, Stamp: c.lww.flushed})
+		}
+		for k, s := range c.lww.deleted {
+			res = append(res, Event{Op: OpDelete, Key: k, Stamp: s})
+		}
+	}
+	for k, v := range c.items {
+		if !v.Expired() {
+			res = append(res, Event{Op: OpSet, Key: k, Item: v, Stamp: v.Stamp})
+		}
+	}
+	return res
+}
+
+// Items returns a copy of the unexpired items, keyed by stored key
+func (c *cache) Items() map[string]Item {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := make(map[string]Item, len(c.items))
+	for k, v := range c.items {
+		if !v.Expired() {
+			res[k] = v
+		}
+	}
+	return res
+}
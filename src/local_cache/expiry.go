@@ -0,0 +1,129 @@
+package local_cache
+
+import (
+	"sort"
+	"time"
+)
+
+// NextExpiry returns the unexpired item that expires first; it scans all items, so call it
+// from schedulers rather than on hot paths
+func (c *cache) NextExpiry() (string, time.Time, bool) {
+	now := time.Now().Unix()
+	var (
+		key   string
+		first int64
+	)
+	c.lock.RLock()
+	for k, v := range c.items {
+		if v.ExpireTime == 0 || now > v.ExpireTime {
+			continue
+		}
+		if first == 0 || v.ExpireTime < first || (v.ExpireTime == first && k < key) {
+			key, first = k, v.ExpireTime
+		}
+	}
+	c.lock.RUnlock()
+	if first == 0 {
+		return "", time.Time{}, false
+	}
+	return key, time.Unix(first, 0), true
+}
+
+// ExpiringWithin returns the unexpired keys expiring in the next d, the earliest first;
+// expiration times have a resolution of one second
+func (c *cache) ExpiringWithin(d time.Duration) []string {
+	now := time.Now()
+	from, to := now.Unix(), now.Add(d).Unix()
+	type expiring struct {
+		key string
+		at  int64
+	}
+	var list []expiring
+	c.lock.RLock()
+	for k, v := range c.items {
+		if v.ExpireTime == 0 || v.ExpireTime < from || v.ExpireTime > to {
+			continue
+		}
+		list = append(list, expiring{key: k, at: v.ExpireTime})
+	}
+	c.lock.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].at != list[j].at {
+			return list[i].at < list[j].at
+		}
+		return list[i].key < list[j].key
+	})
+	res := make([]string, len(list))
+	for i, e := range list {
+		res[i] = e.key
+	}
+	return res
+}
+
+// Entry is an expired item delivered by WithExpiredBatch, Key is the stored key
+type Entry struct {
+	Key        string
+	Value      any
+	ExpireTime time.Time
+}
+
+// WithExpiredBatch delivers the expired items to fn in batches of at most size entries instead of
+// calling the OnEvicted callback for each of them, so a pass expiring thousands of items makes a few
+// calls; deletions and evictions still go to OnEvicted, and WithLazyExpiry delivers batches of one
+func WithExpiredBatch(size int, fn func(entries []Entry)) Option {
+	return func(c *cache) {
+		if size > 0 && fn != nil {
+			c.expiredBatch, c.onExpired = size, fn
+		}
+	}
+}
+
+// callExpired delivers entries to the WithExpiredBatch callback, without holding c.lock
+func (c *cache) callExpired(entries []Entry) {
+	for len(entries) > 0 {
+		n := c.expiredBatch
+		if n > len(entries) {
+			n = len(entries)
+		}
+		start := time.Now()
+		c.onExpired(entries[:n:n])
+		if c.slowLog != nil {
+			c.slow("expired_callback", "", start)
+		}
+		entries = entries[n:]
+	}
+}
+
+// WithLazyExpiry makes Get and GetWithExpire delete the expired item they find, running the eviction
+// callback like DeleteExpired does, instead of leaving it to the janitor
+func WithLazyExpiry() Option {
+	return func(c *cache) {
+		c.lazyExpire = true
+	}
+}
+
+// reap deletes k if it is still expired, it may have been set again since the caller looked at it
+func (c *cache) reap(k string) {
+	if !c.lazyExpire {
+		return
+	}
+	c.lock.Lock()
+	item, ok := c.items[k]
+	if !ok || !item.Expired() {
+		c.lock.Unlock()
+		return
+	}
+	if c.onExpired != nil {
+		c.delete(k)
+		c.lock.Unlock()
+		c.callExpired([]Entry{{Key: k, Value: item.Obj, ExpireTime: time.Unix(item.ExpireTime, 0)}})
+		c.callInvalidated()
+		return
+	}
+	v, hasCallBack := c.delete(k)
+	c.lock.Unlock()
+	if hasCallBack {
+		c.evictedCallback(k, v)
+	}
+	c.callInvalidated()
+}
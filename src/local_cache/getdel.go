@@ -0,0 +1,60 @@
+package local_cache
+
+import (
+	"time"
+)
+
+/*
+GetAndDelete and GetAndExpire read and change an item in one step under the lock, like GETDEL and
+GETEX of redis: of several goroutines popping the same key only one gets the value, e.g. a one-time
+token or a job to claim.
+*/
+
+// GetAndDelete returns the value of k and deletes it atomically, only one of concurrent callers gets it
+func (c *cache) GetAndDelete(k string) (any, bool) {
+	k, digest := c.key(k)
+	c.lock.Lock()
+	item, ok := c.items[k]
+	if !ok || item.KeyDigest != digest || item.Expired() {
+		c.lock.Unlock()
+		c.hit(k, false)
+		return nil, false
+	}
+	if c.tombstones != nil {
+		c.bury(k)
+		c.lock.Unlock()
+		c.hit(k, true)
+		c.recordAccess(k, OpDelete, false)
+		return item.Obj, true
+	}
+	v, hasCallBack := c.delete(k)
+	c.lock.Unlock()
+	c.hit(k, true)
+	c.recordAccess(k, OpDelete, false)
+	if hasCallBack {
+		c.evictedCallback(k, v)
+	}
+	c.callInvalidated()
+	return item.Obj, true
+}
+
+// GetAndExpire returns the value of k and sets its expiration to d atomically, NoExpire makes it
+// persistent and DefaultExpire gives it the default expiration
+func (c *cache) GetAndExpire(k string, d time.Duration) (any, bool) {
+	k, digest := c.key(k)
+	c.lock.Lock()
+	item, ok := c.items[k]
+	if !ok || item.KeyDigest != digest || item.Expired() {
+		c.lock.Unlock()
+		c.hit(k, false)
+		return nil, false
+	}
+	item.ExpireTime = c.expireTime(d)
+	item.Stamp = c.stamp(Stamp{})
+	c.items[k] = item
+	c.emit(Event{Op: OpSet, Key: k, Item: item})
+	c.lock.Unlock()
+	c.hit(k, true)
+	c.callInvalidated()
+	return item.Obj, true
+}
@@ -0,0 +1,49 @@
+package local_cache
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+)
+
+/*
+In hashed key mode, keys of at least minLen bytes, e.g. URLs or serialized queries, are stored under
+a 64-bit FNV-1a hash of the key instead of the key itself. The item keeps a 128-bit FNV-1a digest of
+the original key, so a hash collision is detected and reported as a miss rather than returning the
+value of another key; a Set on a colliding key replaces the other item.
+
+The cache only knows the hashed form of such keys: NextExpiry, ExpiringWithin, DeletePrefix, namespace
+quotas and the eviction callback see "#" followed by the hash in hex.
+
+FNV comes from hash/fnv, which also has the 128-bit variant the digest needs. xxhash is faster on long
+keys but only reaches the module indirectly through go-redis and has no 128-bit sum; making it a
+direct dependency isn't worth it, as a key is hashed once per call and collisions are caught by the
+digest anyway.
+*/
+
+// WithHashedKeys stores keys of minLen bytes or more by hash, see above
+func WithHashedKeys(minLen int) Option {
+	return func(c *cache) {
+		if minLen > 0 {
+			c.hashKeysFrom = minLen
+		}
+	}
+}
+
+// key returns the key k is stored under and, for hashed keys, the digest of k
+func (c *cache) key(k string) (string, string) {
+	if c.keyTransform != nil {
+		k = c.keyTransform(k)
+	}
+	if c.hashKeysFrom == 0 || len(k) < c.hashKeysFrom {
+		return k, ""
+	}
+	h := fnv.New128a()
+	h.Write([]byte(k))
+	digest := string(h.Sum(nil))
+	var b [8]byte
+	sum := hashKey(k)
+	for i := range b {
+		b[i] = byte(sum >> (56 - 8*i))
+	}
+	return "#" + hex.EncodeToString(b[:]), digest
+}
@@ -0,0 +1,134 @@
+package local_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLoaderPanicked is returned to the GetMultiOrLoad calls waiting on keys whose loader panicked
+var ErrLoaderPanicked = errors.New("local_cache: loader panicked")
+
+// pendingLoad is a key being loaded by a GetMultiOrLoad call, other calls wait on done
+type pendingLoad struct {
+	done chan struct{}
+	val  any
+	ok   bool
+	err  error
+}
+
+type loadGroup struct {
+	mu    sync.Mutex
+	loads map[string]*pendingLoad
+}
+
+// GetMultiOrLoad returns the cached items of keys and loads the missing ones with a single loader call,
+// storing them with the default expiration. Keys another call is already loading are waited for instead
+// of loaded again. Keys the loader doesn't return are left out of the result.
+func (c *cache) GetMultiOrLoad(ctx context.Context, keys []string,
+	loader func(ctx context.Context, missing []string) (map[string]any, error)) (map[string]any, error) {
+	res := make(map[string]any, len(keys))
+	var missing []string
+	for _, k := range keys {
+		if v, ok := c.Get(k); ok {
+			res[k] = v
+		} else {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+
+	var (
+		owned   []string
+		waiting = map[string]*pendingLoad{}
+		mine    = map[string]*pendingLoad{}
+	)
+	c.loadGroup.mu.Lock()
+	if c.loadGroup.loads == nil {
+		c.loadGroup.loads = make(map[string]*pendingLoad)
+	}
+	for _, k := range missing {
+		if _, dup := mine[k]; dup {
+			continue
+		}
+		if p, ok := c.loadGroup.loads[k]; ok {
+			waiting[k] = p
+			continue
+		}
+		p := &pendingLoad{done: make(chan struct{})}
+		c.loadGroup.loads[k] = p
+		mine[k] = p
+		owned = append(owned, k)
+	}
+	c.loadGroup.mu.Unlock()
+
+	if len(owned) > 0 {
+		finished := false
+		defer func() {
+			// the loader panicked: wake up the waiting calls, the panic goes on to our caller
+			if !finished {
+				for _, k := range owned {
+					mine[k].err = ErrLoaderPanicked
+				}
+				c.loadGroup.finish(owned, mine)
+			}
+		}()
+		start := time.Now()
+		loaded, err := loader(ctx, owned)
+		if c.slowLog != nil {
+			c.slow("loader", "", start)
+		}
+		for _, k := range owned {
+			p := mine[k]
+			p.err = err
+			if err == nil {
+				if v, ok := loaded[k]; ok {
+					p.val, p.ok = v, true
+					if sErr := c.Set(k, v, DefaultExpire); sErr != nil {
+						p.err = sErr
+					}
+				}
+			}
+		}
+		c.loadGroup.finish(owned, mine)
+		finished = true
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range owned {
+			if p := mine[k]; p.err != nil {
+				return nil, p.err
+			} else if p.ok {
+				res[k] = p.val
+			}
+		}
+	}
+
+	for k, p := range waiting {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		if p.ok {
+			res[k] = p.val
+		}
+	}
+	return res, nil
+}
+
+// finish removes the keys a call loaded and releases the calls waiting for them
+func (g *loadGroup) finish(owned []string, mine map[string]*pendingLoad) {
+	g.mu.Lock()
+	for _, k := range owned {
+		delete(g.loads, k)
+		close(mine[k].done)
+	}
+	g.mu.Unlock()
+}
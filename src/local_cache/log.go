@@ -0,0 +1,23 @@
+package local_cache
+
+import (
+	"cache/src/logging"
+)
+
+/*
+The warnings of the cache go to the Logger set with WithLogger: janitor runs overrunning their
+interval, the Sets shed under load, and for a Manager the failures of its cron jobs, e.g. refreshes.
+*/
+
+// WithLogger sets the Logger of the warnings of the cache, and of WithSlowLog when it has none
+func WithLogger(l logging.Logger) Option {
+	return func(c *cache) {
+		c.logger = l
+	}
+}
+
+func (c *cache) log(level logging.Level, msg string, keyvals ...any) {
+	if c.logger != nil {
+		c.logger.Log(level, msg, keyvals...)
+	}
+}
@@ -0,0 +1,156 @@
+package local_cache
+
+import "time"
+
+/*
+WithLWW makes concurrent writers converge when caches replicate to each other: every change gets a
+Stamp, the wall clock in nanoseconds moved past every stamp seen so far plus the node ID for ties, and
+Apply only applies a change stamped after the last known change of its key. A change applied emits
+its stamp unchanged, so a change that comes back to its origin is ignored instead of bouncing between
+the caches.
+
+Deletes are remembered for the retention period, so that a Set stamped before a Delete but received
+after it doesn't bring the item back; the retention must cover the replication delay. A Flush applies
+to the items stamped before it. Changes without a stamp come before every other, all the caches of a
+group need WithLWW with distinct node IDs.
+*/
+
+// Stamp orders the changes of caches in WithLWW mode, the zero Stamp comes before every other
+type Stamp struct {
+	Time int64
+	Node string
+}
+
+// After reports whether s comes after o, the last-write-wins merge keeps the change with the later stamp
+func (s Stamp) After(o Stamp) bool {
+	if s.Time != o.Time {
+		return s.Time > o.Time
+	}
+	return s.Node > o.Node
+}
+
+func (s Stamp) IsZero() bool {
+	return s == Stamp{}
+}
+
+type lww struct {
+	node      string
+	retention time.Duration
+	// last is the latest stamp time seen, local stamps move past it
+	last    int64
+	deleted map[string]Stamp
+	flushed Stamp
+	// applying is the stamp of the change Apply is applying, the caller holds c.lock
+	applying Stamp
+}
+
+// WithLWW turns on last-write-wins stamps with the given node ID, deletes are remembered for retention
+func WithLWW(node string, retention time.Duration) Option {
+	return func(c *cache) {
+		c.lww = &lww{node: node, retention: retention, deleted: make(map[string]Stamp)}
+	}
+}
+
+// stamp returns the stamp of a change: s when it is set, the stamp being applied, or a new local
+// stamp; the zero Stamp without WithLWW. The caller holds c.lock
+func (c *cache) stamp(s Stamp) Stamp {
+	l := c.lww
+	if l == nil {
+		return Stamp{}
+	}
+	if s.IsZero() {
+		s = l.applying
+	}
+	if s.IsZero() {
+		now := time.Now().UnixNano()
+		if now <= l.last {
+			now = l.last + 1
+		}
+		s = Stamp{Time: now, Node: l.node}
+	}
+	if s.Time > l.last {
+		l.last = s.Time
+	}
+	return s
+}
+
+// current returns the stamp of the last known change of k, the caller holds c.lock
+func (c *cache) current(k string) Stamp {
+	if item, ok := c.items[k]; ok {
+		return item.Stamp
+	}
+	s := c.lww.deleted[k]
+	if c.lww.flushed.After(s) {
+		s = c.lww.flushed
+	}
+	return s
+}
+
+// applyLWW applies e in WithLWW mode when it is newer than what c knows, the caller holds c.lock
+func (c *cache) applyLWW(e Event) ([]Object, error) {
+	switch e.Op {
+	case OpSet:
+		if !e.Item.Stamp.After(c.current(e.Key)) {
+			return nil, nil
+		}
+		return c.put(e.Key, e.Item)
+	case OpDelete:
+		if !e.Stamp.After(c.current(e.Key)) {
+			return nil, nil
+		}
+		c.lww.applying = e.Stamp
+		defer func() { c.lww.applying = Stamp{} }()
+		if _, ok := c.items[e.Key]; !ok {
+			// remembered and passed on all the same
+			c.emit(e)
+			return nil, nil
+		}
+		if v, ok := c.delete(e.Key); ok {
+			return []Object{{key: e.Key, val: v}}, nil
+		}
+	case OpFlush:
+		if !e.Stamp.After(c.lww.flushed) {
+			return nil, nil
+		}
+		for k, item := range c.items {
+			if _, pinned := c.pinned[k]; pinned && !c.flushPinned {
+				continue
+			}
+			if !item.Stamp.After(e.Stamp) {
+				delete(c.items, k)
+				c.untrack(k)
+				delete(c.pinned, k)
+			}
+		}
+		c.emit(e)
+	}
+	return nil, nil
+}
+
+// recordLWW remembers a delete or a flush, the caller holds c.lock
+func (c *cache) recordLWW(e Event) {
+	switch e.Op {
+	case OpDelete:
+		c.lww.deleted[e.Key] = e.Stamp
+	case OpFlush:
+		c.lww.flushed = e.Stamp
+		for k, s := range c.lww.deleted {
+			if !s.After(e.Stamp) {
+				delete(c.lww.deleted, k)
+			}
+		}
+	}
+}
+
+// pruneLWW forgets the deletes older than the retention, the caller holds c.lock
+func (c *cache) pruneLWW(now time.Time) {
+	if c.lww == nil {
+		return
+	}
+	before := now.Add(-c.lww.retention).UnixNano()
+	for k, s := range c.lww.deleted {
+		if s.Time < before {
+			delete(c.lww.deleted, k)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package local_cache
+
+import (
+	"cache/src/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manager owns named caches sharing one janitor goroutine, so an application with many caches
+// runs a single ticker instead of one per cache
+type Manager struct {
+	defaultExpire time.Duration
+	opts          []Option
+	lock          sync.Mutex
+	caches        map[string]*Cache
+	stop          chan struct{}
+	stopOnce      sync.Once
+	onJobError    func(name string, err error)
+	logger        logging.Logger
+}
+
+// NewManager returns a manager whose janitor cleans every cache each cleanupInterval; caches are created
+// with defaultExpiration and opts
+func NewManager(defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Manager {
+	m := &Manager{
+		defaultExpire: defaultExpiration,
+		opts:          opts,
+		caches:        make(map[string]*Cache),
+		stop:          make(chan struct{}),
+	}
+	if cleanupInterval > 0 {
+		go m.runJanitor(cleanupInterval)
+	}
+	return m
+}
+
+// Get returns the cache named name, creating it on first use with the manager options followed by opts
+func (m *Manager) Get(name string, opts ...Option) *Cache {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if c, ok := m.caches[name]; ok {
+		return c
+	}
+	c := NewCache(m.defaultExpire, 0, append(append([]Option(nil), m.opts...), opts...)...)
+	c.managed = true
+	m.caches[name] = c
+	return c
+}
+
+// Names returns the names of the caches, sorted
+func (m *Manager) Names() []string {
+	m.lock.Lock()
+	res := make([]string, 0, len(m.caches))
+	for name := range m.caches {
+		res = append(res, name)
+	}
+	m.lock.Unlock()
+	sort.Strings(res)
+	return res
+}
+
+// Stats returns the stats of every cache by name
+func (m *Manager) Stats() map[string]Stats {
+	caches := m.snapshot()
+	res := make(map[string]Stats, len(caches))
+	for name, c := range caches {
+		res[name] = c.Stats()
+	}
+	return res
+}
+
+// CloseAll stops the janitor and the jobs and forgets the caches; caches already returned by Get
+// keep working without cleanup
+func (m *Manager) CloseAll() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	m.lock.Lock()
+	m.caches = make(map[string]*Cache)
+	m.lock.Unlock()
+}
+
+func (m *Manager) snapshot() map[string]*Cache {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := make(map[string]*Cache, len(m.caches))
+	for name, c := range m.caches {
+		res[name] = c
+	}
+	return res
+}
+
+func (m *Manager) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, c := range m.snapshot() {
+				c.DeleteExpired()
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
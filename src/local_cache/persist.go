@@ -0,0 +1,158 @@
+package local_cache
+
+import (
+	"cache/src/encryption"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+Snapshots are the gob encoded item map, custom value types must be registered with gob.Register
+before Save and Load. WithEncryption seals the file with AES-GCM, see the encryption package.
+*/
+
+type PersistOption func(o *persistOptions)
+
+type persistOptions struct {
+	key encryption.KeyProvider
+}
+
+// WithEncryption encrypts SaveFile output and decrypts LoadFile input with the provider's key
+func WithEncryption(key encryption.KeyProvider) PersistOption {
+	return func(o *persistOptions) {
+		o.key = key
+	}
+}
+
+// Save writes the unexpired items to w
+func (c *cache) Save(w io.Writer) error {
+	now := time.Now().Unix()
+	c.lock.RLock()
+	items := make(map[string]Item, len(c.items))
+	for k, v := range c.items {
+		if v.ExpireTime > 0 && now > v.ExpireTime {
+			continue
+		}
+		items[k] = v
+	}
+	c.lock.RUnlock()
+	return gob.NewEncoder(w).Encode(items)
+}
+
+// Load adds the unexpired items read from r, items already in the cache are kept
+func (c *cache) Load(r io.Reader) error {
+	items := map[string]Item{}
+	if err := gob.NewDecoder(r).Decode(&items); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k, v := range items {
+		if v.ExpireTime > 0 && now > v.ExpireTime {
+			continue
+		}
+		if !c.exist(k) {
+			c.items[k] = v
+			c.track(k, v)
+		}
+	}
+	return nil
+}
+
+// SaveFile writes a snapshot to a temporary file and renames it to path, so a crash never leaves a partial snapshot
+func (c *cache) SaveFile(path string, opts ...PersistOption) (err error) {
+	o := newPersistOptions(opts)
+	f, err := os.CreateTemp(filepath.Dir(path), ".cache-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	var w io.WriteCloser = nopWriteCloser{f}
+	if o.key != nil {
+		key, err := o.key.Key()
+		if err != nil {
+			return err
+		}
+		if w, err = encryption.NewWriter(f, key); err != nil {
+			return err
+		}
+	}
+	if err = c.Save(w); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (c *cache) LoadFile(path string, opts ...PersistOption) error {
+	o := newPersistOptions(opts)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if o.key != nil {
+		key, err := o.key.Key()
+		if err != nil {
+			return err
+		}
+		if r, err = encryption.NewReader(f, key); err != nil {
+			return err
+		}
+	}
+	return c.Load(r)
+}
+
+// ShutdownHandler returns a function that stops the janitor, so expired item callbacks already running
+// complete and no new ones start, then saves a snapshot to path; later calls return the first result.
+// The cache is still usable afterwards but no longer cleans up expired items.
+//
+//	defer c.ShutdownHandler("/var/lib/app/cache.snapshot")()
+func (c *cache) ShutdownHandler(path string, opts ...PersistOption) func() error {
+	var (
+		once sync.Once
+		err  error
+	)
+	return func() error {
+		once.Do(func() {
+			StopJanitor(c)
+			err = c.SaveFile(path, opts...)
+		})
+		return err
+	}
+}
+
+func newPersistOptions(opts []PersistOption) *persistOptions {
+	o := &persistOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,44 @@
+package local_cache
+
+/*
+A pinned item is never chosen by capacity or quota eviction, and Flush keeps it unless WithFlushPinned
+is set; Delete and expiration still remove it, and the pin goes away with the item. When every item is
+pinned the cache may grow past WithMaxEntries.
+*/
+
+// WithFlushPinned makes Flush remove pinned items too
+func WithFlushPinned() Option {
+	return func(c *cache) {
+		c.flushPinned = true
+	}
+}
+
+// Pin exempts k from eviction, it returns false when k is not in the cache
+func (c *cache) Pin(k string) bool {
+	k, _ = c.key(k)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.items[k]; !ok {
+		return false
+	}
+	if c.pinned == nil {
+		c.pinned = make(map[string]struct{})
+	}
+	c.pinned[k] = struct{}{}
+	return true
+}
+
+func (c *cache) Unpin(k string) {
+	k, _ = c.key(k)
+	c.lock.Lock()
+	delete(c.pinned, k)
+	c.lock.Unlock()
+}
+
+func (c *cache) Pinned(k string) bool {
+	k, _ = c.key(k)
+	c.lock.RLock()
+	_, ok := c.pinned[k]
+	c.lock.RUnlock()
+	return ok
+}
@@ -0,0 +1,84 @@
+package local_cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+WatchMemory is a relief valve for memory pressure: when the heap goes above a threshold, or the caller
+signals pressure, a fraction of the items is evicted at once. Victims are picked like capacity eviction
+picks them, expired and soon expiring items first; the cache keeps no access recency to go by.
+*/
+
+// PressureConfig configures WatchMemory, Threshold or Signal is required
+type PressureConfig struct {
+	// Threshold in bytes, items are evicted while MemoryUsage reports more; 0 only reacts to Signal
+	Threshold uint64
+	// MemoryUsage reports the memory in use, the Go heap by default
+	MemoryUsage func() uint64
+	// Signal triggers an eviction regardless of the threshold, e.g. from a cgroup memory event
+	Signal <-chan struct{}
+	// Fraction of the items evicted per relief, 0.25 by default
+	Fraction float64
+	Interval time.Duration
+	// OnRelief is called after every relief with the number of evicted items and the memory reported
+	// before it, 0 when the relief was signaled
+	OnRelief func(evicted int, mem uint64)
+}
+
+var ErrInvalidPressureConfig = errors.New("local_cache: invalid pressure config")
+
+// EvictFraction evicts the given fraction of the items and returns how many were evicted
+func (c *cache) EvictFraction(fraction float64) int {
+	c.lock.Lock()
+	n := int(float64(len(c.items)) * fraction)
+	if n == 0 && fraction > 0 && len(c.items) > 0 {
+		n = 1
+	}
+	before := len(c.items)
+	evicted := c.evict(n)
+	n = before - len(c.items)
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	return n
+}
+
+// WatchMemory checks the memory every cfg.Interval and evicts cfg.Fraction of the items when it is
+// above cfg.Threshold or cfg.Signal fires, until ctx is done
+func (c *cache) WatchMemory(ctx context.Context, cfg PressureConfig) error {
+	if (cfg.Threshold == 0 && cfg.Signal == nil) || cfg.Interval <= 0 || cfg.Fraction < 0 || cfg.Fraction > 1 {
+		return ErrInvalidPressureConfig
+	}
+	if cfg.Fraction == 0 {
+		cfg.Fraction = 0.25
+	}
+	if cfg.MemoryUsage == nil {
+		cfg.MemoryUsage = heapAlloc
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			var mem uint64
+			select {
+			case <-ticker.C:
+				if cfg.Threshold == 0 {
+					continue
+				}
+				if mem = cfg.MemoryUsage(); mem <= cfg.Threshold {
+					continue
+				}
+			case <-cfg.Signal:
+			case <-ctx.Done():
+				return
+			}
+			n := c.EvictFraction(cfg.Fraction)
+			if cfg.OnRelief != nil {
+				cfg.OnRelief(n, mem)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,239 @@
+package local_cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+A namespace is a key prefix, e.g. "tenant:a:". A quota bounds the items and the bytes stored under
+a namespace; keys belong to the namespace with the longest matching prefix. Bytes are measured by the
+Sizer, which counts strings and []byte by length; set WithSizer for other value types.
+*/
+
+var ErrQuotaExceeded = errors.New("local_cache: namespace quota exceeded")
+
+type OverflowPolicy int
+
+const (
+	// OverflowReject fails the Set with ErrQuotaExceeded
+	OverflowReject OverflowPolicy = iota
+	// OverflowEvictOwn evicts items of the same namespace, the ones expiring first, to make room
+	OverflowEvictOwn
+)
+
+// Sizer returns the size in bytes an item is accounted with
+type Sizer func(k string, v any) int64
+
+// DefaultSizer counts the key and the length of string and []byte values, other values count 8 bytes
+func DefaultSizer(k string, v any) int64 {
+	switch val := v.(type) {
+	case string:
+		return int64(len(k) + len(val))
+	case []byte:
+		return int64(len(k) + len(val))
+	}
+	return int64(len(k) + 8)
+}
+
+// WithSizer sets the Sizer used by namespace quotas
+func WithSizer(s Sizer) Option {
+	return func(c *cache) {
+		c.sizer = s
+	}
+}
+
+type namespace struct {
+	prefix   string
+	maxItems int
+	maxBytes int64
+	policy   OverflowPolicy
+	keys     map[string]int64
+	bytes    int64
+}
+
+// WithNamespaceQuota bounds the items (maxItems) and bytes (maxBytes) under prefix, 0 leaves a bound unset
+func WithNamespaceQuota(prefix string, maxItems int, maxBytes int64, policy OverflowPolicy) Option {
+	return func(c *cache) {
+		c.setNamespaceQuota(prefix, maxItems, maxBytes, policy)
+	}
+}
+
+// SetNamespaceQuota adds or changes the quota of prefix at runtime; a lowered quota applies to later Sets,
+// items already stored are kept
+func (c *cache) SetNamespaceQuota(prefix string, maxItems int, maxBytes int64, policy OverflowPolicy) {
+	c.lock.Lock()
+	c.setNamespaceQuota(prefix, maxItems, maxBytes, policy)
+	c.lock.Unlock()
+}
+
+func (c *cache) setNamespaceQuota(prefix string, maxItems int, maxBytes int64, policy OverflowPolicy) {
+	for _, ns := range c.namespaces {
+		if ns.prefix == prefix {
+			ns.maxItems, ns.maxBytes, ns.policy = maxItems, maxBytes, policy
+			return
+		}
+	}
+	ns := &namespace{
+		prefix:   prefix,
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+		policy:   policy,
+		keys:     make(map[string]int64),
+	}
+	c.namespaces = append(c.namespaces, ns)
+	// longest prefix first, so the first match is the most specific namespace
+	sort.SliceStable(c.namespaces, func(i, j int) bool {
+		return len(c.namespaces[i].prefix) > len(c.namespaces[j].prefix)
+	})
+	// items stored before the quota, they may belong to a broader namespace until now
+	for k, v := range c.items {
+		if c.namespaceOf(k) == ns {
+			for _, other := range c.namespaces {
+				if other != ns {
+					if size, ok := other.keys[k]; ok {
+						other.bytes -= size
+						delete(other.keys, k)
+					}
+				}
+			}
+			c.track(k, v)
+		}
+	}
+}
+
+func (c *cache) namespaceOf(k string) *namespace {
+	for _, ns := range c.namespaces {
+		if strings.HasPrefix(k, ns.prefix) {
+			return ns
+		}
+	}
+	return nil
+}
+
+func (c *cache) sizeOf(k string, v any) int64 {
+	if c.sizer != nil {
+		return c.sizer(k, v)
+	}
+	return DefaultSizer(k, v)
+}
+
+// track accounts k after it was stored, the caller holds c.lock
+func (c *cache) track(k string, item Item) {
+	ns := c.namespaceOf(k)
+	if ns == nil {
+		return
+	}
+	size := c.sizeOf(k, item.Obj)
+	ns.bytes += size - ns.keys[k]
+	ns.keys[k] = size
+}
+
+// untrack removes k from the accounting, the caller holds c.lock
+func (c *cache) untrack(k string) {
+	ns := c.namespaceOf(k)
+	if ns == nil {
+		return
+	}
+	if size, ok := ns.keys[k]; ok {
+		ns.bytes -= size
+		delete(ns.keys, k)
+	}
+}
+
+// admit checks the quota of k before v is stored, evicting items of the namespace when its policy allows;
+// the caller holds c.lock
+func (c *cache) admit(k string, v any) ([]Object, error) {
+	ns := c.namespaceOf(k)
+	if ns == nil {
+		return nil, nil
+	}
+	size := c.sizeOf(k, v)
+	if ns.maxBytes > 0 && size > ns.maxBytes {
+		return nil, fmt.Errorf("%w: %s item of %d bytes, max %d", ErrQuotaExceeded, ns.prefix, size, ns.maxBytes)
+	}
+	old, exists := ns.keys[k]
+	over := func() bool {
+		n := len(ns.keys)
+		if !exists {
+			n++
+		}
+		return (ns.maxItems > 0 && n > ns.maxItems) || (ns.maxBytes > 0 && ns.bytes-old+size > ns.maxBytes)
+	}
+	if !over() {
+		return nil, nil
+	}
+	if ns.policy != OverflowEvictOwn {
+		return nil, fmt.Errorf("%w: %s", ErrQuotaExceeded, ns.prefix)
+	}
+	var evicted []Object
+	now := time.Now().Unix()
+	for over() {
+		victim, ok := c.pickVictim(ns, k, now)
+		if !ok {
+			return evicted, fmt.Errorf("%w: %s", ErrQuotaExceeded, ns.prefix)
+		}
+		if v, ok := c.delete(victim); ok {
+			evicted = append(evicted, Object{key: victim, val: v})
+		}
+		c.evictions.Add(1)
+	}
+	return evicted, nil
+}
+
+// pickVictim samples the keys of ns other than skip like evict does
+func (c *cache) pickVictim(ns *namespace, skip string, now int64) (string, bool) {
+	var (
+		victim string
+		best   int64
+		i      int
+	)
+	for k := range ns.keys {
+		if _, ok := c.pinned[k]; ok || k == skip {
+			continue
+		}
+		rank := evictionRank(c.items[k], now)
+		if i == 0 || rank < best {
+			victim, best = k, rank
+		}
+		i++
+		if rank == 0 || i == evictionSamples {
+			break
+		}
+	}
+	return victim, i > 0
+}
+
+// DeletePrefix deletes all items whose key starts with prefix and returns how many were deleted;
+// it scans all items
+func (c *cache) DeletePrefix(prefix string) int {
+	var evicted []Object
+	n := 0
+	c.lock.Lock()
+	for k := range c.items {
+		if strings.HasPrefix(k, prefix) {
+			if v, ok := c.delete(k); ok {
+				evicted = append(evicted, Object{key: k, val: v})
+			}
+			n++
+		}
+	}
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	return n
+}
+
+// NamespaceUsage returns the items and bytes accounted under the quota of prefix
+func (c *cache) NamespaceUsage(prefix string) (int, int64, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, ns := range c.namespaces {
+		if ns.prefix == prefix {
+			return len(ns.keys), ns.bytes, true
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,50 @@
+package local_cache
+
+/*
+ReplaceAll swaps the whole content of the cache at once, for the datasets rebuilt periodically, e.g. a
+reference table reloaded every hour: readers see the old items until the swap and the new ones after
+it, never a partial or empty cache. The new map is built before the lock is taken, so the swap itself
+is short.
+*/
+
+// ReplaceAll replaces the items of the cache with items, keyed by stored key like Items returns them;
+// the items are stored as is, without the validators, quotas and max entries, and without running the
+// eviction callback of the replaced ones, like Flush. The pins of the keys still present are kept,
+// the dependencies of DependOn are dropped. Subscribers get an OpFlush followed by an OpSet per item
+func (c *cache) ReplaceAll(items map[string]Item) {
+	fresh := make(map[string]Item, len(items))
+	for k, v := range items {
+		if !v.Expired() {
+			fresh[k] = v
+		}
+	}
+	c.lock.Lock()
+	if c.deps != nil {
+		c.deps.parents, c.deps.children = map[string]map[string]struct{}{}, map[string]map[string]struct{}{}
+	}
+	for k := range c.pinned {
+		if _, ok := fresh[k]; !ok {
+			delete(c.pinned, k)
+		}
+	}
+	if c.tombstones != nil {
+		c.tombstones = map[string]tombstone{}
+	}
+	for _, ns := range c.namespaces {
+		ns.keys, ns.bytes = map[string]int64{}, 0
+	}
+	for k, v := range fresh {
+		v.Stamp = c.stamp(Stamp{})
+		fresh[k] = v
+		c.track(k, v)
+	}
+	c.items = fresh
+	if c.shrink != nil {
+		c.shrink.peak = len(fresh)
+	}
+	c.emit(Event{Op: OpFlush})
+	for k, v := range fresh {
+		c.emit(Event{Op: OpSet, Key: k, Item: v})
+	}
+	c.lock.Unlock()
+}
@@ -0,0 +1,126 @@
+package local_cache
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+/*
+Scheduled invalidation, for items tied to a wall-clock event such as the end of a sale or the expiry
+of a token: SetUntil stores an item until a deadline and InvalidateAt deletes a key at a time, whatever
+it holds then. The expiration of the items has whole-second precision, so the deletions are also
+scheduled on a timer, which fires at the exact time instead of waiting for the janitor.
+*/
+
+type scheduled struct {
+	at  time.Time
+	key string
+	// expire is the expiration of the item SetUntil stored, the deletion is skipped once the item was
+	// replaced; 0 for InvalidateAt
+	expire int64
+}
+
+type scheduleHeap []scheduled
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h scheduleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scheduleHeap) Push(x any)        { *h = append(*h, x.(scheduled)) }
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type schedule struct {
+	mu    sync.Mutex
+	heap  scheduleHeap
+	timer *time.Timer
+}
+
+// SetUntil sets k until deadline, a deadline already past deletes k instead
+func (c *cache) SetUntil(k string, v any, deadline time.Time) error {
+	d := time.Until(deadline)
+	if d <= 0 {
+		c.Delete(k)
+		return nil
+	}
+	if err := c.Set(k, v, d); err != nil {
+		return err
+	}
+	k, _ = c.key(k)
+	c.lock.RLock()
+	item, ok := c.items[k]
+	c.lock.RUnlock()
+	if ok {
+		c.scheduleAt(scheduled{at: deadline, key: k, expire: item.ExpireTime})
+	}
+	return nil
+}
+
+// InvalidateAt deletes k at t, whatever it holds by then; a time already past deletes k now
+func (c *cache) InvalidateAt(k string, t time.Time) {
+	if !time.Now().Before(t) {
+		c.Delete(k)
+		return
+	}
+	k, _ = c.key(k)
+	c.scheduleAt(scheduled{at: t, key: k})
+}
+
+func (c *cache) scheduleAt(s scheduled) {
+	c.lock.Lock()
+	if c.schedule == nil {
+		c.schedule = &schedule{}
+	}
+	sc := c.schedule
+	c.lock.Unlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	heap.Push(&sc.heap, s)
+	if sc.heap[0] == s {
+		c.arm(sc)
+	}
+}
+
+// arm sets the timer to the first scheduled deletion, the caller holds sc.mu
+func (c *cache) arm(sc *schedule) {
+	if sc.timer != nil {
+		sc.timer.Stop()
+		sc.timer = nil
+	}
+	if len(sc.heap) > 0 {
+		sc.timer = time.AfterFunc(time.Until(sc.heap[0].at), c.runSchedule)
+	}
+}
+
+// runSchedule deletes the items whose time came
+func (c *cache) runSchedule() {
+	sc := c.schedule
+	now := time.Now()
+	var due []scheduled
+	sc.mu.Lock()
+	for len(sc.heap) > 0 && !sc.heap[0].at.After(now) {
+		due = append(due, heap.Pop(&sc.heap).(scheduled))
+	}
+	c.arm(sc)
+	sc.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	var evicted []Object
+	c.lock.Lock()
+	for _, s := range due {
+		item, ok := c.items[s.key]
+		if !ok || (s.expire != 0 && item.ExpireTime != s.expire) {
+			continue
+		}
+		if v, ok := c.delete(s.key); ok {
+			evicted = append(evicted, Object{key: s.key, val: v})
+		}
+	}
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+}
@@ -0,0 +1,203 @@
+package local_cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+/*
+Set values, for membership-heavy features such as permissions or audiences: SAdd and SRem keep a Set
+under a key, and SUnion, SInter and SDiff combine the sets of several keys under one read lock, so
+they see a consistent snapshot. A Set is never modified once stored, SAdd and SRem store a copy, so
+the Sets returned by Get and Items can be read without the lock. Missing keys are empty sets, like in
+redis, and the keys holding other values fail with ErrNotSet.
+*/
+
+var ErrNotSet = errors.New("local_cache: value is not a set")
+
+// Set is the value of the keys written by SAdd
+type Set map[string]struct{}
+
+// Members returns the members of the set, sorted
+func (s Set) Members() []string {
+	res := make([]string, 0, len(s))
+	for m := range s {
+		res = append(res, m)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// SAdd adds members to the set of k and returns the number of members added; a new set gets the
+// default expiration, an existing one keeps its own
+func (c *cache) SAdd(k string, members ...string) (int, error) {
+	return c.updateSet(k, func(s Set) int {
+		n := 0
+		for _, m := range members {
+			if _, ok := s[m]; !ok {
+				s[m] = struct{}{}
+				n++
+			}
+		}
+		return n
+	})
+}
+
+// SRem removes members from the set of k and returns the number of members removed, the key is
+// deleted with its last member
+func (c *cache) SRem(k string, members ...string) (int, error) {
+	return c.updateSet(k, func(s Set) int {
+		n := 0
+		for _, m := range members {
+			if _, ok := s[m]; ok {
+				delete(s, m)
+				n++
+			}
+		}
+		return n
+	})
+}
+
+// SIsMember reports whether m is a member of the set of k
+func (c *cache) SIsMember(k, m string) (bool, error) {
+	k, digest := c.key(k)
+	c.lock.RLock()
+	s, err := c.getSet(k, digest)
+	c.lock.RUnlock()
+	if err != nil {
+		return false, err
+	}
+	_, ok := s[m]
+	return ok, nil
+}
+
+// SMembers returns the members of the set of k, sorted
+func (c *cache) SMembers(k string) ([]string, error) {
+	return c.combine([]string{k}, func(sets []Set) Set { return sets[0] })
+}
+
+// SUnion returns the members of any of the sets of keys, sorted
+func (c *cache) SUnion(keys ...string) ([]string, error) {
+	return c.combine(keys, func(sets []Set) Set {
+		res := Set{}
+		for _, s := range sets {
+			for m := range s {
+				res[m] = struct{}{}
+			}
+		}
+		return res
+	})
+}
+
+// SInter returns the members of all of the sets of keys, sorted
+func (c *cache) SInter(keys ...string) ([]string, error) {
+	return c.combine(keys, func(sets []Set) Set {
+		res := Set{}
+	next:
+		for m := range sets[0] {
+			for _, s := range sets[1:] {
+				if _, ok := s[m]; !ok {
+					continue next
+				}
+			}
+			res[m] = struct{}{}
+		}
+		return res
+	})
+}
+
+// SDiff returns the members of the set of the first key in none of the sets of the others, sorted
+func (c *cache) SDiff(keys ...string) ([]string, error) {
+	return c.combine(keys, func(sets []Set) Set {
+		res := Set{}
+	next:
+		for m := range sets[0] {
+			for _, s := range sets[1:] {
+				if _, ok := s[m]; ok {
+					continue next
+				}
+			}
+			res[m] = struct{}{}
+		}
+		return res
+	})
+}
+
+// combine reads the sets of keys under one read lock and returns the members of the set fn makes of them
+func (c *cache) combine(keys []string, fn func(sets []Set) Set) ([]string, error) {
+	if len(keys) == 0 {
+		return []string{}, nil
+	}
+	sets := make([]Set, len(keys))
+	c.lock.RLock()
+	for i, k := range keys {
+		k, digest := c.key(k)
+		s, err := c.getSet(k, digest)
+		if err != nil {
+			c.lock.RUnlock()
+			return nil, err
+		}
+		sets[i] = s
+	}
+	c.lock.RUnlock()
+	return fn(sets).Members(), nil
+}
+
+// getSet returns the set of k, nil when k is missing or expired, the caller holds c.lock
+func (c *cache) getSet(k, digest string) (Set, error) {
+	item, ok := c.items[k]
+	if !ok || item.KeyDigest != digest || item.Expired() {
+		return nil, nil
+	}
+	s, ok := item.Obj.(Set)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s holds %T", ErrNotSet, k, item.Obj)
+	}
+	return s, nil
+}
+
+// updateSet applies fn to a copy of the set of k and stores it when fn changed it
+func (c *cache) updateSet(k string, fn func(s Set) int) (int, error) {
+	k, digest := c.key(k)
+	if err := c.validate(k, Set{}); err != nil {
+		return 0, err
+	}
+	c.lock.Lock()
+	cur, err := c.getSet(k, digest)
+	if err != nil {
+		c.lock.Unlock()
+		return 0, err
+	}
+	s := make(Set, len(cur))
+	for m := range cur {
+		s[m] = struct{}{}
+	}
+	n := fn(s)
+	if n == 0 {
+		c.lock.Unlock()
+		return 0, nil
+	}
+	var (
+		evicted     []Object
+		v           any
+		hasCallBack bool
+	)
+	if len(s) == 0 {
+		v, hasCallBack = c.delete(k)
+	} else {
+		item := Item{ExpireTime: c.expireTime(DefaultExpire)}
+		if cur != nil {
+			item = c.items[k]
+		}
+		item.Obj, item.KeyDigest = s, digest
+		evicted, err = c.put(k, item)
+	}
+	c.lock.Unlock()
+	c.callEvicted(evicted)
+	if hasCallBack {
+		c.evictedCallback(k, v)
+	}
+	c.callInvalidated()
+	return n, err
+}
@@ -0,0 +1,110 @@
+package local_cache
+
+import (
+	"cache/src/logging"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+Load shedding: while the cache takes more Sets per second than configured, or a queue fed by the
+cache is backed up, the Sets under a priority are rejected with ErrShed, or only a sample of them is
+kept, so the writes that matter keep a bounded latency through a spike. Gets are never shed.
+*/
+
+// Priority orders the Sets for load shedding, Set is PriorityNormal
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+var ErrShed = errors.New("local_cache: set shed under load")
+
+// ShedConfig configures WithLoadShedding, MaxWriteRate or QueueDepth is required
+type ShedConfig struct {
+	// MaxWriteRate is the Sets per second, shed ones included, above which the cache is overloaded
+	MaxWriteRate int
+	// QueueDepth reports the depth of a queue the cache feeds, e.g. of the callbacks processed
+	// asynchronously; the cache is overloaded while it is above MaxQueueDepth
+	QueueDepth    func() int
+	MaxQueueDepth int
+	// Below is the priority the Sets are shed under, PriorityNormal by default: only the Sets of
+	// PriorityLow are shed
+	Below Priority
+	// SampleRate is the fraction of the Sets under Below still accepted while overloaded
+	SampleRate float64
+}
+
+// WithLoadShedding sheds the Sets of low priority while the cache is overloaded, see ShedConfig;
+// Stats counts them in Shed
+func WithLoadShedding(cfg ShedConfig) Option {
+	return func(c *cache) {
+		if cfg.MaxWriteRate > 0 || cfg.QueueDepth != nil {
+			c.shedder = &shedder{cfg: cfg, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+		}
+	}
+}
+
+type shedder struct {
+	cfg    ShedConfig
+	mu     sync.Mutex
+	window int64
+	writes int
+	// logged is the window of the last logged shed
+	logged int64
+	rnd    *rand.Rand
+}
+
+// firstShed reports whether a shed is the first of its window, so shedding is logged once a second
+func (s *shedder) firstShed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logged == s.window {
+		return false
+	}
+	s.logged = s.window
+	return true
+}
+
+// admit counts a Set of priority p and reports whether it may proceed
+func (s *shedder) admit(p Priority) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now().Unix(); now != s.window {
+		s.window, s.writes = now, 0
+	}
+	s.writes++
+	if p >= s.cfg.Below {
+		return true
+	}
+	overloaded := s.cfg.MaxWriteRate > 0 && s.writes > s.cfg.MaxWriteRate
+	if !overloaded && s.cfg.QueueDepth != nil {
+		overloaded = s.cfg.QueueDepth() > s.cfg.MaxQueueDepth
+	}
+	return !overloaded || (s.cfg.SampleRate > 0 && s.rnd.Float64() < s.cfg.SampleRate)
+}
+
+// SetWithPriority is Set with the priority WithLoadShedding sheds the Sets by, it returns ErrShed
+// when the Set was shed
+func (c *cache) SetWithPriority(k string, v any, d time.Duration, p Priority) error {
+	if err := c.admitSet(k, p); err != nil {
+		return err
+	}
+	return c.write(k, v, d, Item{})
+}
+
+// admitSet returns ErrShed when a Set of k with priority p is shed
+func (c *cache) admitSet(k string, p Priority) error {
+	if c.shedder != nil && !c.shedder.admit(p) {
+		if c.shed.Add(1); c.logger != nil && c.shedder.firstShed() {
+			c.log(logging.LevelWarn, "local_cache: shedding sets under load", "key", k, "priority", p, "shed", c.shed.Load())
+		}
+		return ErrShed
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package local_cache
+
+/*
+A Go map keeps its buckets when items are deleted, so a cache that grew to millions of items during a
+spike holds on to their memory after they expired. With WithMapShrink, DeleteExpired copies the items
+into a right-sized map once they fell below a fraction of the peak count since the previous copy,
+releasing the buckets to the GC. The copy holds the lock for a time proportional to the items left.
+*/
+
+// shrinkMinPeak is the peak below which the map is never rebuilt, small maps are not worth the copy
+const shrinkMinPeak = 1024
+
+// WithMapShrink rebuilds the item map in DeleteExpired, and so in the janitor, when the items fell
+// below ratio of their peak count, e.g. 0.25; Stats counts the rebuilds in MapRebuilds
+func WithMapShrink(ratio float64) Option {
+	return func(c *cache) {
+		if ratio > 0 && ratio < 1 {
+			c.shrink = &shrinker{ratio: ratio}
+		}
+	}
+}
+
+type shrinker struct {
+	ratio    float64
+	peak     int
+	rebuilds uint64
+}
+
+// notePeak records the item count after a write, the caller holds c.lock
+func (c *cache) notePeak() {
+	if s := c.shrink; s != nil && len(c.items) > s.peak {
+		s.peak = len(c.items)
+	}
+}
+
+// shrinkMap rebuilds the item map when it shrank enough, the caller holds c.lock
+func (c *cache) shrinkMap() {
+	s := c.shrink
+	if s == nil || s.peak < shrinkMinPeak || float64(len(c.items)) >= s.ratio*float64(s.peak) {
+		return
+	}
+	items := make(map[string]Item, len(c.items))
+	for k, v := range c.items {
+		items[k] = v
+	}
+	c.items = items
+	s.peak = len(items)
+	s.rebuilds++
+}
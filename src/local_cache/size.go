@@ -0,0 +1,65 @@
+package local_cache
+
+import "sync/atomic"
+
+/*
+The size histogram counts the values written by Set, SetIfExpiringWithin and Replace by the size the
+Sizer gives them, the same cost namespace quotas account. Bucket i counts sizes up to SizeBuckets[i],
+the last bucket counts the larger ones.
+*/
+
+// SizeBuckets are the upper bounds in bytes of the histogram buckets but the last
+var SizeBuckets = []int64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+type sizeStats struct {
+	buckets   []atomic.Uint64
+	threshold int64
+	onLarge   func(k string, size int64)
+}
+
+// WithSizeHistogram turns on the size histogram reported in Stats.Sizes
+func WithSizeHistogram() Option {
+	return func(c *cache) {
+		if c.sizes == nil {
+			c.sizes = &sizeStats{buckets: make([]atomic.Uint64, len(SizeBuckets)+1)}
+		}
+	}
+}
+
+// WithLargeValueCallback turns on the size histogram and calls fn for every value larger than threshold
+// bytes, e.g. to log accidental caching of huge payloads; the value is stored anyway
+func WithLargeValueCallback(threshold int64, fn func(k string, size int64)) Option {
+	return func(c *cache) {
+		WithSizeHistogram()(c)
+		c.sizes.threshold = threshold
+		c.sizes.onLarge = fn
+	}
+}
+
+// observeSize counts v in the histogram, it runs without c.lock so the callback may use the cache
+func (c *cache) observeSize(k string, v any) {
+	s := c.sizes
+	if s == nil {
+		return
+	}
+	size := c.sizeOf(k, v)
+	i := 0
+	for i < len(SizeBuckets) && size > SizeBuckets[i] {
+		i++
+	}
+	s.buckets[i].Add(1)
+	if s.onLarge != nil && size > s.threshold {
+		s.onLarge(k, size)
+	}
+}
+
+func (s *sizeStats) snapshot() []uint64 {
+	if s == nil {
+		return nil
+	}
+	res := make([]uint64, len(s.buckets))
+	for i := range s.buckets {
+		res[i] = s.buckets[i].Load()
+	}
+	return res
+}
@@ -0,0 +1,55 @@
+package local_cache
+
+import (
+	"cache/src/logging"
+	"strconv"
+	"time"
+)
+
+/*
+The slow operation log reports the Gets, Sets, loader calls of GetMultiOrLoad and callbacks that take
+longer than a threshold, with the operation, the key and the duration; callbacks and loaders are the
+usual suspects, since they run application code. Keys may be hashed when they hold personal data.
+*/
+
+// SlowLogConfig configures WithSlowLog
+type SlowLogConfig struct {
+	Threshold time.Duration
+	// Logger defaults to the one of WithLogger
+	Logger logging.Logger
+	// HashKeys logs the FNV-1a hash of the keys instead of the keys
+	HashKeys bool
+}
+
+// WithSlowLog logs the operations slower than cfg.Threshold to cfg.Logger
+func WithSlowLog(cfg SlowLogConfig) Option {
+	return func(c *cache) {
+		if cfg.Threshold > 0 {
+			c.slowLog = &cfg
+		}
+	}
+}
+
+// slow logs op on key when it started more than the threshold ago; key is empty for batches
+func (c *cache) slow(op, key string, start time.Time) {
+	d := time.Since(start)
+	if d < c.slowLog.Threshold {
+		return
+	}
+	if key != "" && c.slowLog.HashKeys {
+		key = strconv.FormatUint(hashKey(key), 16)
+	}
+	if c.slowLog.Logger != nil {
+		c.slowLog.Logger.Log(logging.LevelWarn, "local_cache: slow operation", "op", op, "key", key, "duration", d)
+		return
+	}
+	c.log(logging.LevelWarn, "local_cache: slow operation", "op", op, "key", key, "duration", d)
+}
+
+// evictedCallback runs the OnEvicted callback, timed with WithSlowLog
+func (c *cache) evictedCallback(k string, v any) {
+	if c.slowLog != nil {
+		defer c.slow("evicted_callback", k, time.Now())
+	}
+	c.onEvicted(k, v)
+}
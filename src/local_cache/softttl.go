@@ -0,0 +1,72 @@
+package local_cache
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+Soft and hard TTLs formalize stale-while-revalidate per item: past its soft TTL an item is still
+served, but GetWithState reports it stale so the caller refreshes it, e.g. in the background; past
+its hard TTL it expires like any other item. Both have the whole-second precision of the expirations.
+*/
+
+var ErrSoftTTL = errors.New("local_cache: soft TTL longer than hard TTL")
+
+// State is the freshness of an item returned by GetWithState
+type State int
+
+const (
+	StateMiss State = iota
+	StateFresh
+	StateStale
+)
+
+func (s State) String() string {
+	switch s {
+	case StateFresh:
+		return "fresh"
+	case StateStale:
+		return "stale"
+	}
+	return "miss"
+}
+
+// SetWithTTLs is Set with a soft TTL, after which GetWithState reports the item stale, and a hard TTL
+// after which it expires; hard may be DefaultExpire or NoExpire, soft must not be longer
+func (c *cache) SetWithTTLs(k string, v any, soft, hard time.Duration) error {
+	if soft <= 0 {
+		return c.Set(k, v, hard)
+	}
+	limit := hard
+	if limit == DefaultExpire {
+		// Reconfigure may change the default expiration, write resolves it again under the lock
+		c.lock.RLock()
+		limit = c.defaultExpire
+		c.lock.RUnlock()
+	}
+	if limit > 0 && soft > limit {
+		return ErrSoftTTL
+	}
+	if err := c.admitSet(k, PriorityNormal); err != nil {
+		return err
+	}
+	return c.write(k, v, hard, Item{SoftExpireTime: time.Now().Add(soft).Unix()})
+}
+
+// GetWithState is Get reporting whether the item is fresh or stale, StateMiss when it isn't found
+func (c *cache) GetWithState(k string) (any, State) {
+	item, ok := c.lookup(k)
+	switch {
+	case !ok:
+		return nil, StateMiss
+	case item.Stale():
+		return item.Obj, StateStale
+	}
+	return item.Obj, StateFresh
+}
+
+// Stale reports whether the item is past its soft TTL
+func (i *Item) Stale() bool {
+	return i.SoftExpireTime > 0 && time.Now().Unix() > i.SoftExpireTime
+}
@@ -0,0 +1,47 @@
+package local_cache
+
+import (
+	"time"
+)
+
+/*
+Tags label the items with what they belong to, e.g. "tenant:42" or "page:home", so diagnostics can
+list what is cached for a tenant: Items and the events of Subscribe carry them in Item.Tags, and Range
+iterates over the items having given tags. Tags are replaced by every Set of the key, a Set without
+tags clears them.
+*/
+
+// SetWithTags is Set tagging the item with tags
+func (c *cache) SetWithTags(k string, v any, d time.Duration, tags ...string) error {
+	if err := c.admitSet(k, PriorityNormal); err != nil {
+		return err
+	}
+	return c.write(k, v, d, Item{Tags: append([]string(nil), tags...)})
+}
+
+// Range calls fn with the unexpired items having all of tags, every item when there are none, until fn
+// returns false. It iterates over a copy, fn may call the cache
+func (c *cache) Range(fn func(k string, item Item) bool, tags ...string) {
+	for k, item := range c.Items() {
+		if item.HasTags(tags...) && !fn(k, item) {
+			return
+		}
+	}
+}
+
+// HasTags reports whether the item has all of tags
+func (i *Item) HasTags(tags ...string) bool {
+	for _, t := range tags {
+		found := false
+		for _, it := range i.Tags {
+			if it == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package local_cache
+
+import "time"
+
+/*
+In tombstone mode Delete doesn't remove an item right away: the item is kept aside for a grace period
+during which Get misses and Restore can bring it back. Setting the key again discards the tombstone.
+The deletion becomes final, and the eviction callback runs, when DeleteExpired (the janitor) finds the
+grace period over. Expiration and eviction are not affected.
+*/
+
+type tombstone struct {
+	item  Item
+	until int64
+}
+
+// WithTombstones turns on tombstone mode with the given grace period
+func WithTombstones(grace time.Duration) Option {
+	return func(c *cache) {
+		if grace > 0 {
+			c.tombstoneGrace = grace
+			c.tombstones = make(map[string]tombstone)
+		}
+	}
+}
+
+// bury moves k to the tombstones, the caller holds c.lock
+func (c *cache) bury(k string) {
+	item, ok := c.items[k]
+	if !ok {
+		return
+	}
+	delete(c.items, k)
+	c.untrack(k)
+	delete(c.pinned, k)
+	c.emit(Event{Op: OpDelete, Key: k})
+	c.tombstones[k] = tombstone{item: item, until: time.Now().Add(c.tombstoneGrace).UnixNano()}
+}
+
+// Restore undoes the Delete of k within the grace period, it returns false when there is nothing to restore
+func (c *cache) Restore(k string) bool {
+	k, _ = c.key(k)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t, ok := c.tombstones[k]
+	if !ok {
+		return false
+	}
+	delete(c.tombstones, k)
+	if time.Now().UnixNano() > t.until || t.item.Expired() {
+		return false
+	}
+	t.item.Stamp = c.stamp(Stamp{})
+	c.items[k] = t.item
+	c.track(k, t.item)
+	c.emit(Event{Op: OpSet, Key: k, Item: t.item})
+	return true
+}
+
+// purgeTombstones removes the tombstones past their grace period, the caller holds c.lock
+func (c *cache) purgeTombstones(now int64) []Object {
+	var res []Object
+	for k, t := range c.tombstones {
+		if now > t.until {
+			delete(c.tombstones, k)
+			if c.onEvicted != nil {
+				res = append(res, Object{key: k, val: t.item})
+			}
+		}
+	}
+	return res
+}
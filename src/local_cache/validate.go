@@ -0,0 +1,136 @@
+package local_cache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"unicode/utf8"
+)
+
+var (
+	ErrKeyTooLong     = errors.New("local_cache: key too long")
+	ErrInvalidKeyChar = errors.New("local_cache: invalid character in key")
+	ErrNilValue       = errors.New("local_cache: nil value")
+	ErrKeyNotFound    = errors.New("local_cache: key not found")
+	ErrKeyExists      = errors.New("local_cache: key already exists")
+)
+
+// NilPolicy decides what Set and Replace do with nil values, including typed nils such as a nil *T
+type NilPolicy int
+
+const (
+	// NilStore stores nil like any other value, Get then reports it as present: (nil, true)
+	NilStore NilPolicy = iota
+	// NilReject fails the Set with ErrNilValue, so a hit never returns nil
+	NilReject
+)
+
+type Option func(c *cache)
+
+// WithMaxKeyLen rejects keys longer than n bytes on Set and Replace
+func WithMaxKeyLen(n int) Option {
+	return func(c *cache) {
+		c.validators = append(c.validators, func(k string) error {
+			if len(k) > n {
+				return fmt.Errorf("%w: %d bytes, max %d", ErrKeyTooLong, len(k), n)
+			}
+			return nil
+		})
+	}
+}
+
+// WithKeyCharset rejects keys containing a rune for which allowed returns false, or invalid UTF-8
+func WithKeyCharset(allowed func(r rune) bool) Option {
+	return func(c *cache) {
+		c.validators = append(c.validators, func(k string) error {
+			for i, r := range k {
+				if r == utf8.RuneError || !allowed(r) {
+					return fmt.Errorf("%w: %q at byte %d", ErrInvalidKeyChar, r, i)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// WithKeyValidator adds a custom check run on Set and Replace, its error is returned as is
+func WithKeyValidator(fn func(k string) error) Option {
+	return func(c *cache) {
+		c.validators = append(c.validators, fn)
+	}
+}
+
+// WithValueValidator checks the values Get and GetWithExpire find: those for which fn returns false, e.g.
+// of an old schema or corrupted by a Load, are reported as misses and deleted, running the eviction
+// callback; Stats counts them in Invalid. fn runs with the cache locked when deleting, it must not call
+// the cache
+func WithValueValidator(fn func(key string, val any) bool) Option {
+	return func(c *cache) {
+		c.valueValidator = fn
+	}
+}
+
+// WithKeyTransform canonicalizes keys, e.g. with strings.ToLower or strings.TrimSpace, on every operation
+// taking a key, before the validators; several transforms are applied in order. Keys are stored
+// transformed, so NextExpiry, ExpiringWithin, prefixes and callbacks see the transformed keys
+func WithKeyTransform(fn func(k string) string) Option {
+	return func(c *cache) {
+		if prev := c.keyTransform; prev != nil {
+			c.keyTransform = func(k string) string { return fn(prev(k)) }
+			return
+		}
+		c.keyTransform = fn
+	}
+}
+
+// WithNilPolicy sets how nil values are handled, NilStore by default
+func WithNilPolicy(p NilPolicy) Option {
+	return func(c *cache) {
+		c.nilPolicy = p
+	}
+}
+
+func (c *cache) validate(k string, v any) error {
+	for _, fn := range c.validators {
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	if c.nilPolicy == NilReject && isNil(v) {
+		return fmt.Errorf("%w: %s", ErrNilValue, k)
+	}
+	return nil
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// invalid reports whether the validator rejects the value of k and deletes it then, unless it was set
+// again since the caller read it
+func (c *cache) invalid(k string, v any) bool {
+	if c.valueValidator == nil || c.valueValidator(k, v) {
+		return false
+	}
+	c.invalids.Add(1)
+	c.lock.Lock()
+	item, ok := c.items[k]
+	if !ok || c.valueValidator(k, item.Obj) {
+		c.lock.Unlock()
+		return true
+	}
+	v, hasCallBack := c.delete(k)
+	c.lock.Unlock()
+	if hasCallBack {
+		c.evictedCallback(k, v)
+	}
+	c.callInvalidated()
+	return true
+}
@@ -0,0 +1,74 @@
+/*
+The package defines the Logger the other packages of the module report to, so their warnings end up
+in the logs of the application whatever library it logs with:
+
+	Logger: Takes a level, a message and alternating keys and values, like log/slog and zap's SugaredLogger.
+	Func: Adapts a function to a Logger.
+	Sugared: Adapts a zap SugaredLogger, or anything with its Debugw/Infow/Warnw/Errorw methods.
+	Slog: Adapts a log/slog Logger, built with go1.21 or later.
+	Nop: Discards everything, the default of the packages taking a Logger.
+
+The levels have the values of log/slog, so an adapter can pass them as they are.
+*/
+
+package logging
+
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// Logger receives the messages of the packages, keyvals alternate string keys and values
+type Logger interface {
+	Log(level Level, msg string, keyvals ...any)
+}
+
+// Func adapts a function to a Logger
+type Func func(level Level, msg string, keyvals ...any)
+
+func (f Func) Log(level Level, msg string, keyvals ...any) {
+	f(level, msg, keyvals...)
+}
+
+// Nop discards every message
+var Nop Logger = Func(func(Level, string, ...any) {})
+
+// SugaredLogger is the part of zap's *SugaredLogger Sugared uses, so the module doesn't depend on zap
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...any)
+	Infow(msg string, keysAndValues ...any)
+	Warnw(msg string, keysAndValues ...any)
+	Errorw(msg string, keysAndValues ...any)
+}
+
+// Sugared adapts a zap SugaredLogger, e.g. logging.Sugared(zapLogger.Sugar())
+func Sugared(s SugaredLogger) Logger {
+	return Func(func(level Level, msg string, keyvals ...any) {
+		switch {
+		case level < LevelInfo:
+			s.Debugw(msg, keyvals...)
+		case level < LevelWarn:
+			s.Infow(msg, keyvals...)
+		case level < LevelError:
+			s.Warnw(msg, keyvals...)
+		default:
+			s.Errorw(msg, keyvals...)
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type sugared []string
+
+func (s *sugared) log(level, msg string, kv []any) {
+	*s = append(*s, fmt.Sprint(level, " ", msg, " ", kv))
+}
+
+func (s *sugared) Debugw(msg string, kv ...any) { s.log("debug", msg, kv) }
+func (s *sugared) Infow(msg string, kv ...any)  { s.log("info", msg, kv) }
+func (s *sugared) Warnw(msg string, kv ...any)  { s.log("warn", msg, kv) }
+func (s *sugared) Errorw(msg string, kv ...any) { s.log("error", msg, kv) }
+
+func TestSugared(t *testing.T) {
+	var s sugared
+	l := Sugared(&s)
+	l.Log(LevelDebug, "a")
+	l.Log(LevelInfo, "b", "key", 1)
+	l.Log(LevelWarn+1, "c")
+	l.Log(LevelError, "d")
+	want := []string{"debug a []", "info b [key 1]", "warn c []", "error d []"}
+	if !reflect.DeepEqual([]string(s), want) {
+		t.Fatalf("expected %v, got %v", want, s)
+	}
+	if LevelWarn.String() != "WARN" || Level(-8).String() != "DEBUG" {
+		t.Fatalf("unexpected level names: %v %v", LevelWarn, Level(-8))
+	}
+}
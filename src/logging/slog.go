@@ -0,0 +1,18 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Slog adapts a log/slog Logger, nil is slog.Default()
+func Slog(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return Func(func(level Level, msg string, keyvals ...any) {
+		l.Log(context.Background(), slog.Level(level), msg, keyvals...)
+	})
+}
@@ -0,0 +1,20 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := Slog(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	l.Log(LevelDebug, "hidden")
+	l.Log(LevelWarn, "slow operation", "key", "user:12")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, `level=WARN msg="slow operation" key=user:12`) {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+The package memoizes functions in a cache.Cache:
+
+	memo := memoize.Func(loadUser, time.Minute, c)
+	user, err := memo(ctx, id)
+
+Concurrent calls with the same key share one call of fn. Errors are not cached unless WithErrorTTL
+is set, and a cached error comes back as a plain error with the same message. With the Redis cache
+values come back through the codec, they are converted back to V with a JSON round trip.
+*/
+
+package memoize
+
+import (
+	"cache/src/cache"
+	"cache/src/codec"
+	"cache/src/internal/singleflight"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// errPrefix marks cached errors, the entry is a string so it survives every codec
+const errPrefix = "\x00memoize:error:"
+
+type options[K comparable, V any] struct {
+	prefix   string
+	keyFunc  func(K) string
+	errTTL   time.Duration
+	cacheErr func(error) bool
+	ttlFunc  func(K, V) time.Duration
+}
+
+type Option[K comparable, V any] func(o *options[K, V])
+
+// WithPrefix sets the prefix of the cache keys, "memoize:" by default; memoized functions
+// sharing a cache need different prefixes
+func WithPrefix[K comparable, V any](prefix string) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.prefix = prefix
+	}
+}
+
+// WithKeyFunc turns arguments into keys, fmt.Sprint by default
+func WithKeyFunc[K comparable, V any](fn func(K) string) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.keyFunc = fn
+	}
+}
+
+// WithErrorTTL caches errors of fn for ttl; cacheIf selects the cached errors, nil caches all of them
+func WithErrorTTL[K comparable, V any](ttl time.Duration, cacheIf func(error) bool) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.errTTL = ttl
+		o.cacheErr = cacheIf
+	}
+}
+
+// WithTTLFunc overrides the TTL per key and result, e.g. shorter TTLs for empty results
+func WithTTLFunc[K comparable, V any](fn func(K, V) time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.ttlFunc = fn
+	}
+}
+
+// Func returns fn memoized in c with ttl
+func Func[K comparable, V any](fn func(ctx context.Context, k K) (V, error), ttl time.Duration,
+	c cache.Cache, opts ...Option[K, V]) func(ctx context.Context, k K) (V, error) {
+	o := &options[K, V]{
+		prefix:  "memoize:",
+		keyFunc: func(k K) string { return fmt.Sprint(k) },
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	var group singleflight.Group
+	return func(ctx context.Context, k K) (V, error) {
+		key := o.prefix + o.keyFunc(k)
+		if val, err := c.Get(ctx, key); err == nil {
+			return decode[V](val)
+		} else if err != cache.ErrNotFound {
+			var zero V
+			return zero, err
+		}
+		val, err := group.Do(key, func() (any, error) {
+			v, err := fn(ctx, k)
+			if err != nil {
+				if o.errTTL > 0 && (o.cacheErr == nil || o.cacheErr(err)) {
+					_ = c.Set(ctx, key, errPrefix+err.Error(), o.errTTL)
+				}
+				return nil, err
+			}
+			d := ttl
+			if o.ttlFunc != nil {
+				d = o.ttlFunc(k, v)
+			}
+			return v, c.Set(ctx, key, v, d)
+		})
+		// on a failed Set the value is returned along with the error, as cacheaside does
+		res, _ := val.(V)
+		return res, err
+	}
+}
+
+func decode[V any](val any) (V, error) {
+	var res V
+	if s, ok := val.(string); ok && strings.HasPrefix(s, errPrefix) {
+		return res, errors.New(strings.TrimPrefix(s, errPrefix))
+	}
+	if v, ok := val.(V); ok {
+		return v, nil
+	}
+	data, err := codec.JSON.Marshal(val)
+	if err != nil {
+		return res, err
+	}
+	return res, codec.JSON.Unmarshal(data, &res)
+}
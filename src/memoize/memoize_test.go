@@ -0,0 +1,78 @@
+package memoize
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type user struct {
+	ID   int
+	Name string
+}
+
+func TestFunc(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	memo := Func(func(ctx context.Context, id int) (user, error) {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return user{ID: id, Name: "will"}, nil
+	}, time.Minute, cache.NewLocal(local_cache.NewCache(time.Minute, 0)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if u, err := memo(ctx, 1); err != nil || u.Name != "will" {
+				t.Errorf("unexpected result %v %v", u, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := memo(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected one call, got %d", n)
+	}
+}
+
+func TestFuncErrors(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLocal(local_cache.NewCache(time.Minute, 0))
+	errTemp, errGone := errors.New("temporary"), errors.New("gone")
+	calls := 0
+	memo := Func(func(ctx context.Context, id int) (string, error) {
+		calls++
+		if id == 1 {
+			return "", errTemp
+		}
+		return "", errGone
+	}, time.Minute, c, WithErrorTTL[int, string](time.Minute, func(err error) bool { return err == errGone }))
+
+	for i := 0; i < 2; i++ {
+		_, _ = memo(ctx, 1)
+		if _, err := memo(ctx, 2); err == nil || err.Error() != "gone" {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	// 2 calls for the uncached error, 1 for the cached one
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// values read from the redis cache come back as decoded JSON
+	u, err := decode[user](map[string]any{"ID": float64(1), "Name": "will"})
+	if err != nil || u != (user{ID: 1, Name: "will"}) {
+		t.Fatalf("unexpected decode result %v %v", u, err)
+	}
+}
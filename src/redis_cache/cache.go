@@ -0,0 +1,501 @@
+/*
+The package provides a Redis-backed cache with the same shape as local_cache:
+
+	Set: Sets an item in the cache with an expiration time, WithWriteCoalescing skips repeated identical Sets.
+	SetDefault: Sets an item in the cache with the default expiration time.
+	SetNoExpire: Sets an item in the cache with no expiration time.
+	Replace: Replaces an item in the cache only if it already exists.
+	Get: Gets an item from the cache.
+	GetWithExpire: Gets an item from the cache with its expiration time.
+	MGet, MSet, DeleteMulti: Gets, sets and deletes several items in one pipeline, reporting per-key errors.
+	GetOrCompute: Gets an item, computing and storing it on a miss.
+	TTL: Returns the remaining time to live of an item.
+	Delete: Deletes an item from the cache.
+	Flush: Deletes all items under the key prefix; without a prefix it needs WithFlushDB and flushes the database.
+	Stats: Returns the hit/miss counters and the number of items.
+	HealthCheck: Pings the server.
+
+Values are encoded with a codec.Codec, JSON by default, so numbers come back as float64 and
+structs as map[string]any; use GetTo to decode into a concrete type, which is required with
+codec.Gob. Expirations follow local_cache: DefaultExpire uses the cache default and NoExpire
+stores the key without a TTL.
+
+Any redis.UniversalClient can back the cache: single node, sentinel (*redis.Client from
+NewFailoverClient) or cluster. Every command touches one key, or keys of one slot, and Flush, Stats
+and ItemCount walk every master of a cluster.
+*/
+
+package redis_cache
+
+import (
+	"cache/src/codec"
+	"cache/src/internal/slot"
+	"cache/src/local_cache"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	NoExpire = local_cache.NoExpire
+
+	DefaultExpire = local_cache.DefaultExpire
+)
+
+type Cache struct {
+	client        redis.Cmdable
+	defaultExpire time.Duration
+	prefix        string
+	flushDB       bool
+	codec         codec.Codec
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	coalesce      *coalescer
+}
+
+type Option func(c *Cache)
+
+// WithPrefix prepends prefix to every key, so the cache can share a database with other data
+func WithPrefix(prefix string) Option {
+	return func(c *Cache) {
+		c.prefix = prefix
+	}
+}
+
+// WithFlushDB lets Flush issue FLUSHDB when there is no prefix, for a cache that owns its database;
+// without it Flush refuses to run without a prefix, so it can't wipe data it doesn't own
+func WithFlushDB() Option {
+	return func(c *Cache) {
+		c.flushDB = true
+	}
+}
+
+// ErrFlushWithoutPrefix is returned by Flush on a cache without a prefix and without WithFlushDB
+var ErrFlushWithoutPrefix = errors.New("redis_cache: flush without a prefix needs WithFlushDB")
+
+// WithCodec sets the codec used for values, codec.JSON by default
+func WithCodec(cc codec.Codec) Option {
+	return func(c *Cache) {
+		c.codec = cc
+	}
+}
+
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	Items  int
+}
+
+// NewCache returns a cache over client, usually a redis.UniversalClient
+func NewCache(client redis.Cmdable, defaultExpiration time.Duration, opts ...Option) *Cache {
+	if defaultExpiration <= 0 {
+		defaultExpiration = NoExpire
+	}
+	c := &Cache{
+		client:        client,
+		defaultExpire: defaultExpiration,
+		codec:         codec.JSON,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Cache) key(k string) string {
+	return c.prefix + k
+}
+
+func (c *Cache) hit(ok bool) {
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// ttl maps a local_cache style duration to the redis expiration, 0 means no expiration
+func (c *Cache) ttl(d time.Duration) time.Duration {
+	if d == DefaultExpire {
+		d = c.defaultExpire
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d
+}
+
+func (c *Cache) Set(ctx context.Context, k string, v any, d time.Duration) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if c.coalesce != nil && c.coalesce.seen(k, data, c.ttl(d)) {
+		return nil
+	}
+	if err = c.client.Set(ctx, c.key(k), data, c.ttl(d)).Err(); err != nil && c.coalesce != nil {
+		c.coalesce.forget(k)
+	}
+	return err
+}
+
+func (c *Cache) SetDefault(ctx context.Context, k string, v any) error {
+	return c.Set(ctx, k, v, DefaultExpire)
+}
+
+func (c *Cache) SetNoExpire(ctx context.Context, k string, v any) error {
+	return c.Set(ctx, k, v, NoExpire)
+}
+
+func (c *Cache) Replace(ctx context.Context, k string, v any, d time.Duration) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ok, err := c.client.SetXX(ctx, c.key(k), data, c.ttl(d)).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Item %s doesn't exist", k)
+	}
+	return nil
+}
+
+func (c *Cache) Get(ctx context.Context, k string) (any, bool, error) {
+	var v any
+	ok, err := c.GetTo(ctx, k, &v)
+	return v, ok, err
+}
+
+// GetTo decodes the item into dst, which must be a pointer
+func (c *Cache) GetTo(ctx context.Context, k string, dst any) (bool, error) {
+	data, err := c.client.Get(ctx, c.key(k)).Bytes()
+	if err == redis.Nil {
+		c.hit(false)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	c.hit(true)
+	return true, c.codec.Unmarshal(data, dst)
+}
+
+func (c *Cache) GetWithExpire(ctx context.Context, k string) (any, time.Time, bool, error) {
+	var (
+		get  *redis.StringCmd
+		pttl *redis.DurationCmd
+	)
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, c.key(k))
+		pttl = pipe.PTTL(ctx, c.key(k))
+		return nil
+	})
+	if err == redis.Nil {
+		c.hit(false)
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	c.hit(true)
+	var v any
+	if err = c.codec.Unmarshal([]byte(get.Val()), &v); err != nil {
+		return nil, time.Time{}, false, err
+	}
+	if ttl := pttl.Val(); ttl > 0 {
+		return v, time.Now().Add(ttl), true, nil
+	}
+	return v, time.Time{}, true, nil
+}
+
+// BatchError is returned by MGet, MSet and DeleteMulti when some keys failed, the other keys
+// went through; a key of a pipeline lost to a connection error fails with that error
+type BatchError struct {
+	Errors map[string]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("redis_cache: %d keys of the batch failed", len(e.Errors))
+}
+
+func (e *BatchError) add(k string, err error) {
+	if e.Errors == nil {
+		e.Errors = make(map[string]error)
+	}
+	e.Errors[k] = err
+}
+
+// cmdErr returns the error of a pipelined cmd; go-redis doesn't set it when the pipeline failed to
+// get a connection, a command is then failed with the pipeline error unless that is a server reply
+func cmdErr(cmd redis.Cmder, pipeErr error) error {
+	if err := cmd.Err(); err != nil {
+		return err
+	}
+	var reply redis.Error
+	if pipeErr != nil && pipeErr != redis.Nil && !errors.As(pipeErr, &reply) {
+		return pipeErr
+	}
+	return nil
+}
+
+func (e *BatchError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// MGet gets several items with a pipeline of GETs, which also works on redis cluster
+// where keys live in different slots; missing keys are left out of the result, keys that
+// failed to be read or decoded are reported in a *BatchError along with the others
+func (c *Cache) MGet(ctx context.Context, keys ...string) (map[string]any, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, pipeErr := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = pipe.Get(ctx, c.key(k))
+		}
+		return nil
+	})
+	res := make(map[string]any, len(keys))
+	var batchErr BatchError
+	for i, cmd := range cmds {
+		if err := cmdErr(cmd, pipeErr); err != nil && err != redis.Nil {
+			batchErr.add(keys[i], err)
+			continue
+		}
+		data, err := cmd.Bytes()
+		if err == redis.Nil {
+			c.hit(false)
+			continue
+		}
+		if err != nil {
+			batchErr.add(keys[i], err)
+			continue
+		}
+		c.hit(true)
+		var v any
+		if err = c.codec.Unmarshal(data, &v); err != nil {
+			batchErr.add(keys[i], err)
+			continue
+		}
+		res[keys[i]] = v
+	}
+	return res, batchErr.err()
+}
+
+// Entry is an item of MSet, its TTL follows Set
+type Entry struct {
+	Value any
+	TTL   time.Duration
+}
+
+// MSet sets several items with a pipeline of SETs, each with its own TTL. Unlike MSET it works on
+// redis cluster and needs no script for the TTLs, but it isn't atomic: keys that failed to be encoded
+// or written are reported in a *BatchError, the others are set
+func (c *Cache) MSet(ctx context.Context, entries map[string]Entry) error {
+	var batchErr BatchError
+	cmds := make(map[string]*redis.StatusCmd, len(entries))
+	_, pipeErr := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for k, e := range entries {
+			// the coalescer must not skip a later Set of the value k had before
+			if c.coalesce != nil {
+				c.coalesce.forget(k)
+			}
+			data, err := c.codec.Marshal(e.Value)
+			if err != nil {
+				batchErr.add(k, err)
+				continue
+			}
+			cmds[k] = pipe.Set(ctx, c.key(k), data, c.ttl(e.TTL))
+		}
+		return nil
+	})
+	for k, cmd := range cmds {
+		if err := cmdErr(cmd, pipeErr); err != nil {
+			batchErr.add(k, err)
+		}
+	}
+	return batchErr.err()
+}
+
+// DeleteMulti deletes several items with a pipeline of DELs, one per key so that it works on redis
+// cluster; keys that failed are reported in a *BatchError, the others are deleted
+func (c *Cache) DeleteMulti(ctx context.Context, keys ...string) error {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, pipeErr := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, k := range keys {
+			if c.coalesce != nil {
+				c.coalesce.forget(k)
+			}
+			cmds[i] = pipe.Del(ctx, c.key(k))
+		}
+		return nil
+	})
+	var batchErr BatchError
+	for i, cmd := range cmds {
+		if err := cmdErr(cmd, pipeErr); err != nil {
+			batchErr.add(keys[i], err)
+		}
+	}
+	return batchErr.err()
+}
+
+// GetOrCompute returns the cached item, or calls fn on a miss and stores its result with expiration d;
+// the computed value is returned as is, without the codec round trip
+func (c *Cache) GetOrCompute(ctx context.Context, k string, d time.Duration, fn func() (any, error)) (any, error) {
+	v, ok, err := c.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return v, nil
+	}
+	v, err = fn()
+	if err != nil {
+		return nil, err
+	}
+	return v, c.Set(ctx, k, v, d)
+}
+
+// TTL returns the remaining time to live, NoExpire for items without expiration and false for missing items
+func (c *Cache) TTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	ttl, err := c.client.PTTL(ctx, c.key(k)).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	// redis returns -2 for missing keys and -1 for keys without expiration
+	switch {
+	case ttl == -2:
+		return 0, false, nil
+	case ttl < 0:
+		return NoExpire, true, nil
+	}
+	return ttl, true, nil
+}
+
+func (c *Cache) Delete(ctx context.Context, k string) error {
+	if c.coalesce != nil {
+		c.coalesce.forget(k)
+	}
+	return c.client.Del(ctx, c.key(k)).Err()
+}
+
+// Flush deletes all items under the prefix; without a prefix it flushes the database if WithFlushDB is set
+// and returns ErrFlushWithoutPrefix otherwise
+func (c *Cache) Flush(ctx context.Context) error {
+	if c.prefix == "" && !c.flushDB {
+		return ErrFlushWithoutPrefix
+	}
+	if c.coalesce != nil {
+		c.coalesce.reset()
+	}
+	if c.prefix == "" {
+		if cc, ok := c.client.(masters); ok {
+			return cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+				return node.FlushDB(ctx).Err()
+			})
+		}
+		return c.client.FlushDB(ctx).Err()
+	}
+	return c.scan(ctx, func(keys []string) error {
+		// a DEL of several keys must stay within a slot on redis cluster
+		_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, group := range slot.Split(keys) {
+				pipe.Del(ctx, group...)
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// ItemCount returns the number of items under the prefix, or the size of the database without a prefix
+func (c *Cache) ItemCount(ctx context.Context) (int, error) {
+	if c.prefix == "" {
+		n, err := c.client.DBSize(ctx).Result()
+		return int(n), err
+	}
+	n := 0
+	err := c.scan(ctx, func(keys []string) error {
+		n += len(keys)
+		return nil
+	})
+	return n, err
+}
+
+func (c *Cache) Stats(ctx context.Context) (Stats, error) {
+	n, err := c.ItemCount(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Items:  n,
+	}, nil
+}
+
+// HealthCheck pings the server
+func (c *Cache) HealthCheck(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// masters is implemented by *redis.ClusterClient, whose SCAN and FLUSHDB only reach one node
+type masters interface {
+	ForEachMaster(ctx context.Context, fn func(ctx context.Context, client *redis.Client) error) error
+}
+
+// scan walks the keys under the prefix in batches, on every master of a cluster
+func (c *Cache) scan(ctx context.Context, fn func(keys []string) error) error {
+	if cc, ok := c.client.(masters); ok {
+		var lock sync.Mutex
+		return cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node, c.prefix, func(keys []string) error {
+				// ForEachMaster runs the nodes concurrently
+				lock.Lock()
+				defer lock.Unlock()
+				return fn(keys)
+			})
+		})
+	}
+	return scanNode(ctx, c.client, c.prefix, fn)
+}
+
+func scanNode(ctx context.Context, client redis.Cmdable, prefix string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, escapeGlob(prefix)+"*", 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err = fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// escapeGlob escapes the glob metacharacters of SCAN MATCH, so a prefix like "user[1]:" only matches itself
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
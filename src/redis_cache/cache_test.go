@@ -0,0 +1,105 @@
+package redis_cache
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	c := NewCache(nil, time.Minute)
+	if c.ttl(DefaultExpire) != time.Minute || c.ttl(NoExpire) != 0 || c.ttl(time.Second) != time.Second {
+		t.Fatal("unexpected ttl mapping")
+	}
+	c = NewCache(nil, 0)
+	if c.ttl(DefaultExpire) != 0 {
+		t.Fatal("default expiration should be no expiration")
+	}
+}
+
+func TestFlushWithoutPrefix(t *testing.T) {
+	// refused before any command is sent
+	if err := NewCache(nil, time.Minute).Flush(context.Background()); err != ErrFlushWithoutPrefix {
+		t.Fatalf("expected ErrFlushWithoutPrefix, got %v", err)
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	if got := escapeGlob(`a*b?c[1]\d:`); got != `a\*b\?c\[1\]\\d:` {
+		t.Fatalf("unexpected pattern %s", got)
+	}
+}
+
+// TestCache needs a redis server, set REDIS_ADDR to run it
+func TestCache(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	ctx := context.Background()
+	c := NewCache(redis.NewClient(&redis.Options{Addr: addr}), time.Second*2)
+	defer c.Delete(ctx, "name")
+	defer c.Delete(ctx, "age")
+
+	if err := c.HealthCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "name", "will", DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(c.GetWithExpire(ctx, "name"))
+	if err := c.Replace(ctx, "age", 13, DefaultExpire); err == nil {
+		t.Fatal("replace should fail on a missing key")
+	}
+	t.Log(c.GetOrCompute(ctx, "age", NoExpire, func() (any, error) {
+		return 13, nil
+	}))
+	t.Log(c.MGet(ctx, "name", "age", "missing"))
+
+	err := c.MSet(ctx, map[string]Entry{"name": {Value: "yin", TTL: time.Minute}, "bad": {Value: make(chan int)}})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 1 || batchErr.Errors["bad"] == nil {
+		t.Fatalf("only the key that can't be encoded should fail, got %v", err)
+	}
+	if err = c.DeleteMulti(ctx, "name", "age", "missing"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchErrorOnConnectionFailure(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}), time.Minute)
+	err := c.MSet(ctx, map[string]Entry{"a": {Value: 1}, "b": {Value: 2}})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 2 {
+		t.Fatalf("every key should fail, got %v", err)
+	}
+	res, err := c.MGet(ctx, "a", "b")
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 2 || len(res) != 0 {
+		t.Fatalf("every key should fail, got %v %v", res, err)
+	}
+}
+
+func TestCoalescer(t *testing.T) {
+	co := &coalescer{window: 50 * time.Millisecond, writes: make(map[string]write)}
+	if co.seen("name", []byte(`"will"`), time.Minute) {
+		t.Fatal("the first write should not be coalesced")
+	}
+	if !co.seen("name", []byte(`"will"`), time.Minute) {
+		t.Fatal("an identical write within the window should be coalesced")
+	}
+	if co.seen("name", []byte(`"yin"`), time.Minute) || co.seen("name", []byte(`"yin"`), time.Hour) {
+		t.Fatal("a different value or ttl should be written")
+	}
+	co.forget("name")
+	if co.seen("name", []byte(`"yin"`), time.Hour) {
+		t.Fatal("a forgotten key should be written")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if co.seen("name", []byte(`"yin"`), time.Hour) {
+		t.Fatal("a write after the window should be written")
+	}
+}
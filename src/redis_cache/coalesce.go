@@ -0,0 +1,68 @@
+package redis_cache
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// WithWriteCoalescing skips a Set when the same key was set to the same encoded value with the same TTL
+// through this cache within window, to cut the traffic of chatty callers. A skipped Set doesn't extend
+// the TTL, and a change made to the key by another client within the window is not overwritten, so
+// keep the window short
+func WithWriteCoalescing(window time.Duration) Option {
+	return func(c *Cache) {
+		if window > 0 {
+			c.coalesce = &coalescer{window: window, writes: make(map[string]write)}
+		}
+	}
+}
+
+type write struct {
+	sum uint64
+	ttl time.Duration
+	at  time.Time
+}
+
+type coalescer struct {
+	window time.Duration
+	mu     sync.Mutex
+	writes map[string]write
+	// sweepAt is when the writes older than the window are dropped next
+	sweepAt time.Time
+}
+
+// seen reports whether the write was made within the window, and records it otherwise
+func (co *coalescer) seen(k string, data []byte, ttl time.Duration) bool {
+	h := fnv.New64a()
+	h.Write(data)
+	sum := h.Sum64()
+	now := time.Now()
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if w, ok := co.writes[k]; ok && w.sum == sum && w.ttl == ttl && now.Sub(w.at) < co.window {
+		return true
+	}
+	co.writes[k] = write{sum: sum, ttl: ttl, at: now}
+	if now.After(co.sweepAt) {
+		for key, w := range co.writes {
+			if now.Sub(w.at) >= co.window {
+				delete(co.writes, key)
+			}
+		}
+		co.sweepAt = now.Add(co.window)
+	}
+	return false
+}
+
+func (co *coalescer) forget(k string) {
+	co.mu.Lock()
+	delete(co.writes, k)
+	co.mu.Unlock()
+}
+
+func (co *coalescer) reset() {
+	co.mu.Lock()
+	co.writes = make(map[string]write)
+	co.mu.Unlock()
+}
@@ -0,0 +1,94 @@
+package redis_lock
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+/*
+运维接口: 查看锁当前的持有者, 以及强制释放卡住的锁
+*/
+
+var (
+	//go:embed lua/force_unlock.lua
+	luaForceUnlock string
+)
+
+// AdminBackend 支持运维操作的锁后端
+type AdminBackend interface {
+	// Inspect 返回锁的持有者和剩余过期时间, 锁不存在时 owner 为空
+	Inspect(ctx context.Context, key string) (string, time.Duration, error)
+	// ForceRelease 不校验持有者直接删除锁
+	ForceRelease(ctx context.Context, key string) error
+}
+
+// Inspect 查看锁的持有者和剩余过期时间, 锁不存在时 owner 为空字符串
+func (c *Client) Inspect(ctx context.Context, key string) (owner string, ttl time.Duration, err error) {
+	admin, ok := c.backend.(AdminBackend)
+	if !ok {
+		return "", 0, ErrBackendNotSupported
+	}
+	return admin.Inspect(ctx, key)
+}
+
+// ForceUnlock 强制释放锁, 原持有者后续的续约和解锁都会返回 ErrLockNotHold
+func (c *Client) ForceUnlock(ctx context.Context, key string) error {
+	admin, ok := c.backend.(AdminBackend)
+	if !ok {
+		return ErrBackendNotSupported
+	}
+	return admin.ForceRelease(ctx, key)
+}
+
+func (r *redisBackend) Inspect(ctx context.Context, key string) (string, time.Duration, error) {
+	var (
+		get  *redis.StringCmd
+		pttl *redis.DurationCmd
+	)
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err == redis.Nil {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	ttl := pttl.Val()
+	// 没有设置过期时间
+	if ttl < 0 {
+		ttl = 0
+	}
+	return get.Val(), ttl, nil
+}
+
+func (r *redisBackend) ForceRelease(ctx context.Context, key string) error {
+	return r.client.Eval(ctx, luaForceUnlock, []string{key}, releaseChannel(key)).Err()
+}
+
+func (m *MemoryBackend) Inspect(ctx context.Context, key string) (string, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.get(key)
+	if !ok {
+		return "", 0, nil
+	}
+	return l.val, time.Until(l.expireAt), nil
+}
+
+func (m *MemoryBackend) ForceRelease(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.locks, key)
+	return nil
+}
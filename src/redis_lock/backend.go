@@ -0,0 +1,62 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// LockBackend 锁的存储后端, 默认使用 redis + lua 脚本实现, 也可以使用内存实现用于单测或者单机降级
+type LockBackend interface {
+	// Acquire 尝试加锁, 成功时返回 fencing token(大于 0), 锁被别人持有时返回 0
+	// 相同的 val 可以重入, 重入时刷新过期时间
+	Acquire(ctx context.Context, key string, val string, expiration time.Duration) (int64, error)
+	// Release 释放锁, 锁已经不被 val 持有时返回 false
+	Release(ctx context.Context, key string, val string) (bool, error)
+	// Refresh 续约, 锁已经不被 val 持有时返回 false
+	Refresh(ctx context.Context, key string, val string, expiration time.Duration) (bool, error)
+	// Transfer 把锁交给 newVal, 保留剩余的过期时间, 锁已经不被 val 持有时返回 false
+	Transfer(ctx context.Context, key string, val string, newVal string) (bool, error)
+}
+
+// NewRedisBackend 基于 redis lua 脚本的锁后端
+func NewRedisBackend(c redis.Cmdable) LockBackend {
+	return &redisBackend{
+		client: c,
+	}
+}
+
+type redisBackend struct {
+	client redis.Cmdable
+}
+
+func (r *redisBackend) Acquire(ctx context.Context, key string, val string, expiration time.Duration) (int64, error) {
+	if err := checkSlot(r.client, key, fencingKey(key)); err != nil {
+		return 0, err
+	}
+	return r.client.Eval(ctx, luaLock, []string{key, fencingKey(key)}, val, expiration.Milliseconds()).Int64()
+}
+
+func (r *redisBackend) Release(ctx context.Context, key string, val string) (bool, error) {
+	res, err := r.client.Eval(ctx, luaUnlock, []string{key}, val, releaseChannel(key)).Int64()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	return res == DelSuccess, nil
+}
+
+func (r *redisBackend) Refresh(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	res, err := r.client.Eval(ctx, luaRefresh, []string{key}, val, expiration.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == NotExistKey, nil
+}
+
+func (r *redisBackend) Transfer(ctx context.Context, key string, val string, newVal string) (bool, error) {
+	res, err := r.client.Eval(ctx, luaTransfer, []string{key}, val, newVal).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
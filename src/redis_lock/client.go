@@ -1,6 +1,7 @@
 package redis_lock
 
 import (
+	"cache/src/logging"
 	"context"
 	"errors"
 	"fmt"
@@ -8,18 +9,84 @@ import (
 	"time"
 )
 
-var (
-	FailToGetLock = errors.New("Fail To Get Lock")
-)
-
 type Client struct {
-	client redis.Cmdable
+	// client 为 nil 时只能使用 LockBackend 支持的操作
+	client     redis.Cmdable
+	backend    LockBackend
+	subscriber Subscriber
+	watchdog   time.Duration
+	ins        Instrumentation
+	keyPattern func(key string) string
+	local      *localLocks
+
+	maxHold      time.Duration
+	forceRelease bool
+	logger       logging.Logger
+}
+
+type ClientOption func(c *Client)
+
+// Subscriber 支持订阅的 redis 客户端, *redis.Client / *redis.ClusterClient 都满足
+type Subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// WithSubscriber 开启订阅等待: 等待者订阅锁的释放消息, 锁被释放时立刻重试, 而不是固定间隔轮询
+// 重试策略依然生效, 作为锁自然过期(没有释放消息)时的兜底
+func WithSubscriber(sub Subscriber) ClientOption {
+	return func(c *Client) {
+		c.subscriber = sub
+	}
+}
+
+// WithWatchdog 开启看门狗: 每个加锁成功的锁都会自动以 interval 间隔续约, 直到 UnLock
+// 续约失败时锁的 Done 会被关闭, 通过 Lock.Err 获取原因
+func WithWatchdog(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.watchdog = interval
+	}
+}
+
+// WithLogger 设置记录告警的 Logger: 看门狗续约失败、锁丢失和 panic
+func WithLogger(l logging.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithMaxHold 限制锁的最大持有时长, 防止失控的临界区借助看门狗一直持有锁
+// 超过 maxHold 后停止续约, 关闭锁的 Done(Err 返回 ErrMaxHoldExceeded), forceRelease 为 true 时同时释放锁
+func WithMaxHold(maxHold time.Duration, forceRelease bool) ClientOption {
+	return func(c *Client) {
+		c.maxHold, c.forceRelease = maxHold, forceRelease
+	}
 }
 
-func NewClient(c redis.Cmdable) *Client {
-	return &Client{
-		client: c,
+// NewClient 使用 redis 客户端创建, 可以是任意 redis.UniversalClient, 集群上的注意事项见 HashTag
+func NewClient(c redis.Cmdable, opts ...ClientOption) *Client {
+	res := &Client{
+		client:  c,
+		backend: NewRedisBackend(c),
+		ins:     nopInstrumentation{},
+	}
+	for _, opt := range opts {
+		opt(res)
 	}
+	return res
+}
+
+// NewClientWithBackend 使用自定义后端创建客户端
+// 支持 Lock / TryLock / TryLockWithTimeout 以及返回的 Lock 上的操作,
+// 信号量、多 key 锁、公平锁和运维接口需要后端实现对应的 SemaphoreBackend / MultiBackend / FairBackend / AdminBackend, 否则返回 ErrBackendNotSupported
+func NewClientWithBackend(b LockBackend, opts ...ClientOption) *Client {
+	res := &Client{
+		backend: b,
+		ins:     nopInstrumentation{},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
 }
 
 func (c *Client) Lock(ctx context.Context, key string, val string, expiration time.Duration, retry RetryStrategy, timeout time.Duration) (*Lock, error) {
@@ -36,7 +103,90 @@ func (c *Client) Lock(ctx context.Context, key string, val string, expiration ti
 				r runtimeTimer
 			}
 	*/
-	var timer *time.Timer
+	if err := checkExpiration(expiration); err != nil {
+		return nil, err
+	}
+	start, attempts := time.Now(), 0
+	release, err := c.lockLocal(ctx, key)
+	if err != nil {
+		c.ins.ObserveAcquire(c.label(key), time.Since(start), 0, err)
+		return nil, err
+	}
+	watch, unsubscribe := c.watchRelease(key)
+	defer unsubscribe()
+	token, err := acquire(ctx, retry, timeout, watch, func(ctx context.Context) (int64, error) {
+		attempts++
+		return c.backend.Acquire(ctx, key, val, expiration)
+	})
+	c.ins.ObserveAcquire(c.label(key), time.Since(start), attempts-1, err)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	l := newLock(c.backend, key, val, expiration, token)
+	l.release = release
+	return c.track(l), nil
+}
+
+// lockLocal 开启进程内锁时先在本地竞争, 返回本地锁的释放函数
+func (c *Client) lockLocal(ctx context.Context, key string) (func(), error) {
+	if c.local == nil {
+		return func() {}, nil
+	}
+	return c.local.lock(ctx, key)
+}
+
+// track 为新获得的锁设置监控, 按需启动看门狗和最大持有时长的检查
+func (c *Client) track(l *Lock) *Lock {
+	l.ins, l.label, l.logger = c.ins, c.label(l.key), c.logger
+	if c.watchdog > 0 {
+		go l.watchdog(c.watchdog)
+	}
+	if c.maxHold > 0 {
+		go l.guardHold(c.maxHold, c.forceRelease)
+	}
+	return l
+}
+
+// watchRelease 返回订阅锁的释放消息的函数和取消订阅的函数, 没有开启订阅时订阅函数为 nil
+// acquire 在第一次加锁失败后才订阅, 锁空闲时加锁不需要额外的订阅往返
+func (c *Client) watchRelease(key string) (func(ctx context.Context) (<-chan *redis.Message, error), func()) {
+	if c.subscriber == nil || c.client == nil {
+		return nil, func() {}
+	}
+	var pubsub *redis.PubSub
+	watch := func(ctx context.Context) (<-chan *redis.Message, error) {
+		p := c.subscriber.Subscribe(ctx, releaseChannel(key))
+		// 等待订阅生效, 避免在订阅成功之前错过释放消息
+		if _, err := p.Receive(ctx); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+		pubsub = p
+		return p.Channel(), nil
+	}
+	return watch, func() {
+		if pubsub != nil {
+			_ = pubsub.Close()
+		}
+	}
+}
+
+// releaseChannel 锁释放时发布消息的 channel
+func releaseChannel(key string) string {
+	return key + ":released"
+}
+
+// acquire 按照重试策略反复执行 attempt, 直到 attempt 返回正数(加锁成功)、重试机会耗尽或 ctx 结束
+// 每次 attempt 都使用单独的超时时间 timeout; watch 不为 nil 时在第一次加锁失败后订阅, 订阅生效后立即重试一次,
+// 避免错过订阅之前的释放, 之后订阅收到消息时不再等待重试间隔, 立即重试
+func acquire(ctx context.Context, retry RetryStrategy, timeout time.Duration,
+	watch func(ctx context.Context) (<-chan *redis.Message, error),
+	attempt func(ctx context.Context) (int64, error)) (int64, error) {
+	var (
+		timer  *time.Timer
+		wakeup <-chan *redis.Message
+	)
 	defer func() {
 		if timer != nil {
 			timer.Stop()
@@ -44,25 +194,41 @@ func (c *Client) Lock(ctx context.Context, key string, val string, expiration ti
 	}()
 	for {
 		tCtx, cancelFunc := context.WithTimeout(ctx, timeout)
-		res, err := c.client.Eval(tCtx, luaLock, []string{key}, val, expiration.Seconds()).Result()
+		res, err := attempt(tCtx)
 		cancelFunc()
+		// 加锁成功, 即使 ctx 刚好结束也要返回锁, 否则锁在后端一直被占用到过期
+		if err == nil && res > 0 {
+			return res, nil
+		}
+		// 调用方的 ctx 已经结束, 不再重试
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
 		// 加锁超时了直接返回错误即可
-		if err != nil && err == context.DeadlineExceeded {
-			return nil, err
+		if err != nil && errors.Is(err, context.DeadlineExceeded) {
+			return 0, err
 		}
-		// 加锁成功
-		if res == "OK" {
-			return newLock(c.client, key, val, expiration), nil
+		if err == nil && watch != nil {
+			if wakeup, err = watch(ctx); err != nil {
+				return 0, err
+			}
+			watch = nil
+			continue
 		}
 		// 加锁未超时且加锁失败，那就重试几次
-		interval, ok := retry.Next()
+		var (
+			interval time.Duration
+			ok       bool
+		)
+		if retry != nil {
+			interval, ok = retry.Next()
+		}
 		if !ok {
+			// 最后一次失败的原因: 锁被人持有或者 redis 返回的错误
 			if err == nil {
-				err = fmt.Errorf("锁被人持有")
-			} else {
-				err = fmt.Errorf("最后一次重试错误: %w", err)
+				err = ErrLockHeldByOther
 			}
-			return nil, fmt.Errorf("重试机会耗尽, %w", err)
+			return 0, wrap(ErrRetriesExhausted, err)
 		}
 		if timer == nil {
 			timer = time.NewTimer(interval)
@@ -71,22 +237,69 @@ func (c *Client) Lock(ctx context.Context, key string, val string, expiration ti
 		}
 		select {
 		case <-timer.C:
+		case <-wakeup:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return 0, ctx.Err()
 		}
 	}
 }
 
 func (c *Client) TryLock(ctx context.Context,
 	key string, val any, expiration time.Duration) (*Lock, error) {
-	ok, err := c.client.SetNX(ctx, key, val, expiration).Result()
+	if err := checkExpiration(expiration); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	release := func() {}
+	if c.local != nil {
+		var ok bool
+		// 本地已经有协程持有锁, 不需要再访问 redis
+		if release, ok = c.local.tryLock(key); !ok {
+			c.ins.ObserveAcquire(c.label(key), time.Since(start), 0, ErrLockHeldByOther)
+			return nil, ErrLockHeldByOther
+		}
+	}
+	token, err := c.backend.Acquire(ctx, key, fmt.Sprint(val), expiration)
+	if err == nil && token <= 0 {
+		err = ErrLockHeldByOther
+	}
+	c.ins.ObserveAcquire(c.label(key), time.Since(start), 0, err)
 	if err != nil {
+		release()
 		return nil, err
 	}
-	if !ok {
-		return nil, FailToGetLock
+	l := newLock(c.backend, key, fmt.Sprint(val), expiration, token)
+	l.release = release
+	return c.track(l), nil
+}
+
+// TryLockWithTimeout 在 maxWait 时间内不断尝试加锁, 介于只尝试一次的 TryLock 和按重试策略加锁的 Lock 之间
+// 超过 maxWait 仍未获得锁时返回 ErrLockHeldByOther
+func (c *Client) TryLockWithTimeout(ctx context.Context, key string, val string, expiration time.Duration, maxWait time.Duration) (*Lock, error) {
+	wCtx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+	retry := &LimitedTotalTimeRetry{
+		Interval:   tryLockInterval(maxWait),
+		MaxElapsed: maxWait,
+	}
+	l, err := c.Lock(wCtx, key, val, expiration, retry, maxWait)
+	if err != nil && ctx.Err() == nil &&
+		(errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrRetriesExhausted)) {
+		return nil, ErrLockHeldByOther
+	}
+	return l, err
+}
+
+// tryLockInterval 重试间隔取 maxWait 的十分之一, 限制在 [10ms, 100ms] 之间
+func tryLockInterval(maxWait time.Duration) time.Duration {
+	interval := maxWait / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > 100*time.Millisecond {
+		interval = 100 * time.Millisecond
 	}
-	return newLock(c.client, key, val, expiration), nil
+	return interval
 }
 
 /*
@@ -0,0 +1,1035 @@
+package redis_lock
+
+import (
+	"cache/src/logging"
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockRetry(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	l, err := c.Lock(ctx, "key", "a", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("token", l.Token())
+
+	// 锁被 a 持有, b 重试耗尽
+	_, err = c.Lock(ctx, "key", "b", time.Second, &FixIntervalRetry{Interval: 10 * time.Millisecond, Max: 3}, time.Second)
+	if !errors.Is(err, ErrRetriesExhausted) || !errors.Is(err, ErrLockHeldByOther) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a 释放之后 b 重试成功, token 递增
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = l.UnLock(ctx)
+	}()
+	l2, err := c.Lock(ctx, "key", "b", time.Second, &FixIntervalRetry{Interval: 10 * time.Millisecond, Max: 20}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l2.Token() <= l.Token() {
+		t.Fatalf("token should increase, got %d after %d", l2.Token(), l.Token())
+	}
+}
+
+// TestLockCancelledAfterAcquire ctx 在加锁成功之后才结束, 依然返回锁, 不会让锁在后端悬空
+func TestLockCancelledAfterAcquire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &cancelBackend{LockBackend: NewMemoryBackend(), cancel: cancel}
+	c := NewClientWithBackend(b)
+
+	l, err := c.Lock(ctx, "key", "a", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.UnLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next 依次调用 Next, 返回每次的间隔, 直到策略不再允许重试或者达到 limit 次
+func next(r RetryStrategy, limit int) []time.Duration {
+	var res []time.Duration
+	for i := 0; i < limit; i++ {
+		interval, ok := r.Next()
+		if !ok {
+			break
+		}
+		res = append(res, interval)
+	}
+	return res
+}
+
+func TestRetryStrategy(t *testing.T) {
+	ms := time.Millisecond
+	testCases := []struct {
+		name  string
+		retry RetryStrategy
+		want  []time.Duration
+	}{
+		{name: "fix interval", retry: &FixIntervalRetry{Interval: ms, Max: 3}, want: []time.Duration{ms, ms, ms}},
+		{name: "no retry", retry: &FixIntervalRetry{Interval: ms}},
+		{name: "exponential growth", retry: &ExponentialBackoffRetry{Initial: ms, Multiplier: 3, Max: 4},
+			want: []time.Duration{ms, 3 * ms, 9 * ms, 27 * ms}},
+		{name: "default multiplier", retry: &ExponentialBackoffRetry{Initial: ms, Max: 3},
+			want: []time.Duration{ms, 2 * ms, 4 * ms}},
+		{name: "max interval", retry: &ExponentialBackoffRetry{Initial: ms, MaxInterval: 5 * ms, Max: 5},
+			want: []time.Duration{ms, 2 * ms, 4 * ms, 5 * ms, 5 * ms}},
+		{name: "zero initial", retry: &ExponentialBackoffRetry{Max: 2},
+			want: []time.Duration{minBackoffInterval, 2 * minBackoffInterval}},
+		{name: "jitter without strategy", retry: &JitterRetry{Jitter: 0.2}},
+		{name: "max elapsed without strategy", retry: WithMaxElapsed(nil, time.Second)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := next(tc.retry, 10); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// 总时长包括等待的间隔: 第 3 次重试会在 200ms 之后, 超过 250ms 的限制
+	elapsed := WithMaxElapsed(&FixIntervalRetry{Interval: 100 * ms, Max: 10}, 250*ms)
+	for i := 0; ; i++ {
+		interval, ok := elapsed.Next()
+		if !ok {
+			if i != 2 {
+				t.Fatalf("expected 2 retries within the max elapsed time, got %d", i)
+			}
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	// 抖动在 [0.8, 1.2] 倍间隔之间, 次数与被包装的策略一致
+	jitter := &JitterRetry{Strategy: &FixIntervalRetry{Interval: 100 * ms, Max: 100}, Jitter: 0.2}
+	got := next(jitter, 1000)
+	if len(got) != 100 {
+		t.Fatalf("expected 100 retries, got %d", len(got))
+	}
+	for _, interval := range got {
+		if interval < 80*ms || interval > 120*ms {
+			t.Fatalf("interval %v out of the jitter bounds", interval)
+		}
+	}
+}
+
+// recordingInstrumentation 记录每次埋点调用
+type recordingInstrumentation struct {
+	mu       sync.Mutex
+	acquires []acquireRecord
+	refreshs []error
+	releases []error
+}
+
+type acquireRecord struct {
+	key     string
+	retries int
+	err     error
+}
+
+func (r *recordingInstrumentation) ObserveAcquire(key string, latency time.Duration, retries int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.acquires = append(r.acquires, acquireRecord{key: key, retries: retries, err: err})
+}
+
+func (r *recordingInstrumentation) ObserveRefresh(key string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshs = append(r.refreshs, err)
+}
+
+func (r *recordingInstrumentation) ObserveRelease(key string, held time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.releases = append(r.releases, err)
+}
+
+// lastAcquire 返回最近一次加锁的记录
+func (r *recordingInstrumentation) lastAcquire() acquireRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.acquires[len(r.acquires)-1]
+}
+
+func TestInstrumentation(t *testing.T) {
+	ins := &recordingInstrumentation{}
+	c := NewClientWithBackend(NewMemoryBackend(), WithInstrumentation(ins), WithKeyPattern(func(key string) string {
+		return "order:*"
+	}))
+	ctx := context.Background()
+
+	// 第一次就加锁成功, 没有重试
+	l, err := c.Lock(ctx, "order:1", "a", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := ins.lastAcquire(); rec != (acquireRecord{key: "order:*"}) {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+
+	// 重试耗尽, 重试次数不包括第一次尝试
+	_, err = c.Lock(ctx, "order:1", "b", time.Second, &FixIntervalRetry{Interval: time.Millisecond, Max: 2}, time.Second)
+	if rec := ins.lastAcquire(); rec.retries != 2 || rec.err != err || !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("unexpected record %+v for %v", rec, err)
+	}
+
+	// ctx 超时
+	tCtx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	_, err = c.Lock(tCtx, "order:1", "b", time.Second, &FixIntervalRetry{Interval: 10 * time.Millisecond, Max: 100}, time.Second)
+	cancel()
+	if rec := ins.lastAcquire(); rec.retries < 1 || rec.err != context.DeadlineExceeded || err != context.DeadlineExceeded {
+		t.Fatalf("unexpected record %+v for %v", rec, err)
+	}
+
+	// 重试之后成功
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = l.Refresh(ctx)
+		_ = l.UnLock(ctx)
+	}()
+	l2, err := c.Lock(ctx, "order:1", "b", time.Second, &FixIntervalRetry{Interval: 5 * time.Millisecond, Max: 100}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := ins.lastAcquire(); rec.retries < 1 || rec.err != nil {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	_, err = c.TryLock(ctx, "order:1", "c", time.Second)
+	if rec := ins.lastAcquire(); rec.retries != 0 || rec.err != ErrLockHeldByOther || err != ErrLockHeldByOther {
+		t.Fatalf("unexpected record %+v for %v", rec, err)
+	}
+	if err = l2.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = l2.UnLock(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+	if len(ins.acquires) != 5 || len(ins.refreshs) != 1 || ins.refreshs[0] != nil {
+		t.Fatalf("unexpected records %+v %v", ins.acquires, ins.refreshs)
+	}
+	if len(ins.releases) != 3 || ins.releases[0] != nil || ins.releases[1] != nil || ins.releases[2] != ErrLockNotHold {
+		t.Fatalf("unexpected releases %v", ins.releases)
+	}
+}
+
+func TestUnLockByWrongOwner(t *testing.T) {
+	b := NewMemoryBackend()
+	c := NewClientWithBackend(b)
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.TryLock(ctx, "key", "b", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 伪造一个 b 持有的锁去解锁
+	fake := newLock(b, "key", "b", time.Second, 0)
+	if err = fake.UnLock(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = l.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.UnLock(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRefreshAfterExpired(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	// 锁过期后被 b 抢走, a 续约失败并收到锁丢失通知
+	if _, err = c.TryLock(ctx, "key", "b", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Refresh(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-l.Done():
+	default:
+		t.Fatal("Done should be closed after lock lost")
+	}
+	if l.Err() != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", l.Err())
+	}
+}
+
+func TestAutoRefresh(t *testing.T) {
+	b := &refreshNotifyBackend{LockBackend: NewMemoryBackend(), refreshed: make(chan struct{}, 1)}
+	c := NewClientWithBackend(b)
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- l.AutoRefresh(10*time.Millisecond, time.Second)
+	}()
+	// 等到成功续约几次, 不依赖调度的快慢
+	for i := 0; i < 3; i++ {
+		select {
+		case <-b.refreshed:
+		case <-time.After(time.Second):
+			t.Fatal("AutoRefresh should keep refreshing the lock")
+		}
+	}
+	if _, err = c.TryLock(ctx, "key", "b", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = l.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AutoRefresh should return after UnLock")
+	}
+	if l.Err() != nil {
+		t.Fatalf("UnLock should not mark the lock lost: %v", l.Err())
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	b := NewMemoryBackend()
+	logged := make(chan string, 1)
+	logger := logging.Func(func(level logging.Level, msg string, keyvals ...any) {
+		logged <- msg
+	})
+	c := NewClientWithBackend(b, WithWatchdog(10*time.Millisecond), WithLogger(logger))
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err = c.TryLock(ctx, "key", "b", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 锁被强制删除后, 看门狗续约失败并关闭 Done
+	if _, err = b.Release(ctx, "key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done should be closed after watchdog failed")
+	}
+	if msg := <-logged; msg != "redis_lock: watchdog lost the lock" {
+		t.Fatalf("unexpected log: %s", msg)
+	}
+}
+
+// TestWatchdogFailover 主从切换期间的连接错误不会让看门狗放弃, 锁过期之后才判定丢失
+func TestWatchdogFailover(t *testing.T) {
+	b := &flakyBackend{LockBackend: NewMemoryBackend()}
+	c := NewClientWithBackend(b, WithWatchdog(10*time.Millisecond))
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.UnLock(ctx)
+	b.failing.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	b.failing.Store(false)
+	time.Sleep(50 * time.Millisecond)
+	if l.Err() != nil {
+		t.Fatalf("the lock should survive a short failover: %v", l.Err())
+	}
+
+	b.failing.Store(true)
+	select {
+	case <-l.Done():
+		if !errors.Is(l.Err(), io.EOF) {
+			t.Fatalf("unexpected error: %v", l.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Done should be closed once the lock expired")
+	}
+}
+
+func TestClusterSlots(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:1"}})
+	defer rdb.Close()
+	c := NewClient(rdb)
+	// 检查发生在访问 redis 之前
+	if _, err := c.TryLock(ctx, "order:1", "a", time.Second); err != ErrCrossSlot {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.LockMulti(ctx, []string{"{order}:1", "{user}:1"}, "a", time.Second, nil, time.Second); err != ErrCrossSlot {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if HashTag("order:1") != "{order:1}" || HashTag("{order}:1") != "{order}:1" {
+		t.Fatal("HashTag should only tag keys without a hash tag")
+	}
+}
+
+func TestMaxHold(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend(), WithWatchdog(10*time.Millisecond), WithMaxHold(50*time.Millisecond, true))
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done should be closed after max hold exceeded")
+	}
+	if l.Err() != ErrMaxHoldExceeded {
+		t.Fatalf("unexpected error: %v", l.Err())
+	}
+	// 超时后锁被强制释放
+	time.Sleep(10 * time.Millisecond)
+	if _, err = c.TryLock(ctx, "key", "b", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTryLockWithTimeout(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err = c.TryLockWithTimeout(ctx, "key", "b", time.Second, 50*time.Millisecond); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Log("waited", time.Since(start))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = l.UnLock(ctx)
+	}()
+	if _, err = c.TryLockWithTimeout(ctx, "key", "b", time.Second, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := l.Transfer(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Token() != l.Token() {
+		t.Fatalf("token should be kept, got %d want %d", next.Token(), l.Token())
+	}
+	if err = l.UnLock(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = next.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalLock(t *testing.T) {
+	var calls int64
+	b := &countingBackend{LockBackend: NewMemoryBackend(), calls: &calls}
+	c := NewClientWithBackend(b, WithLocalLock())
+	ctx := context.Background()
+
+	var (
+		wg  sync.WaitGroup
+		cnt int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := c.Lock(ctx, "key", "a", time.Second, nil, time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			cnt++
+			_ = l.UnLock(ctx)
+		}()
+	}
+	wg.Wait()
+	// 同一进程内先在本地排队, 每个协程只访问一次后端
+	if cnt != 10 || atomic.LoadInt64(&calls) != 10 {
+		t.Fatalf("cnt %d, backend calls %d", cnt, calls)
+	}
+}
+
+func TestInspectAndForceUnlock(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, ttl, err := c.Inspect(ctx, "key")
+	if err != nil || owner != "a" || ttl <= 0 {
+		t.Fatalf("unexpected inspect result: %s %v %v", owner, ttl, err)
+	}
+	if err = c.ForceUnlock(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _, _ = c.Inspect(ctx, "key"); owner != "" {
+		t.Fatalf("lock should be released, owner %s", owner)
+	}
+	if err = l.Refresh(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestInvalidExpiration(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	if _, err := c.TryLock(context.Background(), "key", "a", time.Microsecond); err != ErrInvalidExpiration {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 只实现了 LockBackend 的后端不支持公平锁
+	c = NewClientWithBackend(&countingBackend{LockBackend: NewMemoryBackend(), calls: new(int64)})
+	if _, err := c.FairLock(context.Background(), "key", "a", time.Second, nil, time.Second); err != ErrBackendNotSupported {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFairLock(t *testing.T) {
+	b := NewMemoryBackend()
+	c := NewClientWithBackend(b)
+	ctx := context.Background()
+
+	l, err := c.FairLock(ctx, "key", "a", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b 先于 c 排队
+	for _, val := range []string{"b", "c"} {
+		if token, err := b.AcquireFair(ctx, "key", val, time.Second, FairLockWaiterTimeout); err != nil || token != 0 {
+			t.Fatalf("%s should wait in the queue, got %d %v", val, token, err)
+		}
+	}
+	if err = l.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 锁空闲了, 但 c 不在队首, 只能等 b
+	if _, err = c.FairLock(ctx, "key", "c", time.Second, &FixIntervalRetry{Interval: time.Millisecond, Max: 2}, time.Second); !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("c should not jump the queue: %v", err)
+	}
+	lb, err := c.FairLock(ctx, "key", "b", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb.Token() != l.Token()+1 {
+		t.Fatalf("unexpected token %d after %d", lb.Token(), l.Token())
+	}
+
+	// c 放弃时已经离开队列, d 不需要等它
+	if _, err = c.FairLock(ctx, "key", "d", time.Second, nil, time.Second); !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = lb.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.TryLock(ctx, "key", "x", time.Second); err != nil {
+		t.Fatalf("the queue should be empty after the waiters gave up: %v", err)
+	}
+}
+
+// TestFairLockWaiterTimeout 队首的等待者超过 FairLockWaiterTimeout 没有再次尝试, 会被移出队列
+func TestFairLockWaiterTimeout(t *testing.T) {
+	b := NewMemoryBackend()
+	ctx := context.Background()
+
+	if token, err := b.AcquireFair(ctx, "key", "a", time.Second, time.Second); err != nil || token == 0 {
+		t.Fatalf("unexpected acquire result %d %v", token, err)
+	}
+	if token, _ := b.AcquireFair(ctx, "key", "b", time.Second, time.Second); token != 0 {
+		t.Fatal("b should wait in the queue")
+	}
+	if _, err := b.Release(ctx, "key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// b 的心跳还在, c 排在 b 后面
+	if token, _ := b.AcquireFair(ctx, "key", "c", time.Second, 30*time.Millisecond); token != 0 {
+		t.Fatal("c should wait behind b")
+	}
+	time.Sleep(20 * time.Millisecond)
+	// c 再次尝试, 刷新自己的心跳
+	if token, _ := b.AcquireFair(ctx, "key", "c", time.Second, 30*time.Millisecond); token != 0 {
+		t.Fatal("c should still wait behind b")
+	}
+	time.Sleep(20 * time.Millisecond)
+	// b 的心跳过期被移出队列, c 成为队首
+	if token, err := b.AcquireFair(ctx, "key", "c", time.Second, 30*time.Millisecond); err != nil || token == 0 {
+		t.Fatalf("c should get the lock after b timed out: %d %v", token, err)
+	}
+}
+
+func TestRWLock(t *testing.T) {
+	c := NewRWClientWithBackend(NewMemoryBackend(), WithWriterPreference())
+	ctx := context.Background()
+
+	r1, err := c.RLock(ctx, "key", "r1", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := c.RLock(ctx, "key", "r2", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.WLock(ctx, "key", "w", time.Second, nil, time.Second); !errors.Is(err, ErrLockHeldByOther) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 写者登记等待之后, 新的读者被拒绝, 已有的读者可以续约
+	if _, err = c.RLock(ctx, "key", "r3", time.Second, nil, time.Second); !errors.Is(err, ErrLockHeldByOther) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = r1.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = r1.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = r1.UnLock(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = r2.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.WLock(ctx, "key", "w", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.RLock(ctx, "key", "r1", time.Second, nil, time.Second); !errors.Is(err, ErrLockHeldByOther) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = w.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Refresh(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	sem := c.Semaphore("sem", 2)
+	for _, val := range []string{"a", "b"} {
+		if err := sem.TryAcquire(ctx, val, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sem.TryAcquire(ctx, "c", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 已经持有许可的可以续期
+	if err := sem.TryAcquire(ctx, "a", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// a 的许可过期之后被回收
+	time.Sleep(40 * time.Millisecond)
+	if err := sem.Acquire(ctx, "c", time.Second, nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := sem.Release(ctx, "a"); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sem.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sem.TryAcquire(ctx, "d", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockMulti(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key:2", "b", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 有一个 key 被别人持有, 所有 key 都不加锁
+	if _, err = c.LockMulti(ctx, []string{"key:2", "key:1"}, "a", time.Second, nil, time.Second); !errors.Is(err, ErrLockHeldByOther) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if owner, _, _ := c.Inspect(ctx, "key:1"); owner != "" {
+		t.Fatalf("key:1 should not be locked, owner %s", owner)
+	}
+	if err = l.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ml, err := c.LockMulti(ctx, []string{"key:2", "key:1", "key:2"}, "a", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := ml.Keys(); len(keys) != 2 || keys[0] != "key:1" || keys[1] != "key:2" {
+		t.Fatalf("keys should be sorted and deduplicated: %v", keys)
+	}
+	if _, err = c.TryLock(ctx, "key:1", "b", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = ml.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 其中一个 key 被强制释放后, 续约和解锁都报告锁已丢失
+	if err = c.ForceUnlock(ctx, "key:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ml.Refresh(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = ml.UnLock(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if owner, _, _ := c.Inspect(ctx, "key:2"); owner != "" {
+		t.Fatalf("key:2 should be released, owner %s", owner)
+	}
+}
+
+func TestElector(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend())
+	ctx := context.Background()
+
+	a := c.NewElector("leader", "a", 300*time.Millisecond)
+	b := c.NewElector("leader", "b", 300*time.Millisecond)
+	if err := a.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Campaign(ctx); err != ErrAlreadyCampaigning {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if leader, err := a.Leader(ctx); err != nil || leader != "a" {
+		t.Fatalf("unexpected leader: %s %v", leader, err)
+	}
+	elected := make(chan error, 1)
+	go func() {
+		elected <- b.Campaign(ctx)
+	}()
+	// a 的续约让 leadership 一直保持, b 只能等待
+	time.Sleep(150 * time.Millisecond)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatal("a should still be the leader")
+	}
+	if err := a.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-elected:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("b should be elected after a resigned")
+	}
+	if leader, err := b.Leader(ctx); err != nil || leader != "b" {
+		t.Fatalf("unexpected leader: %s %v", leader, err)
+	}
+	if v := <-a.Observe(); !v {
+		t.Fatal("a should observe becoming the leader first")
+	}
+	if v := <-a.Observe(); v {
+		t.Fatal("a should observe losing the leadership")
+	}
+
+	// leadership 被强制收回后, b 发现锁丢失并重新当选
+	if v := <-b.Observe(); !v {
+		t.Fatal("b should observe becoming the leader")
+	}
+	if err := c.ForceUnlock(ctx, "leader"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []bool{false, true} {
+		select {
+		case v := <-b.Observe():
+			if v != want {
+				t.Fatalf("unexpected leadership change %v", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("b should be re-elected")
+		}
+	}
+	if err := b.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Leader(ctx); err != ErrNoLeader {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// TestElectorLocalLock 失去 leadership 时释放进程内的本地锁, 否则永远无法重新当选
+func TestElectorLocalLock(t *testing.T) {
+	c := NewClientWithBackend(NewMemoryBackend(), WithLocalLock())
+	ctx := context.Background()
+
+	e := c.NewElector("leader", "a", 300*time.Millisecond)
+	if err := e.Campaign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Resign(ctx)
+	if err := c.ForceUnlock(ctx, "leader"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []bool{true, false, true} {
+		select {
+		case v := <-e.Observe():
+			if v != want {
+				t.Fatalf("unexpected leadership change %v", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("a should be re-elected")
+		}
+	}
+}
+
+// TestRedisBackendResult 使用 mock 的 redis.Cmdable 校验脚本返回值的处理
+func TestRedisBackendResult(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockCmdable{}
+	c := NewClient(mock)
+
+	mock.res, mock.err = int64(0), nil
+	if _, err := c.TryLock(ctx, "key", "a", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mock.res = int64(7)
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil || l.Token() != 7 {
+		t.Fatalf("unexpected lock: %v %v", l, err)
+	}
+
+	// 解锁脚本返回 0 表示锁已不是自己的
+	mock.res = int64(0)
+	if err = l.UnLock(ctx); err != ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// redis 返回的错误原样返回, 续约超时包装为 ErrRefreshTimeout
+	mock.res, mock.err = nil, context.DeadlineExceeded
+	if err = l.Refresh(ctx); !errors.Is(err, ErrRefreshTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// countingSubscriber 记录订阅次数, 订阅连接的是不存在的 redis, 等待订阅生效时返回错误
+type countingSubscriber struct {
+	rdb   *redis.Client
+	calls int
+}
+
+func (s *countingSubscriber) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	s.calls++
+	return s.rdb.Subscribe(ctx, channels...)
+}
+
+func TestLazySubscribe(t *testing.T) {
+	sub := &countingSubscriber{rdb: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})}
+	defer sub.rdb.Close()
+	mock := &mockCmdable{res: int64(1)}
+	c := NewClient(mock, WithSubscriber(sub))
+	ctx := context.Background()
+
+	// 锁空闲时第一次加锁就成功, 不需要订阅
+	if _, err := c.Lock(ctx, "key", "a", time.Second, nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if sub.calls != 0 {
+		t.Fatalf("an uncontended lock should not subscribe, got %d subscriptions", sub.calls)
+	}
+
+	// 第一次加锁失败后才订阅, 订阅失败的错误原样返回
+	mock.res = int64(0)
+	if _, err := c.Lock(ctx, "key", "b", time.Second, nil, time.Second); err == nil || errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected the subscription error, got %v", err)
+	}
+	if sub.calls != 1 {
+		t.Fatalf("expected 1 subscription, got %d", sub.calls)
+	}
+}
+
+// TestRedisScripts 在真实的 redis 上校验 lua 脚本, 需要设置 REDIS_ADDR
+func TestRedisScripts(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	ctx := context.Background()
+	c := NewClient(rdb, WithSubscriber(rdb))
+	key := "redis_lock_test:" + time.Now().Format(time.RFC3339Nano)
+	defer rdb.Del(ctx, key, fencingKey(key))
+
+	l, err := c.Lock(ctx, key, "a", 500*time.Millisecond, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, _ := rdb.PTTL(ctx, key).Result()
+	if ttl <= 0 || ttl > 500*time.Millisecond {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = l.UnLock(ctx)
+	}()
+	// 订阅模式下锁释放后立即被唤醒, 不需要等待重试间隔
+	start := time.Now()
+	l2, err := c.Lock(ctx, key, "b", time.Second, &FixIntervalRetry{Interval: time.Second, Max: 3}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("waiter was not woken up, waited %v", time.Since(start))
+	}
+	if l2.Token() != l.Token()+1 {
+		t.Fatalf("unexpected token %d after %d", l2.Token(), l.Token())
+	}
+	if err = l2.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sem := c.Semaphore(key+":sem", 2)
+	defer rdb.Del(ctx, key+":sem")
+	for _, val := range []string{"a", "b"} {
+		if err = sem.TryAcquire(ctx, val, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sem.TryAcquire(ctx, "c", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ml, err := c.LockMulti(ctx, []string{key + ":2", key + ":1"}, "a", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.TryLock(ctx, key+":1", "b", time.Second); err != ErrLockHeldByOther {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = ml.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rdb.Del(ctx, fencingKey(key+":1"))
+
+	fl, err := c.FairLock(ctx, key+":fair", "a", time.Second, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Del(ctx, fairKeys(key+":fair")...)
+	if token, err := c.backend.(FairBackend).AcquireFair(ctx, key+":fair", "b", time.Second, time.Second); err != nil || token != 0 {
+		t.Fatalf("b should wait in the queue, got %d %v", token, err)
+	}
+	if err = fl.UnLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 锁空闲了, 但 c 排在 b 后面
+	if _, err = c.FairLock(ctx, key+":fair", "c", time.Second, nil, time.Second); !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("c should not jump the queue: %v", err)
+	}
+}
+
+type countingBackend struct {
+	LockBackend
+	calls *int64
+}
+
+func (c *countingBackend) Acquire(ctx context.Context, key string, val string, expiration time.Duration) (int64, error) {
+	atomic.AddInt64(c.calls, 1)
+	return c.LockBackend.Acquire(ctx, key, val, expiration)
+}
+
+// refreshNotifyBackend 每次续约成功都发出通知
+type refreshNotifyBackend struct {
+	LockBackend
+	refreshed chan struct{}
+}
+
+func (r *refreshNotifyBackend) Refresh(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	ok, err := r.LockBackend.Refresh(ctx, key, val, expiration)
+	if ok {
+		select {
+		case r.refreshed <- struct{}{}:
+		default:
+		}
+	}
+	return ok, err
+}
+
+// cancelBackend 加锁之后取消调用方的 ctx
+type cancelBackend struct {
+	LockBackend
+	cancel context.CancelFunc
+}
+
+func (c *cancelBackend) Acquire(ctx context.Context, key string, val string, expiration time.Duration) (int64, error) {
+	token, err := c.LockBackend.Acquire(ctx, key, val, expiration)
+	c.cancel()
+	return token, err
+}
+
+// flakyBackend failing 为 true 时续约返回连接断开的错误
+type flakyBackend struct {
+	LockBackend
+	failing atomic.Bool
+}
+
+func (f *flakyBackend) Refresh(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	if f.failing.Load() {
+		return false, io.EOF
+	}
+	return f.LockBackend.Refresh(ctx, key, val, expiration)
+}
+
+// mockCmdable 只实现了 Eval, 所有脚本都返回 res, err
+type mockCmdable struct {
+	redis.Cmdable
+	res any
+	err error
+}
+
+func (m *mockCmdable) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return redis.NewCmdResult(m.res, m.err)
+}
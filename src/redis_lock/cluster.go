@@ -0,0 +1,58 @@
+package redis_lock
+
+import (
+	"cache/src/internal/slot"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+集群与哨兵:
+NewClient / NewRWClient 接受任意 redis.UniversalClient (单节点、哨兵 NewFailoverClient、集群)
+锁脚本会同时访问锁的 key 和由它派生的 key (key:fencing, key:queue 等), 集群上这些 key 必须落在同一个 slot,
+因此集群上锁的 key 要带 hash tag, 例如 HashTag("order:1") 得到 "{order:1}"; 多 key 锁的所有 key 也必须在同一个 slot
+不满足时在访问 redis 之前返回 ErrCrossSlot, 而不是 redis 的 CROSSSLOT 错误
+哨兵切换主节点期间续约会遇到连接错误、READONLY 等错误, 看门狗在锁过期之前以 FailoverRetryInterval 间隔重试, 而不是直接判定锁丢失
+*/
+
+// FailoverRetryInterval 看门狗遇到主从切换类错误时的重试间隔
+var FailoverRetryInterval = 100 * time.Millisecond
+
+// HashTag 给 key 加上 hash tag, 使 key 和由它派生的 key 落在集群的同一个 slot, 已经带 hash tag 的 key 原样返回
+func HashTag(key string) string {
+	return slot.Tag(key)
+}
+
+// checkSlot 集群客户端上检查脚本访问的 key 是否在同一个 slot
+func checkSlot(client redis.Cmdable, keys ...string) error {
+	if _, ok := client.(*redis.ClusterClient); ok && !slot.Same(keys...) {
+		return ErrCrossSlot
+	}
+	return nil
+}
+
+// failoverErr 判断是否是主从切换期间的临时错误: 连接断开、旧主节点降级为从节点、新主节点还在加载数据等
+func failoverErr(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		for _, prefix := range []string{"READONLY ", "LOADING ", "MASTERDOWN ", "TRYAGAIN ", "CLUSTERDOWN "} {
+			if strings.HasPrefix(redisErr.Error(), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
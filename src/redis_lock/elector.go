@@ -0,0 +1,192 @@
+package redis_lock
+
+import (
+	"cache/src/logging"
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+基于分布式锁的 leader 选举:
+	Campaign 阻塞直到成为 leader, 之后在后台续约; 失去 leadership 后自动重新竞选, 直到 Resign
+	Observe 返回 leadership 变化的通知, true 表示成为 leader, false 表示失去 leader
+*/
+
+type Elector struct {
+	client *Client
+	key    string
+	val    string
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	lock     *Lock
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	observer chan bool
+}
+
+// NewElector 创建选举器, val 用于标识当前候选者, ttl 为 leadership 的过期时间
+func (c *Client) NewElector(key string, val string, ttl time.Duration) *Elector {
+	return &Elector{
+		client:   c,
+		key:      key,
+		val:      val,
+		ttl:      ttl,
+		observer: make(chan bool, 16),
+	}
+}
+
+// Campaign 参与竞选, 阻塞直到成为 leader 或者 ctx 结束
+func (e *Elector) Campaign(ctx context.Context) error {
+	e.mu.Lock()
+	if e.cancel != nil {
+		e.mu.Unlock()
+		return ErrAlreadyCampaigning
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	e.cancel, e.stopped = cancel, make(chan struct{})
+	e.mu.Unlock()
+
+	lock, err := e.campaign(ctx)
+	if err != nil {
+		cancel()
+		close(e.stopped)
+		e.mu.Lock()
+		e.cancel = nil
+		e.mu.Unlock()
+		return err
+	}
+	e.setLock(lock)
+	go e.run(runCtx, lock)
+	return nil
+}
+
+// Resign 放弃 leadership 并停止自动重新竞选
+func (e *Elector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	cancel, stopped := e.cancel, e.stopped
+	e.cancel = nil
+	e.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-stopped
+	lock := e.setLock(nil)
+	if lock == nil {
+		return nil
+	}
+	return lock.UnLock(ctx)
+}
+
+// Leader 返回当前 leader 的标识, 没有 leader 时返回 ErrNoLeader
+func (e *Elector) Leader(ctx context.Context) (string, error) {
+	val, _, err := e.client.Inspect(ctx, e.key)
+	if err != nil {
+		return "", err
+	}
+	if val == "" {
+		return "", ErrNoLeader
+	}
+	return val, nil
+}
+
+// IsLeader 当前候选者是否是 leader
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lock != nil
+}
+
+// Observe 返回 leadership 变化的通知, 消费过慢时通知会被丢弃
+func (e *Elector) Observe() <-chan bool {
+	return e.observer
+}
+
+// run 持有 leadership 直到丢失, 然后重新竞选, 直到 ctx 结束
+func (e *Elector) run(ctx context.Context, lock *Lock) {
+	defer close(e.stopped)
+	for {
+		e.hold(ctx, lock)
+		if ctx.Err() != nil {
+			return
+		}
+		// 释放失去的锁: 进程内的本地锁和看门狗随之释放, 否则重新竞选时本地锁一直被占用
+		uCtx, cancel := context.WithTimeout(ctx, e.interval())
+		if err := lock.UnLock(uCtx); err != nil && err != ErrLockNotHold {
+			lock.log(logging.LevelWarn, "redis_lock: elector failed to release the lost lock", "key", e.key, "error", err)
+		}
+		cancel()
+		e.setLock(nil)
+		var err error
+		if lock, err = e.campaign(ctx); err != nil {
+			return
+		}
+		e.setLock(lock)
+	}
+}
+
+// campaign 每隔 ttl/3 尝试一次加锁, 直到成功或者 ctx 结束
+func (e *Elector) campaign(ctx context.Context) (*Lock, error) {
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+	for {
+		lock, err := e.client.TryLock(ctx, e.key, e.val, e.ttl)
+		if err == nil {
+			return lock, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// hold 每隔 ttl/3 续约一次, 锁被别人持有或者超过 ttl 没有续约成功时返回
+func (e *Elector) hold(ctx context.Context, lock *Lock) {
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+	lastRefresh := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			rCtx, cancel := context.WithTimeout(ctx, e.interval())
+			err := lock.Refresh(rCtx)
+			cancel()
+			if err == nil {
+				lastRefresh = time.Now()
+				continue
+			}
+			if err == ErrLockNotHold || time.Since(lastRefresh) > e.ttl {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Elector) interval() time.Duration {
+	interval := e.ttl / 3
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// setLock 更新当前持有的锁并在 leadership 变化时通知观察者, 返回之前的锁
+func (e *Elector) setLock(lock *Lock) *Lock {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old := e.lock
+	e.lock = lock
+	if (old == nil) != (lock == nil) {
+		select {
+		case e.observer <- lock != nil:
+		default:
+		}
+	}
+	return old
+}
@@ -0,0 +1,73 @@
+package redis_lock
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrLockHeldByOther 锁被其他人持有
+	ErrLockHeldByOther = errors.New("Lock Is Held By Other")
+
+	// FailToGetLock 加锁失败, 与 ErrLockHeldByOther 相同, 保留用于兼容
+	FailToGetLock = ErrLockHeldByOther
+
+	// ErrRetriesExhausted 重试机会耗尽, 可以继续通过 errors.Is 判断最后一次失败的原因
+	ErrRetriesExhausted = errors.New("Retries Exhausted")
+
+	// ErrRefreshTimeout 续约超时
+	ErrRefreshTimeout = errors.New("Refresh Lock Timeout")
+
+	ErrLockNotHold = errors.New("Do Not Hold The Lock !")
+
+	// ErrNoLeader 当前没有 leader
+	ErrNoLeader = errors.New("No Leader Elected")
+
+	// ErrAlreadyCampaigning 选举器已经在竞选中
+	ErrAlreadyCampaigning = errors.New("Elector Is Already Campaigning")
+
+	// ErrBackendNotSupported 当前锁后端不支持该操作
+	ErrBackendNotSupported = errors.New("Operation Not Supported By Lock Backend")
+
+	// ErrMaxHoldExceeded 锁的持有时间超过了最大限制
+	ErrMaxHoldExceeded = errors.New("Lock Held Longer Than Max Hold Time")
+
+	// ErrInvalidExpiration 锁的过期时间必须至少为 1 毫秒
+	ErrInvalidExpiration = errors.New("Invalid Lock Expiration")
+
+	// ErrCrossSlot 集群上同一个脚本访问的 key 不在同一个 slot, 需要用 HashTag 给 key 加上 hash tag
+	ErrCrossSlot = errors.New("Lock Keys Hash To Different Cluster Slots")
+)
+
+// checkExpiration 过期时间以毫秒为单位传给 redis, 不足 1 毫秒会被截断为 0
+func checkExpiration(expiration time.Duration) error {
+	if expiration < time.Millisecond {
+		return ErrInvalidExpiration
+	}
+	return nil
+}
+
+// wrapError 同时匹配 sentinel 和底层的 cause
+type wrapError struct {
+	sentinel error
+	cause    error
+}
+
+func wrap(sentinel, cause error) error {
+	return &wrapError{
+		sentinel: sentinel,
+		cause:    cause,
+	}
+}
+
+func (e *wrapError) Error() string {
+	return e.sentinel.Error() + ": " + e.cause.Error()
+}
+
+func (e *wrapError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e *wrapError) Unwrap() error {
+	return e.cause
+}
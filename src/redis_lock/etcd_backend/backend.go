@@ -0,0 +1,328 @@
+package etcd_backend
+
+import (
+	"bytes"
+	"cache/src/redis_lock"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+基于 etcd 的锁后端, 通过 etcd v3 的 JSON gateway(/v3/kv/txn、/v3/lease/*) 访问, 不依赖 clientv3
+	加锁: 申请租约, 事务中判断 key 的 create_revision 为 0 时写入并绑定租约, 集群的 revision 作为 fencing token
+	续约: 对 key 绑定的租约 keepalive, etcd 的租约以秒为单位, 过期时间会向上取整
+		租约的 TTL 在申请之后不能修改, 续约的过期时间与租约不同时申请新的租约, 事务中判断 value 相同时把 key 绑定到新租约
+	解锁: 事务中判断 value 相同时删除 key 并回收租约
+*/
+
+var (
+	_ redis_lock.LockBackend  = (*Backend)(nil)
+	_ redis_lock.AdminBackend = (*Backend)(nil)
+)
+
+type Backend struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewBackend endpoint 为 etcd 的地址, 例如 http://127.0.0.1:2379
+func NewBackend(endpoint string, client *http.Client) *Backend {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Backend{
+		endpoint: endpoint,
+		client:   client,
+	}
+}
+
+func (b *Backend) Acquire(ctx context.Context, key string, val string, expiration time.Duration) (int64, error) {
+	lease, err := b.grant(ctx, expiration)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := b.txn(ctx, txnRequest{
+		Compare: []compare{{Key: encode(key), Result: "EQUAL", Target: "CREATE", CreateRevision: "0"}},
+		Success: []requestOp{{RequestPut: &putRequest{Key: encode(key), Value: encode(val), Lease: lease}}},
+		Failure: []requestOp{{RequestRange: &rangeRequest{Key: encode(key)}}},
+	})
+	if err != nil {
+		_ = b.revoke(context.Background(), lease)
+		return 0, err
+	}
+	if resp.Succeeded {
+		return int64(resp.Header.Revision), nil
+	}
+	// 锁已经存在, 新申请的租约不再需要
+	_ = b.revoke(ctx, lease)
+	kv := resp.firstKV()
+	if kv == nil || decode(kv.Value) != val {
+		return 0, nil
+	}
+	// 相同的 val 重入, 刷新已有的租约
+	ok, err := b.refresh(ctx, key, val, int64(kv.Lease), expiration)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return int64(kv.CreateRevision), nil
+}
+
+func (b *Backend) Release(ctx context.Context, key string, val string) (bool, error) {
+	resp, err := b.txn(ctx, txnRequest{
+		Compare: []compare{{Key: encode(key), Result: "EQUAL", Target: "VALUE", Value: encode(val)}},
+		Success: []requestOp{
+			{RequestRange: &rangeRequest{Key: encode(key)}},
+			{RequestDeleteRange: &rangeRequest{Key: encode(key)}},
+		},
+	})
+	if err != nil || !resp.Succeeded {
+		return false, err
+	}
+	if kv := resp.firstKV(); kv != nil && kv.Lease != 0 {
+		_ = b.revoke(ctx, int64(kv.Lease))
+	}
+	return true, nil
+}
+
+func (b *Backend) Refresh(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	var resp rangeResponse
+	if err := b.post(ctx, "/v3/kv/range", rangeRequest{Key: encode(key)}, &resp); err != nil {
+		return false, err
+	}
+	if len(resp.Kvs) == 0 || decode(resp.Kvs[0].Value) != val {
+		return false, nil
+	}
+	return b.refresh(ctx, key, val, int64(resp.Kvs[0].Lease), expiration)
+}
+
+// refresh 续约 key 绑定的租约 lease, 租约已经过期时返回 false; 过期时间与租约的 TTL 不同时换成新的租约
+func (b *Backend) refresh(ctx context.Context, key string, val string, lease int64, expiration time.Duration) (bool, error) {
+	ttl, err := b.keepAlive(ctx, lease)
+	if err != nil || ttl <= 0 {
+		return false, err
+	}
+	if ttl == leaseTTL(expiration) {
+		return true, nil
+	}
+	next, err := b.grant(ctx, expiration)
+	if err != nil {
+		return false, err
+	}
+	// 重新 put 不会改变 create_revision, fencing token 保持不变
+	resp, err := b.txn(ctx, txnRequest{
+		Compare: []compare{{Key: encode(key), Result: "EQUAL", Target: "VALUE", Value: encode(val)}},
+		Success: []requestOp{{RequestPut: &putRequest{Key: encode(key), Value: encode(val), Lease: next}}},
+	})
+	if err != nil || !resp.Succeeded {
+		_ = b.revoke(context.Background(), next)
+		return false, err
+	}
+	_ = b.revoke(ctx, lease)
+	return true, nil
+}
+
+func (b *Backend) Transfer(ctx context.Context, key string, val string, newVal string) (bool, error) {
+	resp, err := b.txn(ctx, txnRequest{
+		Compare: []compare{{Key: encode(key), Result: "EQUAL", Target: "VALUE", Value: encode(val)}},
+		Success: []requestOp{{RequestPut: &putRequest{Key: encode(key), Value: encode(newVal), IgnoreLease: true}}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// leaseTTL etcd 租约的 TTL 以秒为单位, 向上取整, 至少 1 秒
+func leaseTTL(expiration time.Duration) int64 {
+	ttl := int64(math.Ceil(expiration.Seconds()))
+	if ttl < 1 {
+		ttl = 1
+	}
+	return ttl
+}
+
+func (b *Backend) grant(ctx context.Context, expiration time.Duration) (int64, error) {
+	ttl := leaseTTL(expiration)
+	var resp struct {
+		ID    int64String `json:"ID"`
+		Error string      `json:"error"`
+	}
+	if err := b.post(ctx, "/v3/lease/grant", map[string]int64{"TTL": ttl}, &resp); err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("etcd_backend: grant lease: %s", resp.Error)
+	}
+	return int64(resp.ID), nil
+}
+
+// keepAlive 续约一次, 返回租约的 TTL(秒), 租约已经过期时返回 0
+func (b *Backend) keepAlive(ctx context.Context, lease int64) (int64, error) {
+	var resp struct {
+		Result struct {
+			TTL int64String `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := b.post(ctx, "/v3/lease/keepalive", map[string]string{"ID": strconv.FormatInt(lease, 10)}, &resp); err != nil {
+		return 0, err
+	}
+	return int64(resp.Result.TTL), nil
+}
+
+func (b *Backend) revoke(ctx context.Context, lease int64) error {
+	return b.post(ctx, "/v3/lease/revoke", map[string]string{"ID": strconv.FormatInt(lease, 10)}, nil)
+}
+
+func (b *Backend) txn(ctx context.Context, req txnRequest) (*txnResponse, error) {
+	var resp txnResponse
+	if err := b.post(ctx, "/v3/kv/txn", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *Backend) post(ctx context.Context, path string, body any, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd_backend: %s returned %s", path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func decode(s string) string {
+	res, _ := base64.StdEncoding.DecodeString(s)
+	return string(res)
+}
+
+// int64String gateway 把 int64 编码为字符串
+type int64String int64
+
+func (i *int64String) UnmarshalJSON(data []byte) error {
+	s := string(bytes.Trim(data, `"`))
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = int64String(v)
+	return nil
+}
+
+type compare struct {
+	Key            string `json:"key"`
+	Result         string `json:"result"`
+	Target         string `json:"target"`
+	CreateRevision string `json:"create_revision,omitempty"`
+	Value          string `json:"value,omitempty"`
+}
+
+type putRequest struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Lease       int64  `json:"lease,omitempty,string"`
+	IgnoreLease bool   `json:"ignore_lease,omitempty"`
+}
+
+type rangeRequest struct {
+	Key string `json:"key"`
+}
+
+type requestOp struct {
+	RequestRange       *rangeRequest `json:"request_range,omitempty"`
+	RequestPut         *putRequest   `json:"request_put,omitempty"`
+	RequestDeleteRange *rangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []compare   `json:"compare"`
+	Success []requestOp `json:"success"`
+	Failure []requestOp `json:"failure,omitempty"`
+}
+
+type keyValue struct {
+	Key            string      `json:"key"`
+	Value          string      `json:"value"`
+	CreateRevision int64String `json:"create_revision"`
+	Lease          int64String `json:"lease"`
+}
+
+type rangeResponse struct {
+	Kvs []keyValue `json:"kvs"`
+}
+
+type txnResponse struct {
+	Header struct {
+		Revision int64String `json:"revision"`
+	} `json:"header"`
+	Succeeded bool `json:"succeeded"`
+	Responses []struct {
+		ResponseRange *rangeResponse `json:"response_range"`
+	} `json:"responses"`
+}
+
+// firstKV 返回事务中第一个 range 请求查到的 kv
+func (t *txnResponse) firstKV() *keyValue {
+	for _, r := range t.Responses {
+		if r.ResponseRange != nil && len(r.ResponseRange.Kvs) > 0 {
+			return &r.ResponseRange.Kvs[0]
+		}
+	}
+	return nil
+}
+
+func (b *Backend) Inspect(ctx context.Context, key string) (string, time.Duration, error) {
+	var resp rangeResponse
+	if err := b.post(ctx, "/v3/kv/range", rangeRequest{Key: encode(key)}, &resp); err != nil {
+		return "", 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", 0, nil
+	}
+	kv := resp.Kvs[0]
+	if kv.Lease == 0 {
+		return decode(kv.Value), 0, nil
+	}
+	var lease struct {
+		TTL int64String `json:"TTL"`
+	}
+	if err := b.post(ctx, "/v3/lease/timetolive", map[string]string{"ID": strconv.FormatInt(int64(kv.Lease), 10)}, &lease); err != nil {
+		return "", 0, err
+	}
+	ttl := time.Duration(lease.TTL) * time.Second
+	if ttl < 0 {
+		ttl = 0
+	}
+	return decode(kv.Value), ttl, nil
+}
+
+func (b *Backend) ForceRelease(ctx context.Context, key string) error {
+	return b.post(ctx, "/v3/kv/deleterange", rangeRequest{Key: encode(key)}, nil)
+}
@@ -0,0 +1,288 @@
+package etcd_backend
+
+import (
+	"cache/src/redis_lock"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGateway 模拟 etcd v3 JSON gateway 中后端用到的接口, 租约只有在调用 expire 时才会过期
+type fakeGateway struct {
+	mu        sync.Mutex
+	revision  int64
+	nextLease int64
+	kvs       map[string]*fakeKV
+	leases    map[int64]int64 // 租约 -> 申请时的 TTL(秒)
+}
+
+type fakeKV struct {
+	value          string
+	createRevision int64
+	lease          int64
+}
+
+func newFakeGateway(t *testing.T) (*fakeGateway, *Backend) {
+	g := &fakeGateway{
+		revision: 1,
+		kvs:      make(map[string]*fakeKV),
+		leases:   make(map[int64]int64),
+	}
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	return g, NewBackend(srv.URL, srv.Client())
+}
+
+// expire 模拟租约过期: 删除租约以及绑定在上面的 key
+func (g *fakeGateway) expire(lease int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.leases, lease)
+	for k, kv := range g.kvs {
+		if kv.lease == lease {
+			delete(g.kvs, k)
+		}
+	}
+}
+
+// lease 返回 key 绑定的租约和租约的 TTL
+func (g *fakeGateway) lease(key string) (int64, int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	kv, ok := g.kvs[encode(key)]
+	if !ok {
+		return 0, 0
+	}
+	return kv.lease, g.leases[kv.lease]
+}
+
+func (g *fakeGateway) hasLease(lease int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.leases[lease]
+	return ok
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var req struct {
+		ID      int64String `json:"ID"`
+		TTL     int64       `json:"TTL"`
+		Key     string      `json:"key"`
+		Compare []compare   `json:"compare"`
+		Success []requestOp `json:"success"`
+		Failure []requestOp `json:"failure"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var resp any
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		g.nextLease++
+		g.leases[g.nextLease] = req.TTL
+		resp = map[string]string{"ID": strconv.FormatInt(g.nextLease, 10), "TTL": strconv.FormatInt(req.TTL, 10)}
+	case "/v3/lease/keepalive":
+		// 过期的租约不返回 TTL
+		result := map[string]string{"ID": strconv.FormatInt(int64(req.ID), 10)}
+		if ttl, ok := g.leases[int64(req.ID)]; ok {
+			result["TTL"] = strconv.FormatInt(ttl, 10)
+		}
+		resp = map[string]any{"result": result}
+	case "/v3/lease/timetolive":
+		ttl, ok := g.leases[int64(req.ID)]
+		if !ok {
+			ttl = -1
+		}
+		resp = map[string]string{"TTL": strconv.FormatInt(ttl, 10)}
+	case "/v3/lease/revoke":
+		lease := int64(req.ID)
+		delete(g.leases, lease)
+		for k, kv := range g.kvs {
+			if kv.lease == lease {
+				delete(g.kvs, k)
+			}
+		}
+		resp = struct{}{}
+	case "/v3/kv/range":
+		resp = g.rangeKey(req.Key)
+	case "/v3/kv/deleterange":
+		delete(g.kvs, req.Key)
+		resp = struct{}{}
+	case "/v3/kv/txn":
+		succeeded := true
+		for _, c := range req.Compare {
+			kv := g.kvs[c.Key]
+			switch c.Target {
+			case "CREATE":
+				succeeded = succeeded && kv == nil
+			case "VALUE":
+				succeeded = succeeded && kv != nil && kv.value == c.Value
+			}
+		}
+		ops := req.Success
+		if !succeeded {
+			ops = req.Failure
+		}
+		var responses []map[string]any
+		for _, op := range ops {
+			switch {
+			case op.RequestRange != nil:
+				responses = append(responses, map[string]any{"response_range": g.rangeKey(op.RequestRange.Key)})
+			case op.RequestPut != nil:
+				g.revision++
+				kv, ok := g.kvs[op.RequestPut.Key]
+				if !ok {
+					kv = &fakeKV{createRevision: g.revision}
+					g.kvs[op.RequestPut.Key] = kv
+				}
+				kv.value = op.RequestPut.Value
+				if !op.RequestPut.IgnoreLease {
+					kv.lease = op.RequestPut.Lease
+				}
+			case op.RequestDeleteRange != nil:
+				g.revision++
+				delete(g.kvs, op.RequestDeleteRange.Key)
+			}
+		}
+		resp = map[string]any{
+			"header":    map[string]string{"revision": strconv.FormatInt(g.revision, 10)},
+			"succeeded": succeeded,
+			"responses": responses,
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (g *fakeGateway) rangeKey(key string) map[string]any {
+	kv, ok := g.kvs[key]
+	if !ok {
+		return map[string]any{}
+	}
+	return map[string]any{"kvs": []map[string]string{{
+		"key":             key,
+		"value":           kv.value,
+		"create_revision": strconv.FormatInt(kv.createRevision, 10),
+		"lease":           strconv.FormatInt(kv.lease, 10),
+	}}}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	g, b := newFakeGateway(t)
+	ctx := context.Background()
+
+	token, err := b.Acquire(ctx, "key", "a", 1500*time.Millisecond)
+	if err != nil || token <= 0 {
+		t.Fatalf("unexpected acquire result %d %v", token, err)
+	}
+	// 租约以秒为单位向上取整
+	if _, ttl := g.lease("key"); ttl != 2 {
+		t.Fatalf("unexpected lease ttl %d", ttl)
+	}
+	if res, err := b.Acquire(ctx, "key", "b", time.Second); err != nil || res != 0 {
+		t.Fatalf("lock held by a should not be acquired: %d %v", res, err)
+	}
+	// 相同的 val 重入, token 不变
+	if res, err := b.Acquire(ctx, "key", "a", 2*time.Second); err != nil || res != token {
+		t.Fatalf("unexpected reentrant result %d %v, want %d", res, err, token)
+	}
+	owner, ttl, err := b.Inspect(ctx, "key")
+	if err != nil || owner != "a" || ttl != 2*time.Second {
+		t.Fatalf("unexpected inspect result %s %v %v", owner, ttl, err)
+	}
+
+	if ok, err := b.Release(ctx, "key", "b"); err != nil || ok {
+		t.Fatalf("b should not release the lock of a: %v %v", ok, err)
+	}
+	if ok, err := b.Release(ctx, "key", "a"); err != nil || !ok {
+		t.Fatalf("unexpected release result %v %v", ok, err)
+	}
+	if g.hasLease(1) || g.hasLease(2) {
+		t.Fatal("leases should be revoked")
+	}
+	next, err := b.Acquire(ctx, "key", "b", time.Second)
+	if err != nil || next <= token {
+		t.Fatalf("token should increase, got %d after %d: %v", next, token, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	g, b := newFakeGateway(t)
+	ctx := context.Background()
+
+	token, err := b.Acquire(ctx, "key", "a", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, _ := g.lease("key")
+	if ok, err := b.Refresh(ctx, "key", "a", 2*time.Second); err != nil || !ok {
+		t.Fatalf("unexpected refresh result %v %v", ok, err)
+	}
+	if next, _ := g.lease("key"); next != lease {
+		t.Fatal("refresh with the same ttl should keep the lease")
+	}
+
+	// 过期时间不同时换成新的租约, 旧租约被回收, token 不变
+	if ok, err := b.Refresh(ctx, "key", "a", 5*time.Second); err != nil || !ok {
+		t.Fatalf("unexpected refresh result %v %v", ok, err)
+	}
+	next, ttl := g.lease("key")
+	if next == lease || ttl != 5 {
+		t.Fatalf("expected a new 5s lease, got lease %d with ttl %d", next, ttl)
+	}
+	if g.hasLease(lease) {
+		t.Fatal("the old lease should be revoked")
+	}
+	if res, err := b.Acquire(ctx, "key", "a", 5*time.Second); err != nil || res != token {
+		t.Fatalf("token should be kept, got %d want %d: %v", res, token, err)
+	}
+
+	if ok, err := b.Refresh(ctx, "key", "b", time.Second); err != nil || ok {
+		t.Fatalf("b should not refresh the lock of a: %v %v", ok, err)
+	}
+}
+
+func TestLostLease(t *testing.T) {
+	g, b := newFakeGateway(t)
+	c := redis_lock.NewClientWithBackend(b)
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, _ := g.lease("key")
+	g.expire(lease)
+	if err = l.Refresh(ctx); err != redis_lock.ErrLockNotHold {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l.Err() != redis_lock.ErrLockNotHold {
+		t.Fatalf("the lock should be marked lost, got %v", l.Err())
+	}
+	if _, err = c.TryLock(ctx, "key", "b", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 租约刚好在读取 key 之后过期, keepalive 没有返回 TTL
+	token, err := b.Acquire(ctx, "other", "a", time.Second)
+	if err != nil || token <= 0 {
+		t.Fatalf("unexpected acquire result %d %v", token, err)
+	}
+	lease, _ = g.lease("other")
+	g.mu.Lock()
+	delete(g.leases, lease)
+	g.mu.Unlock()
+	if ok, err := b.Refresh(ctx, "other", "a", time.Second); err != nil || ok {
+		t.Fatalf("refresh of an expired lease should report the lock lost: %v %v", ok, err)
+	}
+}
@@ -0,0 +1,164 @@
+package redis_lock
+
+import (
+	"context"
+	_ "embed"
+	"time"
+)
+
+/*
+公平锁: 等待者按照第一次尝试的时间在 zset 中排队, 只有队首的等待者才能获得锁, 避免高并发下某些等待者一直抢不到锁
+等待者每次重试都会刷新自己的心跳, 超过 FairLockWaiterTimeout 没有重试的等待者会被移出队列
+后端需要实现 FairBackend, redis 和内存后端都支持
+*/
+
+var (
+	//go:embed lua/fair_lock.lua
+	luaFairLock string
+
+	// FairLockWaiterTimeout 等待者超过该时间没有再次尝试加锁就会被移出队列, 需要大于重试间隔
+	FairLockWaiterTimeout = 10 * time.Second
+)
+
+// FairBackend 支持公平锁的锁后端, 与普通锁共用同一个 key 和 fencing token
+type FairBackend interface {
+	// AcquireFair 清理超过 waiterTimeout 没有再次尝试的等待者, 锁已经被 val 持有时续期并返回 token;
+	// 否则 val 入队(已在队列中则刷新心跳), 锁空闲且 val 在队首时加锁、出队并返回 token, 其余情况返回 0
+	AcquireFair(ctx context.Context, key string, val string, expiration time.Duration, waiterTimeout time.Duration) (int64, error)
+	// LeaveFair 把 val 移出等待队列
+	LeaveFair(ctx context.Context, key string, val string) error
+}
+
+// FairLock 以公平模式加锁, 返回的锁与 Lock 一致, 可以正常 UnLock / Refresh
+func (c *Client) FairLock(ctx context.Context, key string, val string, expiration time.Duration, retry RetryStrategy, timeout time.Duration) (*Lock, error) {
+	backend, ok := c.backend.(FairBackend)
+	if !ok {
+		return nil, ErrBackendNotSupported
+	}
+	if err := checkExpiration(expiration); err != nil {
+		return nil, err
+	}
+	if err := checkSlot(c.client, fairKeys(key)...); err != nil {
+		return nil, err
+	}
+	start, attempts := time.Now(), 0
+	release, err := c.lockLocal(ctx, key)
+	if err != nil {
+		c.ins.ObserveAcquire(c.label(key), time.Since(start), 0, err)
+		return nil, err
+	}
+	watch, unsubscribe := c.watchRelease(key)
+	defer unsubscribe()
+	token, err := acquire(ctx, retry, timeout, watch, func(ctx context.Context) (int64, error) {
+		attempts++
+		return backend.AcquireFair(ctx, key, val, expiration, FairLockWaiterTimeout)
+	})
+	c.ins.ObserveAcquire(c.label(key), time.Since(start), attempts-1, err)
+	if err != nil {
+		release()
+		// 放弃加锁, 离开队列, 不影响后面的等待者
+		_ = backend.LeaveFair(context.Background(), key, val)
+		return nil, err
+	}
+	l := newLock(c.backend, key, val, expiration, token)
+	l.release = release
+	return c.track(l), nil
+}
+
+func (r *redisBackend) AcquireFair(ctx context.Context, key string, val string, expiration time.Duration, waiterTimeout time.Duration) (int64, error) {
+	return r.client.Eval(ctx, luaFairLock, fairKeys(key), val, expiration.Milliseconds(),
+		time.Now().UnixMilli(), waiterTimeout.Milliseconds()).Int64()
+}
+
+func (r *redisBackend) LeaveFair(ctx context.Context, key string, val string) error {
+	if err := r.client.ZRem(ctx, fairQueueKey(key), val).Err(); err != nil {
+		return err
+	}
+	return r.client.ZRem(ctx, fairHeartbeatKey(key), val).Err()
+}
+
+// memoryWaiter 内存公平锁的等待者, 按入队顺序保存在切片中
+type memoryWaiter struct {
+	val       string
+	heartbeat time.Time
+}
+
+func (m *MemoryBackend) AcquireFair(ctx context.Context, key string, val string, expiration time.Duration, waiterTimeout time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	// 清理长时间没有再次尝试的等待者, 避免队首的等待者放弃后其他人永远拿不到锁
+	queue := m.fairQueues[key][:0]
+	joined := false
+	for _, w := range m.fairQueues[key] {
+		if now.Sub(w.heartbeat) >= waiterTimeout {
+			continue
+		}
+		if w.val == val {
+			w.heartbeat, joined = now, true
+		}
+		queue = append(queue, w)
+	}
+	l, ok := m.get(key)
+	if ok && l.val == val {
+		l.expireAt = now.Add(expiration)
+		m.locks[key] = l
+		m.setFairQueue(key, queue)
+		if m.tokens[key] == 0 {
+			m.tokens[key]++
+		}
+		return m.tokens[key], nil
+	}
+	if !joined {
+		queue = append(queue, memoryWaiter{val: val, heartbeat: now})
+	}
+	// 只有队首的等待者可以获得锁
+	if ok || queue[0].val != val {
+		m.setFairQueue(key, queue)
+		return 0, nil
+	}
+	m.locks[key] = memoryLock{val: val, expireAt: now.Add(expiration)}
+	m.setFairQueue(key, queue[1:])
+	m.tokens[key]++
+	return m.tokens[key], nil
+}
+
+func (m *MemoryBackend) LeaveFair(ctx context.Context, key string, val string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	queue := m.fairQueues[key][:0]
+	for _, w := range m.fairQueues[key] {
+		if w.val != val {
+			queue = append(queue, w)
+		}
+	}
+	m.setFairQueue(key, queue)
+	return nil
+}
+
+// setFairQueue 保存 key 的等待队列, 队列为空时删除, 调用方持有 m.lock
+func (m *MemoryBackend) setFairQueue(key string, queue []memoryWaiter) {
+	if len(queue) == 0 {
+		delete(m.fairQueues, key)
+		return
+	}
+	m.fairQueues[key] = queue
+}
+
+func fairKeys(key string) []string {
+	return []string{key, fairQueueKey(key), fairHeartbeatKey(key), fencingKey(key)}
+}
+
+func fairQueueKey(key string) string {
+	return key + ":queue"
+}
+
+func fairHeartbeatKey(key string) string {
+	return key + ":heartbeat"
+}
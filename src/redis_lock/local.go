@@ -0,0 +1,90 @@
+package redis_lock
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+进程内的锁: 同一个进程内的协程先在本地竞争, 只有拿到本地锁的协程才会去访问 redis
+在同进程竞争激烈的场景下可以大幅减少 redis 的请求次数
+注意开启后同一进程内不再支持使用相同 val 重入
+*/
+
+// WithLocalLock 开启进程内加锁快速路径
+func WithLocalLock() ClientOption {
+	return func(c *Client) {
+		c.local = &localLocks{
+			locks: make(map[string]*localLock),
+		}
+	}
+}
+
+type localLocks struct {
+	mu    sync.Mutex
+	locks map[string]*localLock
+}
+
+type localLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// get 获取 key 对应的本地锁并增加引用计数
+func (l *localLocks) get(key string) *localLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ll, ok := l.locks[key]
+	if !ok {
+		ll = &localLock{
+			ch: make(chan struct{}, 1),
+		}
+		l.locks[key] = ll
+	}
+	ll.refs++
+	return ll
+}
+
+// put 减少引用计数, 没有人使用时删除, 避免 map 无限增长
+func (l *localLocks) put(key string, ll *localLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ll.refs--
+	if ll.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+// lock 阻塞获取本地锁, 返回释放函数
+func (l *localLocks) lock(ctx context.Context, key string) (func(), error) {
+	ll := l.get(key)
+	select {
+	case ll.ch <- struct{}{}:
+		return l.releaseFunc(key, ll), nil
+	case <-ctx.Done():
+		l.put(key, ll)
+		return nil, ctx.Err()
+	}
+}
+
+// tryLock 尝试获取本地锁
+func (l *localLocks) tryLock(key string) (func(), bool) {
+	ll := l.get(key)
+	select {
+	case ll.ch <- struct{}{}:
+		return l.releaseFunc(key, ll), true
+	default:
+		l.put(key, ll)
+		return nil, false
+	}
+}
+
+func (l *localLocks) releaseFunc(key string, ll *localLock) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-ll.ch
+			l.put(key, ll)
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package redis_lock
 
 import (
+	"cache/src/logging"
 	"context"
 	_ "embed"
 	"errors"
-	"github.com/redis/go-redis/v9"
+	"fmt"
+	"sync"
 	"time"
 )
 
@@ -18,51 +20,186 @@ var (
 	//go:embed lua/refresh.lua
 	luaRefresh string
 
-	ErrLockNotHold = errors.New("Do Not Hold The Lock !")
+	//go:embed lua/transfer.lua
+	luaTransfer string
 
 	DelSuccess, NotExistKey int64 = 1, 1
 )
 
 type Lock struct {
-	client  redis.Cmdable
+	backend LockBackend
 	key     string
-	val     any
+	val     string
 	expired time.Duration
 	unlock  chan struct{}
+	token   int64
+
+	// unlock 在 UnLock 时关闭，通知续约协程退出
+	unlockOnce sync.Once
+	// release 释放进程内的本地锁
+	release func()
+
+	// done 在确认锁已丢失时关闭，lostOnce 保证只关闭一次, lostErr 记录丢失的原因
+	done     chan struct{}
+	lostOnce sync.Once
+	lostErr  error
+
+	// 监控埋点
+	ins        Instrumentation
+	label      string
+	acquiredAt time.Time
+	// logger 记录看门狗的告警, 为 nil 时不记录
+	logger logging.Logger
 }
 
-func newLock(c redis.Cmdable, k string, v any, d time.Duration) *Lock {
+func newLock(b LockBackend, k string, v string, d time.Duration, token int64) *Lock {
 	return &Lock{
-		client:  c,
+		backend: b,
 		key:     k,
 		val:     v,
 		expired: d,
+		token:   token,
+		unlock:  make(chan struct{}),
+		release: func() {},
+		done:    make(chan struct{}),
+
+		ins:        nopInstrumentation{},
+		label:      k,
+		acquiredAt: time.Now(),
 	}
 }
 
-func (c *Lock) UnLock(ctx context.Context) error {
-	res, err := c.client.Eval(ctx, luaUnlock, []string{c.key}, c.val).Int64()
-	if err == redis.Nil || res != DelSuccess {
-		return ErrLockNotHold
+// fencingKey 每个锁对应的 fencing 计数器 key
+func fencingKey(key string) string {
+	return key + ":fencing"
+}
+
+// Token 返回加锁时获得的 fencing token
+// 每次成功加锁 token 都会单调递增，下游资源可以拒绝携带旧 token 的写入，防止过期的锁持有者覆盖数据
+func (c *Lock) Token() int64 {
+	return c.token
+}
+
+// Done 返回一个在锁丢失时被关闭的 channel
+// 当续约发现锁已不再被当前持有者持有时关闭，长时间运行的临界区可以监听它并及时退出
+func (c *Lock) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 在 Done 关闭之后返回锁丢失的原因，之前返回 nil
+func (c *Lock) Err() error {
+	select {
+	case <-c.done:
+		return c.lostErr
+	default:
+		return nil
+	}
+}
+
+// markLost 标记锁已丢失，关闭 done
+func (c *Lock) markLost(err error) {
+	c.lostOnce.Do(func() {
+		c.lostErr = err
+		close(c.done)
+	})
+}
+
+// guardHold 持有时间超过 maxHold 时标记锁丢失, 停止续约, forceRelease 时同时释放锁
+func (c *Lock) guardHold(maxHold time.Duration, forceRelease bool) {
+	timer := time.NewTimer(maxHold)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		c.markLost(ErrMaxHoldExceeded)
+		if forceRelease {
+			ctx, cancel := context.WithTimeout(context.Background(), c.expired)
+			_ = c.UnLock(ctx)
+			cancel()
+		}
+	case <-c.unlock:
+	case <-c.done:
+	}
+}
+
+// watchdog 自动续约直到锁被释放，续约失败或者 panic 都会通过 Done 通知调用方, 并记录到 logger
+func (c *Lock) watchdog(interval time.Duration) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.log(logging.LevelError, "redis_lock: watchdog panic", "key", c.key, "panic", r)
+			c.markLost(fmt.Errorf("redis_lock: watchdog panic: %v", r))
+		}
+	}()
+	if err := c.AutoRefresh(interval, interval); err != nil {
+		c.log(logging.LevelWarn, "redis_lock: watchdog lost the lock", "key", c.key, "error", err)
+		c.markLost(err)
+	}
+}
+
+func (c *Lock) log(level logging.Level, msg string, keyvals ...any) {
+	if c.logger != nil {
+		c.logger.Log(level, msg, keyvals...)
+	}
+}
+
+func (c *Lock) UnLock(ctx context.Context) (err error) {
+	defer func() {
+		c.ins.ObserveRelease(c.label, time.Since(c.acquiredAt), err)
+	}()
+	// 不论是否还持有锁, 先停止续约, 否则释放之后的续约会把正常的解锁当成锁丢失
+	stopped := false
+	c.unlockOnce.Do(func() {
+		close(c.unlock)
+		stopped = true
+	})
+	ok, err := c.backend.Release(ctx, c.key, c.val)
+	// Transfer 之后本地锁已经交给新的持有者, 不再释放
+	if stopped {
+		c.release()
 	}
 	if err != nil {
 		return err
 	}
+	if !ok {
+		return ErrLockNotHold
+	}
 
 	return nil
 }
 
-func (c *Lock) Refresh(ctx context.Context) error {
-	res, err := c.client.Eval(ctx, luaRefresh, []string{c.key}, c.val, c.expired).Int64()
+func (c *Lock) Refresh(ctx context.Context) (err error) {
+	defer func() {
+		c.ins.ObserveRefresh(c.label, err)
+	}()
+	if c.Err() == ErrMaxHoldExceeded {
+		return ErrMaxHoldExceeded
+	}
+	ok, err := c.backend.Refresh(ctx, c.key, c.val, c.expired)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return wrap(ErrRefreshTimeout, err)
+	}
 	if err != nil {
 		return err
 	}
-	if res != NotExistKey {
+	if !ok {
+		// 与 UnLock 并发的续约, 锁是被正常释放的, 不算丢失
+		if !c.unlocked() {
+			c.markLost(ErrLockNotHold)
+		}
 		return ErrLockNotHold
 	}
 	return nil
 }
 
+// unlocked 返回是否已经调用过 UnLock 或 Transfer
+func (c *Lock) unlocked() bool {
+	select {
+	case <-c.unlock:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Lock) AutoRefresh(interval, timeout time.Duration) error {
 	// 自动加锁到什么时候结束：1）手动 unlock  2) 续约规定的最大时长
 	// 续时是否一直执行
@@ -72,50 +209,71 @@ func (c *Lock) AutoRefresh(interval, timeout time.Duration) error {
 		ticker.Stop()
 	}()
 	ch := make(chan struct{}, 1)
+	retry := func() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	// lastOK 最近一次续约成功的时间, 超过过期时间之后锁在 redis 中已经过期, 不再重试
+	lastOK := time.Now()
+	refresh := func() error {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+		err := c.Refresh(ctx)
+		cancelFunc()
+		switch {
+		case err == nil:
+			lastOK = time.Now()
+		// 续约锁超过了最大限制时长
+		case errors.Is(err, ErrRefreshTimeout):
+			retry()
+			return nil
+		// 哨兵切换主节点期间的临时错误, 锁过期之前稍后重试
+		case failoverErr(err) && time.Since(lastOK) < c.expired:
+			c.log(logging.LevelWarn, "redis_lock: refresh failed during failover, retrying", "key", c.key, "error", err)
+			time.AfterFunc(FailoverRetryInterval, retry)
+			return nil
+		}
+		// TODO 针对不同错误类型应该怎么处理 锁 (调用方解决)
+		return err
+	}
 	for {
 		select {
 		case <-ticker.C:
-			ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
-			err := c.Refresh(ctx)
-			cancelFunc()
-
-			// 续约锁超过了最大限制时长
-			if err == context.DeadlineExceeded {
-				select {
-				case ch <- struct{}{}:
-				default:
-				}
-				continue
-			}
-			// TODO 针对不同错误类型应该怎么处理 锁 (调用方解决)
-			if err != nil {
+			if err := refresh(); err != nil && !c.unlocked() {
 				return err
 			}
 		case <-ch:
-			ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
-			err := c.Refresh(ctx)
-			cancelFunc()
-
-			// 续约锁超过了最大限制时长
-			if err == context.DeadlineExceeded {
-				select {
-				case ch <- struct{}{}:
-				default:
-				}
-				continue
-			}
-			// TODO 针对不同错误类型应该怎么处理 锁 (调用方解决)
-			if err != nil {
+			if err := refresh(); err != nil && !c.unlocked() {
 				return err
 			}
 		// 锁已经成功释放
 		case <-c.unlock:
 			return nil
+		// 锁已经丢失(例如超过最大持有时长), 不再续约
+		case <-c.done:
+			return c.lostErr
 		}
 	}
 }
 
-// TODO
-func (c *Lock) GiveLockToOther() {
-
+// Transfer 把锁原子地交给 newOwnerVal, 不存在先释放再加锁的空窗期, 用于 leader 交接等场景
+// 成功后当前的锁不再被持有(看门狗停止续约), 返回新持有者对应的锁, 剩余过期时间和 fencing token 保持不变
+func (c *Lock) Transfer(ctx context.Context, newOwnerVal string) (*Lock, error) {
+	ok, err := c.backend.Transfer(ctx, c.key, c.val, newOwnerVal)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		c.markLost(ErrLockNotHold)
+		return nil, ErrLockNotHold
+	}
+	next := newLock(c.backend, c.key, newOwnerVal, c.expired, c.token)
+	next.ins, next.label, next.logger = c.ins, c.label, c.logger
+	c.unlockOnce.Do(func() {
+		close(c.unlock)
+		// 进程内的本地锁也一起交给新的持有者
+		next.release = c.release
+	})
+	return next, nil
 }
@@ -0,0 +1,30 @@
+-- KEYS[1] 锁, KEYS[2] 排队队列(score 为入队时间), KEYS[3] 等待者心跳(score 为最近一次尝试时间), KEYS[4] fencing 计数器
+-- ARGV[1] 持有者, ARGV[2] 过期时间(毫秒), ARGV[3] 当前时间(毫秒), ARGV[4] 等待者超时时间(毫秒)
+local now = tonumber(ARGV[3])
+-- 清理长时间没有再次尝试的等待者, 避免队首的等待者放弃后其他人永远拿不到锁
+local stale = redis.call("zrangebyscore", KEYS[3], "-inf", now - tonumber(ARGV[4]))
+for _, member in ipairs(stale) do
+    redis.call("zrem", KEYS[2], member)
+    redis.call("zrem", KEYS[3], member)
+end
+
+local val = redis.call("get", KEYS[1])
+if val == ARGV[1] then
+    redis.call("pexpire", KEYS[1], ARGV[2])
+    return tonumber(redis.call("get", KEYS[4]) or redis.call("incr", KEYS[4]))
+end
+
+redis.call("zadd", KEYS[2], "NX", now, ARGV[1])
+redis.call("zadd", KEYS[3], now, ARGV[1])
+if val then
+    return 0
+end
+-- 只有队首的等待者可以获得锁
+local head = redis.call("zrange", KEYS[2], 0, 0)[1]
+if head ~= ARGV[1] then
+    return 0
+end
+redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+redis.call("zrem", KEYS[2], ARGV[1])
+redis.call("zrem", KEYS[3], ARGV[1])
+return redis.call("incr", KEYS[4])
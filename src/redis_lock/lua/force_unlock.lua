@@ -0,0 +1,5 @@
+local res = redis.call("del", KEYS[1])
+if res == 1 then
+    redis.call("publish", ARGV[1], KEYS[1])
+end
+return res
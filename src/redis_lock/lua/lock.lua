@@ -1,9 +1,14 @@
-local val = redis.call("get", KEYS[1])
-if not val then
-    return redis.call('set', KEYS[1], ARGV[1], 'PX', ARGV[2])
-elseif val == ARGV[1] then
-    redis.call('expire', KEYS[1], ARGV[2])
-    return  "OK"
-else
-    return ""
-end
+local val = redis.call("get", KEYS[1])
+if not val then
+    redis.call('set', KEYS[1], ARGV[1], 'PX', ARGV[2])
+    return redis.call('incr', KEYS[2])
+elseif val == ARGV[1] then
+    redis.call('pexpire', KEYS[1], ARGV[2])
+    local token = redis.call('get', KEYS[2])
+    if not token then
+        token = redis.call('incr', KEYS[2])
+    end
+    return tonumber(token)
+else
+    return 0
+end
@@ -0,0 +1,11 @@
+-- 所有 key 都空闲(或者已经被自己持有)时才一起加锁
+for _, key in ipairs(KEYS) do
+    local val = redis.call("get", key)
+    if val and val ~= ARGV[1] then
+        return 0
+    end
+end
+for _, key in ipairs(KEYS) do
+    redis.call("set", key, ARGV[1], "PX", ARGV[2])
+end
+return 1
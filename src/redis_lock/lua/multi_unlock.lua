@@ -0,0 +1,9 @@
+-- ARGV[1] 持有者, ARGV[i+1] 为 KEYS[i] 的释放通知 channel
+local cnt = 0
+for i, key in ipairs(KEYS) do
+    if redis.call("get", key) == ARGV[1] then
+        cnt = cnt + redis.call("del", key)
+        redis.call("publish", ARGV[i + 1], key)
+    end
+end
+return cnt
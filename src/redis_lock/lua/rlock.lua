@@ -0,0 +1,13 @@
+-- KEYS[1] 读写锁 hash, KEYS[2] 写者等待标记
+-- ARGV[1] 持有者, ARGV[2] 过期时间(毫秒), ARGV[3] 是否写优先
+local mode = redis.call("hget", KEYS[1], "mode")
+if mode == "write" then
+    return 0
+end
+if ARGV[3] == "1" and redis.call("exists", KEYS[2]) == 1 then
+    return 0
+end
+redis.call("hset", KEYS[1], "mode", "read")
+redis.call("hincrby", KEYS[1], "r:" .. ARGV[1], 1)
+redis.call("pexpire", KEYS[1], ARGV[2])
+return 1
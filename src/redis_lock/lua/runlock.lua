@@ -0,0 +1,12 @@
+local field = "r:" .. ARGV[1]
+if redis.call("hget", KEYS[1], "mode") ~= "read" or redis.call("hexists", KEYS[1], field) == 0 then
+    return 0
+end
+if redis.call("hincrby", KEYS[1], field, -1) <= 0 then
+    redis.call("hdel", KEYS[1], field)
+end
+-- 只剩下 mode 字段说明已没有读者
+if redis.call("hlen", KEYS[1]) <= 1 then
+    redis.call("del", KEYS[1])
+end
+return 1
@@ -0,0 +1,7 @@
+local mode = redis.call("hget", KEYS[1], "mode")
+if (mode == "read" and redis.call("hexists", KEYS[1], "r:" .. ARGV[1]) == 1)
+    or (mode == "write" and redis.call("hget", KEYS[1], "owner") == ARGV[1]) then
+    return redis.call("pexpire", KEYS[1], ARGV[2])
+else
+    return 0
+end
@@ -0,0 +1,14 @@
+-- KEYS[1] 信号量 zset, member 为持有者, score 为许可过期时间
+-- ARGV[1] 持有者, ARGV[2] 过期时间(毫秒), ARGV[3] 当前时间(毫秒), ARGV[4] 许可数量
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[2])
+-- 清理已经过期的许可
+redis.call("zremrangebyscore", KEYS[1], "-inf", now)
+if redis.call("zscore", KEYS[1], ARGV[1]) or redis.call("zcard", KEYS[1]) < tonumber(ARGV[4]) then
+    redis.call("zadd", KEYS[1], now + ttl, ARGV[1])
+    if redis.call("pttl", KEYS[1]) < ttl then
+        redis.call("pexpire", KEYS[1], ttl)
+    end
+    return 1
+end
+return 0
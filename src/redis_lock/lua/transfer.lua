@@ -0,0 +1,12 @@
+-- 把锁原子地交给下一个持有者, 保留剩余的过期时间
+if redis.call("get", KEYS[1]) == ARGV[1] then
+    local ttl = redis.call("pttl", KEYS[1])
+    if ttl > 0 then
+        redis.call("set", KEYS[1], ARGV[2], "PX", ttl)
+    else
+        redis.call("set", KEYS[1], ARGV[2])
+    end
+    return 1
+else
+    return 0
+end
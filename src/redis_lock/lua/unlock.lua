@@ -1,5 +1,8 @@
-if redis.call("get", KEYS[1]) == ARGV[1] then
-    return redis.call("del", KEYS[1])
-else
-    return 0
-end
\ No newline at end of file
+if redis.call("get", KEYS[1]) == ARGV[1] then
+    local res = redis.call("del", KEYS[1])
+    -- 通知订阅了释放消息的等待者
+    redis.call("publish", ARGV[2], KEYS[1])
+    return res
+else
+    return 0
+end
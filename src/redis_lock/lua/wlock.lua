@@ -0,0 +1,19 @@
+-- KEYS[1] 读写锁 hash, KEYS[2] 写者等待标记
+-- ARGV[1] 持有者, ARGV[2] 过期时间(毫秒), ARGV[3] 是否写优先
+local mode = redis.call("hget", KEYS[1], "mode")
+if not mode then
+    redis.call("hset", KEYS[1], "mode", "write")
+    redis.call("hset", KEYS[1], "owner", ARGV[1])
+    redis.call("pexpire", KEYS[1], ARGV[2])
+    redis.call("del", KEYS[2])
+    return 1
+end
+if mode == "write" and redis.call("hget", KEYS[1], "owner") == ARGV[1] then
+    redis.call("pexpire", KEYS[1], ARGV[2])
+    return 1
+end
+if ARGV[3] == "1" then
+    -- 标记有写者在等待, 新的读者不再获得锁
+    redis.call("set", KEYS[2], ARGV[1], "PX", ARGV[2])
+end
+return 0
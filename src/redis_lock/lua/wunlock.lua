@@ -0,0 +1,5 @@
+if redis.call("hget", KEYS[1], "mode") == "write" and redis.call("hget", KEYS[1], "owner") == ARGV[1] then
+    return redis.call("del", KEYS[1])
+else
+    return 0
+end
@@ -0,0 +1,113 @@
+package redis_lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryBackend 纯内存的锁后端, 只在当前进程内生效
+// 用于在没有 redis 的环境下测试加锁逻辑, 或者单机部署时的降级
+// 除了 LockBackend 之外还实现了 AdminBackend、MultiBackend、SemaphoreBackend、RWBackend 和 FairBackend
+type MemoryBackend struct {
+	lock   sync.Mutex
+	locks  map[string]memoryLock
+	tokens map[string]int64
+	// semaphores 每个信号量的持有者及其许可的过期时间
+	semaphores map[string]map[string]time.Time
+	// rwLocks 读写锁, writerWait 写优先时写者等待标记的过期时间
+	rwLocks    map[string]*memoryRWLock
+	writerWait map[string]time.Time
+	// fairQueues 公平锁的等待队列, 按入队顺序排列
+	fairQueues map[string][]memoryWaiter
+}
+
+type memoryLock struct {
+	val      string
+	expireAt time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		locks:      make(map[string]memoryLock),
+		tokens:     make(map[string]int64),
+		semaphores: make(map[string]map[string]time.Time),
+		rwLocks:    make(map[string]*memoryRWLock),
+		writerWait: make(map[string]time.Time),
+		fairQueues: make(map[string][]memoryWaiter),
+	}
+}
+
+// get 返回未过期的锁, 已过期的锁直接删除
+func (m *MemoryBackend) get(key string) (memoryLock, bool) {
+	l, ok := m.locks[key]
+	if ok && !time.Now().Before(l.expireAt) {
+		delete(m.locks, key)
+		return memoryLock{}, false
+	}
+	return l, ok
+}
+
+func (m *MemoryBackend) Acquire(ctx context.Context, key string, val string, expiration time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.get(key)
+	if ok && l.val != val {
+		return 0, nil
+	}
+	m.locks[key] = memoryLock{
+		val:      val,
+		expireAt: time.Now().Add(expiration),
+	}
+	if !ok || m.tokens[key] == 0 {
+		m.tokens[key]++
+	}
+	return m.tokens[key], nil
+}
+
+func (m *MemoryBackend) Release(ctx context.Context, key string, val string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.get(key)
+	if !ok || l.val != val {
+		return false, nil
+	}
+	delete(m.locks, key)
+	return true, nil
+}
+
+func (m *MemoryBackend) Refresh(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.get(key)
+	if !ok || l.val != val {
+		return false, nil
+	}
+	l.expireAt = time.Now().Add(expiration)
+	m.locks[key] = l
+	return true, nil
+}
+
+func (m *MemoryBackend) Transfer(ctx context.Context, key string, val string, newVal string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.get(key)
+	if !ok || l.val != val {
+		return false, nil
+	}
+	l.val = newVal
+	m.locks[key] = l
+	return true, nil
+}
@@ -0,0 +1,44 @@
+package redis_lock
+
+import "time"
+
+// Instrumentation 锁的监控埋点, 可以对接 prometheus / opentelemetry 等
+// key 参数是经过 KeyPattern 归类之后的值, 避免监控维度随着锁的数量无限增长
+type Instrumentation interface {
+	// ObserveAcquire 加锁结束, 记录加锁耗时和重试次数, 加锁失败时 err 不为空
+	ObserveAcquire(key string, latency time.Duration, retries int, err error)
+	// ObserveRefresh 续约结束, 续约失败时 err 不为空
+	ObserveRefresh(key string, err error)
+	// ObserveRelease 解锁结束, 记录锁的持有时长
+	ObserveRelease(key string, held time.Duration, err error)
+}
+
+// WithInstrumentation 设置监控埋点
+func WithInstrumentation(ins Instrumentation) ClientOption {
+	return func(c *Client) {
+		c.ins = ins
+	}
+}
+
+// WithKeyPattern 设置 key 的归类方法, 例如把 order:123 归类为 order:*
+func WithKeyPattern(pattern func(key string) string) ClientOption {
+	return func(c *Client) {
+		c.keyPattern = pattern
+	}
+}
+
+type nopInstrumentation struct{}
+
+func (nopInstrumentation) ObserveAcquire(string, time.Duration, int, error) {}
+
+func (nopInstrumentation) ObserveRefresh(string, error) {}
+
+func (nopInstrumentation) ObserveRelease(string, time.Duration, error) {}
+
+// label 返回 key 在监控中使用的维度值
+func (c *Client) label(key string) string {
+	if c.keyPattern == nil {
+		return key
+	}
+	return c.keyPattern(key)
+}
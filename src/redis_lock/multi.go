@@ -0,0 +1,191 @@
+package redis_lock
+
+import (
+	"context"
+	_ "embed"
+	"sort"
+	"time"
+)
+
+/*
+多 key 加锁: 同时锁住多个资源, 要么全部成功要么全部失败, redis 上在一个 lua 脚本中完成, 后端需要实现 MultiBackend
+key 会先排序去重, 避免不同调用方以不同顺序加锁时互相等待; 集群上所有 key 必须在同一个 slot, 可以使用相同的 hash tag
+*/
+
+var (
+	//go:embed lua/multi_lock.lua
+	luaMultiLock string
+
+	//go:embed lua/multi_unlock.lua
+	luaMultiUnlock string
+
+	//go:embed lua/multi_refresh.lua
+	luaMultiRefresh string
+)
+
+// MultiBackend 支持多 key 加锁的锁后端, 与单个 key 的锁共用同一组 key
+type MultiBackend interface {
+	// AcquireMulti 所有 key 都空闲或者已经被 val 持有时一起加锁, 有一个 key 被别人持有时返回 false
+	AcquireMulti(ctx context.Context, keys []string, val string, expiration time.Duration) (bool, error)
+	// ReleaseMulti 释放仍被 val 持有的 key, 返回释放的数量
+	ReleaseMulti(ctx context.Context, keys []string, val string) (int, error)
+	// RefreshMulti 所有 key 都被 val 持有时一起续约, 否则返回 false
+	RefreshMulti(ctx context.Context, keys []string, val string, expiration time.Duration) (bool, error)
+}
+
+type MultiLock struct {
+	backend MultiBackend
+	keys    []string
+	val     string
+	expired time.Duration
+}
+
+// LockMulti 同时锁住多个 key, 只要有一个 key 被别人持有就按照重试策略重试
+func (c *Client) LockMulti(ctx context.Context, keys []string, val string, expiration time.Duration, retry RetryStrategy, timeout time.Duration) (*MultiLock, error) {
+	backend, ok := c.backend.(MultiBackend)
+	if !ok {
+		return nil, ErrBackendNotSupported
+	}
+	if err := checkExpiration(expiration); err != nil {
+		return nil, err
+	}
+	keys = sortKeys(keys)
+	if err := checkSlot(c.client, keys...); err != nil {
+		return nil, err
+	}
+	_, err := acquire(ctx, retry, timeout, nil, func(ctx context.Context) (int64, error) {
+		locked, err := backend.AcquireMulti(ctx, keys, val, expiration)
+		if locked {
+			return 1, err
+		}
+		return 0, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MultiLock{
+		backend: backend,
+		keys:    keys,
+		val:     val,
+		expired: expiration,
+	}, nil
+}
+
+// sortKeys 排序并去重
+func sortKeys(keys []string) []string {
+	res := make([]string, len(keys))
+	copy(res, keys)
+	sort.Strings(res)
+	n := 0
+	for i, key := range res {
+		if i == 0 || key != res[n-1] {
+			res[n] = key
+			n++
+		}
+	}
+	return res[:n]
+}
+
+// Keys 返回被锁住的 key
+func (m *MultiLock) Keys() []string {
+	return m.keys
+}
+
+// UnLock 一起释放所有 key, 有任何一个 key 已经不再被持有时返回 ErrLockNotHold
+func (m *MultiLock) UnLock(ctx context.Context) error {
+	n, err := m.backend.ReleaseMulti(ctx, m.keys, m.val)
+	if err != nil {
+		return err
+	}
+	if n != len(m.keys) {
+		return ErrLockNotHold
+	}
+	return nil
+}
+
+// Refresh 一起续约所有 key
+func (m *MultiLock) Refresh(ctx context.Context) error {
+	ok, err := m.backend.RefreshMulti(ctx, m.keys, m.val, m.expired)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHold
+	}
+	return nil
+}
+
+func (r *redisBackend) AcquireMulti(ctx context.Context, keys []string, val string, expiration time.Duration) (bool, error) {
+	res, err := r.client.Eval(ctx, luaMultiLock, keys, val, expiration.Milliseconds()).Int64()
+	return res == 1, err
+}
+
+func (r *redisBackend) ReleaseMulti(ctx context.Context, keys []string, val string) (int, error) {
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, val)
+	for _, key := range keys {
+		args = append(args, releaseChannel(key))
+	}
+	res, err := r.client.Eval(ctx, luaMultiUnlock, keys, args...).Int64()
+	return int(res), err
+}
+
+func (r *redisBackend) RefreshMulti(ctx context.Context, keys []string, val string, expiration time.Duration) (bool, error) {
+	res, err := r.client.Eval(ctx, luaMultiRefresh, keys, val, expiration.Milliseconds()).Int64()
+	return res == 1, err
+}
+
+func (m *MemoryBackend) AcquireMulti(ctx context.Context, keys []string, val string, expiration time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, key := range keys {
+		if l, ok := m.get(key); ok && l.val != val {
+			return false, nil
+		}
+	}
+	for _, key := range keys {
+		m.locks[key] = memoryLock{
+			val:      val,
+			expireAt: time.Now().Add(expiration),
+		}
+	}
+	return true, nil
+}
+
+func (m *MemoryBackend) ReleaseMulti(ctx context.Context, keys []string, val string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	n := 0
+	for _, key := range keys {
+		if l, ok := m.get(key); ok && l.val == val {
+			delete(m.locks, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryBackend) RefreshMulti(ctx context.Context, keys []string, val string, expiration time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, key := range keys {
+		if l, ok := m.get(key); !ok || l.val != val {
+			return false, nil
+		}
+	}
+	for _, key := range keys {
+		l := m.locks[key]
+		l.expireAt = time.Now().Add(expiration)
+		m.locks[key] = l
+	}
+	return true, nil
+}
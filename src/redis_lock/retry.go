@@ -1,11 +1,19 @@
 package redis_lock
 
-import "time"
+import (
+	"math/rand"
+	"time"
+)
 
+// RetryStrategy 重试策略, 策略是有状态的, 每次加锁都需要使用新的实例
+// 加锁时传入 nil 表示不重试
 type RetryStrategy interface {
 	Next() (time.Duration, bool)
 }
 
+// minBackoffInterval Initial 未设置时指数退避的初始间隔, 避免间隔一直为 0 导致忙等
+const minBackoffInterval = 10 * time.Millisecond
+
 type FixIntervalRetry struct {
 	Interval time.Duration // 重试间隔
 	Max      int           // 最大次数
@@ -16,3 +24,91 @@ func (f *FixIntervalRetry) Next() (time.Duration, bool) {
 	f.cnt++
 	return f.Interval, f.cnt <= f.Max
 }
+
+// ExponentialBackoffRetry 指数退避, 每次重试的间隔是上一次的 Multiplier 倍, 最大不超过 MaxInterval
+type ExponentialBackoffRetry struct {
+	Initial     time.Duration // 初始重试间隔, <=0 时按 minBackoffInterval 处理
+	MaxInterval time.Duration // 最大重试间隔, <=0 表示不限制
+	Multiplier  float64       // 增长倍数, <=1 时按 2 处理
+	Max         int           // 最大次数
+	cnt         int
+	interval    time.Duration
+}
+
+func (e *ExponentialBackoffRetry) Next() (time.Duration, bool) {
+	e.cnt++
+	if e.interval == 0 {
+		e.interval = e.Initial
+		if e.interval <= 0 {
+			e.interval = minBackoffInterval
+		}
+	} else {
+		multiplier := e.Multiplier
+		if multiplier <= 1 {
+			multiplier = 2
+		}
+		e.interval = time.Duration(float64(e.interval) * multiplier)
+	}
+	if e.MaxInterval > 0 && e.interval > e.MaxInterval {
+		e.interval = e.MaxInterval
+	}
+	return e.interval, e.cnt <= e.Max
+}
+
+// JitterRetry 在被包装策略的间隔上增加随机抖动, 避免大量等待者在同一时刻一起重试
+type JitterRetry struct {
+	Strategy RetryStrategy // 被包装的重试策略, nil 表示不重试
+	Jitter   float64       // 抖动比例, 0.2 表示在 [0.8, 1.2] 倍间隔之间随机
+}
+
+func (j *JitterRetry) Next() (time.Duration, bool) {
+	if j.Strategy == nil {
+		return 0, false
+	}
+	interval, ok := j.Strategy.Next()
+	if j.Jitter <= 0 || interval <= 0 {
+		return interval, ok
+	}
+	delta := float64(interval) * j.Jitter
+	interval = time.Duration(float64(interval) - delta + rand.Float64()*2*delta)
+	return interval, ok
+}
+
+// LimitedTotalTimeRetry 固定间隔重试, 直到从第一次重试开始累计超过 MaxElapsed
+type LimitedTotalTimeRetry struct {
+	Interval   time.Duration // 重试间隔
+	MaxElapsed time.Duration // 最大总时长
+	start      time.Time
+}
+
+func (l *LimitedTotalTimeRetry) Next() (time.Duration, bool) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	return l.Interval, time.Since(l.start)+l.Interval <= l.MaxElapsed
+}
+
+// WithMaxElapsed 为任意重试策略增加总时长限制, strategy 为 nil 时不重试
+func WithMaxElapsed(strategy RetryStrategy, maxElapsed time.Duration) RetryStrategy {
+	return &maxElapsedRetry{
+		strategy:   strategy,
+		maxElapsed: maxElapsed,
+	}
+}
+
+type maxElapsedRetry struct {
+	strategy   RetryStrategy
+	maxElapsed time.Duration
+	start      time.Time
+}
+
+func (m *maxElapsedRetry) Next() (time.Duration, bool) {
+	if m.strategy == nil {
+		return 0, false
+	}
+	if m.start.IsZero() {
+		m.start = time.Now()
+	}
+	interval, ok := m.strategy.Next()
+	return interval, ok && time.Since(m.start)+interval <= m.maxElapsed
+}
@@ -0,0 +1,281 @@
+package redis_lock
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+/*
+读写锁: 使用一个 redis hash 保存锁状态
+	mode  -> read / write
+	owner -> 写锁持有者
+	r:xxx -> 每个读者的重入次数
+多个读者可以同时持有读锁, 写锁是独占的; 开启写优先后, 有写者等待时新的读者会被拒绝, 避免写者饥饿
+NewRWClientWithBackend 可以使用其他实现了 RWBackend 的后端, 例如 MemoryBackend
+*/
+
+var (
+	//go:embed lua/rlock.lua
+	luaRLock string
+
+	//go:embed lua/wlock.lua
+	luaWLock string
+
+	//go:embed lua/runlock.lua
+	luaRUnlock string
+
+	//go:embed lua/wunlock.lua
+	luaWUnlock string
+
+	//go:embed lua/rwrefresh.lua
+	luaRWRefresh string
+)
+
+type RWOption func(c *RWClient)
+
+// WithWriterPreference 开启写优先
+func WithWriterPreference() RWOption {
+	return func(c *RWClient) {
+		c.writerPreferred = true
+	}
+}
+
+// RWBackend 读写锁的存储后端
+type RWBackend interface {
+	// AcquireRW 加读锁或者写锁, 相同的 val 可以重入, 失败时返回 false
+	// writerPreferred 为 true 时, 有写者在等待则拒绝新的读者, 加写锁失败的写者登记等待
+	AcquireRW(ctx context.Context, key string, val string, write bool, expiration time.Duration, writerPreferred bool) (bool, error)
+	// ReleaseRW 释放一次读锁或者写锁, 没有持有时返回 false
+	ReleaseRW(ctx context.Context, key string, val string, write bool) (bool, error)
+	// RefreshRW 续约, 没有持有时返回 false
+	RefreshRW(ctx context.Context, key string, val string, expiration time.Duration) (bool, error)
+}
+
+type RWClient struct {
+	// client 只用于集群上的 slot 检查, 使用其他后端时为 nil
+	client          redis.Cmdable
+	backend         RWBackend
+	writerPreferred bool
+}
+
+func NewRWClient(c redis.Cmdable, opts ...RWOption) *RWClient {
+	res := &RWClient{
+		client:  c,
+		backend: &redisBackend{client: c},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// NewRWClientWithBackend 使用自定义后端创建读写锁客户端
+func NewRWClientWithBackend(b RWBackend, opts ...RWOption) *RWClient {
+	res := &RWClient{
+		backend: b,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// RLock 加读锁
+func (c *RWClient) RLock(ctx context.Context, key string, val string, expiration time.Duration, retry RetryStrategy, timeout time.Duration) (*RWLock, error) {
+	return c.lock(ctx, false, key, val, expiration, retry, timeout)
+}
+
+// WLock 加写锁
+func (c *RWClient) WLock(ctx context.Context, key string, val string, expiration time.Duration, retry RetryStrategy, timeout time.Duration) (*RWLock, error) {
+	return c.lock(ctx, true, key, val, expiration, retry, timeout)
+}
+
+func (c *RWClient) lock(ctx context.Context, write bool, key string, val string,
+	expiration time.Duration, retry RetryStrategy, timeout time.Duration) (*RWLock, error) {
+	if err := checkExpiration(expiration); err != nil {
+		return nil, err
+	}
+	if err := checkSlot(c.client, key, writerWaitKey(key)); err != nil {
+		return nil, err
+	}
+	_, err := acquire(ctx, retry, timeout, nil, func(ctx context.Context) (int64, error) {
+		locked, err := c.backend.AcquireRW(ctx, key, val, write, expiration, c.writerPreferred)
+		if locked {
+			return 1, err
+		}
+		return 0, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RWLock{
+		backend: c.backend,
+		key:     key,
+		val:     val,
+		expired: expiration,
+		write:   write,
+	}, nil
+}
+
+// writerWaitKey 写者等待标记
+func writerWaitKey(key string) string {
+	return key + ":writer_waiting"
+}
+
+type RWLock struct {
+	backend RWBackend
+	key     string
+	val     string
+	expired time.Duration
+	write   bool
+}
+
+func (l *RWLock) UnLock(ctx context.Context) error {
+	ok, err := l.backend.ReleaseRW(ctx, l.key, l.val, l.write)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHold
+	}
+	return nil
+}
+
+// Refresh 续约, 注意所有读者共享同一个过期时间
+func (l *RWLock) Refresh(ctx context.Context) error {
+	ok, err := l.backend.RefreshRW(ctx, l.key, l.val, l.expired)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHold
+	}
+	return nil
+}
+
+func (r *redisBackend) AcquireRW(ctx context.Context, key string, val string, write bool, expiration time.Duration, writerPreferred bool) (bool, error) {
+	script, preferred := luaRLock, "0"
+	if write {
+		script = luaWLock
+	}
+	if writerPreferred {
+		preferred = "1"
+	}
+	res, err := r.client.Eval(ctx, script, []string{key, writerWaitKey(key)}, val, expiration.Milliseconds(), preferred).Int64()
+	return res == 1, err
+}
+
+func (r *redisBackend) ReleaseRW(ctx context.Context, key string, val string, write bool) (bool, error) {
+	script := luaRUnlock
+	if write {
+		script = luaWUnlock
+	}
+	res, err := r.client.Eval(ctx, script, []string{key}, val).Int64()
+	return res == DelSuccess, err
+}
+
+func (r *redisBackend) RefreshRW(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	res, err := r.client.Eval(ctx, luaRWRefresh, []string{key}, val, expiration.Milliseconds()).Int64()
+	return res == 1, err
+}
+
+// memoryRWLock 内存后端的读写锁, readers 记录每个读者的重入次数
+type memoryRWLock struct {
+	write    bool
+	owner    string
+	readers  map[string]int
+	expireAt time.Time
+}
+
+// getRW 返回未过期的读写锁, 已过期的直接删除
+func (m *MemoryBackend) getRW(key string) (*memoryRWLock, bool) {
+	l, ok := m.rwLocks[key]
+	if ok && !time.Now().Before(l.expireAt) {
+		delete(m.rwLocks, key)
+		return nil, false
+	}
+	return l, ok
+}
+
+func (m *MemoryBackend) AcquireRW(ctx context.Context, key string, val string, write bool, expiration time.Duration, writerPreferred bool) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	l, ok := m.getRW(key)
+	if write {
+		if !ok {
+			m.rwLocks[key] = &memoryRWLock{write: true, owner: val, expireAt: now.Add(expiration)}
+			delete(m.writerWait, key)
+			return true, nil
+		}
+		if l.write && l.owner == val {
+			l.expireAt = now.Add(expiration)
+			return true, nil
+		}
+		if writerPreferred {
+			m.writerWait[key] = now.Add(expiration)
+		}
+		return false, nil
+	}
+	if ok && l.write {
+		return false, nil
+	}
+	if writerPreferred && now.Before(m.writerWait[key]) {
+		return false, nil
+	}
+	if !ok {
+		l = &memoryRWLock{readers: make(map[string]int)}
+		m.rwLocks[key] = l
+	}
+	l.readers[val]++
+	l.expireAt = now.Add(expiration)
+	return true, nil
+}
+
+func (m *MemoryBackend) ReleaseRW(ctx context.Context, key string, val string, write bool) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.getRW(key)
+	if !ok || l.write != write {
+		return false, nil
+	}
+	if write {
+		if l.owner != val {
+			return false, nil
+		}
+		delete(m.rwLocks, key)
+		return true, nil
+	}
+	if l.readers[val] == 0 {
+		return false, nil
+	}
+	if l.readers[val]--; l.readers[val] == 0 {
+		delete(l.readers, val)
+	}
+	if len(l.readers) == 0 {
+		delete(m.rwLocks, key)
+	}
+	return true, nil
+}
+
+func (m *MemoryBackend) RefreshRW(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.getRW(key)
+	if !ok || (l.write && l.owner != val) || (!l.write && l.readers[val] == 0) {
+		return false, nil
+	}
+	l.expireAt = time.Now().Add(expiration)
+	return true, nil
+}
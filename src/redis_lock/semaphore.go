@@ -0,0 +1,145 @@
+package redis_lock
+
+import (
+	"context"
+	_ "embed"
+	"time"
+)
+
+/*
+信号量: 最多允许 permits 个持有者同时持有, 用于在多个实例之间限制并发度
+每个许可都有自己的过期时间, 持有者崩溃后许可会自动释放; 同一持有者再次 TryAcquire 即可续期
+后端需要实现 SemaphoreBackend, redis 和内存后端都支持
+*/
+
+var (
+	//go:embed lua/semaphore_acquire.lua
+	luaSemaphoreAcquire string
+)
+
+// SemaphoreBackend 支持信号量的锁后端
+type SemaphoreBackend interface {
+	// AcquireSemaphore 清理过期的许可, val 已经持有许可或者持有者少于 permits 时获得(续期)许可, 否则返回 false
+	AcquireSemaphore(ctx context.Context, key string, val string, expiration time.Duration, permits int) (bool, error)
+	// ReleaseSemaphore 释放 val 持有的许可, 没有持有时返回 false
+	ReleaseSemaphore(ctx context.Context, key string, val string) (bool, error)
+}
+
+type Semaphore struct {
+	client  *Client
+	key     string
+	permits int
+}
+
+// Semaphore 创建一个最多 permits 个持有者的信号量
+func (c *Client) Semaphore(key string, permits int) *Semaphore {
+	return &Semaphore{
+		client:  c,
+		key:     key,
+		permits: permits,
+	}
+}
+
+// Acquire 按照重试策略获取许可
+func (s *Semaphore) Acquire(ctx context.Context, val string, expiration time.Duration, retry RetryStrategy, timeout time.Duration) error {
+	_, err := acquire(ctx, retry, timeout, nil, func(ctx context.Context) (int64, error) {
+		return s.acquire(ctx, val, expiration)
+	})
+	return err
+}
+
+// TryAcquire 尝试获取一次许可, 没有空闲许可时返回 ErrLockHeldByOther
+func (s *Semaphore) TryAcquire(ctx context.Context, val string, expiration time.Duration) error {
+	res, err := s.acquire(ctx, val, expiration)
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrLockHeldByOther
+	}
+	return nil
+}
+
+func (s *Semaphore) acquire(ctx context.Context, val string, expiration time.Duration) (int64, error) {
+	backend, ok := s.client.backend.(SemaphoreBackend)
+	if !ok {
+		return 0, ErrBackendNotSupported
+	}
+	if err := checkExpiration(expiration); err != nil {
+		return 0, err
+	}
+	ok, err := backend.AcquireSemaphore(ctx, s.key, val, expiration, s.permits)
+	if ok {
+		return 1, err
+	}
+	return 0, err
+}
+
+// Release 释放许可
+func (s *Semaphore) Release(ctx context.Context, val string) error {
+	backend, ok := s.client.backend.(SemaphoreBackend)
+	if !ok {
+		return ErrBackendNotSupported
+	}
+	ok, err := backend.ReleaseSemaphore(ctx, s.key, val)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHold
+	}
+	return nil
+}
+
+func (r *redisBackend) AcquireSemaphore(ctx context.Context, key string, val string, expiration time.Duration, permits int) (bool, error) {
+	res, err := r.client.Eval(ctx, luaSemaphoreAcquire, []string{key}, val,
+		expiration.Milliseconds(), time.Now().UnixMilli(), permits).Int64()
+	return res == 1, err
+}
+
+func (r *redisBackend) ReleaseSemaphore(ctx context.Context, key string, val string) (bool, error) {
+	res, err := r.client.ZRem(ctx, key, val).Result()
+	return res == DelSuccess, err
+}
+
+func (m *MemoryBackend) AcquireSemaphore(ctx context.Context, key string, val string, expiration time.Duration, permits int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	holders := m.semaphores[key]
+	for holder, expireAt := range holders {
+		if !now.Before(expireAt) {
+			delete(holders, holder)
+		}
+	}
+	if _, ok := holders[val]; !ok && len(holders) >= permits {
+		return false, nil
+	}
+	if holders == nil {
+		holders = make(map[string]time.Time)
+		m.semaphores[key] = holders
+	}
+	holders[val] = now.Add(expiration)
+	return true, nil
+}
+
+func (m *MemoryBackend) ReleaseSemaphore(ctx context.Context, key string, val string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	holders := m.semaphores[key]
+	expireAt, ok := holders[val]
+	if !ok {
+		return false, nil
+	}
+	delete(holders, val)
+	if len(holders) == 0 {
+		delete(m.semaphores, key)
+	}
+	return time.Now().Before(expireAt), nil
+}
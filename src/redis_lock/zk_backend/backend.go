@@ -0,0 +1,326 @@
+package zk_backend
+
+import (
+	"cache/src/redis_lock"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+基于 ZooKeeper 的锁后端, 直接实现了 ZooKeeper 的二进制协议中用到的部分, 不依赖第三方客户端
+	加锁: 在 key 对应的目录下创建临时顺序节点, 节点的数据为持有者, 序号最小的节点持有锁, 节点的 czxid 作为 fencing token
+		没有排在最前面时删除自己的节点, 返回 0, 由客户端的重试策略决定是否继续等待
+	续约: 临时节点随会话存在, 会话由后台的心跳维持, 锁的有效期由会话超时决定, expiration 不生效
+	解锁: 持有者相同时按版本号删除节点
+连接断开后不会重连, 之后的操作都返回 ErrClosed, 会话超时后服务端删除临时节点, 锁随之释放
+*/
+
+var (
+	_ redis_lock.LockBackend  = (*Backend)(nil)
+	_ redis_lock.AdminBackend = (*Backend)(nil)
+)
+
+var ErrClosed = errors.New("zk_backend: connection closed")
+
+type Backend struct {
+	conn    net.Conn
+	root    string
+	timeout time.Duration
+
+	// wlock 保证请求完整地写入连接
+	wlock   sync.Mutex
+	lock    sync.Mutex
+	xid     int32
+	pending map[int32]chan reply
+	err     error
+	done    chan struct{}
+}
+
+type reply struct {
+	body []byte
+	err  error
+}
+
+// Dial 连接 addr 上的 ZooKeeper 并建立会话, 锁节点创建在 root 下, 例如 /locks
+func Dial(ctx context.Context, addr string, root string, sessionTimeout time.Duration) (*Backend, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewBackend(ctx, conn, root, sessionTimeout)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewBackend 在已经建立的连接上创建会话, 会话超时以服务端协商的结果为准
+func NewBackend(ctx context.Context, conn net.Conn, root string, sessionTimeout time.Duration) (*Backend, error) {
+	timeout, err := handshake(ctx, conn, sessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backend{
+		conn:    conn,
+		root:    strings.TrimSuffix(root, "/"),
+		timeout: timeout,
+		pending: make(map[int32]chan reply),
+		done:    make(chan struct{}),
+	}
+	go b.read()
+	go b.ping()
+	return b, nil
+}
+
+// SessionTimeout 返回协商后的会话超时, 持有者崩溃后锁最多保留这么久
+func (b *Backend) SessionTimeout() time.Duration {
+	return b.timeout
+}
+
+// Close 关闭会话, 服务端立即删除会话创建的临时节点, 持有的锁全部释放
+func (b *Backend) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	_, err := b.call(ctx, opCloseSession, func(e *encoder) {})
+	b.fail(ErrClosed)
+	return err
+}
+
+func (b *Backend) Acquire(ctx context.Context, key string, val string, expiration time.Duration) (int64, error) {
+	dir := b.dir(key)
+	h, err := b.holder(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+	if h != nil {
+		// 相同的 val 重入, 临时节点不需要刷新
+		if h.data == val {
+			return h.stat.czxid, nil
+		}
+		return 0, nil
+	}
+	// 节点名带上随机前缀, 创建的结果丢失时可以找到并删除自己的节点
+	prefix := nodePrefix()
+	path, stat, err := b.create(ctx, dir, prefix, val)
+	if err != nil {
+		if _, ok := err.(zkError); !ok {
+			go b.cleanup(dir, prefix)
+		}
+		return 0, err
+	}
+	children, err := b.children(ctx, dir)
+	if err == nil && len(children) > 0 && dir+"/"+children[0] == path {
+		return stat.czxid, nil
+	}
+	// 前面还有别人, 放弃这次尝试
+	if dErr := b.delete(context.Background(), path, -1); dErr != nil && dErr != errNoNode {
+		go b.cleanup(dir, prefix)
+	}
+	return 0, err
+}
+
+func (b *Backend) Release(ctx context.Context, key string, val string) (bool, error) {
+	h, err := b.holder(ctx, b.dir(key))
+	if err != nil || h == nil || h.data != val {
+		return false, err
+	}
+	// 按版本号删除, 锁在读取之后被转让时不会误删
+	err = b.delete(ctx, h.path, h.stat.version)
+	if err == errNoNode || err == errBadVersion {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Backend) Refresh(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	h, err := b.holder(ctx, b.dir(key))
+	if err != nil {
+		return false, err
+	}
+	return h != nil && h.data == val, nil
+}
+
+func (b *Backend) Transfer(ctx context.Context, key string, val string, newVal string) (bool, error) {
+	h, err := b.holder(ctx, b.dir(key))
+	if err != nil || h == nil || h.data != val {
+		return false, err
+	}
+	_, err = b.call(ctx, opSetData, func(e *encoder) {
+		e.string(h.path)
+		e.bytes([]byte(newVal))
+		e.int32(h.stat.version)
+	})
+	if err == errNoNode || err == errBadVersion {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Inspect 临时节点没有过期时间, 返回的 ttl 为会话超时, 即持有者崩溃后锁最多保留的时长
+func (b *Backend) Inspect(ctx context.Context, key string) (string, time.Duration, error) {
+	h, err := b.holder(ctx, b.dir(key))
+	if err != nil || h == nil {
+		return "", 0, err
+	}
+	return h.data, b.timeout, nil
+}
+
+// ForceRelease 删除 key 目录下所有的节点, 包括持有者和正在尝试的节点
+func (b *Backend) ForceRelease(ctx context.Context, key string) error {
+	dir := b.dir(key)
+	children, err := b.children(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err = b.delete(ctx, dir+"/"+child, -1); err != nil && err != errNoNode {
+			return err
+		}
+	}
+	return nil
+}
+
+// dir 返回 key 对应的目录, key 中的 / 会被转义
+func (b *Backend) dir(key string) string {
+	return b.root + "/" + url.PathEscape(key)
+}
+
+type node struct {
+	path string
+	data string
+	stat stat
+}
+
+// holder 返回 dir 下序号最小的节点, 没有节点时返回 nil
+func (b *Backend) holder(ctx context.Context, dir string) (*node, error) {
+	for {
+		children, err := b.children(ctx, dir)
+		if err != nil || len(children) == 0 {
+			return nil, err
+		}
+		path := dir + "/" + children[0]
+		d, err := b.call(ctx, opGetData, func(e *encoder) {
+			e.string(path)
+			e.bool(false)
+		})
+		// 节点在列出之后被删除了, 重新查找
+		if err == errNoNode {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		n := &node{path: path, data: string(d.bytes())}
+		n.stat = d.stat()
+		return n, d.err
+	}
+}
+
+// children 按序号从小到大返回 dir 下的节点, 目录不存在时返回空
+func (b *Backend) children(ctx context.Context, dir string) ([]string, error) {
+	d, err := b.call(ctx, opGetChildren, func(e *encoder) {
+		e.string(dir)
+		e.bool(false)
+	})
+	if err == errNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, d.int32())
+	for i := range res {
+		res[i] = d.string()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return sequence(res[i]) < sequence(res[j])
+	})
+	return res, nil
+}
+
+// create 在 dir 下创建临时顺序节点, 目录不存在时先逐级创建目录
+func (b *Backend) create(ctx context.Context, dir string, prefix string, val string) (string, stat, error) {
+	path, st, err := b.create2(ctx, dir+"/"+prefix, []byte(val), flagEphemeral|flagSequence)
+	if err != errNoNode {
+		return path, st, err
+	}
+	parts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	for i := range parts {
+		_, _, err = b.create2(ctx, "/"+strings.Join(parts[:i+1], "/"), nil, 0)
+		if err != nil && err != errNodeExists {
+			return "", stat{}, err
+		}
+	}
+	return b.create2(ctx, dir+"/"+prefix, []byte(val), flagEphemeral|flagSequence)
+}
+
+func (b *Backend) create2(ctx context.Context, path string, data []byte, flags int32) (string, stat, error) {
+	d, err := b.call(ctx, opCreate2, func(e *encoder) {
+		e.string(path)
+		e.bytes(data)
+		e.worldACL()
+		e.int32(flags)
+	})
+	if err != nil {
+		return "", stat{}, err
+	}
+	res := d.string()
+	st := d.stat()
+	return res, st, d.err
+}
+
+func (b *Backend) delete(ctx context.Context, path string, version int32) error {
+	_, err := b.call(ctx, opDelete, func(e *encoder) {
+		e.string(path)
+		e.int32(version)
+	})
+	return err
+}
+
+// cleanup 删除 dir 下以 prefix 开头的节点, 用于创建或删除的结果不确定时
+func (b *Backend) cleanup(dir string, prefix string) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	children, err := b.children(ctx, dir)
+	if err != nil {
+		return
+	}
+	for _, child := range children {
+		if strings.HasPrefix(child, prefix) {
+			_ = b.delete(ctx, dir+"/"+child, -1)
+		}
+	}
+}
+
+// nodePrefix 随机的节点名前缀, 服务端会在后面追加 10 位序号
+func nodePrefix() string {
+	var buf [8]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:]) + "-lock-"
+}
+
+// sequence 解析节点名末尾的序号
+func sequence(name string) int64 {
+	if len(name) < 10 {
+		return -1
+	}
+	res, err := strconv.ParseInt(name[len(name)-10:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return res
+}
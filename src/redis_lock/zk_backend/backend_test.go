@@ -0,0 +1,404 @@
+package zk_backend
+
+import (
+	"cache/src/redis_lock"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer 模拟 ZooKeeper 中后端用到的请求, 会话只有在调用 expire 或者客户端关闭时才会结束
+type fakeServer struct {
+	mu      sync.Mutex
+	zxid    int64
+	session int64
+	nodes   map[string]*fakeNode
+	conns   map[int64]net.Conn
+	ln      net.Listener
+}
+
+type fakeNode struct {
+	data    []byte
+	czxid   int64
+	version int32
+	owner   int64
+	// seq 下一个子节点的序号
+	seq int32
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		nodes: map[string]*fakeNode{"/": {}},
+		conns: make(map[int64]net.Conn),
+		ln:    ln,
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, conn := range s.conns {
+			_ = conn.Close()
+		}
+	})
+	go s.serve()
+	return s
+}
+
+// dial 以新的会话连接服务端
+func (s *fakeServer) dial(t *testing.T) *Backend {
+	b, err := Dial(context.Background(), s.ln.Addr().String(), "/locks", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = b.Close()
+	})
+	return b
+}
+
+// expire 模拟会话超时: 删除会话的临时节点并断开连接
+func (s *fakeServer) expire(session int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeSession(session)
+}
+
+func (s *fakeServer) closeSession(session int64) {
+	for path, n := range s.nodes {
+		if n.owner == session {
+			delete(s.nodes, path)
+		}
+	}
+	if conn, ok := s.conns[session]; ok {
+		_ = conn.Close()
+		delete(s.conns, session)
+	}
+}
+
+// children 返回 path 下的节点名
+func (s *fakeServer) children(path string) []string {
+	var res []string
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for p := range s.nodes {
+		if p != "/" && strings.HasPrefix(p, prefix) && !strings.Contains(p[len(prefix):], "/") {
+			res = append(res, p[len(prefix):])
+		}
+	}
+	return res
+}
+
+func (s *fakeServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.children(path))
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	buf, err := readPacket(conn)
+	if err != nil {
+		return
+	}
+	d := &decoder{buf: buf}
+	d.int32()
+	d.int64()
+	timeout := d.int32()
+	s.mu.Lock()
+	s.session++
+	session := s.session
+	s.conns[session] = conn
+	s.mu.Unlock()
+	e := &encoder{}
+	e.int32(0)
+	e.int32(timeout)
+	e.int64(session)
+	e.bytes(make([]byte, 16))
+	e.bool(false)
+	if writePacket(conn, e.buf) != nil {
+		return
+	}
+	for {
+		buf, err = readPacket(conn)
+		if err != nil {
+			return
+		}
+		d = &decoder{buf: buf}
+		xid, op := d.int32(), d.int32()
+		s.mu.Lock()
+		body := &encoder{}
+		code := s.apply(session, op, d, body)
+		s.zxid++
+		e = &encoder{}
+		e.int32(xid)
+		e.int64(s.zxid)
+		e.int32(int32(code))
+		if code == 0 {
+			e.buf = append(e.buf, body.buf...)
+		}
+		s.mu.Unlock()
+		if writePacket(conn, e.buf) != nil {
+			return
+		}
+		if op == opCloseSession {
+			s.expire(session)
+			return
+		}
+	}
+}
+
+// apply 执行请求, 响应体写入 e, 返回错误码
+func (s *fakeServer) apply(session int64, op int32, d *decoder, e *encoder) zkError {
+	switch op {
+	case opPing, opCloseSession:
+	case opCreate2:
+		path, data := d.string(), d.bytes()
+		for i, n := 0, d.int32(); i < int(n); i++ {
+			d.int32()
+			d.string()
+			d.string()
+		}
+		flags := d.int32()
+		parent := path[:strings.LastIndex(path, "/")]
+		if parent == "" {
+			parent = "/"
+		}
+		p, ok := s.nodes[parent]
+		if !ok {
+			return errNoNode
+		}
+		if flags&flagSequence != 0 {
+			path += fmt.Sprintf("%010d", p.seq)
+			p.seq++
+		}
+		if _, ok = s.nodes[path]; ok {
+			return errNodeExists
+		}
+		n := &fakeNode{data: data, czxid: s.zxid + 1}
+		if flags&flagEphemeral != 0 {
+			n.owner = session
+		}
+		s.nodes[path] = n
+		e.string(path)
+		writeStat(e, n)
+	case opGetChildren:
+		path := d.string()
+		if _, ok := s.nodes[path]; !ok {
+			return errNoNode
+		}
+		children := s.children(path)
+		e.int32(int32(len(children)))
+		for _, child := range children {
+			e.string(child)
+		}
+	case opGetData:
+		n, ok := s.nodes[d.string()]
+		if !ok {
+			return errNoNode
+		}
+		e.bytes(n.data)
+		writeStat(e, n)
+	case opSetData:
+		n, ok := s.nodes[d.string()]
+		data, version := d.bytes(), d.int32()
+		if !ok {
+			return errNoNode
+		}
+		if version != -1 && version != n.version {
+			return errBadVersion
+		}
+		n.data = data
+		n.version++
+		writeStat(e, n)
+	case opDelete:
+		path := d.string()
+		n, ok := s.nodes[path]
+		version := d.int32()
+		if !ok {
+			return errNoNode
+		}
+		if version != -1 && version != n.version {
+			return errBadVersion
+		}
+		if len(s.children(path)) > 0 {
+			return -111
+		}
+		delete(s.nodes, path)
+	default:
+		return -6
+	}
+	return 0
+}
+
+func writeStat(e *encoder, n *fakeNode) {
+	e.int64(n.czxid)
+	e.int64(0)
+	e.int64(0)
+	e.int64(0)
+	e.int32(n.version)
+	e.int32(0)
+	e.int32(0)
+	e.int64(n.owner)
+	e.int32(int32(len(n.data)))
+	e.int32(0)
+	e.int64(0)
+}
+
+func TestAcquireRelease(t *testing.T) {
+	s := newFakeServer(t)
+	b := s.dial(t)
+	ctx := context.Background()
+
+	token, err := b.Acquire(ctx, "order/1", "a", time.Second)
+	if err != nil || token <= 0 {
+		t.Fatalf("unexpected acquire result %d %v", token, err)
+	}
+	// key 中的 / 被转义, 每个 key 一个目录
+	dir := "/locks/order%2F1"
+	if s.count(dir) != 1 {
+		t.Fatalf("expected 1 node under %s", dir)
+	}
+	if res, err := b.Acquire(ctx, "order/1", "b", time.Second); err != nil || res != 0 {
+		t.Fatalf("lock held by a should not be acquired: %d %v", res, err)
+	}
+	if s.count(dir) != 1 {
+		t.Fatal("the node of a failed attempt should be deleted")
+	}
+	// 相同的 val 重入, token 不变
+	if res, err := b.Acquire(ctx, "order/1", "a", time.Second); err != nil || res != token {
+		t.Fatalf("unexpected reentrant result %d %v, want %d", res, err, token)
+	}
+	owner, ttl, err := b.Inspect(ctx, "order/1")
+	if err != nil || owner != "a" || ttl != time.Second {
+		t.Fatalf("unexpected inspect result %s %v %v", owner, ttl, err)
+	}
+
+	if ok, err := b.Release(ctx, "order/1", "b"); err != nil || ok {
+		t.Fatalf("b should not release the lock of a: %v %v", ok, err)
+	}
+	if ok, err := b.Release(ctx, "order/1", "a"); err != nil || !ok {
+		t.Fatalf("unexpected release result %v %v", ok, err)
+	}
+	next, err := b.Acquire(ctx, "order/1", "b", time.Second)
+	if err != nil || next <= token {
+		t.Fatalf("token should increase, got %d after %d: %v", next, token, err)
+	}
+	if err = b.ForceRelease(ctx, "order/1"); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _, err = b.Inspect(ctx, "order/1"); err != nil || owner != "" {
+		t.Fatalf("the lock should be force released, owner %q: %v", owner, err)
+	}
+}
+
+func TestRefreshTransfer(t *testing.T) {
+	s := newFakeServer(t)
+	b := s.dial(t)
+	ctx := context.Background()
+
+	if _, err := b.Acquire(ctx, "key", "a", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.Refresh(ctx, "key", "a", time.Second); err != nil || !ok {
+		t.Fatalf("unexpected refresh result %v %v", ok, err)
+	}
+	if ok, err := b.Transfer(ctx, "key", "a", "c"); err != nil || !ok {
+		t.Fatalf("unexpected transfer result %v %v", ok, err)
+	}
+	if ok, err := b.Refresh(ctx, "key", "a", time.Second); err != nil || ok {
+		t.Fatalf("a should not hold the lock after the transfer: %v %v", ok, err)
+	}
+	if ok, err := b.Release(ctx, "key", "c"); err != nil || !ok {
+		t.Fatalf("unexpected release result %v %v", ok, err)
+	}
+}
+
+func TestLostSession(t *testing.T) {
+	s := newFakeServer(t)
+	b1, b2 := s.dial(t), s.dial(t)
+	c1, c2 := redis_lock.NewClientWithBackend(b1), redis_lock.NewClientWithBackend(b2)
+	ctx := context.Background()
+
+	l, err := c1.TryLock(ctx, "key", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 等待者在锁释放后重试成功
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = l.UnLock(ctx)
+	}()
+	l2, err := c2.Lock(ctx, "key", "b", time.Second, &redis_lock.FixIntervalRetry{Interval: 5 * time.Millisecond, Max: 100}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 会话超时后临时节点被删除, 其他会话可以加锁
+	s.expire(2)
+	if err = l2.Refresh(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = c1.TryLock(ctx, "key", "c", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭会话时释放持有的锁
+	b3 := s.dial(t)
+	if err = b1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := b3.Acquire(ctx, "key", "d", time.Second); err != nil || token <= 0 {
+		t.Fatalf("the lock should be released with the session: %d %v", token, err)
+	}
+}
+
+func TestContention(t *testing.T) {
+	s := newFakeServer(t)
+	ctx := context.Background()
+	backends := make([]*Backend, 8)
+	for i := range backends {
+		backends[i] = s.dial(t)
+	}
+	var (
+		wg      sync.WaitGroup
+		winners sync.Map
+	)
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *Backend) {
+			defer wg.Done()
+			if token, err := b.Acquire(ctx, "key", fmt.Sprint(i), time.Second); err == nil && token > 0 {
+				winners.Store(i, token)
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	n := 0
+	winners.Range(func(k, v any) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Fatalf("expected exactly one winner, got %d", n)
+	}
+	if s.count("/locks/key") != 1 {
+		t.Fatal("the nodes of the losers should be deleted")
+	}
+}
@@ -0,0 +1,346 @@
+package zk_backend
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+/*
+ZooKeeper 协议: 每个包以 4 字节大端长度开头, 整数为大端, 字符串和字节数组以 int32 长度开头(-1 表示 null)
+请求头为 xid + 操作码, 响应头为 xid + zxid + 错误码; 服务端按请求的顺序响应, 心跳的 xid 固定为 -2, watch 通知为 -1
+*/
+
+const (
+	opDelete       int32 = 2
+	opGetData      int32 = 4
+	opSetData      int32 = 5
+	opGetChildren  int32 = 8
+	opPing         int32 = 11
+	opCreate2      int32 = 15
+	opCloseSession int32 = -11
+
+	pingXid  int32 = -2
+	watchXid int32 = -1
+
+	flagEphemeral int32 = 1
+	flagSequence  int32 = 2
+
+	// permAll 所有权限, 锁节点使用 world:anyone
+	permAll int32 = 31
+	// maxPacket 与服务端 jute.maxbuffer 的默认值一致
+	maxPacket = 1 << 20
+)
+
+// zkError ZooKeeper 返回的错误码
+type zkError int32
+
+const (
+	errNoNode     zkError = -101
+	errBadVersion zkError = -103
+	errNodeExists zkError = -110
+)
+
+func (e zkError) Error() string {
+	switch e {
+	case errNoNode:
+		return "zk_backend: node does not exist"
+	case errBadVersion:
+		return "zk_backend: version conflict"
+	case errNodeExists:
+		return "zk_backend: node already exists"
+	}
+	return fmt.Sprintf("zk_backend: error code %d", int32(e))
+}
+
+// handshake 发送连接请求, 返回服务端协商的会话超时
+func handshake(ctx context.Context, conn net.Conn, sessionTimeout time.Duration) (time.Duration, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	e := &encoder{}
+	e.int32(0) // protocolVersion
+	e.int64(0) // lastZxidSeen
+	e.int32(int32(sessionTimeout.Milliseconds()))
+	e.int64(0) // sessionId, 0 表示新会话
+	e.bytes(make([]byte, 16))
+	e.bool(false) // readOnly
+	if err := writePacket(conn, e.buf); err != nil {
+		return 0, err
+	}
+	buf, err := readPacket(conn)
+	if err != nil {
+		return 0, err
+	}
+	d := &decoder{buf: buf}
+	d.int32() // protocolVersion
+	timeout := d.int32()
+	d.int64() // sessionId
+	d.bytes() // passwd
+	if d.err != nil {
+		return 0, d.err
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%w: session rejected", ErrClosed)
+	}
+	return time.Duration(timeout) * time.Millisecond, nil
+}
+
+// call 发送请求并等待响应, 返回的 decoder 位于响应头之后
+func (b *Backend) call(ctx context.Context, op int32, body func(e *encoder)) (*decoder, error) {
+	ch := make(chan reply, 1)
+	b.lock.Lock()
+	if b.err != nil {
+		b.lock.Unlock()
+		return nil, b.err
+	}
+	b.xid++
+	xid := b.xid
+	b.pending[xid] = ch
+	b.lock.Unlock()
+
+	e := &encoder{}
+	e.int32(xid)
+	e.int32(op)
+	body(e)
+	if err := b.write(e.buf); err != nil {
+		b.fail(err)
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &decoder{buf: r.body}, nil
+	case <-ctx.Done():
+		b.lock.Lock()
+		delete(b.pending, xid)
+		b.lock.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (b *Backend) write(buf []byte) error {
+	b.wlock.Lock()
+	defer b.wlock.Unlock()
+	return writePacket(b.conn, buf)
+}
+
+// read 读取响应并交给等待的请求, 连接出错时结束所有等待的请求
+func (b *Backend) read() {
+	for {
+		buf, err := readPacket(b.conn)
+		if err != nil {
+			b.fail(err)
+			return
+		}
+		d := &decoder{buf: buf}
+		xid := d.int32()
+		d.int64() // zxid
+		code := d.int32()
+		if d.err != nil {
+			b.fail(d.err)
+			return
+		}
+		if xid == pingXid || xid == watchXid {
+			continue
+		}
+		b.lock.Lock()
+		ch, ok := b.pending[xid]
+		delete(b.pending, xid)
+		b.lock.Unlock()
+		if !ok {
+			continue
+		}
+		r := reply{body: d.buf}
+		if code != 0 {
+			r.err = zkError(code)
+		}
+		ch <- r
+	}
+}
+
+// ping 每隔会话超时的 1/3 发送一次心跳, 维持会话和临时节点
+func (b *Backend) ping() {
+	ticker := time.NewTicker(b.timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e := &encoder{}
+			e.int32(pingXid)
+			e.int32(opPing)
+			if err := b.write(e.buf); err != nil {
+				b.fail(err)
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// fail 关闭连接, 之后的请求都返回 ErrClosed
+func (b *Backend) fail(err error) {
+	b.lock.Lock()
+	if b.err != nil {
+		b.lock.Unlock()
+		return
+	}
+	if err != ErrClosed {
+		err = fmt.Errorf("%w: %v", ErrClosed, err)
+	}
+	b.err = err
+	pending := b.pending
+	b.pending = make(map[int32]chan reply)
+	close(b.done)
+	b.lock.Unlock()
+	_ = b.conn.Close()
+	for _, ch := range pending {
+		ch <- reply{err: err}
+	}
+}
+
+func writePacket(w io.Writer, buf []byte) error {
+	packet := make([]byte, 4, 4+len(buf))
+	binary.BigEndian.PutUint32(packet, uint32(len(buf)))
+	_, err := w.Write(append(packet, buf...))
+	return err
+}
+
+func readPacket(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxPacket {
+		return nil, fmt.Errorf("zk_backend: packet of %d bytes", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.int32(int32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// worldACL 只有一项 world:anyone 所有权限的 ACL
+func (e *encoder) worldACL() {
+	e.int32(1)
+	e.int32(permAll)
+	e.string("world")
+	e.string("anyone")
+}
+
+// decoder 按顺序读取字段, 数据不足时记录错误并返回零值
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	res := d.buf[:n]
+	d.buf = d.buf[n:]
+	return res
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	b := d.next(1)
+	return b != nil && b[0] != 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n == -1 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// stat 节点的元数据, 只保留用到的字段
+type stat struct {
+	czxid   int64
+	version int32
+}
+
+func (d *decoder) stat() stat {
+	var s stat
+	s.czxid = d.int64()
+	d.int64() // mzxid
+	d.int64() // ctime
+	d.int64() // mtime
+	s.version = d.int32()
+	d.int32() // cversion
+	d.int32() // aversion
+	d.int64() // ephemeralOwner
+	d.int32() // dataLength
+	d.int32() // numChildren
+	d.int64() // pzxid
+	return s
+}
@@ -0,0 +1,142 @@
+package replication
+
+import (
+	"cache/src/local_cache"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var errGap = errors.New("replication: gap in the sequence numbers")
+
+type FollowerOption func(f *Follower)
+
+// WithRetryInterval sets the delay before reconnecting to the leader, one second by default
+func WithRetryInterval(d time.Duration) FollowerOption {
+	return func(f *Follower) {
+		if d > 0 {
+			f.retry = d
+		}
+	}
+}
+
+// WithOnError receives the errors that ended a connection to the leader
+func WithOnError(fn func(err error)) FollowerOption {
+	return func(f *Follower) {
+		f.onError = fn
+	}
+}
+
+type Follower struct {
+	c       *local_cache.Cache
+	addr    string
+	retry   time.Duration
+	onError func(err error)
+
+	epoch int64
+	// next is the sequence number of the next change to apply, 0 until the first snapshot
+	next    uint64
+	applied atomic.Uint64
+}
+
+// NewFollower replicates the leader listening on addr into c, call Run to start
+func NewFollower(c *local_cache.Cache, addr string, opts ...FollowerOption) *Follower {
+	f := &Follower{
+		c:     c,
+		addr:  addr,
+		retry: time.Second,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Seq returns the sequence number of the last change applied
+func (f *Follower) Seq() uint64 {
+	return f.applied.Load()
+}
+
+// Run replicates until ctx is done, reconnecting after errors; it returns ctx.Err()
+func (f *Follower) Run(ctx context.Context) error {
+	for {
+		err := f.session(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if f.onError != nil && err != nil {
+			f.onError(err)
+		}
+		t := time.NewTimer(f.retry)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (f *Follower) session(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", f.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// unblocks Decode when ctx is done
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err = gob.NewEncoder(conn).Encode(hello{Epoch: f.epoch, From: f.next}); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(conn)
+	for {
+		var fr frame
+		if err = dec.Decode(&fr); err != nil {
+			return err
+		}
+		if fr.Snapshot {
+			f.resync(fr)
+			continue
+		}
+		if fr.Epoch != f.epoch || fr.Seq != f.next {
+			err = fmt.Errorf("%w: expected %d, got %d", errGap, f.next, fr.Seq)
+			// the next connection asks for a snapshot
+			f.next = 0
+			return err
+		}
+		if err = f.c.Apply(fr.Event); err != nil && f.onError != nil {
+			// a quota of the follower rejected the item, the follower keeps going
+			f.onError(err)
+		}
+		f.applied.Store(fr.Seq)
+		f.next++
+	}
+}
+
+// resync replaces the items of the follower with a snapshot, in WithLWW mode the flush is ignored
+// and the snapshot is merged instead
+func (f *Follower) resync(fr frame) {
+	_ = f.c.Apply(local_cache.Event{Op: local_cache.OpFlush})
+	for _, e := range fr.Events {
+		if err := f.c.Apply(e); err != nil && f.onError != nil {
+			f.onError(err)
+		}
+	}
+	f.epoch = fr.Epoch
+	f.next = fr.Seq + 1
+	f.applied.Store(fr.Seq)
+}
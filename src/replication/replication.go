@@ -0,0 +1,215 @@
+/*
+The package replicates a local_cache.Cache to warm standbys over TCP, using its event stream:
+
+	Leader: Numbers the changes of the cache and streams them to every follower that connects.
+	Follower: Connects to a leader and applies its changes to another cache, reconnecting when the
+		connection drops.
+
+Followers ask for the changes after the last one they applied. A follower that is new, that the
+backlog of the leader no longer covers, that sees a gap in the sequence numbers or that talks to a
+restarted leader (another epoch) gets a snapshot of the items first, then the changes that follow it.
+Replication is asynchronous: a change is acknowledged to the writer before any follower has it.
+
+Several caches may write and replicate to each other when they all use local_cache.WithLWW: each
+change is stamped, the later stamp wins and a change that comes back to its origin is not passed on.
+
+Frames are gob encoded, custom value types must be registered with gob.Register on both sides.
+*/
+
+package replication
+
+import (
+	"cache/src/local_cache"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("replication: leader closed")
+
+// hello is the first frame of a follower, From is the first sequence number it wants
+type hello struct {
+	Epoch int64
+	From  uint64
+}
+
+// frame carries one change, or a snapshot of the state at Seq when Snapshot is set
+type frame struct {
+	Epoch    int64
+	Seq      uint64
+	Event    local_cache.Event
+	Snapshot bool
+	Events   []local_cache.Event
+}
+
+type LeaderOption func(l *Leader)
+
+// WithBacklog keeps the last n changes for the followers that reconnect, 4096 by default
+func WithBacklog(n int) LeaderOption {
+	return func(l *Leader) {
+		if n > 0 {
+			l.backlogSize = n
+		}
+	}
+}
+
+type Leader struct {
+	c           *local_cache.Cache
+	epoch       int64
+	backlogSize int
+	unsubscribe func()
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// seq is the sequence number of the last change, backlog holds the changes from first on
+	seq     uint64
+	first   uint64
+	backlog []local_cache.Event
+	closed  bool
+	lns     []net.Listener
+	conns   map[net.Conn]struct{}
+}
+
+// NewLeader starts numbering the changes of c, call Serve to stream them
+func NewLeader(c *local_cache.Cache, opts ...LeaderOption) *Leader {
+	l := &Leader{
+		c:           c,
+		epoch:       time.Now().UnixNano() ^ rand.Int63(),
+		backlogSize: 4096,
+		first:       1,
+		conns:       make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.cond = sync.NewCond(&l.mu)
+	l.unsubscribe = c.Subscribe(l.record)
+	return l
+}
+
+// record runs under the cache lock
+func (l *Leader) record(e local_cache.Event) {
+	l.mu.Lock()
+	l.seq++
+	l.backlog = append(l.backlog, e)
+	if n := len(l.backlog) - l.backlogSize; n > 0 {
+		l.backlog = append(l.backlog[:0:0], l.backlog[n:]...)
+		l.first += uint64(n)
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// Seq returns the sequence number of the last change
+func (l *Leader) Seq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Serve accepts followers on ln until Close, it always returns a non-nil error
+func (l *Leader) Serve(ln net.Listener) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		_ = ln.Close()
+		return ErrClosed
+	}
+	l.lns = append(l.lns, ln)
+	l.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		go l.serve(conn)
+	}
+}
+
+func (l *Leader) serve(conn net.Conn) {
+	defer conn.Close()
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.conns[conn] = struct{}{}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+	}()
+
+	var h hello
+	if err := gob.NewDecoder(conn).Decode(&h); err != nil {
+		return
+	}
+	enc := gob.NewEncoder(conn)
+	next := h.From
+	if h.Epoch != l.epoch {
+		next = 0
+	}
+	for {
+		l.mu.Lock()
+		for !l.closed && next != 0 && next == l.seq+1 {
+			l.cond.Wait()
+		}
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		if next == 0 || next < l.first || next > l.seq+1 {
+			// the state at seq is the snapshot, the changes that follow are streamed from the backlog;
+			// a change that also made it into the snapshot is applied twice, which is harmless
+			seq := l.seq
+			l.mu.Unlock()
+			if err := enc.Encode(frame{Epoch: l.epoch, Seq: seq, Snapshot: true, Events: l.c.Snapshot()}); err != nil {
+				return
+			}
+			next = seq + 1
+			continue
+		}
+		events := append([]local_cache.Event(nil), l.backlog[next-l.first:]...)
+		l.mu.Unlock()
+		for _, e := range events {
+			if err := enc.Encode(frame{Epoch: l.epoch, Seq: next, Event: e}); err != nil {
+				return
+			}
+			next++
+		}
+	}
+}
+
+// Close stops streaming, closing the listeners and the follower connections
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	lns := l.lns
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+	l.unsubscribe()
+	var err error
+	for _, ln := range lns {
+		if cerr := ln.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
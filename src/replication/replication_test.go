@@ -0,0 +1,139 @@
+package replication
+
+import (
+	"cache/src/local_cache"
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func start(t *testing.T, opts ...LeaderOption) (*local_cache.Cache, *Leader, string) {
+	t.Helper()
+	c := local_cache.NewCache(time.Minute, 0)
+	l := NewLeader(c, opts...)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go l.Serve(ln)
+	t.Cleanup(func() { _ = l.Close() })
+	return c, l, ln.Addr().String()
+}
+
+func TestReplication(t *testing.T) {
+	leader, l, addr := start(t)
+	_ = leader.Set("before", "snapshot", time.Minute)
+
+	standby := local_cache.NewCache(time.Minute, 0)
+	f := NewFollower(standby, addr, WithRetryInterval(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	_ = leader.Set("name", "will", time.Minute)
+	_ = leader.Set("age", 13, local_cache.NoExpire)
+	leader.Delete("name")
+	_ = leader.Rename("age", "years", false)
+	waitFor(t, func() bool { return f.Seq() == l.Seq() })
+	if !reflect.DeepEqual(standby.Items(), leader.Items()) {
+		t.Fatalf("the standby diverged: %v != %v", standby.Items(), leader.Items())
+	}
+
+	leader.Flush()
+	waitFor(t, func() bool { return f.Seq() == l.Seq() })
+	if standby.ItemCount() != 0 {
+		t.Fatalf("the flush should be replicated, got %v", standby.Items())
+	}
+}
+
+func TestResyncAfterBacklog(t *testing.T) {
+	leader, l, addr := start(t, WithBacklog(2))
+	standby := local_cache.NewCache(time.Minute, 0)
+	f := NewFollower(standby, addr, WithRetryInterval(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = f.Run(ctx)
+		close(done)
+	}()
+	_ = leader.Set("a", 1, time.Minute)
+	waitFor(t, func() bool { return f.Seq() == l.Seq() })
+	cancel()
+	<-done
+
+	// the follower is down for more changes than the backlog holds
+	for i := 0; i < 10; i++ {
+		_ = leader.Set("k"+strconv.Itoa(i), i, time.Minute)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+	waitFor(t, func() bool { return f.Seq() == l.Seq() })
+	if !reflect.DeepEqual(standby.Items(), leader.Items()) {
+		t.Fatalf("the standby should have resynced: %v != %v", standby.Items(), leader.Items())
+	}
+}
+
+// TestMultiWriter replicates two caches to each other, concurrent writes converge with WithLWW
+func TestMultiWriter(t *testing.T) {
+	nodes := make([]*local_cache.Cache, 2)
+	leaders := make([]*Leader, 2)
+	addrs := make([]string, 2)
+	for i, id := range []string{"a", "b"} {
+		nodes[i] = local_cache.NewCache(time.Minute, 0, local_cache.WithLWW(id, time.Minute))
+		leaders[i] = NewLeader(nodes[i])
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go leaders[i].Serve(ln)
+		defer leaders[i].Close()
+		addrs[i] = ln.Addr().String()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	followers := []*Follower{
+		NewFollower(nodes[0], addrs[1], WithRetryInterval(10*time.Millisecond)),
+		NewFollower(nodes[1], addrs[0], WithRetryInterval(10*time.Millisecond)),
+	}
+	for _, f := range followers {
+		go f.Run(ctx)
+	}
+
+	for i := 0; i < 50; i++ {
+		k := "k" + strconv.Itoa(i%5)
+		_ = nodes[0].Set(k, "a"+strconv.Itoa(i), time.Minute)
+		_ = nodes[1].Set(k, "b"+strconv.Itoa(i), time.Minute)
+		if i%7 == 0 {
+			nodes[i%2].Delete(k)
+		}
+	}
+	settled := func() bool {
+		return followers[0].Seq() == leaders[1].Seq() && followers[1].Seq() == leaders[0].Seq()
+	}
+	waitFor(t, settled)
+	// the changes that came back to their origin were not passed on again
+	seqs := []uint64{leaders[0].Seq(), leaders[1].Seq()}
+	time.Sleep(50 * time.Millisecond)
+	if !settled() || leaders[0].Seq() != seqs[0] || leaders[1].Seq() != seqs[1] {
+		t.Fatal("the caches keep exchanging changes")
+	}
+	if !reflect.DeepEqual(nodes[0].Items(), nodes[1].Items()) {
+		t.Fatalf("the caches diverged: %v != %v", nodes[0].Items(), nodes[1].Items())
+	}
+}
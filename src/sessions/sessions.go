@@ -0,0 +1,162 @@
+/*
+The package stores HTTP sessions in a cache.Cache, so the same code runs on local_cache in a
+single process and on the Redis cache behind a load balancer:
+
+	Get: Loads the session named by the request cookie, or starts a new one.
+	Save: Stores the session with a sliding TTL and sets the cookie.
+	Destroy: Deletes the session and expires the cookie.
+	Regenerate: Moves the session to a new ID, call it after login to prevent session fixation.
+
+Values go through the cache as is, so with the Redis cache they follow the codec rules,
+e.g. JSON numbers come back as float64.
+*/
+
+package sessions
+
+import (
+	"cache/src/cache"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+type Session struct {
+	ID     string
+	Values map[string]any
+	isNew  bool
+}
+
+// IsNew reports whether the session was started by this request
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+type Option func(m *Manager)
+
+// WithTTL sets the idle timeout of a session, 30 minutes by default
+func WithTTL(ttl time.Duration) Option {
+	return func(m *Manager) {
+		m.ttl = ttl
+	}
+}
+
+// WithCookie sets the template of the session cookie, Value, MaxAge and Expires are set by the manager
+func WithCookie(cookie http.Cookie) Option {
+	return func(m *Manager) {
+		m.cookie = cookie
+	}
+}
+
+// WithKeyPrefix sets the prefix of the cache keys, "session:" by default
+func WithKeyPrefix(prefix string) Option {
+	return func(m *Manager) {
+		m.prefix = prefix
+	}
+}
+
+type Manager struct {
+	cache  cache.Cache
+	ttl    time.Duration
+	prefix string
+	cookie http.Cookie
+}
+
+func NewManager(c cache.Cache, opts ...Option) *Manager {
+	res := &Manager{
+		cache:  c,
+		ttl:    30 * time.Minute,
+		prefix: "session:",
+		cookie: http.Cookie{
+			Name:     "session_id",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func (m *Manager) Get(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.cookie.Name)
+	if err != nil || cookie.Value == "" {
+		return m.newSession()
+	}
+	val, err := m.cache.Get(r.Context(), m.prefix+cookie.Value)
+	if err == cache.ErrNotFound {
+		// expired or forged ids are not reused, a new id is issued
+		return m.newSession()
+	}
+	if err != nil {
+		return nil, err
+	}
+	values, _ := val.(map[string]any)
+	return &Session{ID: cookie.Value, Values: copyValues(values)}, nil
+}
+
+func (m *Manager) Save(w http.ResponseWriter, r *http.Request, s *Session) error {
+	if err := m.cache.Set(r.Context(), m.prefix+s.ID, copyValues(s.Values), m.ttl); err != nil {
+		return err
+	}
+	cookie := m.cookie
+	cookie.Value = s.ID
+	cookie.MaxAge = int(m.ttl / time.Second)
+	cookie.Expires = time.Now().Add(m.ttl)
+	http.SetCookie(w, &cookie)
+	s.isNew = false
+	return nil
+}
+
+func (m *Manager) Destroy(w http.ResponseWriter, r *http.Request, s *Session) error {
+	if err := m.cache.Delete(r.Context(), m.prefix+s.ID); err != nil {
+		return err
+	}
+	cookie := m.cookie
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// Regenerate deletes the stored session and gives s a new ID, Save must be called to store it
+func (m *Manager) Regenerate(ctx context.Context, s *Session) error {
+	if err := m.cache.Delete(ctx, m.prefix+s.ID); err != nil {
+		return err
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	s.ID = id
+	return nil
+}
+
+func (m *Manager) newSession() (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, Values: map[string]any{}, isNew: true}, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// copyValues keeps the map in a local cache apart from the map handlers modify
+func copyValues(values map[string]any) map[string]any {
+	res := make(map[string]any, len(values))
+	for k, v := range values {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,53 @@
+package sessions
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	m := NewManager(cache.NewLocal(local_cache.NewCache(time.Minute, 0)))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	s, err := m.Get(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsNew() {
+		t.Fatal("session without cookie should be new")
+	}
+	s.Values["user"] = "will"
+	w := httptest.NewRecorder()
+	if err = m.Save(w, r, s); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != s.ID || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	loaded, err := m.Get(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.IsNew() || loaded.ID != s.ID || loaded.Values["user"] != "will" {
+		t.Fatalf("unexpected session %+v", loaded)
+	}
+
+	old := loaded.ID
+	if err = m.Regenerate(r.Context(), loaded); err != nil || loaded.ID == old {
+		t.Fatalf("regenerate should change the id, %v", err)
+	}
+	if err = m.Destroy(httptest.NewRecorder(), r, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ = m.Get(r); !s.IsNew() || s.ID == old {
+		t.Fatal("the old id should not be reused")
+	}
+}
@@ -0,0 +1,178 @@
+package simulator
+
+import (
+	"cache/src/lru"
+	"container/list"
+)
+
+type lruPolicy struct {
+	c *lru.LRUCache
+}
+
+// NewLRU simulates the lru package, keys are truncated to int
+func NewLRU(capacity int) Policy {
+	return &lruPolicy{c: lru.Constructor(capacity)}
+}
+
+func (p *lruPolicy) Access(key uint64) bool {
+	if p.c.Get(int(key)) != -1 {
+		return true
+	}
+	p.c.Put(int(key), 0)
+	return false
+}
+
+// lfuPolicy evicts the least frequently used key, the least recently used one among equal frequencies
+type lfuPolicy struct {
+	capacity int
+	minFreq  int
+	keys     map[uint64]*list.Element
+	freqs    map[int]*list.List
+}
+
+type lfuEntry struct {
+	key  uint64
+	freq int
+}
+
+func NewLFU(capacity int) Policy {
+	return &lfuPolicy{
+		capacity: capacity,
+		keys:     make(map[uint64]*list.Element),
+		freqs:    make(map[int]*list.List),
+	}
+}
+
+func (p *lfuPolicy) Access(key uint64) bool {
+	if e, ok := p.keys[key]; ok {
+		ent := e.Value.(*lfuEntry)
+		p.freqs[ent.freq].Remove(e)
+		if ent.freq == p.minFreq && p.freqs[ent.freq].Len() == 0 {
+			p.minFreq++
+		}
+		ent.freq++
+		p.keys[key] = p.bucket(ent.freq).PushFront(ent)
+		return true
+	}
+	if p.capacity <= 0 {
+		return false
+	}
+	if len(p.keys) >= p.capacity {
+		victim := p.freqs[p.minFreq].Back()
+		p.freqs[p.minFreq].Remove(victim)
+		delete(p.keys, victim.Value.(*lfuEntry).key)
+	}
+	p.minFreq = 1
+	p.keys[key] = p.bucket(1).PushFront(&lfuEntry{key: key, freq: 1})
+	return false
+}
+
+func (p *lfuPolicy) bucket(freq int) *list.List {
+	l, ok := p.freqs[freq]
+	if !ok {
+		l = list.New()
+		p.freqs[freq] = l
+	}
+	return l
+}
+
+// arcPolicy is the Adaptive Replacement Cache of Megiddo and Modha: t1/t2 hold cached keys seen
+// once/several times, b1/b2 are ghost lists of keys evicted from them, p is the target size of t1
+type arcPolicy struct {
+	c              int
+	p              int
+	t1, t2, b1, b2 *list.List
+	keys           map[uint64]*list.Element
+	where          map[uint64]*list.List
+}
+
+func NewARC(capacity int) Policy {
+	return &arcPolicy{
+		c:     capacity,
+		t1:    list.New(),
+		t2:    list.New(),
+		b1:    list.New(),
+		b2:    list.New(),
+		keys:  make(map[uint64]*list.Element),
+		where: make(map[uint64]*list.List),
+	}
+}
+
+func (p *arcPolicy) Access(key uint64) bool {
+	if p.c <= 0 {
+		return false
+	}
+	switch p.where[key] {
+	case p.t1, p.t2:
+		p.move(key, p.t2)
+		return true
+	case p.b1:
+		p.p = min(p.c, p.p+max(p.b2.Len()/p.b1.Len(), 1))
+		p.replace(false)
+		p.move(key, p.t2)
+		return false
+	case p.b2:
+		p.p = max(0, p.p-max(p.b1.Len()/p.b2.Len(), 1))
+		p.replace(true)
+		p.move(key, p.t2)
+		return false
+	}
+	if l1 := p.t1.Len() + p.b1.Len(); l1 == p.c {
+		if p.t1.Len() < p.c {
+			p.drop(p.b1)
+			p.replace(false)
+		} else {
+			p.drop(p.t1)
+		}
+	} else if total := l1 + p.t2.Len() + p.b2.Len(); total >= p.c {
+		if total == 2*p.c {
+			p.drop(p.b2)
+		}
+		p.replace(false)
+	}
+	p.move(key, p.t1)
+	return false
+}
+
+// replace moves the LRU key of t1 or t2 to its ghost list
+func (p *arcPolicy) replace(inB2 bool) {
+	if p.t1.Len() > 0 && (p.t1.Len() > p.p || (inB2 && p.t1.Len() == p.p)) {
+		p.move(p.t1.Back().Value.(uint64), p.b1)
+	} else if p.t2.Len() > 0 {
+		p.move(p.t2.Back().Value.(uint64), p.b2)
+	}
+}
+
+// move puts key at the MRU end of l, removing it from its current list
+func (p *arcPolicy) move(key uint64, l *list.List) {
+	if cur, ok := p.where[key]; ok {
+		cur.Remove(p.keys[key])
+	}
+	p.keys[key] = l.PushFront(key)
+	p.where[key] = l
+}
+
+// drop forgets the LRU key of l
+func (p *arcPolicy) drop(l *list.List) {
+	e := l.Back()
+	if e == nil {
+		return
+	}
+	key := l.Remove(e).(uint64)
+	delete(p.keys, key)
+	delete(p.where, key)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,186 @@
+/*
+The package replays an access trace against eviction policies at several capacities and reports the
+hit ratio, throughput and allocations of each run, to help choosing a policy and a size before
+deploying it:
+
+	trace, _ := simulator.ReadTrace(f, simulator.FormatAccessLogCSV)
+	results := simulator.Run(trace, simulator.DefaultPolicies(), []int{1000, 10000})
+	simulator.WriteReport(os.Stdout, results)
+
+Every access is a read that fills the cache on a miss. Traces come from local_cache DumpAccessLog
+(CSV or JSON lines, only get records are replayed) or from plain text with one key per line, or are
+generated by Zipf, Uniform and Scan. cmd/cachebench wraps the package in a command line tool.
+*/
+
+package simulator
+
+import (
+	"bufio"
+	"cache/src/local_cache"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type Format int
+
+const (
+	FormatAccessLogCSV Format = iota
+	FormatAccessLogJSON
+	// FormatKeys is one key per line, numeric keys are used as is and others are hashed
+	FormatKeys
+)
+
+// Policy is an eviction policy under simulation, Access reports a hit and admits the key on a miss
+type Policy interface {
+	Access(key uint64) bool
+}
+
+type Factory func(capacity int) Policy
+
+// DefaultPolicies returns the policies shipped with the package
+func DefaultPolicies() map[string]Factory {
+	return map[string]Factory{
+		"lru": NewLRU,
+		"lfu": NewLFU,
+		"arc": NewARC,
+	}
+}
+
+type Result struct {
+	Policy   string
+	Capacity int
+	Accesses int
+	Hits     int
+	// Duration is the time of the run, Allocs and AllocBytes the heap allocations of the policy
+	// during the run, counted process wide
+	Duration   time.Duration
+	Allocs     uint64
+	AllocBytes uint64
+}
+
+func (r Result) HitRatio() float64 {
+	if r.Accesses == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Accesses)
+}
+
+// Throughput returns the accesses per second of the run
+func (r Result) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Accesses) / r.Duration.Seconds()
+}
+
+// Run replays trace against every policy at every capacity, results are sorted by policy and capacity
+func Run(trace []uint64, policies map[string]Factory, capacities []int) []Result {
+	var res []Result
+	for name, factory := range policies {
+		for _, capacity := range capacities {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			start := time.Now()
+			p := factory(capacity)
+			r := Result{Policy: name, Capacity: capacity, Accesses: len(trace)}
+			for _, key := range trace {
+				if p.Access(key) {
+					r.Hits++
+				}
+			}
+			r.Duration = time.Since(start)
+			runtime.ReadMemStats(&after)
+			r.Allocs = after.Mallocs - before.Mallocs
+			r.AllocBytes = after.TotalAlloc - before.TotalAlloc
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Policy != res[j].Policy {
+			return res[i].Policy < res[j].Policy
+		}
+		return res[i].Capacity < res[j].Capacity
+	})
+	return res
+}
+
+func WriteReport(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POLICY\tCAPACITY\tACCESSES\tHITS\tHIT RATIO\tOPS/S\tALLOCS\tBYTES")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.4f\t%.0f\t%d\t%d\n", r.Policy, r.Capacity, r.Accesses, r.Hits,
+			r.HitRatio(), r.Throughput(), r.Allocs, r.AllocBytes)
+	}
+	return tw.Flush()
+}
+
+func ReadTrace(r io.Reader, format Format) ([]uint64, error) {
+	var res []uint64
+	switch format {
+	case FormatAccessLogCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = 4
+		header := true
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return res, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header {
+				header = false
+				continue
+			}
+			if local_cache.AccessOp(rec[1]) != local_cache.OpGet {
+				continue
+			}
+			key, err := strconv.ParseUint(rec[0], 16, 64)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, key)
+		}
+	case FormatAccessLogJSON:
+		dec := json.NewDecoder(r)
+		for {
+			var rec local_cache.AccessRecord
+			err := dec.Decode(&rec)
+			if err == io.EOF {
+				return res, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if rec.Op == local_cache.OpGet {
+				res = append(res, rec.KeyHash)
+			}
+		}
+	case FormatKeys:
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			line := sc.Text()
+			if line == "" {
+				continue
+			}
+			key, err := strconv.ParseUint(line, 10, 64)
+			if err != nil {
+				h := fnv.New64a()
+				_, _ = h.Write([]byte(line))
+				key = h.Sum64()
+			}
+			res = append(res, key)
+		}
+		return res, sc.Err()
+	}
+	return nil, fmt.Errorf("simulator: unknown trace format %d", format)
+}
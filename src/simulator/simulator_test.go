@@ -0,0 +1,116 @@
+package simulator
+
+import (
+	"bytes"
+	"cache/src/local_cache"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanTrace mixes a small hot set read twice per round with a long one-off scan,
+// which flushes the hot set out of an LRU cache between rounds
+func scanTrace() []uint64 {
+	var trace []uint64
+	scan := uint64(1000)
+	for i := 0; i < 200; i++ {
+		for rep := 0; rep < 2; rep++ {
+			for k := uint64(0); k < 10; k++ {
+				trace = append(trace, k)
+			}
+		}
+		for j := 0; j < 20; j++ {
+			trace = append(trace, scan)
+			scan++
+		}
+	}
+	return trace
+}
+
+func TestRun(t *testing.T) {
+	results := Run(scanTrace(), DefaultPolicies(), []int{20})
+	ratios := map[string]float64{}
+	for _, r := range results {
+		ratios[r.Policy] = r.HitRatio()
+	}
+	// lru only hits the second read of each round
+	if ratios["lru"] > 0.26 {
+		t.Fatalf("the scan should flush lru, got %v", ratios["lru"])
+	}
+	if ratios["lfu"] < 0.45 || ratios["arc"] < 0.45 {
+		t.Fatalf("lfu and arc should keep the hot set, got %v", ratios)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "POLICY") || strings.Count(buf.String(), "\n") != 4 {
+		t.Fatalf("unexpected report %q", buf.String())
+	}
+}
+
+func TestARCSize(t *testing.T) {
+	p := NewARC(50).(*arcPolicy)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		p.Access(uint64(r.Intn(200)))
+		if p.t1.Len()+p.t2.Len() > p.c || p.t1.Len()+p.t2.Len()+p.b1.Len()+p.b2.Len() > 2*p.c {
+			t.Fatalf("arc lists out of bounds at access %d", i)
+		}
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	ce := local_cache.NewCache(time.Minute, 0, local_cache.WithAccessLog(1, 10))
+	if err := ce.Set("name", "will", local_cache.DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	ce.Get("name")
+	ce.Get("age")
+
+	for _, format := range []local_cache.AccessLogFormat{local_cache.AccessLogCSV, local_cache.AccessLogJSON} {
+		ce.Get("name")
+		var buf bytes.Buffer
+		if err := ce.DumpAccessLog(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+		trace, err := ReadTrace(&buf, Format(format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trace) == 0 || trace[len(trace)-1] == 0 {
+			t.Fatalf("unexpected trace %v", trace)
+		}
+	}
+
+	trace, err := ReadTrace(strings.NewReader("1\n2\n\nuser:1\n"), FormatKeys)
+	if err != nil || len(trace) != 3 || trace[0] != 1 || trace[1] != 2 {
+		t.Fatalf("unexpected trace %v %v", trace, err)
+	}
+}
+
+func TestWorkloads(t *testing.T) {
+	zipf := Zipf(10000, 1000, 1.2, 1)
+	counts := map[uint64]int{}
+	for _, k := range zipf {
+		if k >= 1000 {
+			t.Fatalf("key out of range: %d", k)
+		}
+		counts[k]++
+	}
+	if counts[0] < counts[10] || counts[0] < 1000 {
+		t.Fatalf("key 0 should be the most popular, got %d vs %d", counts[0], counts[10])
+	}
+	results := Run(Scan(1000, 100), map[string]Factory{"lru": NewLRU}, []int{50})
+	if results[0].Hits != 0 || results[0].Duration <= 0 {
+		t.Fatalf("a scan larger than lru should never hit, got %+v", results[0])
+	}
+	if _, err := Workload("zipf", 10, 10, 1, 1); err == nil {
+		t.Fatal("an exponent of 1 should fail")
+	}
+	if u := Uniform(100, 10, 1); len(u) != 100 {
+		t.Fatalf("unexpected uniform workload length %d", len(u))
+	}
+}
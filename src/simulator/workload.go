@@ -0,0 +1,65 @@
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*
+Synthetic workloads, for experiments without a recorded trace: each returns n accesses over keys
+distinct keys, drawn from a source seeded with seed so runs are reproducible.
+*/
+
+// Zipf draws keys with a Zipf distribution of exponent s > 1, key 0 being the most popular
+func Zipf(n, keys int, s float64, seed int64) []uint64 {
+	if keys <= 0 || s <= 1 {
+		return nil
+	}
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), s, 1, uint64(keys-1))
+	res := make([]uint64, n)
+	for i := range res {
+		res[i] = z.Uint64()
+	}
+	return res
+}
+
+// Uniform draws every key with the same probability
+func Uniform(n, keys int, seed int64) []uint64 {
+	if keys <= 0 {
+		return nil
+	}
+	r := rand.New(rand.NewSource(seed))
+	res := make([]uint64, n)
+	for i := range res {
+		res[i] = uint64(r.Intn(keys))
+	}
+	return res
+}
+
+// Scan reads the keys in order, over and over, the worst case of LRU below keys entries
+func Scan(n, keys int) []uint64 {
+	if keys <= 0 {
+		return nil
+	}
+	res := make([]uint64, n)
+	for i := range res {
+		res[i] = uint64(i % keys)
+	}
+	return res
+}
+
+// Workload returns the accesses of the workload named "zipf", "uniform" or "scan"
+func Workload(name string, n, keys int, s float64, seed int64) ([]uint64, error) {
+	switch name {
+	case "zipf":
+		if s <= 1 {
+			return nil, fmt.Errorf("simulator: the zipf exponent must be > 1, got %v", s)
+		}
+		return Zipf(n, keys, s, seed), nil
+	case "uniform":
+		return Uniform(n, keys, seed), nil
+	case "scan":
+		return Scan(n, keys), nil
+	}
+	return nil, fmt.Errorf("simulator: unknown workload %q", name)
+}
@@ -0,0 +1,246 @@
+/*
+The package caches database/sql query results in a cache.Cache:
+
+	key: the query with collapsed whitespace, the encoded args and the versions of the tables it reads.
+	value: the columns and rows, encoded with a codec.Codec (gob by default, which keeps the driver types)
+		and stored as base64 text, so backends that encode values as JSON don't mangle the binary data.
+	invalidation: every table has a version tag in the cache; Exec bumps the tags of the table it writes
+		and Invalidate bumps them explicitly, so cached results of queries reading those tables are
+		never served again and expire on their own.
+
+Tables are taken from the FROM and JOIN clauses of queries and from the target of INSERT, UPDATE,
+DELETE and REPLACE statements; QueryTables and ExecTables name them explicitly for the statements
+the parser gets wrong.
+*/
+
+package sqlcache
+
+import (
+	"cache/src/cache"
+	"cache/src/codec"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	// driver values are stored in []any, gob needs their types registered
+	gob.Register(time.Time{})
+}
+
+var (
+	readTables  = regexp.MustCompile("(?i)\\b(?:from|join)\\s+([`\"\\w.]+)")
+	writeTables = regexp.MustCompile("(?i)^\\s*(?:insert\\s+(?:ignore\\s+)?into|replace\\s+into|update|delete\\s+from)\\s+([`\"\\w.]+)")
+	spaces      = regexp.MustCompile(`\s+`)
+)
+
+type Rows struct {
+	Columns []string
+	Values  [][]any
+}
+
+func (r *Rows) Len() int {
+	return len(r.Values)
+}
+
+// Maps returns the rows as column name to value maps
+func (r *Rows) Maps() []map[string]any {
+	res := make([]map[string]any, len(r.Values))
+	for i, row := range r.Values {
+		m := make(map[string]any, len(r.Columns))
+		for j, col := range r.Columns {
+			m[col] = row[j]
+		}
+		res[i] = m
+	}
+	return res
+}
+
+type Option func(d *DB)
+
+// WithCodec sets the codec of cached rows, codec.Gob by default
+func WithCodec(c codec.Codec) Option {
+	return func(d *DB) {
+		d.codec = c
+	}
+}
+
+// WithOnInvalidate is called with the tables whose cached results were invalidated
+func WithOnInvalidate(fn func(tables []string)) Option {
+	return func(d *DB) {
+		d.onInvalidate = fn
+	}
+}
+
+type DB struct {
+	db           *sql.DB
+	cache        cache.Cache
+	ttl          time.Duration
+	codec        codec.Codec
+	onInvalidate func(tables []string)
+	seq          atomic.Uint64
+}
+
+func New(db *sql.DB, c cache.Cache, ttl time.Duration, opts ...Option) *DB {
+	res := &DB{
+		db:           db,
+		cache:        c,
+		ttl:          ttl,
+		codec:        codec.Gob,
+		onInvalidate: func([]string) {},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// Query returns the cached result of query, or runs it and caches the result
+func (d *DB) Query(ctx context.Context, query string, args ...any) (*Rows, error) {
+	return d.QueryTables(ctx, parseTables(readTables, query), d.ttl, query, args...)
+}
+
+// QueryTables is Query with explicit tables and TTL
+func (d *DB) QueryTables(ctx context.Context, tables []string, ttl time.Duration, query string, args ...any) (*Rows, error) {
+	key, err := d.key(ctx, tables, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if val, err := d.cache.Get(ctx, key); err == nil {
+		if rows, ok := d.decode(val); ok {
+			return rows, nil
+		}
+	} else if err != cache.ErrNotFound {
+		return nil, err
+	}
+	rows, err := d.query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.codec.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+	return rows, d.cache.Set(ctx, key, base64.StdEncoding.EncodeToString(data), ttl)
+}
+
+// decode returns the rows of a cached value; a value it can't decode is treated as a miss
+func (d *DB) decode(val any) (*Rows, bool) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	var rows Rows
+	if err = d.codec.Unmarshal(data, &rows); err != nil {
+		return nil, false
+	}
+	return &rows, true
+}
+
+// Exec runs a write statement and invalidates the table it writes
+func (d *DB) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.ExecTables(ctx, parseTables(writeTables, query), query, args...)
+}
+
+// ExecTables is Exec with explicit tables to invalidate
+func (d *DB) ExecTables(ctx context.Context, tables []string, query string, args ...any) (sql.Result, error) {
+	res, err := d.db.ExecContext(ctx, query, args...)
+	// a failed statement may still have changed rows, e.g. outside a transaction
+	if iErr := d.Invalidate(ctx, tables...); iErr != nil && err == nil {
+		err = iErr
+	}
+	return res, err
+}
+
+// Invalidate drops the cached results of all queries reading tables
+func (d *DB) Invalidate(ctx context.Context, tables ...string) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	version := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatUint(d.seq.Add(1), 36)
+	for _, t := range tables {
+		if err := d.cache.Set(ctx, tagKey(t), version, cache.NoExpire); err != nil {
+			return err
+		}
+	}
+	d.onInvalidate(tables)
+	return nil
+}
+
+func (d *DB) query(ctx context.Context, query string, args []any) (*Rows, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	res := &Rows{Columns: cols}
+	for rows.Next() {
+		row := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		res.Values = append(res.Values, row)
+	}
+	return res, rows.Err()
+}
+
+func (d *DB) key(ctx context.Context, tables []string, query string, args []any) (string, error) {
+	h := sha1.New()
+	h.Write([]byte(strings.TrimSpace(spaces.ReplaceAllString(query, " "))))
+	argData, err := codec.JSON.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	h.Write(argData)
+	for _, t := range tables {
+		version, err := d.cache.Get(ctx, tagKey(t))
+		if err != nil && err != cache.ErrNotFound {
+			return "", err
+		}
+		h.Write([]byte("|" + t + "="))
+		if s, ok := version.(string); ok {
+			h.Write([]byte(s))
+		}
+	}
+	return "sqlcache:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func tagKey(table string) string {
+	return "sqlcache:tag:" + table
+}
+
+// parseTables returns the sorted, deduplicated and lower cased tables matched by re
+func parseTables(re *regexp.Regexp, query string) []string {
+	seen := map[string]bool{}
+	var res []string
+	for _, m := range re.FindAllStringSubmatch(query, -1) {
+		t := strings.ToLower(strings.Trim(m[1], "`\""))
+		if !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,164 @@
+package sqlcache
+
+import (
+	"cache/src/cache"
+	"cache/src/codec"
+	"cache/src/local_cache"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDriver answers every query with one row and counts the statements it runs
+type fakeDriver struct {
+	queries atomic.Int32
+	execs   atomic.Int32
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d: d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{d: c.d}, nil }
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (s *fakeStmt) Close() error { return nil }
+
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.d.execs.Add(1)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.d.queries.Add(1)
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct{ done bool }
+
+func (r *fakeRows) Columns() []string { return []string{"id", "name"} }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0], dest[1] = int64(1), "will"
+	return nil
+}
+
+func TestQuery(t *testing.T) {
+	drv := &fakeDriver{}
+	sql.Register("sqlcache_fake", drv)
+	db, err := sql.Open("sqlcache_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	var invalidated []string
+	d := New(db, cache.NewLocal(local_cache.NewCache(time.Minute, 0)), time.Minute,
+		WithOnInvalidate(func(tables []string) { invalidated = tables }))
+
+	for i := 0; i < 2; i++ {
+		rows, err := d.Query(ctx, "SELECT id, name\n  FROM users WHERE id = ?", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rows.Maps(), []map[string]any{{"id": int64(1), "name": "will"}}) {
+			t.Fatalf("unexpected rows %v", rows.Maps())
+		}
+	}
+	if _, err = d.Query(ctx, "SELECT id, name FROM users WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := drv.queries.Load(); n != 1 {
+		t.Fatalf("normalized query should be served from the cache, got %d queries", n)
+	}
+
+	if _, err = d.Exec(ctx, "UPDATE `users` SET name = ? WHERE id = ?", "yin", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(invalidated, []string{"users"}) {
+		t.Fatalf("unexpected invalidated tables %v", invalidated)
+	}
+	if _, err = d.Query(ctx, "SELECT id, name FROM users WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := drv.queries.Load(); n != 2 {
+		t.Fatalf("write should invalidate the cached result, got %d queries", n)
+	}
+}
+
+// jsonCache round trips every value through codec.JSON, like cache.NewRedis with the default codec
+type jsonCache struct {
+	cache.Cache
+}
+
+func (c jsonCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	data, err := codec.JSON.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.Cache.Set(ctx, key, data, ttl)
+}
+
+func (c jsonCache) Get(ctx context.Context, key string) (any, error) {
+	val, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var res any
+	return res, codec.JSON.Unmarshal(val.([]byte), &res)
+}
+
+func TestQueryJSONBackend(t *testing.T) {
+	drv := &fakeDriver{}
+	sql.Register("sqlcache_fake_json", drv)
+	db, err := sql.Open("sqlcache_fake_json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	d := New(db, jsonCache{cache.NewLocal(local_cache.NewCache(time.Minute, 0))}, time.Minute)
+	for i := 0; i < 2; i++ {
+		rows, err := d.Query(ctx, "SELECT id, name FROM users WHERE id = ?", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rows.Maps(), []map[string]any{{"id": int64(1), "name": "will"}}) {
+			t.Fatalf("unexpected rows %v", rows.Maps())
+		}
+	}
+	// the gob bytes survive the JSON encoding, so the second query is a hit
+	if n := drv.queries.Load(); n != 1 {
+		t.Fatalf("expected the result to be served from the cache, got %d queries", n)
+	}
+}
+
+func TestParseTables(t *testing.T) {
+	got := parseTables(readTables, "select * from Orders o join `users` u on o.uid = u.id left join orders x on 1=1")
+	if !reflect.DeepEqual(got, []string{"orders", "users"}) {
+		t.Fatalf("unexpected read tables %v", got)
+	}
+	if got = parseTables(writeTables, "  INSERT IGNORE INTO db.items (id) VALUES (?)"); !reflect.DeepEqual(got, []string{"db.items"}) {
+		t.Fatalf("unexpected write tables %v", got)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+The package shares one local_cache.Cache between tenants, giving every tenant an isolated view:
+
+	Tenant: Returns the view of a tenant, its keys are stored under a prefix no other tenant can produce.
+	SetQuota: Bounds the items and bytes of a tenant, tenants without their own quota use the default one.
+	Stats: Returns the hit/miss counters, items and bytes of every tenant seen so far.
+
+A view implements cache.Cache, Flush on a view only deletes the keys of its tenant.
+*/
+
+package tenantcache
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Quota bounds a tenant, see local_cache.WithNamespaceQuota; the zero Quota is unbounded
+type Quota struct {
+	MaxItems int
+	MaxBytes int64
+	Policy   local_cache.OverflowPolicy
+}
+
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	Items  int
+	Bytes  int64
+}
+
+type Cache struct {
+	c            *local_cache.Cache
+	defaultQuota Quota
+	lock         sync.Mutex
+	tenants      map[string]*Tenant
+}
+
+// New shares c between tenants, each tenant is bounded by quota unless SetQuota overrides it
+func New(c *local_cache.Cache, quota Quota) *Cache {
+	return &Cache{
+		c:            c,
+		defaultQuota: quota,
+		tenants:      make(map[string]*Tenant),
+	}
+}
+
+// Tenant returns the view of id, creating it on first use
+func (c *Cache) Tenant(id string) *Tenant {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.tenant(id, c.defaultQuota)
+}
+
+// SetQuota changes the quota of id; items already stored are kept when the quota is lowered
+func (c *Cache) SetQuota(id string, q Quota) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t, ok := c.tenants[id]
+	if !ok {
+		c.tenant(id, q)
+		return
+	}
+	c.c.SetNamespaceQuota(t.prefix, q.MaxItems, q.MaxBytes, q.Policy)
+}
+
+// tenant returns the view of id, the caller holds c.lock
+func (c *Cache) tenant(id string, q Quota) *Tenant {
+	if t, ok := c.tenants[id]; ok {
+		return t
+	}
+	t := &Tenant{
+		id: id,
+		// the length makes the prefix unambiguous, tenant "a" never sees the keys of tenant "a:b"
+		prefix: strconv.Itoa(len(id)) + ":" + id + ":",
+		c:      c.c,
+	}
+	// the namespace also accounts an unbounded tenant, Stats reads its items and bytes from it
+	c.c.SetNamespaceQuota(t.prefix, q.MaxItems, q.MaxBytes, q.Policy)
+	c.tenants[id] = t
+	return t
+}
+
+// Stats returns the stats of every tenant returned by Tenant or set with SetQuota
+func (c *Cache) Stats() map[string]Stats {
+	c.lock.Lock()
+	tenants := make([]*Tenant, 0, len(c.tenants))
+	for _, t := range c.tenants {
+		tenants = append(tenants, t)
+	}
+	c.lock.Unlock()
+	res := make(map[string]Stats, len(tenants))
+	for _, t := range tenants {
+		res[t.id] = t.stats()
+	}
+	return res
+}
+
+var _ cache.Cache = (*Tenant)(nil)
+
+// Tenant is the view of one tenant, keys passed to it are relative to the tenant
+type Tenant struct {
+	id     string
+	prefix string
+	c      *local_cache.Cache
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (t *Tenant) ID() string {
+	return t.id
+}
+
+func (t *Tenant) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	return t.c.SetCtx(ctx, t.prefix+key, val, ttl)
+}
+
+func (t *Tenant) Get(ctx context.Context, key string) (any, error) {
+	val, ok, err := t.c.GetCtx(ctx, t.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		t.misses.Add(1)
+		return nil, cache.ErrNotFound
+	}
+	t.hits.Add(1)
+	return val, nil
+}
+
+func (t *Tenant) Delete(ctx context.Context, key string) error {
+	return t.c.DeleteCtx(ctx, t.prefix+key)
+}
+
+func (t *Tenant) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	ttl, ok := t.c.TTL(t.prefix + key)
+	if !ok {
+		return 0, cache.ErrNotFound
+	}
+	return ttl, nil
+}
+
+// Flush deletes the items of the tenant only
+func (t *Tenant) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.c.DeletePrefix(t.prefix)
+	return nil
+}
+
+func (t *Tenant) Stats(ctx context.Context) (cache.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return cache.Stats{}, err
+	}
+	s := t.stats()
+	return cache.Stats{Hits: s.Hits, Misses: s.Misses, Items: s.Items}, nil
+}
+
+// HealthCheck checks the shared cache
+func (t *Tenant) HealthCheck(ctx context.Context) error {
+	return t.c.HealthCheck(ctx)
+}
+
+func (t *Tenant) stats() Stats {
+	items, bytes, _ := t.c.NamespaceUsage(t.prefix)
+	return Stats{
+		Hits:   t.hits.Load(),
+		Misses: t.misses.Load(),
+		Items:  items,
+		Bytes:  bytes,
+	}
+}
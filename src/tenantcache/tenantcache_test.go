@@ -0,0 +1,54 @@
+package tenantcache
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTenants(t *testing.T) {
+	ctx := context.Background()
+	lc := local_cache.NewCache(time.Minute, 0)
+	c := New(lc, Quota{MaxItems: 2})
+	a, ab := c.Tenant("a"), c.Tenant("a:b")
+
+	if err := a.Set(ctx, "b:name", "a", cache.DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	if err := ab.Set(ctx, "name", "ab", cache.DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ab.Get(ctx, "name"); err != nil || v != "ab" {
+		t.Fatalf("unexpected get result %v %v", v, err)
+	}
+	if _, err := a.Get(ctx, "name"); err != cache.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	_ = a.Set(ctx, "age", 13, cache.DefaultExpire)
+	if err := a.Set(ctx, "city", "bj", cache.DefaultExpire); !errors.Is(err, local_cache.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	c.SetQuota("a", Quota{MaxItems: 3})
+	if err := a.Set(ctx, "city", "bj", cache.DefaultExpire); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if lc.ItemCount() != 1 {
+		t.Fatalf("flush should keep the other tenant, got %d items", lc.ItemCount())
+	}
+
+	stats := c.Stats()
+	if s := stats["a"]; s.Hits != 0 || s.Misses != 1 || s.Items != 0 {
+		t.Fatalf("unexpected stats of a %+v", s)
+	}
+	if s := stats["a:b"]; s.Hits != 1 || s.Misses != 0 || s.Items != 1 || s.Bytes != int64(len("3:a:b:name")+len("ab")) {
+		t.Fatalf("unexpected stats of a:b %+v", s)
+	}
+}
@@ -0,0 +1,91 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+/*
+WithInvalidation uses the broadcasting mode of redis client-side caching (CLIENT TRACKING BCAST):
+redis notifies a connection of every write to the keys under a prefix, whoever reads them. The
+notifications are redirected to the same connection, subscribed to __redis__:invalidate. That
+connection speaks RESP2, go-redis doesn't read RESP3 invalidation pushes on a subscribed connection.
+
+The Sets of the process are notified as well, so they drop the L1 entry they just wrote and the next
+Get reads L2. L1 is flushed whenever the subscription is (re)established or a notification can't be
+read, since notifications may have been missed meanwhile; a FLUSHALL is notified that way too.
+*/
+
+// invalidateChannel is the channel redis publishes tracking notifications on in RESP2
+const invalidateChannel = "__redis__:invalidate"
+
+// WithInvalidation subscribes to the invalidations of the keys under prefix, the key prefix of L2
+// (redis_cache.WithPrefix), on a dedicated connection made from opts; it needs redis 6
+func WithInvalidation(opts *redis.Options, prefix string) Option {
+	return func(c *Cache) {
+		c.tracking = c.track(opts, prefix)
+	}
+}
+
+func (c *Cache) track(opts *redis.Options, prefix string) *tracking {
+	o := *opts
+	o.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		if opts.OnConnect != nil {
+			if err := opts.OnConnect(ctx, cn); err != nil {
+				return err
+			}
+		}
+		if err := cn.Hello(ctx, 2, "", "", "").Err(); err != nil {
+			return err
+		}
+		id, err := cn.ClientID(ctx).Result()
+		if err != nil {
+			return err
+		}
+		args := []any{"CLIENT", "TRACKING", "ON", "REDIRECT", id, "BCAST"}
+		if prefix != "" {
+			args = append(args, "PREFIX", prefix)
+		}
+		return cn.Process(ctx, redis.NewCmd(ctx, args...))
+	}
+	client := redis.NewClient(&o)
+	t := &tracking{
+		client: client,
+		pubsub: client.Subscribe(context.Background(), invalidateChannel),
+		done:   make(chan struct{}),
+	}
+	go c.listen(t, prefix)
+	return t
+}
+
+func (c *Cache) listen(t *tracking, prefix string) {
+	defer close(t.done)
+	for {
+		msg, err := t.pubsub.Receive(context.Background())
+		if errors.Is(err, redis.ErrClosed) {
+			return
+		}
+		if err != nil {
+			c.invalidate(nil, true)
+			// the subscription reconnects on the next Receive
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			c.invalidate(nil, true)
+		case *redis.Message:
+			keys := msg.PayloadSlice
+			if msg.Payload != "" {
+				keys = append(keys, msg.Payload)
+			}
+			for i, k := range keys {
+				keys[i] = strings.TrimPrefix(k, prefix)
+			}
+			c.invalidate(keys, false)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package tiered
+
+import (
+	"cache/src/cache"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+Read-your-writes: a Get racing a Set of the same process may read L2 before the write and backfill L1
+after it, leaving L1 stale for the L1 TTL, and an L2 read from a replica may lag behind the write. With
+WithReadYourWrites the keys set or deleted by this process are remembered for a window, during which
+its Gets skip L1, read the authority and don't backfill.
+*/
+
+// WithReadYourWrites makes the Gets of a key written by this process within window read the
+// authority, L2 unless WithAuthority is set
+func WithReadYourWrites(window time.Duration) Option {
+	return func(c *Cache) {
+		if window > 0 {
+			c.written = &recentWrites{window: window, until: make(map[string]time.Time)}
+		}
+	}
+}
+
+// WithAuthority sets the cache read for the keys recently written, e.g. a client of the redis
+// primary when L2 reads from replicas; it only applies with WithReadYourWrites
+func WithAuthority(authority cache.Cache) Option {
+	return func(c *Cache) {
+		c.authority = authority
+	}
+}
+
+// recentWrites holds the keys written within the window
+type recentWrites struct {
+	window time.Duration
+	mu     sync.Mutex
+	until  map[string]time.Time
+	pruned time.Time
+}
+
+func (r *recentWrites) record(key string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.until[key] = now.Add(r.window)
+	if now.Sub(r.pruned) < r.window {
+		return
+	}
+	r.pruned = now
+	for k, until := range r.until {
+		if now.After(until) {
+			delete(r.until, k)
+		}
+	}
+}
+
+func (r *recentWrites) contains(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until, ok := r.until[key]
+	return ok && time.Now().Before(until)
+}
+
+// recent reports whether key was written within the read-your-writes window
+func (c *Cache) recent(key string) bool {
+	return c.written != nil && c.written.contains(key)
+}
+
+// readAuthority reads a key written recently, without touching L1
+func (c *Cache) readAuthority(ctx context.Context, key string) (any, error) {
+	src := c.l2
+	if c.authority != nil {
+		src = c.authority
+	}
+	val, err := src.Get(ctx, key)
+	if err == nil {
+		c.hits.Add(1)
+	} else if errors.Is(err, cache.ErrNotFound) {
+		c.misses.Add(1)
+	}
+	return val, err
+}
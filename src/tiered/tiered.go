@@ -0,0 +1,239 @@
+/*
+The package stacks a fast local cache (L1) in front of a shared one (L2), usually a local_cache in
+front of a redis_cache, both through cache.Cache:
+
+	Set: Writes L2, then L1.
+	Get: Reads L1, then L2, backfilling L1 on an L2 hit.
+	Delete: Deletes from L2, then from L1.
+	TTL: Returns the TTL of L2.
+	Flush: Flushes L2, then L1.
+	Stats: Returns the hits and misses of the tiered cache and the items of L2.
+	HealthCheck: Checks both tiers.
+
+L1 entries live at most WithL1TTL, so a write from another process is seen after that delay at worst.
+They never outlive their L2 entry either: the L1 TTL is capped at a fraction of the remaining L2 TTL,
+read with TTL (PTTL on redis) when L1 is backfilled, see WithL1TTLFraction. An L1 with whole-second
+expiry, like local_cache, may still keep an entry up to a second longer.
+WithReadYourWrites makes a process read back its own writes right away, see ryw.go.
+WithInvalidation removes that delay with redis client-side caching: redis tracks the key prefix of L2
+and notifies every process of the keys written, which then drop them from L1.
+*/
+
+package tiered
+
+import (
+	"cache/src/cache"
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultL1TTL = time.Minute
+
+type Option func(c *Cache)
+
+// WithL1TTL bounds how long an entry stays in L1, DefaultL1TTL by default
+func WithL1TTL(d time.Duration) Option {
+	return func(c *Cache) {
+		if d > 0 {
+			c.l1TTL = d
+		}
+	}
+}
+
+// WithL1TTLFraction caps the TTL of an L1 entry at fraction of the remaining TTL of its L2 entry,
+// 1 by default; a lower fraction leaves room for the clock skew and the precision of L1
+func WithL1TTLFraction(fraction float64) Option {
+	return func(c *Cache) {
+		if fraction > 0 && fraction <= 1 {
+			c.fraction = fraction
+		}
+	}
+}
+
+var _ cache.Cache = (*Cache)(nil)
+
+type Cache struct {
+	l1    cache.Cache
+	l2    cache.Cache
+	l1TTL time.Duration
+	// fraction of the remaining L2 TTL an L1 entry may live
+	fraction float64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	// generation is bumped by every invalidation, a Get doesn't backfill L1 with a value read from L2
+	// while an invalidation came in, which may be older than the write it announced
+	generation atomic.Uint64
+	tracking   *tracking
+
+	// written holds the keys recently written, nil without WithReadYourWrites
+	written   *recentWrites
+	authority cache.Cache
+}
+
+func New(l1, l2 cache.Cache, opts ...Option) *Cache {
+	c := &Cache{
+		l1:       l1,
+		l2:       l2,
+		l1TTL:    DefaultL1TTL,
+		fraction: 1,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ttl returns the TTL of an L1 entry whose L2 entry expires in remaining, NoExpire included;
+// it returns 0 when the entry should not be put in L1
+func (c *Cache) ttl(remaining time.Duration) time.Duration {
+	if remaining < 0 {
+		return c.l1TTL
+	}
+	d := time.Duration(float64(remaining) * c.fraction)
+	if d > c.l1TTL {
+		return c.l1TTL
+	}
+	return d
+}
+
+// backfill puts val in L1 for the part of the remaining L2 TTL it may live there
+func (c *Cache) backfill(ctx context.Context, key string, val any, remaining time.Duration) error {
+	if d := c.ttl(remaining); d > 0 {
+		return c.l1.Set(ctx, key, val, d)
+	}
+	return c.l1.Delete(ctx, key)
+}
+
+func (c *Cache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	if c.written != nil {
+		// before the write, a Get reading the authority meanwhile is at worst as fresh as L2
+		c.written.record(key)
+	}
+	// an invalidation during the L2 write may announce a newer write by another process, which L1
+	// must not be backfilled over
+	gen := c.generation.Load()
+	if err := c.l2.Set(ctx, key, val, ttl); err != nil {
+		return err
+	}
+	remaining := ttl
+	if ttl == cache.DefaultExpire {
+		// only L2 knows its default expiration
+		var err error
+		if remaining, err = c.l2.TTL(ctx, key); err != nil {
+			return c.l1.Delete(ctx, key)
+		}
+	}
+	if c.generation.Load() != gen {
+		return c.l1.Delete(ctx, key)
+	}
+	return c.backfill(ctx, key, val, remaining)
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (any, error) {
+	if c.recent(key) {
+		return c.readAuthority(ctx, key)
+	}
+	if val, err := c.l1.Get(ctx, key); err == nil {
+		c.hits.Add(1)
+		return val, nil
+	} else if !errors.Is(err, cache.ErrNotFound) {
+		return nil, err
+	}
+	gen := c.generation.Load()
+	val, err := c.l2.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		c.misses.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.hits.Add(1)
+	// the value is served even when L1 can't take it, or when the L2 TTL can't be read
+	if remaining, err := c.l2.TTL(ctx, key); err == nil && c.generation.Load() == gen && !c.recent(key) {
+		_ = c.backfill(ctx, key, val, remaining)
+	}
+	return val, nil
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	if c.written != nil {
+		c.written.record(key)
+	}
+	if err := c.l2.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.l1.Delete(ctx, key)
+}
+
+func (c *Cache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.l2.TTL(ctx, key)
+}
+
+func (c *Cache) Flush(ctx context.Context) error {
+	if err := c.l2.Flush(ctx); err != nil {
+		return err
+	}
+	return c.l1.Flush(ctx)
+}
+
+func (c *Cache) Stats(ctx context.Context) (cache.Stats, error) {
+	s, err := c.l2.Stats(ctx)
+	if err != nil {
+		return cache.Stats{}, err
+	}
+	return cache.Stats{Hits: c.hits.Load(), Misses: c.misses.Load(), Items: s.Items}, nil
+}
+
+// HealthCheck checks L1 then L2
+func (c *Cache) HealthCheck(ctx context.Context) error {
+	if err := c.l1.HealthCheck(ctx); err != nil {
+		return err
+	}
+	return c.l2.HealthCheck(ctx)
+}
+
+// invalidate drops keys from L1, all of L1 when all is set
+func (c *Cache) invalidate(keys []string, all bool) {
+	c.generation.Add(1)
+	ctx := context.Background()
+	if all {
+		_ = c.l1.Flush(ctx)
+		return
+	}
+	for _, k := range keys {
+		_ = c.l1.Delete(ctx, k)
+	}
+}
+
+// Close stops the invalidation started by WithInvalidation, if any
+func (c *Cache) Close() error {
+	if c.tracking == nil {
+		return nil
+	}
+	return c.tracking.close()
+}
+
+type tracking struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (t *tracking) close() error {
+	var err error
+	t.once.Do(func() {
+		err = t.pubsub.Close()
+		<-t.done
+		if cerr := t.client.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
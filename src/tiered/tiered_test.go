@@ -0,0 +1,210 @@
+package tiered
+
+import (
+	"cache/src/cache"
+	"cache/src/local_cache"
+	"cache/src/redis_cache"
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"testing"
+	"time"
+)
+
+func newLocal() cache.Cache {
+	return cache.NewLocal(local_cache.NewCache(time.Minute, 0))
+}
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := newLocal(), newLocal()
+	c := New(l1, l2, WithL1TTL(time.Hour))
+
+	if err := c.Set(ctx, "name", "will", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := l1.TTL(ctx, "name"); ttl > time.Minute {
+		t.Fatalf("L1 should not outlive L2, got %v", ttl)
+	}
+	_ = l1.Delete(ctx, "name")
+	if v, err := c.Get(ctx, "name"); err != nil || v != "will" {
+		t.Fatalf("unexpected value: %v %v", v, err)
+	}
+	if v, err := l1.Get(ctx, "name"); err != nil || v != "will" {
+		t.Fatalf("an L2 hit should backfill L1: %v %v", v, err)
+	}
+
+	// another process wrote L2, the invalidation drops the L1 entry
+	_ = l2.Set(ctx, "name", "yin", time.Minute)
+	c.invalidate([]string{"name"}, false)
+	if v, _ := c.Get(ctx, "name"); v != "yin" {
+		t.Fatalf("unexpected value after invalidation: %v", v)
+	}
+
+	if err := c.Delete(ctx, "name"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "name"); err != cache.ErrNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, _ := c.Stats(ctx)
+	if s.Hits != 2 || s.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+// racingL2 lets another process overwrite the key and announce it right after every Set
+type racingL2 struct {
+	cache.Cache
+	c *Cache
+}
+
+func (r *racingL2) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	if err := r.Cache.Set(ctx, key, val, ttl); err != nil {
+		return err
+	}
+	_ = r.Cache.Set(ctx, key, "newer", ttl)
+	r.c.invalidate([]string{key}, false)
+	return nil
+}
+
+func TestSetRacingInvalidation(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := newLocal(), &racingL2{Cache: newLocal()}
+	c := New(l1, l2, WithL1TTL(time.Hour))
+	l2.c = c
+
+	if err := c.Set(ctx, "name", "will", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l1.Get(ctx, "name"); err != cache.ErrNotFound {
+		t.Fatalf("L1 should not be backfilled after an invalidation during Set: %v", err)
+	}
+	if v, _ := c.Get(ctx, "name"); v != "newer" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func TestTTLSync(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := newLocal(), newLocal()
+	c := New(l1, l2, WithL1TTL(time.Hour), WithL1TTLFraction(0.5))
+
+	// L2 has a one minute default expiration
+	_ = c.Set(ctx, "default", "will", cache.DefaultExpire)
+	if ttl, _ := l1.TTL(ctx, "default"); ttl <= 0 || ttl > 31*time.Second {
+		t.Fatalf("L1 should live half of the L2 default, got %v", ttl)
+	}
+
+	// backfilled from an entry another process wrote
+	_ = l2.Set(ctx, "name", "will", 10*time.Second)
+	if v, err := c.Get(ctx, "name"); err != nil || v != "will" {
+		t.Fatalf("unexpected value: %v %v", v, err)
+	}
+	if ttl, _ := l1.TTL(ctx, "name"); ttl <= 0 || ttl > 6*time.Second {
+		t.Fatalf("L1 should live half of the remaining L2 TTL, got %v", ttl)
+	}
+
+	// entries without expiration are bounded by WithL1TTL
+	_ = l2.Set(ctx, "forever", "will", cache.NoExpire)
+	_, _ = c.Get(ctx, "forever")
+	if ttl, _ := l1.TTL(ctx, "forever"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("L1 should live WithL1TTL, got %v", ttl)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := newLocal(), newLocal()
+	c := New(l1, l2, WithReadYourWrites(50*time.Millisecond))
+
+	if err := c.Set(ctx, "name", "will", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// a Get that read L2 before the Set backfills L1 after it
+	_ = l1.Set(ctx, "name", "stale", time.Minute)
+	if v, _ := c.Get(ctx, "name"); v != "will" {
+		t.Fatalf("a recent write should be read back, got %v", v)
+	}
+	if v, _ := l1.Get(ctx, "name"); v != "stale" {
+		t.Fatalf("a recently written key should not be backfilled, got %v", v)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := c.Get(ctx, "name"); v != "stale" {
+		t.Fatalf("past the window L1 is read again, got %v", v)
+	}
+
+	authority := newLocal()
+	c = New(l1, l2, WithReadYourWrites(time.Minute), WithAuthority(authority))
+	_ = authority.Set(ctx, "age", 13, time.Minute)
+	if err := c.Set(ctx, "age", 12, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get(ctx, "age"); v != 13 {
+		t.Fatalf("a recent write should be read from the authority, got %v", v)
+	}
+	if err := c.Delete(ctx, "age"); err != nil {
+		t.Fatal(err)
+	}
+	_ = l1.Set(ctx, "age", 12, time.Minute)
+	if v, _ := c.Get(ctx, "age"); v != 13 {
+		t.Fatalf("a recent delete should be read from the authority, got %v", v)
+	}
+
+	// a miss of the authority wrapped by WithTimeouts still counts as a miss
+	c = New(newLocal(), newLocal(), WithReadYourWrites(time.Minute), WithAuthority(wrappedMiss{newLocal()}))
+	if err := c.Delete(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "gone"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s, _ := c.Stats(ctx); s.Misses != 1 {
+		t.Fatalf("a wrapped miss should be counted, got %+v", s)
+	}
+}
+
+// wrappedMiss wraps the errors of Get, like WithTimeouts does when its deadline fires with the reply
+type wrappedMiss struct {
+	cache.Cache
+}
+
+func (w wrappedMiss) Get(ctx context.Context, key string) (any, error) {
+	val, err := w.Cache.Get(ctx, key)
+	if err != nil {
+		err = &cache.TimeoutError{Op: "Get", Key: key, Limit: time.Second, Err: err}
+	}
+	return val, err
+}
+
+// TestInvalidation needs a redis server, set REDIS_ADDR to run it
+func TestInvalidation(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	ctx := context.Background()
+	opts := &redis.Options{Addr: addr}
+	rdb := redis.NewClient(opts)
+	defer rdb.Close()
+	l2 := cache.NewRedis(redis_cache.NewCache(rdb, time.Minute, redis_cache.WithPrefix("tiered:")))
+	c := New(newLocal(), l2, WithInvalidation(opts, "tiered:"))
+	defer c.Close()
+	defer l2.Flush(ctx)
+
+	_ = c.Set(ctx, "name", "will", time.Minute)
+	// wait for the subscription and the notification of our own Set
+	time.Sleep(100 * time.Millisecond)
+	if v, _ := c.Get(ctx, "name"); v != "will" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	// another process writes redis directly
+	if err := rdb.Set(ctx, "tiered:name", `"yin"`, time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if v, _ := c.Get(ctx, "name"); v != "yin" {
+		t.Fatalf("L1 should have been invalidated, got %v", v)
+	}
+}